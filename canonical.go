@@ -0,0 +1,299 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Canonical returns the canonical JSON encoding of v, as defined by the JSON
+// Canonicalization Scheme (RFC 8785): object members sorted by their UTF-16
+// code units, no insignificant whitespace, minimal string escaping and
+// numbers in the shortest form that round-trips. Equal values always encode
+// to the same bytes, which makes the result suitable for hashing.
+//
+// v is first marshaled with encoding/json, so struct tags and Marshaler
+// implementations are honored. Numbers must be representable as IEEE 754
+// doubles: an integer that would lose precision, such as a large int64, is
+// an error rather than silently changing the hash. Encode such values as
+// strings instead.
+func Canonical(v any) ([]byte, error) {
+	if m, ok := v.(map[string]string); ok && validStringMap(m) {
+		// A common shape, such as commit metadata, encoded without a
+		// round trip through encoding/json.
+		return canonicalStringMap(m), nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal value: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to decode marshaled value: %w", err)
+	}
+
+	var b bytes.Buffer
+	if err := writeCanonical(&b, generic); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeCanonical(b *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalString(b, v)
+	case json.Number:
+		s, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		b.WriteString(s)
+	case []any:
+		b.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeCanonical(b, elem); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sortKeys(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeCanonicalString(b, k)
+			b.WriteByte(':')
+			if err := writeCanonical(b, v[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value of type %T", v)
+	}
+	return nil
+}
+
+// validStringMap reports whether m holds only valid UTF-8, which
+// encoding/json would otherwise replace.
+func validStringMap(m map[string]string) bool {
+	for k, v := range m {
+		if !utf8.ValidString(k) || !utf8.ValidString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func canonicalStringMap(m map[string]string) []byte {
+	if m == nil {
+		return []byte("null")
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortKeys(keys)
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		writeCanonicalString(&b, k)
+		b.WriteByte(':')
+		writeCanonicalString(&b, m[k])
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// compareUTF16 orders strings by their UTF-16 code units, as RFC 8785
+// requires. It differs from byte order only for characters above U+FFFF,
+// whose surrogates sort before U+E000–U+FFFF.
+func compareUTF16(a, b string) int {
+	return slices.Compare(utf16.Encode([]rune(a)), utf16.Encode([]rune(b)))
+}
+
+// sortKeys sorts object member names as RFC 8785 requires.
+func sortKeys(keys []string) {
+	for _, k := range keys {
+		if hasSupplementary(k) {
+			slices.SortFunc(keys, compareUTF16)
+			return
+		}
+	}
+	// Without supplementary characters, UTF-16 order is byte order.
+	slices.Sort(keys)
+}
+
+// hasSupplementary reports whether s holds a character above U+FFFF, which
+// UTF-8 encodes with a leading byte of 0xF0 or more.
+func hasSupplementary(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0xf0 {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCanonicalString writes s as a JSON string, escaping only what JSON
+// requires.
+func writeCanonicalString(b *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	b.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		b.WriteString(s[start:i])
+		start = i + 1
+		switch c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteString(`\u00`)
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		}
+	}
+	b.WriteString(s[start:])
+	b.WriteByte('"')
+}
+
+// canonicalNumber formats n the way ECMAScript's Number.prototype.toString
+// does, which is what RFC 8785 specifies.
+func canonicalNumber(n json.Number) (string, error) {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil || math.IsInf(f, 0) {
+		return "", fmt.Errorf("number %s is not representable as a double", n)
+	}
+	if !strings.ContainsAny(string(n), ".eE") {
+		// An integer: refuse to round it. It may be beyond int64 and still
+		// exact, like 2^63 or 10^20.
+		i, ok := new(big.Int).SetString(string(n), 10)
+		if !ok {
+			return "", fmt.Errorf("invalid integer %s", n)
+		}
+		exact, _ := big.NewFloat(f).Int(nil)
+		if i.Cmp(exact) != 0 {
+			return "", fmt.Errorf("integer %s is not exactly representable as a double", n)
+		}
+	}
+	return formatES6(f), nil
+}
+
+// formatES6 formats a finite double as ECMAScript does.
+func formatES6(f float64) string {
+	if f == 0 {
+		// Also covers negative zero.
+		return "0"
+	}
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+	// Shortest round-tripping digits, as d.ddde±x.
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp, _ := strings.Cut(e, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	x, _ := strconv.Atoi(exp)
+	// The value is 0.digits × 10^n.
+	n := x + 1
+	k := len(digits)
+
+	var s string
+	switch {
+	case k <= n && n <= 21:
+		s = digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		s = digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		s = "0." + strings.Repeat("0", -n) + digits
+	default:
+		s = digits[:1]
+		if k > 1 {
+			s += "." + digits[1:]
+		}
+		if n-1 >= 0 {
+			s += "e+" + strconv.Itoa(n-1)
+		} else {
+			s += "e" + strconv.Itoa(n-1)
+		}
+	}
+	return sign + s
+}
+
+// JSONObject makes any JSON-marshalable value an Object, serialized with
+// Canonical so that equal values always have the same hash. In a store using
+// another format, it is encoded in that format with Encode instead:
+//
+//	hash, err := store.WriteObject(&merkledb.JSONObject[User]{Value: user})
+//
+// Read it back with ReadJSONObject.
+type JSONObject[T any] struct {
+	Value T
+}
+
+// Serialize implements the Object interface for JSONObject.
+func (o *JSONObject[T]) Serialize() ([]byte, error) {
+	return Canonical(o.Value)
+}
+
+// SerializeFormat implements the FormatSerializer interface for JSONObject.
+func (o *JSONObject[T]) SerializeFormat(f Format) ([]byte, error) {
+	return Encode(f, o.Value)
+}
+
+// ReadJSONObject reads the object with the given hex-encoded hash and decodes
+// it into a T, whatever its format. It is the counterpart of writing a
+// JSONObject[T].
+func ReadJSONObject[T any](store *ObjectStore, hash string) (T, error) {
+	var v T
+	data, err := store.ReadRawObject(hash)
+	if err != nil {
+		return v, err
+	}
+	if err := Decode(data, &v); err != nil {
+		return v, fmt.Errorf("failed to decode object %s: %w", hash, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,146 @@
+package merkledb
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestFormatES6(t *testing.T) {
+	// Test vectors from RFC 8785, Appendix B.
+	tests := []struct {
+		bits uint64
+		want string
+	}{
+		{0x0000000000000000, "0"},
+		{0x8000000000000000, "0"},
+		{0x0000000000000001, "5e-324"},
+		{0x8000000000000001, "-5e-324"},
+		{0x7fefffffffffffff, "1.7976931348623157e+308"},
+		{0x4340000000000000, "9007199254740992"},
+		{0x4430000000000000, "295147905179352830000"},
+		{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+		{0x44b52d02c7e14af6, "1e+23"},
+		{0x444b1ae4d6e2ef50, "1e+21"},
+		{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+		{0x3eb0c6f7a0b5ed8d, "0.000001"},
+		{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+		{0x41b3de4355555553, "333333333.3333332"},
+	}
+	for _, tt := range tests {
+		if got := formatES6(math.Float64frombits(tt.bits)); got != tt.want {
+			t.Errorf("formatES6(%#x) = %s, want %s", tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	type inner struct {
+		B int    `json:"b"`
+		A string `json:"a,omitempty"`
+	}
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"struct fields sorted", inner{B: 1, A: "x"}, `{"a":"x","b":1}`},
+		{"nested", map[string]any{"z": []any{1.5, true, nil}, "y": inner{B: 2}}, `{"y":{"b":2},"z":[1.5,true,null]}`},
+		{"no HTML escaping", "<a & b>\u2028", "\"<a & b>\u2028\""},
+		{"control characters", "tab\there\x01", `"tab\there\u0001"`},
+		{"floats", []float64{1e21, 0.1, -0, 100}, `[1e+21,0.1,0,100]`},
+		// The sorting example of RFC 8785, section 3.2.3.
+		{"UTF-16 key order", map[string]int{
+			"\u20ac": 1, "\r": 2, "\ufb33": 3, "1": 4, "\U0001f600": 5, "\u0080": 6, "\u00f6": 7,
+		}, "{\"\\r\":2,\"1\":4,\"\u0080\":6,\"\u00f6\":7,\"\u20ac\":1,\"\U0001f600\":5,\"\ufb33\":3}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonical(tt.value)
+			if err != nil {
+				t.Fatalf("Canonical() failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Canonical() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonical_RejectsImpreciseIntegers(t *testing.T) {
+	if _, err := Canonical(int64(1<<53 + 1)); err == nil {
+		t.Error("Canonical() accepted an integer a double cannot represent")
+	}
+	if _, err := Canonical(uint64(math.MaxUint64)); err == nil {
+		t.Error("Canonical() accepted an integer beyond int64 a double cannot represent")
+	}
+	// Integers beyond int64 are accepted when a double holds them exactly.
+	for n, want := range map[string]string{
+		"9223372036854775808":   "9223372036854776000",
+		"100000000000000000000": "100000000000000000000",
+		"-18446744073709551616": "-18446744073709552000",
+	} {
+		if got, err := Canonical(json.RawMessage(n)); err != nil || string(got) != want {
+			t.Errorf("Canonical(%s) = %s, %v; want %s", n, got, err, want)
+		}
+	}
+	if got, err := Canonical(int64(1 << 53)); err != nil || string(got) != "9007199254740992" {
+		t.Errorf("Canonical(2^53) = %s, %v", got, err)
+	}
+	if _, err := Canonical(func() {}); err == nil {
+		t.Error("Canonical() accepted a value encoding/json cannot marshal")
+	}
+}
+
+func TestJSONObject(t *testing.T) {
+	type user struct {
+		Name  string            `json:"name"`
+		Roles map[string]bool   `json:"roles"`
+		Attrs map[string]string `json:"attrs"`
+	}
+	store := NewObjectStore(NewMockStorage())
+	value := user{
+		Name:  "ada",
+		Roles: map[string]bool{"admin": true, "dev": true, "ops": false},
+		Attrs: map[string]string{"team": "<core>", "site": "paris"},
+	}
+
+	hash, err := store.WriteObject(&JSONObject[user]{Value: value})
+	if err != nil {
+		t.Fatalf("WriteObject() failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		again, _ := store.WriteObject(&JSONObject[user]{Value: value})
+		if again != hash {
+			t.Fatalf("equal values hashed to %s and %s", hash, again)
+		}
+	}
+
+	got, err := ReadJSONObject[user](store, hash)
+	if err != nil {
+		t.Fatalf("ReadJSONObject() failed: %v", err)
+	}
+	if got.Name != value.Name || len(got.Roles) != 3 || got.Attrs["team"] != "<core>" {
+		t.Errorf("ReadJSONObject() = %+v, want %+v", got, value)
+	}
+}
+
+func TestCanonical_StringMapMatchesGeneralPath(t *testing.T) {
+	m := map[string]string{"b": "<&>", "a\n": "\x01", "\U0001f600": "x", "￿": "y", "bad\xff": "z"}
+	generic := map[string]any{}
+	for k, v := range m {
+		generic[k] = v
+	}
+	fast, err1 := Canonical(m)
+	slow, err2 := Canonical(generic)
+	if err1 != nil || err2 != nil || string(fast) != string(slow) {
+		t.Errorf("Canonical(map[string]string) = %s, %v; map[string]any gives %s, %v", fast, err1, slow, err2)
+	}
+	delete(m, "bad\xff")
+	delete(generic, "bad\xff")
+	fast, _ = Canonical(m)
+	slow, _ = Canonical(generic)
+	if string(fast) != string(slow) {
+		t.Errorf("Canonical(map[string]string) = %s, map[string]any gives %s", fast, slow)
+	}
+}
@@ -0,0 +1,168 @@
+// Package cbor implements the deterministic encoding of CBOR (RFC 8949,
+// section 4.2.1) for Go values, and a decoder for it.
+//
+// Deterministic encoding gives every value exactly one byte representation,
+// so encoded values can be hashed: integers, lengths and tags use the
+// shortest argument, floats use the shortest of half, single or double
+// precision that preserves the value, lengths are always definite, and map
+// entries are sorted by the bytewise order of their encoded keys.
+//
+// Go values map to CBOR the way encoding/json maps them to JSON:
+//
+//   - bool, integers, floats and strings map to the corresponding CBOR types;
+//     strings must be valid UTF-8.
+//   - []byte and [N]byte map to byte strings; other slices and arrays to
+//     arrays. A nil slice or map encodes as null.
+//   - Maps map to maps. Structs map to maps keyed by field name, honoring
+//     `cbor:"name,omitempty"` tags, or `json` tags when there is no cbor tag.
+//     Fields of embedded structs are promoted.
+//   - time.Time encodes as a tag 0 RFC 3339 string, with nanoseconds.
+//   - Pointers and interfaces encode the value they hold, or null.
+//
+// Unmarshal accepts any well-formed CBOR with definite lengths, skipping
+// self-described CBOR tags (55799), which callers may use as a format marker.
+package cbor
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Major types.
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// Simple values and float heads of major type 7.
+const (
+	simpleFalse = 20
+	simpleTrue  = 21
+	simpleNull  = 22
+	simpleUndef = 23
+	headFloat16 = 25
+	headFloat32 = 26
+	headFloat64 = 27
+)
+
+// Tag numbers with a meaning in this package.
+const (
+	// TagDateTime marks an RFC 3339 date/time string.
+	TagDateTime = 0
+	// TagEpochTime marks a number of seconds since the Unix epoch.
+	TagEpochTime = 1
+	// TagSelfDescribe marks data as CBOR. It has no effect on the value it
+	// encloses.
+	TagSelfDescribe = 55799
+)
+
+// SelfDescribePrefix is the encoding of TagSelfDescribe. Since no JSON or
+// UTF-8 text starts with these bytes, it identifies CBOR data.
+var SelfDescribePrefix = []byte{0xd9, 0xd9, 0xf7}
+
+// ErrIndefiniteLength is returned when decoding data that uses an
+// indefinite-length string, array or map.
+var ErrIndefiniteLength = errors.New("cbor: indefinite-length items are not supported")
+
+// UnsupportedTypeError is returned when marshaling a value whose type has no
+// CBOR encoding, such as a channel or a function.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "cbor: unsupported type " + e.Type.String()
+}
+
+// field describes one encoded struct field.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // reflect.Type -> []field
+
+// structFields returns the encoded fields of struct type t, with promoted
+// fields of embedded structs, in declaration order.
+func structFields(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+	var fields []field
+	seen := map[string]bool{}
+	var collect func(t reflect.Type, index []int)
+	collect = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag, hasTag := f.Tag.Lookup("cbor")
+			if !hasTag {
+				tag, hasTag = f.Tag.Lookup("json")
+			}
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			idx := append(append([]int(nil), index...), i)
+
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				if f.Type.Kind() == reflect.Struct {
+					collect(ft, idx)
+				}
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			// The shallowest field wins, as in encoding/json.
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			fields = append(fields, field{
+				name:      name,
+				index:     idx,
+				omitEmpty: hasTag && strings.Contains(","+opts+",", ",omitempty,"),
+			})
+		}
+	}
+	collect(t, nil)
+	cached, _ := fieldCache.LoadOrStore(t, fields)
+	return cached.([]field)
+}
+
+// fieldByName returns the field of v named name, matching case-insensitively
+// as encoding/json does when there is no exact match.
+func fieldByName(fields []field, name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func syntaxError(format string, args ...any) error {
+	return fmt.Errorf("cbor: "+format, args...)
+}
@@ -0,0 +1,239 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMarshal_Vectors(t *testing.T) {
+	// Examples from RFC 8949, Appendix A, in their deterministic form.
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{100, "1864"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{uint64(1000000000000), "1b000000e8d4a51000"},
+		{uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{int64(math.MinInt64), "3b7fffffffffffffff"},
+		{0.0, "f90000"},
+		{math.Copysign(0, -1), "f98000"},
+		{1.0, "f93c00"},
+		{1.1, "fb3ff199999999999a"},
+		{1.5, "f93e00"},
+		{65504.0, "f97bff"},
+		{100000.0, "fa47c35000"},
+		{3.4028234663852886e+38, "fa7f7fffff"},
+		{1.0e+300, "fb7e37e43c8800759c"},
+		{5.960464477539063e-8, "f90001"},
+		{0.00006103515625, "f90400"},
+		{-4.0, "f9c400"},
+		{-4.1, "fbc010666666666666"},
+		{math.Inf(1), "f97c00"},
+		{math.NaN(), "f97e00"},
+		{math.Inf(-1), "f9fc00"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]byte{}, "40"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"", "60"},
+		{"a", "6161"},
+		{"IETF", "6449455446"},
+		{"\"\\", "62225c"},
+		{"ü", "62c3bc"},
+		{"水", "63e6b0b4"},
+		{"\U00010151", "64f0908591"},
+		{[]int{}, "80"},
+		{[]int{1, 2, 3}, "83010203"},
+		{[]any{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+		{map[int]int{1: 2, 3: 4}, "a201020304"},
+		{map[string]any{"a": 1, "b": []int{2, 3}}, "a26161016162820203"},
+		{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+	}
+	for _, tt := range tests {
+		got, err := Marshal(tt.value)
+		if err != nil {
+			t.Errorf("Marshal(%v) failed: %v", tt.value, err)
+			continue
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("Marshal(%v) = %x, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMarshal_MapKeysSortedByEncoding(t *testing.T) {
+	// Shorter encodings sort first, so 10 (0a) precedes 100 (1864), which
+	// precedes -1 (20), then "z" (617a) and "aa" (626161).
+	m := map[any]int{"aa": 5, 100: 2, -1: 3, "z": 4, 10: 1}
+	got, err := Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	want := "a5" + "0a01" + "186402" + "2003" + "617a04" + "62616105"
+	if hex.EncodeToString(got) != want {
+		t.Errorf("Marshal() = %x, want %s", got, want)
+	}
+	for i := 0; i < 20; i++ {
+		again, _ := Marshal(m)
+		if !bytes.Equal(again, got) {
+			t.Fatal("Marshal() of the same map is not deterministic")
+		}
+	}
+}
+
+type embedded struct {
+	Shared string `cbor:"shared"`
+}
+
+type record struct {
+	embedded
+	Name     string            `cbor:"name"`
+	Count    int               `json:"count,omitempty"`
+	Data     []byte            `cbor:"data,omitempty"`
+	Tags     map[string]string `cbor:"tags"`
+	Ratio    float64           `cbor:"ratio"`
+	When     time.Time         `cbor:"when"`
+	Next     *record           `cbor:"next"`
+	Skipped  string            `cbor:"-"`
+	Untagged uint16
+	private  int
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := record{
+		embedded: embedded{Shared: "promoted"},
+		Name:     "first",
+		Count:    -42,
+		Data:     []byte{0, 1, 2},
+		Tags:     map[string]string{"b": "2", "a": "1"},
+		Ratio:    0.25,
+		When:     time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC),
+		Next:     &record{Name: "second"},
+		Skipped:  "not encoded",
+		Untagged: 7,
+		private:  1,
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	var out record
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	want := in
+	want.Skipped, want.private = "", 0
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("round trip = %+v, want %+v", out, want)
+	}
+
+	// omitempty drops the zero count and empty data.
+	var generic map[string]any
+	empty, _ := Marshal(record{Name: "x"})
+	if err := Unmarshal(empty, &generic); err != nil {
+		t.Fatalf("Unmarshal() into a map failed: %v", err)
+	}
+	if _, ok := generic["count"]; ok {
+		t.Error("omitempty field was encoded")
+	}
+	if _, ok := generic["Untagged"]; !ok {
+		t.Error("untagged field was not encoded under its Go name")
+	}
+}
+
+func TestUnmarshal_Generic(t *testing.T) {
+	// A self-described {"a": [1.5, 2^64-1, -100], "b": h''}.
+	data, _ := hex.DecodeString("d9d9f7a2616183f93e001bffffffffffffffff3863616240")
+	var v any
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	want := map[string]any{
+		"a": []any{1.5, uint64(math.MaxUint64), int64(-100)},
+		"b": []byte{},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal() = %#v, want %#v", v, want)
+	}
+
+	// {true: null, "x": 1} has a key that is not a string.
+	data, _ = hex.DecodeString("a2f5f6617801")
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(v, map[any]any{true: nil, "x": int64(1)}) {
+		t.Errorf("Unmarshal() = %#v, want a map[any]any", v)
+	}
+}
+
+func TestUnmarshal_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		into any
+		want error
+	}{
+		{"truncated", "1903", new(int), nil},
+		{"trailing data", "0000", new(int), nil},
+		{"indefinite array", "9f01ff", new([]int), ErrIndefiniteLength},
+		{"reserved info", "1c", new(int), nil},
+		{"overflow", "190100", new(int8), nil},
+		{"negative into uint", "20", new(uint), nil},
+		{"type mismatch", "6161", new(int), nil},
+		{"invalid UTF-8", "61ff", new(string), nil},
+		{"huge array", "9bffffffffffffffff", new([]int), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			err := Unmarshal(data, tt.into)
+			if err == nil {
+				t.Fatal("Unmarshal() succeeded, want an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Unmarshal() returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshal_Unsupported(t *testing.T) {
+	var target *UnsupportedTypeError
+	if _, err := Marshal(map[string]any{"f": func() {}}); !errors.As(err, &target) {
+		t.Errorf("Marshal() of a func returned %v, want UnsupportedTypeError", err)
+	}
+	if _, err := Marshal("\xff"); err == nil {
+		t.Error("Marshal() accepted invalid UTF-8")
+	}
+}
+
+func TestFloat16_Exhaustive(t *testing.T) {
+	// Every half-precision value must survive a round trip through
+	// float64, except NaNs, which all encode to the canonical NaN.
+	for h := 0; h <= 0xffff; h++ {
+		f := float16Value(uint16(h))
+		if math.IsNaN(f) {
+			continue
+		}
+		got, ok := float16Bits(f)
+		if !ok || got != uint16(h) {
+			t.Fatalf("float16Bits(%v) = %#04x, %v; want %#04x", f, got, ok, h)
+		}
+	}
+	if _, ok := float16Bits(65520); ok {
+		t.Error("float16Bits accepted a value beyond the half-precision range")
+	}
+}
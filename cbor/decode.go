@@ -0,0 +1,539 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"time"
+	"unicode/utf8"
+)
+
+// Tag is a tagged item decoded into an empty interface, for tags this
+// package does not interpret.
+type Tag struct {
+	Number  uint64
+	Content any
+}
+
+// Unmarshal decodes the CBOR item in data into the value pointed to by v.
+// Data after the item is an error.
+//
+// Decoding into an empty interface produces bool, int64 (uint64 for values
+// above math.MaxInt64), float64, string, []byte, []any, map[string]any (or
+// map[any]any when a key is not a string), time.Time, Tag or nil.
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("cbor: Unmarshal needs a non-nil pointer")
+	}
+	d := &decoder{data: data}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if d.off != len(d.data) {
+		return syntaxError("%d bytes of trailing data", len(d.data)-d.off)
+	}
+	return nil
+}
+
+type decoder struct {
+	data []byte
+	off  int
+}
+
+// maxDepth bounds nesting so hostile input cannot exhaust the stack.
+const maxDepth = 1000
+
+// head reads the initial byte of an item and its argument.
+func (d *decoder) head() (major byte, info byte, arg uint64, err error) {
+	if d.off >= len(d.data) {
+		return 0, 0, 0, syntaxError("unexpected end of data")
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info = b>>5, b&0x1f
+	var n int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	case info == 31:
+		return 0, 0, 0, ErrIndefiniteLength
+	default:
+		return 0, 0, 0, syntaxError("reserved additional information %d", info)
+	}
+	if len(d.data)-d.off < n {
+		return 0, 0, 0, syntaxError("unexpected end of data")
+	}
+	p := d.data[d.off : d.off+n]
+	d.off += n
+	switch n {
+	case 1:
+		arg = uint64(p[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(p))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(p))
+	default:
+		arg = binary.BigEndian.Uint64(p)
+	}
+	return major, info, arg, nil
+}
+
+// bytes reads the n bytes of a string.
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, syntaxError("unexpected end of data")
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+// item is a decoded item without its content: strings are read, but arrays,
+// maps and tag contents are left for the caller.
+type item struct {
+	major byte
+	info  byte
+	arg   uint64
+	str   []byte
+}
+
+func (d *decoder) next() (item, error) {
+	for {
+		major, info, arg, err := d.head()
+		if err != nil {
+			return item{}, err
+		}
+		if major == majorTag && arg == TagSelfDescribe {
+			continue
+		}
+		it := item{major: major, info: info, arg: arg}
+		if major == majorBytes || major == majorText {
+			if it.str, err = d.bytes(arg); err != nil {
+				return item{}, err
+			}
+			if major == majorText && !utf8.Valid(it.str) {
+				return item{}, syntaxError("invalid UTF-8 in text string")
+			}
+		}
+		return it, nil
+	}
+}
+
+// float returns the value of a float item.
+func (it item) float() float64 {
+	switch it.info {
+	case headFloat16:
+		return float16Value(uint16(it.arg))
+	case headFloat32:
+		return float64(math.Float32frombits(uint32(it.arg)))
+	}
+	return math.Float64frombits(it.arg)
+}
+
+func (it item) isFloat() bool {
+	return it.major == majorSimple && it.info >= headFloat16 && it.info <= headFloat64
+}
+
+func (it item) isNull() bool {
+	return it.major == majorSimple && (it.info == simpleNull || it.info == simpleUndef)
+}
+
+func (it item) describe() string {
+	switch it.major {
+	case majorUint, majorNegInt:
+		return "integer"
+	case majorBytes:
+		return "byte string"
+	case majorText:
+		return "text string"
+	case majorArray:
+		return "array"
+	case majorMap:
+		return "map"
+	case majorTag:
+		return "tag"
+	}
+	switch {
+	case it.isFloat():
+		return "float"
+	case it.info == simpleFalse || it.info == simpleTrue:
+		return "bool"
+	case it.isNull():
+		return "null"
+	}
+	return "simple value"
+}
+
+func (d *decoder) decode(v reflect.Value) error {
+	return d.decodeDepth(v, 0)
+}
+
+func (d *decoder) decodeDepth(v reflect.Value, depth int) error {
+	if depth > maxDepth {
+		return syntaxError("nesting deeper than %d", maxDepth)
+	}
+	it, err := d.next()
+	if err != nil {
+		return err
+	}
+	return d.decodeItem(it, v, depth)
+}
+
+func (d *decoder) mismatch(it item, v reflect.Value) error {
+	return syntaxError("cannot decode %s into Go value of type %s", it.describe(), v.Type())
+}
+
+func (d *decoder) decodeItem(it item, v reflect.Value, depth int) error {
+	if it.isNull() {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+			v.SetZero()
+		}
+		// As in encoding/json, null leaves other values unchanged.
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeItem(it, v.Elem(), depth)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.mismatch(it, v)
+		}
+		generic, err := d.generic(it, depth)
+		if err != nil {
+			return err
+		}
+		if generic == nil {
+			v.SetZero()
+		} else {
+			v.Set(reflect.ValueOf(generic))
+		}
+		return nil
+	}
+
+	if v.Type() == timeType {
+		t, err := d.time(it, depth)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch it.major {
+	case majorUint, majorNegInt:
+		return d.setInt(it, v)
+	case majorBytes:
+		switch {
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(bytes.Clone(it.str))
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			if len(it.str) != v.Len() {
+				return syntaxError("cannot decode %d bytes into %s", len(it.str), v.Type())
+			}
+			reflect.Copy(v, reflect.ValueOf(it.str))
+		case v.Kind() == reflect.String:
+			v.SetString(string(it.str))
+		default:
+			return d.mismatch(it, v)
+		}
+	case majorText:
+		if v.Kind() != reflect.String {
+			return d.mismatch(it, v)
+		}
+		v.SetString(string(it.str))
+	case majorArray:
+		return d.array(it, v, depth)
+	case majorMap:
+		return d.mapItem(it, v, depth)
+	case majorTag:
+		// Unknown tags are transparent when decoding into a concrete type.
+		return d.decodeDepth(v, depth+1)
+	default:
+		switch {
+		case it.isFloat():
+			if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+				return d.mismatch(it, v)
+			}
+			v.SetFloat(it.float())
+		case it.info == simpleFalse || it.info == simpleTrue:
+			if v.Kind() != reflect.Bool {
+				return d.mismatch(it, v)
+			}
+			v.SetBool(it.info == simpleTrue)
+		default:
+			return d.mismatch(it, v)
+		}
+	}
+	return nil
+}
+
+func (d *decoder) setInt(it item, v reflect.Value) error {
+	neg := it.major == majorNegInt
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if it.arg > math.MaxInt64 {
+			return syntaxError("integer overflows %s", v.Type())
+		}
+		i := int64(it.arg)
+		if neg {
+			i = -1 - i
+		}
+		if v.OverflowInt(i) {
+			return syntaxError("integer %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if neg || v.OverflowUint(it.arg) {
+			return syntaxError("integer overflows %s", v.Type())
+		}
+		v.SetUint(it.arg)
+	case reflect.Float32, reflect.Float64:
+		f := float64(it.arg)
+		if neg {
+			f = -1 - f
+		}
+		v.SetFloat(f)
+	default:
+		return d.mismatch(it, v)
+	}
+	return nil
+}
+
+func (d *decoder) array(it item, v reflect.Value, depth int) error {
+	if it.arg > uint64(len(d.data)-d.off) {
+		// Every element takes at least one byte.
+		return syntaxError("array length %d exceeds the data", it.arg)
+	}
+	n := int(it.arg)
+	switch v.Kind() {
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+	case reflect.Array:
+		if n != v.Len() {
+			return syntaxError("cannot decode %d elements into %s", n, v.Type())
+		}
+	default:
+		return d.mismatch(it, v)
+	}
+	for i := 0; i < n; i++ {
+		if err := d.decodeDepth(v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) mapItem(it item, v reflect.Value, depth int) error {
+	if it.arg > uint64(len(d.data)-d.off)/2 {
+		return syntaxError("map length %d exceeds the data", it.arg)
+	}
+	n := int(it.arg)
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		}
+		kt, vt := v.Type().Key(), v.Type().Elem()
+		for i := 0; i < n; i++ {
+			key := reflect.New(kt).Elem()
+			if err := d.decodeDepth(key, depth+1); err != nil {
+				return err
+			}
+			value := reflect.New(vt).Elem()
+			if err := d.decodeDepth(value, depth+1); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+		return nil
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		for i := 0; i < n; i++ {
+			var name string
+			if err := d.decodeDepth(reflect.ValueOf(&name).Elem(), depth+1); err != nil {
+				return err
+			}
+			f, ok := fieldByName(fields, name)
+			if !ok {
+				if err := d.skip(depth + 1); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeDepth(fieldForSet(v, f.index), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return d.mismatch(it, v)
+}
+
+// fieldForSet returns the field at index, allocating embedded pointers.
+func fieldForSet(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// skip reads and discards one item.
+func (d *decoder) skip(depth int) error {
+	var discard any
+	return d.decodeDepth(reflect.ValueOf(&discard).Elem(), depth)
+}
+
+func (d *decoder) time(it item, depth int) (time.Time, error) {
+	if it.major == majorTag {
+		inner, err := d.next()
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch it.arg {
+		case TagDateTime:
+			if inner.major != majorText {
+				return time.Time{}, syntaxError("tag 0 encloses a %s", inner.describe())
+			}
+			return parseTime(inner.str)
+		case TagEpochTime:
+			return epochTime(inner)
+		}
+		return d.time(inner, depth+1)
+	}
+	switch {
+	case it.major == majorText:
+		return parseTime(it.str)
+	case it.major == majorUint || it.major == majorNegInt || it.isFloat():
+		return epochTime(it)
+	}
+	return time.Time{}, syntaxError("cannot decode %s into time.Time", it.describe())
+}
+
+func parseTime(s []byte) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, string(s))
+	if err != nil {
+		return time.Time{}, syntaxError("invalid date/time: %v", err)
+	}
+	return t, nil
+}
+
+func epochTime(it item) (time.Time, error) {
+	switch {
+	case it.major == majorUint && it.arg <= math.MaxInt64:
+		return time.Unix(int64(it.arg), 0).UTC(), nil
+	case it.major == majorNegInt && it.arg < math.MaxInt64:
+		return time.Unix(-1-int64(it.arg), 0).UTC(), nil
+	case it.isFloat():
+		sec, frac := math.Modf(it.float())
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	}
+	return time.Time{}, syntaxError("invalid epoch time")
+}
+
+// generic decodes an item into the Go value Unmarshal produces for an empty
+// interface.
+func (d *decoder) generic(it item, depth int) (any, error) {
+	switch it.major {
+	case majorUint:
+		if it.arg > math.MaxInt64 {
+			return it.arg, nil
+		}
+		return int64(it.arg), nil
+	case majorNegInt:
+		if it.arg > math.MaxInt64 {
+			return nil, syntaxError("negative integer overflows int64")
+		}
+		return -1 - int64(it.arg), nil
+	case majorBytes:
+		return bytes.Clone(it.str), nil
+	case majorText:
+		return string(it.str), nil
+	case majorArray:
+		var s []any
+		if err := d.array(it, reflect.ValueOf(&s).Elem(), depth); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case majorMap:
+		return d.genericMap(it, depth)
+	case majorTag:
+		if it.arg == TagDateTime || it.arg == TagEpochTime {
+			return d.time(it, depth)
+		}
+		var content any
+		if err := d.decodeDepth(reflect.ValueOf(&content).Elem(), depth+1); err != nil {
+			return nil, err
+		}
+		return Tag{Number: it.arg, Content: content}, nil
+	}
+	switch {
+	case it.isFloat():
+		return it.float(), nil
+	case it.info == simpleFalse:
+		return false, nil
+	case it.info == simpleTrue:
+		return true, nil
+	case it.isNull():
+		return nil, nil
+	}
+	return nil, syntaxError("unsupported simple value %d", it.arg)
+}
+
+// genericMap decodes a map item into a map[string]any, or a map[any]any if
+// a key is not a string. Byte string keys become strings.
+func (d *decoder) genericMap(it item, depth int) (any, error) {
+	if it.arg > uint64(len(d.data)-d.off)/2 {
+		return nil, syntaxError("map length %d exceeds the data", it.arg)
+	}
+	m := make(map[any]any, it.arg)
+	allStrings := true
+	for i := uint64(0); i < it.arg; i++ {
+		var key, value any
+		if err := d.decodeDepth(reflect.ValueOf(&key).Elem(), depth+1); err != nil {
+			return nil, err
+		}
+		if b, ok := key.([]byte); ok {
+			key = string(b)
+		}
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, syntaxError("map key of type %T is not comparable", key)
+		}
+		if _, ok := key.(string); !ok {
+			allStrings = false
+		}
+		if err := d.decodeDepth(reflect.ValueOf(&value).Elem(), depth+1); err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	if !allStrings {
+		return m, nil
+	}
+	strMap := make(map[string]any, len(m))
+	for k, v := range m {
+		strMap[k.(string)] = v
+	}
+	return strMap, nil
+}
@@ -0,0 +1,288 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"slices"
+	"time"
+	"unicode/utf8"
+)
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// Marshal returns the deterministic CBOR encoding of v.
+func Marshal(v any) ([]byte, error) {
+	e := &encoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+// head appends the initial byte of an item and its argument in the
+// shortest form.
+func (e *encoder) head(major byte, arg uint64) {
+	m := major << 5
+	switch {
+	case arg < 24:
+		e.buf = append(e.buf, m|byte(arg))
+	case arg <= math.MaxUint8:
+		e.buf = append(e.buf, m|24, byte(arg))
+	case arg <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, m|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, m|26), uint32(arg))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, m|27), arg)
+	}
+}
+
+func (e *encoder) int(i int64) {
+	if i < 0 {
+		// -1 - n, computed without overflowing on MinInt64.
+		e.head(majorNegInt, uint64(-(i + 1)))
+		return
+	}
+	e.head(majorUint, uint64(i))
+}
+
+// float appends f in the shortest precision that represents it exactly.
+func (e *encoder) float(f float64) {
+	if math.IsNaN(f) {
+		// The canonical quiet NaN.
+		e.buf = append(e.buf, 0xf9, 0x7e, 0x00)
+		return
+	}
+	if h, ok := float16Bits(f); ok {
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, majorSimple<<5|headFloat16), h)
+		return
+	}
+	if f32 := float32(f); float64(f32) == f {
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, majorSimple<<5|headFloat32), math.Float32bits(f32))
+		return
+	}
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, majorSimple<<5|headFloat64), math.Float64bits(f))
+}
+
+func (e *encoder) text(s string) error {
+	if !utf8.ValidString(s) {
+		return syntaxError("invalid UTF-8 in string %q", s)
+	}
+	e.head(majorText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+	return nil
+}
+
+func (e *encoder) null() {
+	e.buf = append(e.buf, majorSimple<<5|simpleNull)
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.null()
+		return nil
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		e.head(majorTag, TagDateTime)
+		return e.text(t.Format(time.RFC3339Nano))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, majorSimple<<5|simpleTrue)
+		} else {
+			e.buf = append(e.buf, majorSimple<<5|simpleFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.head(majorUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.float(v.Float())
+	case reflect.String:
+		return e.text(v.String())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.null()
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.null()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.head(majorBytes, uint64(v.Len()))
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		return e.array(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.head(majorBytes, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				e.buf = append(e.buf, byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+		return e.array(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.null()
+			return nil
+		}
+		return e.mapValue(v)
+	case reflect.Struct:
+		return e.structValue(v)
+	default:
+		return &UnsupportedTypeError{Type: v.Type()}
+	}
+	return nil
+}
+
+func (e *encoder) array(v reflect.Value) error {
+	e.head(majorArray, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry is an encoded map entry.
+type entry struct {
+	key, value []byte
+}
+
+// writeEntries appends a map of entries sorted by their encoded keys.
+func (e *encoder) writeEntries(entries []entry) {
+	slices.SortFunc(entries, func(a, b entry) int { return bytes.Compare(a.key, b.key) })
+	e.head(majorMap, uint64(len(entries)))
+	for _, en := range entries {
+		e.buf = append(e.buf, en.key...)
+		e.buf = append(e.buf, en.value...)
+	}
+}
+
+// encodeSeparately returns the encoding of v on its own.
+func encodeSeparately(v reflect.Value) ([]byte, error) {
+	sub := &encoder{}
+	if err := sub.encode(v); err != nil {
+		return nil, err
+	}
+	return sub.buf, nil
+}
+
+func (e *encoder) mapValue(v reflect.Value) error {
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := encodeSeparately(iter.Key())
+		if err != nil {
+			return err
+		}
+		value, err := encodeSeparately(iter.Value())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key, value})
+	}
+	e.writeEntries(entries)
+	return nil
+}
+
+func (e *encoder) structValue(v reflect.Value) error {
+	fields := structFields(v.Type())
+	entries := make([]entry, 0, len(fields))
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		key := &encoder{}
+		key.text(f.name)
+		value, err := encodeSeparately(fv)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key.buf, value})
+	}
+	e.writeEntries(entries)
+	return nil
+}
+
+// isEmpty reports whether v is omitted by omitempty, as in encoding/json.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// float16Bits returns the half-precision encoding of f and whether it
+// represents f exactly. f must not be NaN.
+func float16Bits(f float64) (uint16, bool) {
+	bits := math.Float64bits(f)
+	sign := uint16(bits>>48) & 0x8000
+	exp := int(bits>>52&0x7ff) - 1023
+	mant := bits & (1<<52 - 1)
+
+	switch {
+	case math.IsInf(f, 0):
+		return sign | 0x7c00, true
+	case f == 0:
+		return sign, true
+	case exp >= -14 && exp <= 15:
+		// Normal: the mantissa must fit in 10 bits.
+		if mant&(1<<42-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(exp+15)<<10 | uint16(mant>>42), true
+	case exp >= -24 && exp < -14:
+		// Subnormal: the value is m × 2^-24 with m < 1024.
+		full := mant | 1<<52
+		shift := uint(28 - exp)
+		if full&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(full>>shift), true
+	}
+	return 0, false
+}
+
+// float16Value decodes a half-precision float.
+func float16Value(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h >> 10 & 0x1f)
+	mant := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+	return sign * math.Ldexp(1024+mant, exp-25)
+}
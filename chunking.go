@@ -0,0 +1,328 @@
+package merkledb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/bits"
+)
+
+// chunkManifestMagic starts every chunk manifest. It begins with a NUL byte,
+// so it cannot collide with JSON or text objects. Objects whose data happens
+// to start with it are always stored chunked, so that reading them back is
+// never ambiguous.
+var chunkManifestMagic = []byte("\x00merkledb-chunks/1\n")
+
+// ChunkingOptions configures content-defined chunking of large objects. Sizes
+// are in bytes.
+type ChunkingOptions struct {
+	// Threshold is the size above which an object is chunked. Defaults to
+	// 1 MiB.
+	Threshold int
+	// MinSize, AvgSize and MaxSize bound the chunk sizes. AvgSize is rounded
+	// down to a power of two. They default to 16 KiB, 64 KiB and 256 KiB.
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// ChunkManifest lists the chunks a large object was split into. It is stored
+// under the hash of the whole object, so chunking never changes an object's
+// hash.
+type ChunkManifest struct {
+	// Size is the size of the whole object.
+	Size int64 `json:"size"`
+	// Chunks lists the chunks in order.
+	Chunks []ChunkRef `json:"chunks"`
+}
+
+// ChunkRef identifies one chunk of a chunked object.
+type ChunkRef struct {
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// WithChunking makes the ObjectStore split objects larger than the threshold
+// into content-defined chunks, so that a small edit to a large object only
+// stores the few chunks around the change. Chunk boundaries are found with
+// the FastCDC rolling hash, so they depend on the content rather than on
+// offsets, and survive insertions and deletions.
+//
+// Reading chunked objects works whether or not the option is set.
+func WithChunking(opts ChunkingOptions) ObjectStoreOption {
+	c := newChunker(opts)
+	return func(s *ObjectStore) {
+		s.chunker = c
+	}
+}
+
+// gearTable holds the random values of the gear rolling hash. It is generated
+// from a fixed seed: changing it would move every chunk boundary and defeat
+// deduplication against existing stores.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6d65726b6c656462) // "merkledb"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker splits data with FastCDC and normalized chunking: cut points are
+// harder to find before the average size and easier after it, which narrows
+// the spread of chunk sizes.
+type chunker struct {
+	threshold            int
+	minSize, avg, max    int
+	maskSmall, maskLarge uint64
+}
+
+// newChunker returns a chunker for opts, filling in the defaults.
+func newChunker(opts ChunkingOptions) *chunker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 1 << 20
+	}
+	if opts.AvgSize <= 0 {
+		opts.AvgSize = 64 << 10
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = opts.AvgSize / 4
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = opts.AvgSize * 4
+	}
+	n := bits.Len(uint(opts.AvgSize)) - 1
+	avg := 1 << n
+	minSize := min(opts.MinSize, avg)
+	maxSize := max(opts.MaxSize, avg)
+	// The gear hash shifts left, so its high bits depend on the most bytes.
+	highBits := func(k int) uint64 { return ((uint64(1) << k) - 1) << (64 - k) }
+	return &chunker{
+		threshold: opts.Threshold,
+		minSize:   minSize,
+		avg:       avg,
+		max:       maxSize,
+		maskSmall: highBits(n + 1),
+		maskLarge: highBits(max(n-1, 1)),
+	}
+}
+
+// cut returns the length of the first chunk of data.
+func (c *chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	n = min(n, c.max)
+	normal := min(n, c.avg)
+
+	var h uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&c.maskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&c.maskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// split returns the chunks of data, which share its memory.
+func (c *chunker) split(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := c.cut(data)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// shouldChunk reports whether data must be stored chunked.
+func (s *ObjectStore) shouldChunk(data []byte) bool {
+	if bytes.HasPrefix(data, chunkManifestMagic) {
+		return true
+	}
+	return s.chunker != nil && len(data) > s.chunker.threshold
+}
+
+// writeChunked stores data as chunks followed by their manifest under the
+// hash of data, and returns the number of chunks.
+func (s *ObjectStore) writeChunked(data []byte) (int, error) {
+	w := s.newChunkWriter()
+	if _, err := w.Write(data); err != nil {
+		return 0, err
+	}
+	_, chunks, err := w.finish()
+	return chunks, err
+}
+
+// chunkWriter splits a stream into chunks as it is written, storing each
+// chunk as soon as its boundary is known. It buffers at most one maximum-size
+// chunk, and cuts exactly where chunker.split would on the whole content.
+type chunkWriter struct {
+	store    *ObjectStore
+	chunker  *chunker
+	hash     hash.Hash
+	buf      []byte
+	manifest ChunkManifest
+	err      error
+}
+
+func (s *ObjectStore) newChunkWriter() *chunkWriter {
+	c := s.chunker
+	if c == nil {
+		// Streams and objects that look like manifests are chunked even
+		// when chunking is not configured.
+		c = newChunker(ChunkingOptions{})
+	}
+	return &chunkWriter{store: s, chunker: c, hash: sha256.New()}
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.hash.Write(p)
+	w.manifest.Size += int64(len(p))
+	w.buf = append(w.buf, p...)
+	// Keep more than a maximum-size chunk buffered: the cut point is then
+	// final, and at least one byte is left for a second chunk.
+	for len(w.buf) > w.chunker.max {
+		n := w.chunker.cut(w.buf)
+		if w.err = w.putChunk(w.buf[:n]); w.err != nil {
+			return 0, w.err
+		}
+		w.buf = w.buf[n:]
+	}
+	return len(p), nil
+}
+
+func (w *chunkWriter) putChunk(chunk []byte) error {
+	sum := sha256.Sum256(chunk)
+	if err := w.store.storage.Put(sum[:], chunk); err != nil {
+		return fmt.Errorf("failed to store chunk: %w", err)
+	}
+	w.manifest.Chunks = append(w.manifest.Chunks, ChunkRef{Hash: hex.EncodeToString(sum[:]), Size: len(chunk)})
+	return nil
+}
+
+// finish stores the remaining chunks, then the manifest under the hash of
+// everything written, which it returns with the number of chunks. The
+// manifest is written last so it never references a missing chunk.
+func (w *chunkWriter) finish() ([]byte, int, error) {
+	if w.err != nil {
+		return nil, 0, w.err
+	}
+	rest := w.chunker.split(w.buf)
+	if len(w.manifest.Chunks) == 0 && len(rest) == 1 {
+		// A single chunk would have the object's own hash and be overwritten
+		// by the manifest.
+		mid := max(1, len(w.buf)/2)
+		rest = [][]byte{w.buf[:mid], w.buf[mid:]}
+	}
+	for _, chunk := range rest {
+		if len(chunk) == 0 {
+			continue
+		}
+		if err := w.putChunk(chunk); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	key := w.hash.Sum(nil)
+	encoded, err := json.Marshal(w.manifest)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to encode chunk manifest: %w", err)
+	}
+	if err := w.store.storage.Put(key, append(append([]byte(nil), chunkManifestMagic...), encoded...)); err != nil {
+		return nil, 0, fmt.Errorf("failed to store chunk manifest: %w", err)
+	}
+	return key, len(w.manifest.Chunks), nil
+}
+
+// parseChunkManifest decodes value if it is a chunk manifest.
+func parseChunkManifest(value []byte) (*ChunkManifest, bool) {
+	if !bytes.HasPrefix(value, chunkManifestMagic) {
+		return nil, false
+	}
+	var m ChunkManifest
+	if err := json.Unmarshal(value[len(chunkManifestMagic):], &m); err != nil {
+		return nil, false
+	}
+	return &m, true
+}
+
+// verifyChunks reports whether the chunks of m, read from s, are intact and
+// reassemble into the content addressed by key.
+func verifyChunks(s Storage, key []byte, m *ChunkManifest) bool {
+	h := sha256.New()
+	var size int64
+	for _, ref := range m.Chunks {
+		chunkKey, err := hex.DecodeString(ref.Hash)
+		if err != nil {
+			return false
+		}
+		chunk, err := s.Get(chunkKey)
+		if err != nil || len(chunk) != ref.Size {
+			return false
+		}
+		if sum := sha256.Sum256(chunk); !bytes.Equal(sum[:], chunkKey) {
+			return false
+		}
+		h.Write(chunk)
+		size += int64(len(chunk))
+	}
+	return size == m.Size && bytes.Equal(h.Sum(nil), key)
+}
+
+// ReadChunkManifest returns the chunk manifest of the object with the given
+// hex-encoded hash, and false if the object is stored whole.
+func (s *ObjectStore) ReadChunkManifest(hash string) (*ChunkManifest, bool, error) {
+	key, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode hash: %w", err)
+	}
+	value, err := s.storage.Get(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read object: %w", err)
+	}
+	m, ok := parseChunkManifest(value)
+	return m, ok, nil
+}
+
+// reassemble reads the chunks of a manifest stored under key and checks the
+// result against it.
+func (s *ObjectStore) reassemble(key []byte, m *ChunkManifest) ([]byte, error) {
+	// The size is only a hint: the hash check below is what validates data.
+	data := make([]byte, 0, max(0, min(m.Size, 64<<20)))
+	for _, ref := range m.Chunks {
+		chunkKey, err := hex.DecodeString(ref.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk hash %q: %w", ref.Hash, err)
+		}
+		chunk, err := s.storage.Get(chunkKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk %s: %w", ref.Hash, err)
+		}
+		data = append(data, chunk...)
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], key) {
+		return nil, fmt.Errorf("chunked object %x: %w", key, ErrCorruptObject)
+	}
+	return data, nil
+}
@@ -0,0 +1,160 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"testing"
+)
+
+// rawObject is an Object whose serialized form is given directly.
+type rawObject []byte
+
+func (o rawObject) Serialize() ([]byte, error) { return o, nil }
+
+func randomBytes(seed uint64, n int) []byte {
+	r := rand.New(rand.NewPCG(seed, seed))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	return data
+}
+
+func TestChunker_Boundaries(t *testing.T) {
+	c := newChunker(ChunkingOptions{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10})
+	data := randomBytes(1, 1<<20)
+
+	chunks := c.split(data)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks do not concatenate back to the data")
+	}
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if len(chunk) < 1<<10 || len(chunk) > 16<<10 {
+			t.Errorf("chunk %d has size %d, outside [1 KiB, 16 KiB]", i, len(chunk))
+		}
+	}
+	// Around 256 chunks of 4 KiB on average.
+	if n := len(chunks); n < 128 || n > 512 {
+		t.Errorf("split 1 MiB into %d chunks, want about 256", n)
+	}
+
+	again := c.split(append([]byte(nil), data...))
+	if len(again) != len(chunks) {
+		t.Error("splitting the same data twice gave different chunks")
+	}
+}
+
+func TestObjectStore_ChunkedRoundTrip(t *testing.T) {
+	storage := NewMockStorage()
+	store := NewObjectStore(storage, WithChunking(ChunkingOptions{Threshold: 64 << 10, AvgSize: 8 << 10}))
+	data := randomBytes(2, 512<<10)
+
+	hash, err := store.WriteObject(rawObject(data))
+	if err != nil {
+		t.Fatalf("WriteObject() failed: %v", err)
+	}
+	plainHash, _ := NewObjectStore(NewMockStorage()).WriteObject(rawObject(data))
+	if hash != plainHash {
+		t.Errorf("chunked object hash %s differs from the plain hash %s", hash, plainHash)
+	}
+
+	m, chunked, err := store.ReadChunkManifest(hash)
+	if err != nil || !chunked {
+		t.Fatalf("ReadChunkManifest() = %v, %v; want a manifest", chunked, err)
+	}
+	if m.Size != int64(len(data)) || len(m.Chunks) < 2 {
+		t.Errorf("manifest has size %d and %d chunks, want %d bytes in several chunks", m.Size, len(m.Chunks), len(data))
+	}
+
+	got, err := store.ReadRawObject(hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadRawObject() returned %d bytes, %v; want the original %d bytes", len(got), err, len(data))
+	}
+
+	// Small objects are still stored whole.
+	small, _ := store.WriteObject(rawObject("small"))
+	if _, chunked, _ := store.ReadChunkManifest(small); chunked {
+		t.Error("an object below the threshold was chunked")
+	}
+}
+
+func TestObjectStore_ChunkingDeduplicatesEdits(t *testing.T) {
+	storage := NewMockStorage()
+	store := NewObjectStore(storage, WithChunking(ChunkingOptions{Threshold: 64 << 10, AvgSize: 8 << 10}))
+	data := randomBytes(3, 1<<20)
+	store.WriteObject(rawObject(data))
+	before := len(storage.data)
+
+	// Insert a line in the middle, shifting everything after it.
+	edited := append(append(append([]byte(nil), data[:len(data)/2]...), "one new line\n"...), data[len(data)/2:]...)
+	if _, err := store.WriteObject(rawObject(edited)); err != nil {
+		t.Fatalf("WriteObject() failed: %v", err)
+	}
+
+	// The new manifest plus the one or two chunks around the edit.
+	if added := len(storage.data) - before; added > 4 {
+		t.Errorf("the edit added %d keys out of %d, want only a few", added, before)
+	}
+}
+
+func TestObjectStore_ManifestLookalikeIsAlwaysChunked(t *testing.T) {
+	// No chunking configured: data that looks like a manifest must still
+	// round-trip unchanged.
+	store := NewObjectStore(NewMockStorage())
+	data := append(append([]byte(nil), chunkManifestMagic...), `{"size":1,"chunks":[]}`...)
+
+	hash, err := store.WriteObject(rawObject(data))
+	if err != nil {
+		t.Fatalf("WriteObject() failed: %v", err)
+	}
+	got, err := store.ReadRawObject(hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("ReadRawObject() = %q, %v; want %q", got, err, data)
+	}
+}
+
+func TestObjectStore_ChunkedDamage(t *testing.T) {
+	storage := NewMockStorage()
+	store := NewObjectStore(storage, WithChunking(ChunkingOptions{Threshold: 16 << 10, AvgSize: 4 << 10}))
+	hash, _ := store.WriteObject(rawObject(randomBytes(4, 64<<10)))
+	m, _, _ := store.ReadChunkManifest(hash)
+	key, _ := hex.DecodeString(m.Chunks[1].Hash)
+
+	storage.data[string(key)] = []byte("tampered")
+	if _, err := store.ReadRawObject(hash); !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("ReadRawObject() with a tampered chunk returned %v, want ErrCorruptObject", err)
+	}
+
+	delete(storage.data, string(key))
+	if _, err := store.ReadRawObject(hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadRawObject() with a missing chunk returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestVerifyContent_ChecksChunkManifests(t *testing.T) {
+	storage := newLockedStorage()
+	store := NewObjectStore(storage, WithChunking(ChunkingOptions{Threshold: 16 << 10, AvgSize: 4 << 10}))
+	hash, _ := store.WriteObject(rawObject(randomBytes(5, 64<<10)))
+	key, _ := hex.DecodeString(hash)
+	manifest := storage.data[string(key)]
+	verify := VerifyContent(storage)
+
+	if VerifySHA256(key, manifest) {
+		t.Error("VerifySHA256() accepted a chunk manifest")
+	}
+	if !verify(key, manifest) {
+		t.Error("VerifyContent() rejected an intact chunk manifest")
+	}
+	if verify(contentKey("other"), manifest) {
+		t.Error("VerifyContent() accepted a chunk manifest under another hash")
+	}
+
+	m, _, _ := store.ReadChunkManifest(hash)
+	chunkKey, _ := hex.DecodeString(m.Chunks[1].Hash)
+	storage.data[string(chunkKey)] = []byte("tampered")
+	if verify(key, manifest) {
+		t.Error("VerifyContent() accepted a chunk manifest with a tampered chunk")
+	}
+}
@@ -0,0 +1,54 @@
+package merkledb
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time stamped on new commits. Commit timestamps are hashed,
+// so a deterministic Clock makes a pipeline produce byte-identical commits on
+// every run.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to the Clock interface.
+type ClockFunc func() time.Time
+
+// Now implements Clock.
+func (f ClockFunc) Now() time.Time { return f() }
+
+// systemClock is the default Clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// WithClock makes the ObjectStore stamp new commits with the time told by
+// clock rather than the system time.
+func WithClock(clock Clock) ObjectStoreOption {
+	return func(s *ObjectStore) {
+		if clock != nil {
+			s.clock = clock
+		}
+	}
+}
+
+// FixedClock returns a Clock that always tells t.
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time { return t })
+}
+
+// StepClock returns a Clock that tells start, then advances by step on every
+// call. It gives successive commits distinct, reproducible timestamps. It is
+// safe for concurrent use.
+func StepClock(start time.Time, step time.Duration) Clock {
+	var mu sync.Mutex
+	next := start
+	return ClockFunc(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		t := next
+		next = next.Add(step)
+		return t
+	})
+}
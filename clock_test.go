@@ -0,0 +1,68 @@
+package merkledb
+
+import (
+	"testing"
+	"time"
+)
+
+// importFeed commits the same two-version history on a fresh store.
+func importFeed(t *testing.T, opts ...ObjectStoreOption) (first, second string) {
+	t.Helper()
+	ws, _ := NewWorkspace(NewObjectStore(NewMockStorage(), opts...))
+	ws.Add("stops.txt", &mockObject{Data: "v1"})
+	first, err := ws.Commit("first", nil)
+	if err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	ws.Add("stops.txt", &mockObject{Data: "v2"})
+	second, err = ws.Commit("second", []string{first})
+	if err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	return first, second
+}
+
+func TestWithClock_ReproducibleHashes(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	first, second := importFeed(t, WithClock(StepClock(start, time.Minute)))
+	again1, again2 := importFeed(t, WithClock(StepClock(start, time.Minute)))
+	if first != again1 || second != again2 {
+		t.Errorf("re-running the import gave %s, %s; want %s, %s", again1, again2, first, second)
+	}
+	if first != "8c62d31039c669fcc622275ed82d89479234ac98dc0903f6e27aad48b7fead8e" {
+		t.Errorf("first commit hash = %s", first)
+	}
+}
+
+func TestWithClock_Timestamps(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("CET", 3600))
+	store := NewObjectStore(NewMockStorage(), WithClock(StepClock(start, time.Hour)))
+	ws, _ := NewWorkspace(store)
+
+	first, _ := ws.Commit("first", nil)
+	second, _ := ws.Commit("second", []string{first})
+	c1, _ := store.ReadCommit(first)
+	c2, _ := store.ReadCommit(second)
+	if !c1.Timestamp.Equal(start) || c1.Timestamp.Location() != time.UTC {
+		t.Errorf("first timestamp = %v, want %v in UTC", c1.Timestamp, start)
+	}
+	if got := c2.Timestamp.Sub(c1.Timestamp); got != time.Hour {
+		t.Errorf("commits are %v apart, want one step", got)
+	}
+
+	// An explicit timestamp wins over the clock.
+	explicit := time.Date(2020, 2, 2, 0, 0, 0, 0, time.UTC)
+	hash, _ := ws.CommitWithOptions("backfill", nil, &CommitOptions{Timestamp: explicit, Author: &Identity{Name: "Ada"}})
+	c3, _ := store.ReadCommit(hash)
+	if !c3.Timestamp.Equal(explicit) || !c3.Author.When.Equal(explicit) {
+		t.Errorf("backfilled commit at %v by %+v, want %v", c3.Timestamp, c3.Author, explicit)
+	}
+}
+
+func TestFixedClock(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	clock := FixedClock(at)
+	if !clock.Now().Equal(at) || !clock.Now().Equal(at) {
+		t.Error("FixedClock() moved")
+	}
+}
@@ -0,0 +1,262 @@
+package merkledb
+
+import (
+	"fmt"
+	"iter"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Codec encodes the values of a Collection. Encode must be deterministic,
+// since the encoding is hashed.
+type Codec[V any] interface {
+	Encode(v V) ([]byte, error)
+	Decode(data []byte) (V, error)
+}
+
+// KeyCodec maps the keys of a Collection to entry names and back. Distinct
+// keys must map to distinct names.
+type KeyCodec[K comparable] interface {
+	EncodeKey(k K) (string, error)
+	DecodeKey(name string) (K, error)
+}
+
+// FormatCodec returns a Codec encoding values with Encode in format f. It
+// decodes values in either format.
+func FormatCodec[V any](f Format) Codec[V] {
+	return formatCodec[V]{format: f}
+}
+
+type formatCodec[V any] struct {
+	format Format
+}
+
+func (c formatCodec[V]) Encode(v V) ([]byte, error) {
+	return Encode(c.format, v)
+}
+
+func (c formatCodec[V]) Decode(data []byte) (V, error) {
+	var v V
+	err := Decode(data, &v)
+	return v, err
+}
+
+// reflectKeys is the default KeyCodec: it names keys of string kinds by
+// themselves and keys of integer kinds by their decimal form.
+type reflectKeys[K comparable] struct{}
+
+func (reflectKeys[K]) EncodeKey(k K) (string, error) {
+	v := reflect.ValueOf(k)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("no default key codec for %T", k)
+}
+
+func (reflectKeys[K]) DecodeKey(name string) (K, error) {
+	var k K
+	v := reflect.ValueOf(&k).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(name)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(name, 10, v.Type().Bits())
+		if err != nil {
+			return k, fmt.Errorf("invalid integer key %q", name)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(name, 10, v.Type().Bits())
+		if err != nil {
+			return k, fmt.Errorf("invalid integer key %q", name)
+		}
+		v.SetUint(u)
+	default:
+		return k, fmt.Errorf("no default key codec for %T", k)
+	}
+	return k, nil
+}
+
+// CollectionOptions configures a Collection.
+type CollectionOptions[K comparable, V any] struct {
+	// Keys maps keys to entry names. It defaults to the decimal form of
+	// integer keys and to string keys themselves; other key types need one.
+	Keys KeyCodec[K]
+	// Values encodes values. It defaults to FormatCodec in the format of the
+	// workspace's store.
+	Values Codec[V]
+}
+
+// Collection is a typed view of the workspace entries under a path prefix:
+// the value for key k is an object staged as "<prefix>/<name of k>". Changes
+// are staged in the workspace and versioned by its next commit.
+//
+// Like its Workspace, a Collection is not safe for concurrent use.
+type Collection[K comparable, V any] struct {
+	ws     *Workspace
+	prefix string
+	keys   KeyCodec[K]
+	values Codec[V]
+	err    error
+}
+
+// NewCollection returns the collection of ws stored under prefix. opts may be
+// nil.
+func NewCollection[K comparable, V any](ws *Workspace, prefix string, opts *CollectionOptions[K, V]) (*Collection[K, V], error) {
+	if ws == nil {
+		return nil, fmt.Errorf("workspace cannot be nil")
+	}
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return nil, fmt.Errorf("invalid collection prefix %q", prefix)
+	}
+	c := &Collection[K, V]{ws: ws, prefix: prefix + "/"}
+	if opts != nil {
+		c.keys, c.values = opts.Keys, opts.Values
+	}
+	if c.keys == nil {
+		var zero K
+		if _, err := (reflectKeys[K]{}).EncodeKey(zero); err != nil {
+			return nil, err
+		}
+		c.keys = reflectKeys[K]{}
+	}
+	if c.values == nil {
+		c.values = FormatCodec[V](ws.store.format)
+	}
+	return c, nil
+}
+
+// encodedObject is an Object whose serialized form is already known.
+type encodedObject []byte
+
+func (o encodedObject) Serialize() ([]byte, error) { return o, nil }
+
+func (c *Collection[K, V]) name(k K) (string, error) {
+	name, err := c.keys.EncodeKey(k)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode key %v: %w", k, err)
+	}
+	return c.prefix + name, nil
+}
+
+// Put stores v under k, replacing any previous value.
+func (c *Collection[K, V]) Put(k K, v V) error {
+	name, err := c.name(k)
+	if err != nil {
+		return err
+	}
+	data, err := c.values.Encode(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode value for key %v: %w", k, err)
+	}
+	return c.ws.Add(name, encodedObject(data))
+}
+
+// Get returns the value stored under k, or an error matching ErrNotFound.
+func (c *Collection[K, V]) Get(k K) (V, error) {
+	var zero V
+	name, err := c.name(k)
+	if err != nil {
+		return zero, err
+	}
+	hash, ok := c.ws.Get(name)
+	if !ok {
+		return zero, fmt.Errorf("key %v: %w", k, ErrNotFound)
+	}
+	return c.read(name, hash)
+}
+
+func (c *Collection[K, V]) read(name, hash string) (V, error) {
+	data, err := c.ws.store.ReadRawObject(hash)
+	if err != nil {
+		var zero V
+		return zero, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	v, err := c.values.Decode(data)
+	if err != nil {
+		return v, fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// Has reports whether a value is stored under k.
+func (c *Collection[K, V]) Has(k K) bool {
+	name, err := c.name(k)
+	if err != nil {
+		return false
+	}
+	_, ok := c.ws.Get(name)
+	return ok
+}
+
+// Delete removes the value stored under k, and reports whether there was one.
+func (c *Collection[K, V]) Delete(k K) (bool, error) {
+	name, err := c.name(k)
+	if err != nil {
+		return false, err
+	}
+	return c.ws.Remove(name), nil
+}
+
+// Len returns the number of values in the collection.
+func (c *Collection[K, V]) Len() int {
+	n := 0
+	for range c.ws.List(c.prefix) {
+		n++
+	}
+	return n
+}
+
+// Keys returns the keys of the collection, in the order of their names.
+// Iteration stops at the first name that does not decode; Err reports it.
+func (c *Collection[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		c.err = nil
+		for name := range c.ws.List(c.prefix) {
+			k, err := c.keys.DecodeKey(strings.TrimPrefix(name, c.prefix))
+			if err != nil {
+				c.err = fmt.Errorf("failed to decode key of %s: %w", name, err)
+				return
+			}
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// All returns the entries of the collection, in the order of their names.
+// Values are read lazily, one at a time. Iteration stops at the first entry
+// that cannot be read or decoded; Err reports it.
+func (c *Collection[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		c.err = nil
+		for name, hash := range c.ws.List(c.prefix) {
+			k, err := c.keys.DecodeKey(strings.TrimPrefix(name, c.prefix))
+			if err != nil {
+				c.err = fmt.Errorf("failed to decode key of %s: %w", name, err)
+				return
+			}
+			v, err := c.read(name, hash)
+			if err != nil {
+				c.err = err
+				return
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that ended the last iteration over Keys or All, if
+// any.
+func (c *Collection[K, V]) Err() error {
+	return c.err
+}
@@ -0,0 +1,142 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type stop struct {
+	Name string  `json:"name"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+func TestCollection_PutGetDelete(t *testing.T) {
+	ws, _ := NewWorkspace(NewObjectStore(NewMockStorage()))
+	stops, err := NewCollection[string, stop](ws, "stops", nil)
+	if err != nil {
+		t.Fatalf("NewCollection() failed: %v", err)
+	}
+
+	if err := stops.Put("S1", stop{Name: "Central", Lat: 48.85, Lon: 2.35}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	stops.Put("S2", stop{Name: "North"})
+	got, err := stops.Get("S1")
+	if err != nil || got.Name != "Central" || got.Lat != 48.85 {
+		t.Errorf("Get() = %+v, %v; want the stored stop", got, err)
+	}
+	if _, ok := ws.Get("stops/S1"); !ok {
+		t.Error("collection value is not staged under its prefix")
+	}
+	if _, err := stops.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing key returned %v, want ErrNotFound", err)
+	}
+
+	if ok, err := stops.Delete("S2"); !ok || err != nil {
+		t.Errorf("Delete() = %v, %v; want true", ok, err)
+	}
+	if stops.Has("S2") || stops.Len() != 1 {
+		t.Errorf("after Delete(): Has = %v, Len = %d; want false, 1", stops.Has("S2"), stops.Len())
+	}
+}
+
+func TestCollection_AllIsOrderedAndScoped(t *testing.T) {
+	ws, _ := NewWorkspace(NewObjectStore(NewMockStorage()))
+	routes, _ := NewCollection[int, string](ws, "routes", nil)
+	other, _ := NewCollection[int, string](ws, "routes-archive", nil)
+	for _, id := range []int{30, 4, -1} {
+		routes.Put(id, fmt.Sprint("route ", id))
+	}
+	other.Put(99, "archived")
+	ws.Add("routes.txt", &mockObject{Data: "not in the collection"})
+
+	var seen []string
+	for k, v := range routes.All() {
+		seen = append(seen, fmt.Sprint(k, "=", v))
+	}
+	if err := routes.Err(); err != nil {
+		t.Fatalf("All() failed: %v", err)
+	}
+	// Entries come in name order.
+	if got := strings.Join(seen, ", "); got != "-1=route -1, 30=route 30, 4=route 4" {
+		t.Errorf("All() = %s", got)
+	}
+
+	// Stopping early is fine.
+	for range routes.Keys() {
+		break
+	}
+}
+
+func TestCollection_VersionedThroughCommits(t *testing.T) {
+	store := NewObjectStore(NewMockStorage())
+	ws, _ := NewWorkspace(store)
+	stops, _ := NewCollection[string, stop](ws, "stops", nil)
+	stops.Put("S1", stop{Name: "Old name"})
+	first, err := ws.Commit("first", nil)
+	if err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	stops.Put("S1", stop{Name: "New name"})
+	stops.Put("S2", stop{Name: "Added"})
+	if _, err := ws.Commit("second", []string{first}); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	// Checking out the first commit restores its contents.
+	old, _ := NewWorkspace(store)
+	if err := old.Checkout(first); err != nil {
+		t.Fatalf("Checkout() failed: %v", err)
+	}
+	oldStops, _ := NewCollection[string, stop](old, "stops", nil)
+	if got, _ := oldStops.Get("S1"); got.Name != "Old name" || oldStops.Has("S2") {
+		t.Errorf("first commit has S1 = %+v, S2 = %v; want the old name only", got, oldStops.Has("S2"))
+	}
+}
+
+// upperCodec stores strings upper-cased, to check custom codecs are used.
+type upperCodec struct{}
+
+func (upperCodec) Encode(v string) ([]byte, error) { return []byte(strings.ToUpper(v)), nil }
+
+func (upperCodec) Decode(data []byte) (string, error) { return string(data), nil }
+
+func TestCollection_Codecs(t *testing.T) {
+	store := NewObjectStore(NewMockStorage(), WithFormat(FormatCBOR))
+	ws, _ := NewWorkspace(store)
+
+	// The default codec follows the store's format.
+	stops, _ := NewCollection[string, stop](ws, "stops", nil)
+	stops.Put("S1", stop{Name: "Central"})
+	hash, _ := ws.Get("stops/S1")
+	if raw, _ := store.ReadRawObject(hash); DetectFormat(raw) != FormatCBOR {
+		t.Errorf("value stored as %s, want the store's cbor format", DetectFormat(raw))
+	}
+
+	names, _ := NewCollection(ws, "names", &CollectionOptions[string, string]{Values: upperCodec{}})
+	names.Put("a", "shout")
+	if got, _ := names.Get("a"); got != "SHOUT" {
+		t.Errorf("Get() with a custom codec = %q, want SHOUT", got)
+	}
+
+	type point struct{ X, Y int }
+	if _, err := NewCollection[point, string](ws, "points", nil); err == nil {
+		t.Error("NewCollection() accepted a struct key without a KeyCodec")
+	}
+	if _, err := NewCollection[string, string](ws, "bad/", nil); err == nil {
+		t.Error("NewCollection() accepted a prefix ending in a slash")
+	}
+
+	// A name that is not a valid key ends the iteration with an error.
+	ids, _ := NewCollection[uint8, string](ws, "ids", nil)
+	ids.Put(7, "seven")
+	ws.Add("ids/300", &mockObject{})
+	for range ids.All() {
+	}
+	if ids.Err() == nil {
+		t.Error("All() over an undecodable key reported no error")
+	}
+}
@@ -1,59 +1,30 @@
 package merkledb
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"sort"
 	"time"
 )
 
-// Tree represents a versioned directory of objects. It maps a set of names
-// to the hashes of other objects or sub-trees, similar to a Git tree.
-// By implementing the Object interface, a Tree can itself be stored in the ObjectStore.
-type Tree struct {
-	// Entries maps a name (like a filename or a subdirectory name) to a hash
-	Entries map[string]string `json:"entries"`
+// Identity identifies who made a change, and when.
+type Identity struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email,omitempty"`
+	When  time.Time `json:"when"`
 }
 
-// NewTree creates an empty Tree object
-func NewTree() *Tree {
-	return &Tree{Entries: make(map[string]string)}
-}
-
-// Serialize implements the Object interface for Tree.
-// To ensure a stable hash, it marshals the entries map into a canonical JSON formal.
-// It achieves this by storing the keys of the map before serialization.
-func (t *Tree) Serialize() ([]byte, error) {
-	// A simple json.Marshal on a map does not guarantee key order.
-	// To get a stable hash, we must serialie in a cannonical way
-	// We'll build a string representation with sorted keys.
-	keys := make([]string, 0, len(t.Entries))
-	for k := range t.Entries {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	var b bytes.Buffer
-	b.WriteString("{")
-	for i, key := range keys {
-		if i > 0 {
-			b.WriteString(",")
-		}
-		// JSON encode key and value to handle special characters correctly
-		keyBytes, _ := json.Marshal(key)
-		valueBytes, _ := json.Marshal(t.Entries[key])
-		b.WriteString(fmt.Sprintf("%s:%s", string(keyBytes), string(valueBytes)))
+// String returns the identity in the usual "Name <email>" form.
+func (id Identity) String() string {
+	if id.Email == "" {
+		return id.Name
 	}
-	b.WriteString("}")
-
-	return b.Bytes(), nil
+	return fmt.Sprintf("%s <%s>", id.Name, id.Email)
 }
 
 // --- Commit Object ---
 
 // Commit represents a snapshot of a Tree at a specific point in time.
-// It contains metadata about the snapshot, such as the author, message and parent commit
+// It contains metadata about the snapshot, such as the author, message and parent commit.
 // This is the core object that creates the historical, append-only ledger.
 type Commit struct {
 	// TreeHash is the hashh of the root Tree object for this commit.
@@ -66,6 +37,71 @@ type Commit struct {
 
 	Message   string    `json:"message"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// Author is who wrote the change, and Committer who recorded it. Both are
+	// optional; commits without them hash as they did before they existed.
+	Author    *Identity `json:"author,omitempty"`
+	Committer *Identity `json:"committer,omitempty"`
+
+	// Metadata holds free-form key/value pairs, such as the feed version or
+	// source URL of an import. It is encoded in key order, so it does not
+	// affect the stability of the hash.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Signature, if set, signs all the other fields. See Commit.Verify.
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// CommitOptions holds the optional fields of a new commit.
+type CommitOptions struct {
+	// Author and Committer are recorded on the commit. An identity with a
+	// zero When gets the commit's timestamp.
+	Author    *Identity
+	Committer *Identity
+	// Metadata is copied onto the commit. Keys cannot be empty.
+	Metadata map[string]string
+	// Timestamp, if not zero, is used in place of the store's Clock.
+	Timestamp time.Time
+	// Signer, if set, signs the commit.
+	Signer Signer
+}
+
+// apply sets the optional fields of c from opts.
+func (opts *CommitOptions) apply(c *Commit) error {
+	if opts == nil {
+		return nil
+	}
+	identity := func(role string, id *Identity) (*Identity, error) {
+		if id == nil {
+			return nil, nil
+		}
+		if id.Name == "" && id.Email == "" {
+			return nil, fmt.Errorf("%s has neither a name nor an email", role)
+		}
+		out := *id
+		if out.When.IsZero() {
+			out.When = c.Timestamp
+		}
+		out.When = out.When.UTC()
+		return &out, nil
+	}
+	var err error
+	if c.Author, err = identity("author", opts.Author); err != nil {
+		return err
+	}
+	if c.Committer, err = identity("committer", opts.Committer); err != nil {
+		return err
+	}
+	if len(opts.Metadata) > 0 {
+		c.Metadata = make(map[string]string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			if k == "" {
+				return fmt.Errorf("metadata key cannot be empty")
+			}
+			c.Metadata[k] = v
+		}
+	}
+	return nil
 }
 
 // Serialize implements the Object interface for Commit.
@@ -74,22 +110,60 @@ func (c *Commit) Serialize() ([]byte, error) {
 	return json.Marshal(c)
 }
 
+// SerializeFormat implements the FormatSerializer interface for Commit.
+func (c *Commit) SerializeFormat(f Format) ([]byte, error) {
+	if f == FormatJSON {
+		return c.Serialize()
+	}
+	return Encode(f, c)
+}
+
 // CreateCommit is a high-level function that constructs a new Commit object
-// and writes it to the provided ObjectStore.
+// and writes it to the provided ObjectStore, stamped with the time told by
+// the store's Clock.
 // It returns the hash of the newly created commit
-func CreateCommit(store *ObjectStore, treeHash string, message string, parentHashes []string) (string, error) {
+func CreateCommit(store *ObjectStore, treeHash string, message string, parentHashes []string) (hash string, err error) {
+	return CreateCommitWithOptions(store, treeHash, message, parentHashes, nil)
+}
+
+// CreateCommitWithOptions is like CreateCommit, but also records the
+// authorship and metadata in opts, which may be nil.
+func CreateCommitWithOptions(store *ObjectStore, treeHash string, message string, parentHashes []string, opts *CommitOptions) (hash string, err error) {
 	if store == nil {
 		return "", fmt.Errorf("object store cannot be nil")
 	}
-
+	span := store.tracer.StartSpan("merkledb.Commit")
+	span.SetAttribute("tree", treeHash)
+	span.SetAttribute("parents", len(parentHashes))
+	defer func() {
+		span.SetAttribute("hash", hash)
+		span.End(err)
+	}()
+
+	timestamp := store.clock.Now()
+	if opts != nil && !opts.Timestamp.IsZero() {
+		timestamp = opts.Timestamp
+	}
 	commit := &Commit{
 		TreeHash:     treeHash,
 		ParentHashes: parentHashes,
 		Message:      message,
-		Timestamp:    time.Now().UTC(),
+		Timestamp:    timestamp.UTC(),
+	}
+	if err := opts.apply(commit); err != nil {
+		return "", fmt.Errorf("invalid commit options: %w", err)
+	}
+	if opts != nil && opts.Signer != nil {
+		payload, err := commit.SigningPayload()
+		if err != nil {
+			return "", fmt.Errorf("failed to encode commit: %w", err)
+		}
+		if commit.Signature, err = opts.Signer.Sign(payload); err != nil {
+			return "", fmt.Errorf("failed to sign commit: %w", err)
+		}
 	}
 
-	hash, err := store.WriteObject(commit)
+	hash, err = store.WriteObject(commit)
 	if err != nil {
 		return "", fmt.Errorf("failed to write commit: %w", err)
 	}
@@ -5,19 +5,21 @@ package merkledb
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestTree_Serialize_Stability ensures that the serialization of a Tree
 // is deterministic, regardless of the insertion order of its entries.
 func TestTree_Serialize_Stability(t *testing.T) {
 	tree1 := NewTree()
-	tree1.Entries["file.txt"] = "hash_of_file"
-	tree1.Entries["data.csv"] = "hash_of_data"
+	tree1.Entries["file.txt"] = BlobEntry("hash_of_file")
+	tree1.Entries["data.csv"] = BlobEntry("hash_of_data")
 
 	tree2 := NewTree()
-	tree2.Entries["data.csv"] = "hash_of_data"
-	tree2.Entries["file.txt"] = "hash_of_file"
+	tree2.Entries["data.csv"] = BlobEntry("hash_of_data")
+	tree2.Entries["file.txt"] = BlobEntry("hash_of_file")
 
 	bytes1, err1 := tree1.Serialize()
 	if err1 != nil {
@@ -104,3 +106,68 @@ func TestCommit_WithParents(t *testing.T) {
 		t.Errorf("expected parents %v, got %v", parents, decodedCommit.ParentHashes)
 	}
 }
+
+func TestCommit_AuthorshipAndMetadata(t *testing.T) {
+	for _, f := range []Format{FormatJSON, FormatCBOR} {
+		store := NewObjectStore(NewMockStorage(), WithFormat(f))
+		ws, _ := NewWorkspace(store)
+		ws.Add("stops.txt", &mockObject{Data: "stops"})
+
+		written := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		hash, err := ws.CommitWithOptions("Import feed", nil, &CommitOptions{
+			Author:    &Identity{Name: "Ada", Email: "ada@example.com", When: written},
+			Committer: &Identity{Name: "import-bot"},
+			Metadata:  map[string]string{"source-url": "https://example.com/gtfs.zip", "feed-version": "2024-05"},
+		})
+		if err != nil {
+			t.Fatalf("Commit() failed: %v", err)
+		}
+
+		commit, err := store.ReadCommit(hash)
+		if err != nil {
+			t.Fatalf("ReadCommit() failed: %v", err)
+		}
+		if commit.Author == nil || commit.Author.String() != "Ada <ada@example.com>" || !commit.Author.When.Equal(written) {
+			t.Errorf("%s: Author = %+v", f, commit.Author)
+		}
+		// A committer without a time gets the commit's timestamp.
+		if commit.Committer == nil || commit.Committer.Name != "import-bot" || !commit.Committer.When.Equal(commit.Timestamp) {
+			t.Errorf("%s: Committer = %+v, want import-bot at %v", f, commit.Committer, commit.Timestamp)
+		}
+		if commit.Metadata["feed-version"] != "2024-05" || len(commit.Metadata) != 2 {
+			t.Errorf("%s: Metadata = %v", f, commit.Metadata)
+		}
+		if again, _ := store.WriteObject(commit); again != hash {
+			t.Errorf("%s: rewriting a decoded commit gave %s, want %s", f, again, hash)
+		}
+	}
+}
+
+func TestCommit_MetadataIsCanonical(t *testing.T) {
+	c := &Commit{TreeHash: "t", Timestamp: time.Unix(0, 0).UTC(), Metadata: map[string]string{"b": "2", "a": "1"}}
+	data, err := c.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	want := `{"tree":"t","parents":null,"message":"","timestamp":"1970-01-01T00:00:00Z","metadata":{"a":"1","b":"2"}}`
+	if string(data) != want {
+		t.Errorf("Serialize() = %s, want %s", data, want)
+	}
+
+	// Commits without the new fields keep their encoding.
+	c.Metadata = nil
+	data, _ = c.Serialize()
+	if strings.Contains(string(data), "author") || strings.Contains(string(data), "metadata") {
+		t.Errorf("Serialize() of a plain commit = %s", data)
+	}
+}
+
+func TestCommit_InvalidOptions(t *testing.T) {
+	ws, _ := NewWorkspace(NewObjectStore(NewMockStorage()))
+	if _, err := ws.CommitWithOptions("x", nil, &CommitOptions{Author: &Identity{}}); err == nil {
+		t.Error("CommitWithOptions() accepted an author without a name or email")
+	}
+	if _, err := ws.CommitWithOptions("x", nil, &CommitOptions{Metadata: map[string]string{"": "v"}}); err == nil {
+		t.Error("CommitWithOptions() accepted an empty metadata key")
+	}
+}
@@ -0,0 +1,71 @@
+package merkledb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AureClai/merkledb"
+	"github.com/AureClai/merkledb/storage/memstore"
+	"github.com/AureClai/merkledb/storage/storagetest"
+)
+
+// The Storage decorators must keep the contract of the backends they wrap.
+
+func TestInstrumentedStorage_Conformance(t *testing.T) {
+	storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+		return merkledb.NewInstrumentedStorage(memstore.New(nil))
+	})
+	t.Run("Streaming", func(t *testing.T) {
+		storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+			return merkledb.NewInstrumentedStorage(storagetest.NewStreamingStore())
+		})
+	})
+}
+
+func TestTieredStorage_Conformance(t *testing.T) {
+	modes := map[string]merkledb.WriteMode{"WriteThrough": merkledb.WriteThrough, "WriteBack": merkledb.WriteBack}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+				s, err := merkledb.NewTieredStorage(memstore.New(nil), memstore.New(nil), merkledb.TieredOptions{
+					Mode:          mode,
+					FlushInterval: time.Millisecond,
+				})
+				if err != nil {
+					tb.Fatalf("NewTieredStorage() failed: %v", err)
+				}
+				tb.Cleanup(func() { s.Close() })
+				return s
+			})
+		})
+	}
+}
+
+func TestReplicatedStorage_Conformance(t *testing.T) {
+	storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+		replicas := []merkledb.Storage{memstore.New(nil), memstore.New(nil), memstore.New(nil)}
+		s, err := merkledb.NewReplicatedStorage(replicas, merkledb.ReplicatedOptions{
+			// The suite stores arbitrary values, not content, under its keys.
+			Verify: func(key, value []byte) bool { return true },
+		})
+		if err != nil {
+			tb.Fatalf("NewReplicatedStorage() failed: %v", err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestShardedStorage_Conformance(t *testing.T) {
+	storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+		s, err := merkledb.NewShardedStorage([]merkledb.Shard{
+			{Name: "a", Storage: memstore.New(nil)},
+			{Name: "b", Storage: memstore.New(nil)},
+			{Name: "c", Storage: memstore.New(nil)},
+		}, merkledb.ShardedOptions{})
+		if err != nil {
+			tb.Fatalf("NewShardedStorage() failed: %v", err)
+		}
+		return s
+	})
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 	"time"
 
 	"github.com/AureClai/merkledb"
+	"github.com/AureClai/merkledb/storage/memstore"
 )
 
 // --- A User-Defined Data Structure ---
@@ -19,47 +19,18 @@ type User struct {
 
 // Serialize implements the Object interface for User.
 func (u *User) Serialize() ([]byte, error) {
-	return json.Marshal(u)
-}
-
-// --- A Simple In-Memory Storage for this Example ---
-
-// inMemoryStorage is a simple in-memory storage of the merkledb.Storage interface.
-// NOTE: This is for demonstration purposes only. It's the same as the mock
-// storage used in the tests. A real application would use a persistent storage
-// backend like the filesystem or a database.
-type inMemoryStorage struct {
-	data map[string][]byte
-}
-
-func newInMemoryStorage() *inMemoryStorage {
-	return &inMemoryStorage{
-		data: make(map[string][]byte),
-	}
-}
-func (s *inMemoryStorage) Put(key []byte, value []byte) error {
-	s.data[string(key)] = value
-	return nil
-}
-func (s *inMemoryStorage) Get(key []byte) ([]byte, error) {
-	value, ok := s.data[string(key)]
-	if !ok {
-		return nil, merkledb.ErrNotFound
-	}
-	return value, nil
-}
-func (s *inMemoryStorage) Exists(key []byte) (bool, error) {
-	_, ok := s.data[string(key)]
-	return ok, nil
+	// Canonical, unlike json.Marshal, guarantees a stable encoding.
+	return merkledb.Canonical(u)
 }
 
 func main() {
 	log.Println("--- MerkleDB Phase 1 Example ---")
 
 	// 1. Set up the storage backend.
-	// We're using our simple in-memory storage for this example.
+	// We're using the in-memory storage for this example. A real application
+	// would use a persistent backend such as storage/btree.
 	log.Println("Step 1: Initializing in-memory storage backend")
-	storage := newInMemoryStorage()
+	storage := memstore.New(nil)
 
 	// 2. Initialize the ObjectStore.
 	// This is the core engine that handles hashing and storage
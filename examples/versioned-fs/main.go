@@ -3,11 +3,11 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 	"time"
 
 	"github.com/AureClai/merkledb" // <-- IMPORTANT: Replace with your module path
+	"github.com/AureClai/merkledb/storage/memstore"
 )
 
 // --- A User-Defined Data Structure for our "Files" ---
@@ -19,31 +19,8 @@ type FileNode struct {
 
 // Serialize implements the merkledb.Object interface for our FileNode.
 func (f *FileNode) Serialize() ([]byte, error) {
-	return json.Marshal(f)
-}
-
-// --- In-Memory Storage (copied from previous example for simplicity) ---
-type inMemoryStorage struct {
-	data map[string][]byte
-}
-
-func newInMemoryStorage() *inMemoryStorage {
-	return &inMemoryStorage{data: make(map[string][]byte)}
-}
-func (s *inMemoryStorage) Put(key []byte, value []byte) error {
-	s.data[string(key)] = value
-	return nil
-}
-func (s *inMemoryStorage) Get(key []byte) ([]byte, error) {
-	value, ok := s.data[string(key)]
-	if !ok {
-		return nil, merkledb.ErrNotFound
-	}
-	return value, nil
-}
-func (s *inMemoryStorage) Exists(key []byte) (bool, error) {
-	_, ok := s.data[string(key)]
-	return ok, nil
+	// Canonical, unlike json.Marshal, guarantees a stable encoding.
+	return merkledb.Canonical(f)
 }
 
 // --- Main Application Logic ---
@@ -52,8 +29,10 @@ func main() {
 	log.Println("--- MerkleDB Phase 2 Example: Versioning a File System ---")
 
 	// Setup the store
-	storage := newInMemoryStorage()
-	store := merkledb.NewObjectStore(storage)
+	storage := memstore.New(nil)
+	// A fixed starting time makes every run produce the same commit hashes.
+	clock := merkledb.StepClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Minute)
+	store := merkledb.NewObjectStore(storage, merkledb.WithClock(clock))
 
 	// --- Step 1: Create the First Commit ---
 	log.Println("\n=== STEP 1: Creating the Initial Commit ===")
@@ -70,8 +49,8 @@ func main() {
 
 	// 1c. Create a 'Tree' to represent the root directory
 	rootTree_v1 := merkledb.NewTree()
-	rootTree_v1.Entries["file_a.txt"] = hashA_v1
-	rootTree_v1.Entries["file_b.txt"] = hashB_v1
+	rootTree_v1.Entries["file_a.txt"] = merkledb.BlobEntry(hashA_v1)
+	rootTree_v1.Entries["file_b.txt"] = merkledb.BlobEntry(hashB_v1)
 	log.Println("   - Created a root tree object to represent the directory.")
 
 	// 1d. Write the tree object to the store to get its hash
@@ -85,7 +64,6 @@ func main() {
 
 	// --- Step 2: Create a Second Commit with an updated file ---
 	log.Println("\n=== STEP 2: Creating a Second Commit (Updating a File) ===")
-	time.Sleep(1 * time.Second) // Ensure timestamp is different
 
 	// 2a. Create a new version of file A. File B remains unchanged.
 	fileA_v2 := &FileNode{Content: "This is file A, with new and improved content in version 2."}
@@ -97,8 +75,8 @@ func main() {
 
 	// 2c. Create a new Tree for the new state of the root directory
 	rootTree_v2 := merkledb.NewTree()
-	rootTree_v2.Entries["file_a.txt"] = hashA_v2 // <-- New hash for file A
-	rootTree_v2.Entries["file_b.txt"] = hashB_v1 // <-- Re-using the old hash for file B
+	rootTree_v2.Entries["file_a.txt"] = merkledb.BlobEntry(hashA_v2) // <-- New hash for file A
+	rootTree_v2.Entries["file_b.txt"] = merkledb.BlobEntry(hashB_v1) // <-- Re-using the old hash for file B
 	log.Println("   - Created a new root tree with the updated file hash.")
 
 	// 2d. Write the new tree to the store to get its hash
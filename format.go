@@ -0,0 +1,91 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/AureClai/merkledb/cbor"
+)
+
+// Format is an encoding for the objects of a store.
+type Format int
+
+const (
+	// FormatJSON encodes objects as JSON. It is the default.
+	FormatJSON Format = iota
+	// FormatCBOR encodes objects as deterministic CBOR (RFC 8949), prefixed
+	// with the self-described CBOR tag so readers can tell it from JSON. It
+	// is more compact than JSON and represents binary data and numbers
+	// exactly.
+	FormatCBOR
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatCBOR:
+		return "cbor"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// WithFormat sets the format the ObjectStore writes Trees, Commits and other
+// objects implementing FormatSerializer in. Objects are read in whatever
+// format they were written, so a store can switch formats at any time and
+// hold a mix of both. Since the format is part of an object's content, the
+// same tree written in two formats has two hashes.
+func WithFormat(f Format) ObjectStoreOption {
+	return func(s *ObjectStore) {
+		s.format = f
+	}
+}
+
+// FormatSerializer is implemented by objects that can serialize themselves
+// in a chosen format. The ObjectStore uses it in place of Serialize when its
+// format is not FormatJSON.
+type FormatSerializer interface {
+	Object
+	SerializeFormat(f Format) ([]byte, error)
+}
+
+// Encode returns the deterministic encoding of v in format f: Canonical JSON
+// for FormatJSON, and marked deterministic CBOR for FormatCBOR.
+func Encode(f Format, v any) ([]byte, error) {
+	switch f {
+	case FormatJSON:
+		return Canonical(v)
+	case FormatCBOR:
+		data, err := cbor.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]byte(nil), cbor.SelfDescribePrefix...), data...), nil
+	}
+	return nil, fmt.Errorf("unknown format %v", f)
+}
+
+// DetectFormat returns the format of an encoded object.
+func DetectFormat(data []byte) Format {
+	if bytes.HasPrefix(data, cbor.SelfDescribePrefix) {
+		return FormatCBOR
+	}
+	return FormatJSON
+}
+
+// Decode decodes an object encoded in either format into v.
+func Decode(data []byte, v any) error {
+	if DetectFormat(data) == FormatCBOR {
+		return cbor.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// serialize returns the encoding of obj in the store's format.
+func (s *ObjectStore) serialize(obj Object) ([]byte, error) {
+	if fs, ok := obj.(FormatSerializer); ok && s.format != FormatJSON {
+		return fs.SerializeFormat(s.format)
+	}
+	return obj.Serialize()
+}
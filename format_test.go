@@ -0,0 +1,177 @@
+package merkledb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AureClai/merkledb/cbor"
+)
+
+func TestEncode_Formats(t *testing.T) {
+	value := map[string]any{"name": "ada", "score": 1.5}
+
+	j, err := Encode(FormatJSON, value)
+	if err != nil || string(j) != `{"name":"ada","score":1.5}` || DetectFormat(j) != FormatJSON {
+		t.Errorf("Encode(FormatJSON) = %s, %v", j, err)
+	}
+	c, err := Encode(FormatCBOR, value)
+	if err != nil || !bytes.HasPrefix(c, cbor.SelfDescribePrefix) || DetectFormat(c) != FormatCBOR {
+		t.Errorf("Encode(FormatCBOR) = %x, %v; want a self-described CBOR item", c, err)
+	}
+	if len(c) >= len(j) {
+		t.Errorf("CBOR encoding is %d bytes, JSON %d; want CBOR smaller", len(c), len(j))
+	}
+
+	for _, data := range [][]byte{j, c} {
+		var got map[string]any
+		if err := Decode(data, &got); err != nil || got["name"] != "ada" || got["score"] != 1.5 {
+			t.Errorf("Decode(%s encoding) = %v, %v", DetectFormat(data), got, err)
+		}
+	}
+	if _, err := Encode(Format(9), value); err == nil {
+		t.Error("Encode() accepted an unknown format")
+	}
+}
+
+func TestObjectStore_CBORFormat(t *testing.T) {
+	store := NewObjectStore(NewMockStorage(), WithFormat(FormatCBOR))
+	ws, _ := NewWorkspace(store)
+	ws.Add("a", &mockObject{Data: "first"})
+	first, err := ws.Commit("first", nil)
+	if err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	commit, err := store.ReadCommit(first)
+	if err != nil {
+		t.Fatalf("ReadCommit() failed: %v", err)
+	}
+	raw, _ := store.ReadRawObject(first)
+	if DetectFormat(raw) != FormatCBOR {
+		t.Errorf("commit written in %s, want cbor", DetectFormat(raw))
+	}
+	if commit.Message != "first" || commit.Timestamp.IsZero() {
+		t.Errorf("ReadCommit() = %+v, want the written commit", commit)
+	}
+	tree, err := store.ReadTree(commit.TreeHash)
+	if err != nil || len(tree.Entries) != 1 {
+		t.Fatalf("ReadTree() = %v, %v; want one entry", tree, err)
+	}
+
+	// A commit decoded from CBOR re-encodes to the same hash.
+	again, err := store.WriteObject(commit)
+	if err != nil || again != first {
+		t.Errorf("rewriting a decoded commit gave %s, %v; want %s", again, err, first)
+	}
+}
+
+func TestObjectStore_MixedFormatHistory(t *testing.T) {
+	storage := NewMockStorage()
+	jsonStore := NewObjectStore(storage)
+	cborStore := NewObjectStore(storage, WithFormat(FormatCBOR))
+
+	// History alternates between the two formats.
+	var parents []string
+	var commits []string
+	for i, store := range []*ObjectStore{jsonStore, cborStore, jsonStore, cborStore} {
+		ws, _ := NewWorkspace(store)
+		ws.Add("file", &mockObject{Data: fmt.Sprint("version ", i)})
+		hash, err := ws.Commit(fmt.Sprint("commit ", i), parents)
+		if err != nil {
+			t.Fatalf("Commit() failed: %v", err)
+		}
+		parents = []string{hash}
+		commits = append(commits, hash)
+	}
+
+	for _, store := range []*ObjectStore{jsonStore, cborStore} {
+		visited := 0
+		err := WalkHistory(store, commits[3], func(hash string, c *Commit) error {
+			visited++
+			return nil
+		})
+		if err != nil || visited != 4 {
+			t.Errorf("WalkHistory() visited %d commits, %v; want 4", visited, err)
+		}
+		if err := VerifyHistory(store, commits[3]); err != nil {
+			t.Errorf("VerifyHistory() of a mixed history failed: %v", err)
+		}
+	}
+}
+
+func TestJSONObject_CBORFormat(t *testing.T) {
+	type sample struct {
+		Blob  []byte `json:"blob"`
+		Count int64  `json:"count"`
+	}
+	store := NewObjectStore(NewMockStorage(), WithFormat(FormatCBOR))
+	// CBOR keeps integers beyond 2^53 exact, which canonical JSON refuses.
+	value := sample{Blob: []byte{0xff, 0x00}, Count: 1<<62 + 1}
+	hash, err := store.WriteObject(&JSONObject[sample]{Value: value})
+	if err != nil {
+		t.Fatalf("WriteObject() failed: %v", err)
+	}
+	got, err := ReadJSONObject[sample](store, hash)
+	if err != nil || !bytes.Equal(got.Blob, value.Blob) || got.Count != value.Count {
+		t.Errorf("ReadJSONObject() = %+v, %v; want %+v", got, err, value)
+	}
+}
+
+// benchmarkObjects returns a large tree and a merge commit, typical of the
+// objects written on every commit.
+func benchmarkObjects() map[string]Object {
+	tree := NewTree()
+	for i := 0; i < 1000; i++ {
+		sum := sha256.Sum256([]byte(fmt.Sprint(i)))
+		tree.Entries[fmt.Sprintf("routes/route-%04d.json", i)] = BlobEntry(fmt.Sprintf("%x", sum))
+	}
+	commit := &Commit{
+		TreeHash:     fmt.Sprintf("%x", sha256.Sum256([]byte("tree"))),
+		ParentHashes: []string{fmt.Sprintf("%x", sha256.Sum256([]byte("p1"))), fmt.Sprintf("%x", sha256.Sum256([]byte("p2")))},
+		Message:      "Merge feed update",
+		Timestamp:    time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+	return map[string]Object{"Tree": tree, "Commit": commit}
+}
+
+// BenchmarkFormat compares the encoded size (reported as bytes/object) and
+// the encode-and-hash throughput of the two formats.
+func BenchmarkFormat(b *testing.B) {
+	for _, name := range []string{"Tree", "Commit"} {
+		obj := benchmarkObjects()[name].(FormatSerializer)
+		for _, f := range []Format{FormatJSON, FormatCBOR} {
+			b.Run(fmt.Sprintf("%s/%s", name, f), func(b *testing.B) {
+				data, err := obj.SerializeFormat(f)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					data, _ := obj.SerializeFormat(f)
+					sha256.Sum256(data)
+				}
+				b.ReportMetric(float64(len(data)), "bytes/object")
+			})
+		}
+	}
+}
+
+// BenchmarkDecode compares decoding speed.
+func BenchmarkDecode(b *testing.B) {
+	tree := benchmarkObjects()["Tree"].(*Tree)
+	for _, f := range []Format{FormatJSON, FormatCBOR} {
+		b.Run(f.String(), func(b *testing.B) {
+			data, _ := tree.SerializeFormat(f)
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeTree("", data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package merkledb
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// GCOptions configures CollectGarbage.
+type GCOptions struct {
+	// Refs holds the roots of the collection: every object reachable from a
+	// ref is kept. If Refs is also a ReflogStore, as a LoggedRefStore is,
+	// the objects its reflog entries point to are kept too, until the
+	// entries expire. Refs and reflog entries must point at commits or
+	// tags; a tree or blob is kept through a ref by tagging it.
+	Refs RefStore
+	// Roots are further objects to keep.
+	Roots []GCRoot
+	// ReflogExpiry, if set, drops the reflog entries that have expired under
+	// it, at the time told by the store's Clock, before collecting.
+	ReflogExpiry *ReflogExpiry
+	// DryRun reports what would be deleted without changing anything.
+	DryRun bool
+}
+
+// GCRoot is an object CollectGarbage keeps, along with what it refers to.
+type GCRoot struct {
+	Hash string
+	// Kind is the kind of the object. An empty Kind is a commit.
+	Kind TargetKind
+}
+
+// GCStats reports the outcome of CollectGarbage.
+type GCStats struct {
+	// Reachable is the number of objects and chunks kept.
+	Reachable int
+	// Deleted and DeletedBytes are the number and total size of the values
+	// deleted, or that would be on a dry run.
+	Deleted      int
+	DeletedBytes int64
+	// ExpiredReflogEntries is the number of reflog entries dropped.
+	ExpiredReflogEntries int
+}
+
+// CollectGarbage deletes the objects of the store that cannot be reached
+// from the refs, reflogs and roots in opts, including the chunks of chunked
+// objects. Its storage must implement Iterator and Deleter.
+//
+// Objects are reachable through commits, their parents and trees, subtrees,
+// tags and their targets. CollectGarbage fails without deleting any object if
+// a reachable object is missing. It must not run while objects are being
+// written, since an object written for a commit that is not referenced yet
+// would be collected.
+func CollectGarbage(store *ObjectStore, opts *GCOptions) (stats GCStats, err error) {
+	if store == nil {
+		return stats, fmt.Errorf("object store cannot be nil")
+	}
+	if opts == nil {
+		opts = &GCOptions{}
+	}
+	it, ok := AsIterator(store.storage)
+	if !ok {
+		return stats, fmt.Errorf("storage cannot list objects")
+	}
+	deleter, ok := AsDeleter(store.storage)
+	if !ok {
+		return stats, fmt.Errorf("storage cannot delete objects")
+	}
+	span := store.tracer.StartSpan("merkledb.CollectGarbage")
+	defer func() {
+		span.SetAttribute("reachable", stats.Reachable)
+		span.SetAttribute("deleted", stats.Deleted)
+		span.End(err)
+	}()
+
+	roots, err := gcRoots(store, opts, &stats)
+	if err != nil {
+		return stats, err
+	}
+	m := &marker{store: store, reachable: make(map[string]bool), chunks: make(map[string]bool)}
+	for _, root := range roots {
+		if err := m.mark(root.hash, root.kind); err != nil {
+			return stats, fmt.Errorf("failed to mark objects reachable from %s: %w", root.hash, err)
+		}
+	}
+	stats.Reachable = len(m.reachable)
+	for chunk := range m.chunks {
+		if !m.reachable[chunk] {
+			stats.Reachable++
+		}
+	}
+
+	var garbage [][]byte
+	err = it.Iterate(nil, nil, func(key, value []byte) error {
+		if h := hex.EncodeToString(key); !m.reachable[h] && !m.chunks[h] {
+			garbage = append(garbage, key)
+			stats.DeletedBytes += int64(len(value))
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to list objects: %w", err)
+	}
+	stats.Deleted = len(garbage)
+	if opts.DryRun {
+		return stats, nil
+	}
+	for _, key := range garbage {
+		if err := deleter.Delete(key); err != nil {
+			return stats, fmt.Errorf("failed to delete object %x: %w", key, err)
+		}
+	}
+	return stats, nil
+}
+
+// gcRoot is an object CollectGarbage starts marking from.
+type gcRoot struct {
+	hash string
+	kind objectKind
+}
+
+// gcRoots returns the objects CollectGarbage starts marking from, expiring
+// reflog entries on the way.
+func gcRoots(store *ObjectStore, opts *GCOptions, stats *GCStats) ([]gcRoot, error) {
+	var roots []gcRoot
+	for _, root := range opts.Roots {
+		kind := objectCommit
+		switch root.Kind {
+		case "", TargetCommit:
+		case TargetTree, TargetBlob, TargetTag:
+			kind = tagTargetKind(root.Kind)
+		default:
+			return nil, fmt.Errorf("root %s has unknown kind %q", root.Hash, root.Kind)
+		}
+		roots = append(roots, gcRoot{root.Hash, kind})
+	}
+	if opts.Refs == nil {
+		return roots, nil
+	}
+	refs, err := opts.Refs.ListRefs("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	for _, ref := range refs {
+		roots = append(roots, gcRoot{ref.Hash, objectRef})
+	}
+
+	logs, ok := opts.Refs.(ReflogStore)
+	if !ok {
+		return roots, nil
+	}
+	names, err := logs.ListReflogs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reflogs: %w", err)
+	}
+	now := store.clock.Now()
+	for _, name := range names {
+		var entries []ReflogEntry
+		if opts.ReflogExpiry != nil && !opts.DryRun {
+			err = logs.ExpireReflog(name, func(all []ReflogEntry) []ReflogEntry {
+				entries = opts.ReflogExpiry.live(all, now)
+				stats.ExpiredReflogEntries += len(all) - len(entries)
+				return entries
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to expire reflog of %s: %w", name, err)
+			}
+		} else {
+			if entries, err = logs.ReadReflog(name); err != nil {
+				return nil, fmt.Errorf("failed to read reflog of %s: %w", name, err)
+			}
+			if opts.ReflogExpiry != nil {
+				live := opts.ReflogExpiry.live(entries, now)
+				stats.ExpiredReflogEntries += len(entries) - len(live)
+				entries = live
+			}
+		}
+		for _, e := range entries {
+			for _, hash := range []string{e.Old, e.New} {
+				if hash != "" {
+					roots = append(roots, gcRoot{hash, objectRef})
+				}
+			}
+		}
+	}
+	return roots, nil
+}
+
+// marker marks the objects reachable from a set of roots.
+type marker struct {
+	store *ObjectStore
+	// reachable holds the objects visited, and chunks the chunks of the
+	// chunked ones, which are not objects themselves.
+	reachable map[string]bool
+	chunks    map[string]bool
+}
+
+// objectKind is the kind of an object as far as reachability goes.
+type objectKind string
+
+const (
+	// objectRef is the target of a ref or reflog entry: a tag, which is
+	// marked as one, or else a commit.
+	objectRef    objectKind = "ref"
+	objectCommit objectKind = "commit"
+	objectTree   objectKind = "tree"
+	objectTag    objectKind = "tag"
+	objectBlob   objectKind = "blob"
+)
+
+// mark marks hash, an object of the given kind, and what it refers to.
+func (m *marker) mark(hash string, kind objectKind) error {
+	type item struct {
+		hash string
+		kind objectKind
+	}
+	stack := []item{{hash, kind}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if m.reachable[it.hash] {
+			continue
+		}
+
+		key, err := hex.DecodeString(it.hash)
+		if err != nil {
+			return fmt.Errorf("invalid hash %q: %w", it.hash, err)
+		}
+		raw, err := m.store.storage.Get(key)
+		if err != nil {
+			return fmt.Errorf("object %s: %w", it.hash, err)
+		}
+		m.reachable[it.hash] = true
+		if manifest, ok := parseChunkManifest(raw); ok {
+			for _, chunk := range manifest.Chunks {
+				m.chunks[chunk.Hash] = true
+			}
+		}
+		if it.kind == objectBlob {
+			continue
+		}
+
+		data, err := m.store.ReadRawObject(it.hash)
+		if err != nil {
+			return fmt.Errorf("object %s: %w", it.hash, err)
+		}
+		switch it.kind {
+		case objectRef, objectTag:
+			if tag, ok := decodeTag(data); ok {
+				stack = append(stack, item{tag.Target, tagTargetKind(tag.TargetKind)})
+				continue
+			}
+			if it.kind == objectTag {
+				return fmt.Errorf("object %s is not a tag", it.hash)
+			}
+			fallthrough
+		case objectCommit:
+			commit, ok := decodeCommit(data)
+			if !ok {
+				return fmt.Errorf("object %s is not a commit", it.hash)
+			}
+			stack = append(stack, item{commit.TreeHash, objectTree})
+			for _, parent := range commit.ParentHashes {
+				stack = append(stack, item{parent, objectCommit})
+			}
+		case objectTree:
+			tree, err := decodeTree(it.hash, data)
+			if err != nil {
+				return err
+			}
+			for _, entry := range tree.Entries {
+				switch entry.Kind {
+				case KindLink:
+				case KindTree:
+					stack = append(stack, item{entry.Hash, objectTree})
+				default:
+					stack = append(stack, item{entry.Hash, objectBlob})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decodeCommit decodes data as a commit. Every commit has a tree and a
+// timestamp, and the timestamp tells it from a tree with entries named like
+// the fields of a commit, whose values are hashes.
+func decodeCommit(data []byte) (*Commit, bool) {
+	var commit Commit
+	if err := Decode(data, &commit); err != nil || commit.TreeHash == "" || commit.Timestamp.IsZero() {
+		return nil, false
+	}
+	return &commit, true
+}
+
+// tagTargetKind returns the kind of the target of a tag.
+func tagTargetKind(kind TargetKind) objectKind {
+	switch kind {
+	case TargetCommit:
+		return objectCommit
+	case TargetTree:
+		return objectTree
+	case TargetTag:
+		return objectTag
+	}
+	return objectBlob
+}
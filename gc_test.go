@@ -0,0 +1,201 @@
+package merkledb
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gcFixture commits two revisions of a workspace holding a subtree and a
+// chunked object to main, and writes an unreferenced object.
+func gcFixture(t *testing.T) (*ObjectStore, *lockedStorage, *LoggedRefStore, map[string]string) {
+	t.Helper()
+	clock := StepClock(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 24*time.Hour)
+	g := newCommitGraph(t, WithClock(clock), WithChunking(ChunkingOptions{Threshold: 16 << 10, AvgSize: 4 << 10}))
+	refs := NewLoggedRefStore(g.refs, g.refs, &ReflogOptions{Clock: clock})
+	h := g.h
+
+	var parents []string
+	for _, name := range []string{"c1", "c2"} {
+		h[name+"/file"] = g.write(&mockObject{Data: "file in " + name})
+		files := map[string]string{"name": name, "big": strings.Repeat(name+" is a large object. ", 4<<10)}
+		dir := g.tree(map[string]TreeEntry{"file": BlobEntry(h[name+"/file"])})
+		parents = []string{g.commit(name, files, map[string]TreeEntry{"dir": TreeEntryOf(dir)}, parents, nil)}
+	}
+	refs.WriteRef(HeadsPrefix+"main", h["c2"])
+	h["garbage"] = g.write(&mockObject{Data: "unreferenced"})
+	return g.store, g.storage, refs, h
+}
+
+func storageHas(s *lockedStorage, hash string) bool {
+	key, _ := hex.DecodeString(hash)
+	ok, _ := s.Exists(key)
+	return ok
+}
+
+func TestCollectGarbage(t *testing.T) {
+	store, storage, refs, h := gcFixture(t)
+	before := len(storage.data)
+
+	dry, err := CollectGarbage(store, &GCOptions{Refs: refs, DryRun: true})
+	if err != nil {
+		t.Fatalf("CollectGarbage(DryRun) failed: %v", err)
+	}
+	if dry.Deleted != 1 || dry.DeletedBytes == 0 || len(storage.data) != before {
+		t.Errorf("dry run = %+v with %d of %d values left, want 1 deletion and none done", dry, len(storage.data), before)
+	}
+
+	stats, err := CollectGarbage(store, &GCOptions{Refs: refs})
+	if err != nil {
+		t.Fatalf("CollectGarbage() failed: %v", err)
+	}
+	if stats != dry {
+		t.Errorf("CollectGarbage() = %+v, dry run said %+v", stats, dry)
+	}
+	if stats.Reachable != len(storage.data) {
+		t.Errorf("Reachable = %d, but %d values are left", stats.Reachable, len(storage.data))
+	}
+	if storageHas(storage, h["garbage"]) {
+		t.Error("unreferenced object was not collected")
+	}
+	// Everything main reaches, chunks included, can still be read.
+	if err := VerifyHistory(store, h["c2"]); err != nil {
+		t.Errorf("history is damaged after collection: %v", err)
+	}
+	c2, _ := store.ReadCommit(h["c2"])
+	tree, _ := store.ReadTree(c2.TreeHash)
+	if _, err := store.ReadRawObject(tree.Entries["big"].Hash); err != nil {
+		t.Errorf("chunked object is damaged after collection: %v", err)
+	}
+}
+
+func TestCollectGarbage_Reflog(t *testing.T) {
+	store, storage, refs, h := gcFixture(t)
+	// Moving main back leaves c2 reachable only from the reflog.
+	if err := refs.WriteRef(HeadsPrefix+"main", h["c1"]); err != nil {
+		t.Fatalf("WriteRef() failed: %v", err)
+	}
+	if _, err := CollectGarbage(store, &GCOptions{Refs: refs}); err != nil {
+		t.Fatalf("CollectGarbage() failed: %v", err)
+	}
+	if !storageHas(storage, h["c2"]) || !storageHas(storage, h["c2/file"]) {
+		t.Fatal("objects reachable from the reflog were collected")
+	}
+
+	// The store's clock is days ahead of the reflog entries now.
+	stats, err := CollectGarbage(store, &GCOptions{Refs: refs, ReflogExpiry: &ReflogExpiry{MaxAge: time.Hour}})
+	if err != nil {
+		t.Fatalf("CollectGarbage() failed: %v", err)
+	}
+	if stats.ExpiredReflogEntries != 2 {
+		t.Errorf("ExpiredReflogEntries = %d, want 2", stats.ExpiredReflogEntries)
+	}
+	if storageHas(storage, h["c2/file"]) {
+		t.Error("object only reachable from an expired reflog entry was kept")
+	}
+	if !storageHas(storage, h["c1/file"]) {
+		t.Error("object reachable from main was collected")
+	}
+}
+
+func TestCollectGarbage_TagsAndRoots(t *testing.T) {
+	store, storage, refs, h := gcFixture(t)
+	refs.WriteRef(HeadsPrefix+"main", h["c1"])
+	refs.WriteReflog(HeadsPrefix+"main", nil)
+	tag, err := CreateTag(store, refs, "file", h["c2/file"], "", &TagOptions{Kind: TargetBlob})
+	if err != nil {
+		t.Fatalf("CreateTag() failed: %v", err)
+	}
+
+	if _, err := CollectGarbage(store, &GCOptions{Refs: refs, Roots: []GCRoot{{Hash: h["garbage"], Kind: TargetBlob}}}); err != nil {
+		t.Fatalf("CollectGarbage() failed: %v", err)
+	}
+	for _, hash := range []string{tag, h["c2/file"], h["garbage"], h["c1"]} {
+		if !storageHas(storage, hash) {
+			t.Errorf("reachable object %s was collected", hash)
+		}
+	}
+	if storageHas(storage, h["c2"]) {
+		t.Error("commit only reachable from a deleted reflog was kept")
+	}
+}
+
+func TestCollectGarbage_TreeRoot(t *testing.T) {
+	store, storage, refs, _ := gcFixture(t)
+	// Entries named like the fields of a commit must not make the tree
+	// decode as one.
+	file, _ := store.WriteObject(&mockObject{Data: "only in a tree"})
+	sub := NewTree()
+	sub.Entries["file"] = BlobEntry(file)
+	subHash, _ := store.WriteObject(sub)
+	for _, entries := range []map[string]TreeEntry{
+		{"tree": BlobEntry(file), "parents": BlobEntry(subHash)},
+		{"tree": BlobEntry(file), "parents": TreeEntryOf(subHash)},
+	} {
+		tree := NewTree()
+		for name, e := range entries {
+			tree.Entries[name] = e
+		}
+		treeHash, _ := store.WriteObject(tree)
+
+		roots := []GCRoot{{Hash: treeHash, Kind: TargetTree}}
+		if _, err := CollectGarbage(store, &GCOptions{Refs: refs, Roots: roots}); err != nil {
+			t.Fatalf("CollectGarbage() failed: %v", err)
+		}
+		for _, hash := range []string{treeHash, subHash, file} {
+			if !storageHas(storage, hash) {
+				t.Errorf("object %s reachable from a tree root was collected", hash)
+			}
+		}
+	}
+}
+
+func TestCollectGarbage_TaggedTree(t *testing.T) {
+	store, storage, refs, _ := gcFixture(t)
+	file, _ := store.WriteObject(&mockObject{Data: "only in a tree"})
+	tree := NewTree()
+	tree.Entries["tree"] = BlobEntry(file)
+	treeHash, _ := store.WriteObject(tree)
+	if _, err := CreateTag(store, refs, "snapshot", treeHash, "", &TagOptions{Kind: TargetTree}); err != nil {
+		t.Fatalf("CreateTag() failed: %v", err)
+	}
+
+	if _, err := CollectGarbage(store, &GCOptions{Refs: refs}); err != nil {
+		t.Fatalf("CollectGarbage() failed: %v", err)
+	}
+	for _, hash := range []string{treeHash, file} {
+		if !storageHas(storage, hash) {
+			t.Errorf("object %s reachable from a tagged tree was collected", hash)
+		}
+	}
+}
+
+func TestCollectGarbage_Errors(t *testing.T) {
+	if _, err := CollectGarbage(NewObjectStore(NewMockStorage()), nil); err == nil {
+		t.Error("CollectGarbage() accepted a storage that cannot list objects")
+	}
+
+	store, storage, refs, h := gcFixture(t)
+	key, _ := hex.DecodeString(h["c1/file"])
+	storage.Delete(key)
+	before := len(storage.data)
+	if _, err := CollectGarbage(store, &GCOptions{Refs: refs}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CollectGarbage() with a missing object returned %v, want ErrNotFound", err)
+	}
+	if len(storage.data) != before {
+		t.Error("CollectGarbage() deleted objects although it failed")
+	}
+
+	// A ref must point at a commit or a tag.
+	store, storage, refs, h = gcFixture(t)
+	refs.WriteRef("refs/heads/blob", h["garbage"])
+	before = len(storage.data)
+	if _, err := CollectGarbage(store, &GCOptions{Refs: refs}); err == nil {
+		t.Error("CollectGarbage() accepted a ref to a blob")
+	}
+	if len(storage.data) != before {
+		t.Error("CollectGarbage() deleted objects although it failed")
+	}
+}
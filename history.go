@@ -0,0 +1,187 @@
+package merkledb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrStopWalk can be returned by a WalkHistory callback to end the walk early
+// without an error.
+var ErrStopWalk = errors.New("stop walk")
+
+// ErrCorruptObject is returned by VerifyHistory when a stored object does not
+// hash to the key it is stored under.
+var ErrCorruptObject = errors.New("object content does not match its hash")
+
+// ReadCommit reads and decodes the commit with the given hex-encoded hash.
+func (s *ObjectStore) ReadCommit(hash string) (*Commit, error) {
+	data, err := s.ReadRawObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	var commit Commit
+	if err := Decode(data, &commit); err != nil {
+		return nil, fmt.Errorf("failed to decode commit %s: %w", hash, err)
+	}
+	if commit.TreeHash == "" {
+		return nil, fmt.Errorf("object %s is not a commit", hash)
+	}
+	return &commit, nil
+}
+
+// ReadTree reads and decodes the tree with the given hex-encoded hash.
+func (s *ObjectStore) ReadTree(hash string) (*Tree, error) {
+	data, err := s.ReadRawObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTree(hash, data)
+}
+
+// WalkHistory calls fn for the commit with the given hash and every commit
+// reachable from it through parent links. Each commit is visited once, in
+// breadth-first order, so a commit is always visited before its parents on
+// a linear history. If fn returns ErrStopWalk the walk ends and WalkHistory
+// returns nil; any other error ends the walk and is returned.
+func WalkHistory(store *ObjectStore, from string, fn func(hash string, commit *Commit) error) (err error) {
+	if store == nil {
+		return fmt.Errorf("object store cannot be nil")
+	}
+	span := store.tracer.StartSpan("merkledb.WalkHistory")
+	span.SetAttribute("from", from)
+	visited := 0
+	defer func() {
+		span.SetAttribute("commits", visited)
+		span.End(err)
+	}()
+
+	seen := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		commit, err := store.ReadCommit(hash)
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", hash, err)
+		}
+		visited++
+		if err := fn(hash, commit); err != nil {
+			if errors.Is(err, ErrStopWalk) {
+				return nil
+			}
+			return err
+		}
+		for _, parent := range commit.ParentHashes {
+			if !seen[parent] {
+				seen[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return nil
+}
+
+// LogEntry is a commit visited by Log.
+type LogEntry struct {
+	Hash   string
+	Commit *Commit
+	// Signature is the status of the commit's signature. It is
+	// SignatureUnchecked unless Log was given a TrustStore.
+	Signature SignatureStatus
+	// Signer is the name of the trusted key that signed the commit, if
+	// Signature is SignatureGood.
+	Signer string
+}
+
+// LogOptions configures Log.
+type LogOptions struct {
+	// Trust, if set, makes Log check the signature of every commit against
+	// it, flagging unsigned and badly signed commits.
+	Trust *TrustStore
+}
+
+// Log calls fn for every commit reachable from the given one, in the order
+// of WalkHistory. opts may be nil. As with WalkHistory, fn can return
+// ErrStopWalk to end the log early.
+func Log(store *ObjectStore, from string, opts *LogOptions, fn func(LogEntry) error) error {
+	var trust *TrustStore
+	if opts != nil {
+		trust = opts.Trust
+	}
+	return WalkHistory(store, from, func(hash string, commit *Commit) error {
+		entry := LogEntry{Hash: hash, Commit: commit}
+		if trust != nil {
+			name, err := commit.Verify(trust)
+			entry.Signature, entry.Signer = signatureStatus(err), name
+		}
+		return fn(entry)
+	})
+}
+
+// VerifyHistory checks that the history reachable from a commit is complete
+// and intact: every commit, every commit's tree, its subtrees and every
+// object they reference must be present and hash to its key. It returns the first
+// problem found, wrapping ErrNotFound or ErrCorruptObject.
+//
+// A store is consistent if VerifyHistory succeeds for every commit hash a
+// successful Commit returned, whatever failures happened meanwhile.
+func VerifyHistory(store *ObjectStore, commitHash string) error {
+	checked := make(map[string]bool)
+	verify := func(hash string) ([]byte, error) {
+		data, err := store.ReadRawObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		if !checked[hash] {
+			sum := sha256.Sum256(data)
+			if hex.EncodeToString(sum[:]) != hash {
+				return nil, fmt.Errorf("object %s: %w", hash, ErrCorruptObject)
+			}
+			checked[hash] = true
+		}
+		return data, nil
+	}
+
+	// verifyTree checks a tree and, recursively, its entries.
+	var verifyTree func(hash string) error
+	verifyTree = func(hash string) error {
+		if checked[hash] {
+			return nil
+		}
+		data, err := verify(hash)
+		if err != nil {
+			return err
+		}
+		tree, err := decodeTree(hash, data)
+		if err != nil {
+			return err
+		}
+		for name, entry := range tree.Entries {
+			switch entry.Kind {
+			case KindLink:
+				continue
+			case KindTree:
+				err = verifyTree(entry.Hash)
+			default:
+				_, err = verify(entry.Hash)
+			}
+			if err != nil {
+				return fmt.Errorf("bad entry %q in tree %s: %w", name, hash, err)
+			}
+		}
+		return nil
+	}
+
+	return WalkHistory(store, commitHash, func(hash string, commit *Commit) error {
+		if _, err := verify(hash); err != nil {
+			return fmt.Errorf("bad commit: %w", err)
+		}
+		if err := verifyTree(commit.TreeHash); err != nil {
+			return fmt.Errorf("bad tree in commit %s: %w", hash, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+package merkledb
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestWalkHistory(t *testing.T) {
+	store := NewObjectStore(NewMockStorage())
+	tree, _ := store.WriteObject(NewTree())
+
+	root, _ := CreateCommit(store, tree, "root", nil)
+	left, _ := CreateCommit(store, tree, "left", []string{root})
+	right, _ := CreateCommit(store, tree, "right", []string{root})
+	merge, _ := CreateCommit(store, tree, "merge", []string{left, right})
+
+	var messages []string
+	err := WalkHistory(store, merge, func(hash string, c *Commit) error {
+		messages = append(messages, c.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkHistory() failed: %v", err)
+	}
+	want := []string{"merge", "left", "right", "root"}
+	if len(messages) != len(want) {
+		t.Fatalf("WalkHistory() visited %v, want %v", messages, want)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Fatalf("WalkHistory() visited %v, want %v", messages, want)
+		}
+	}
+
+	// ErrStopWalk ends the walk without an error.
+	count := 0
+	err = WalkHistory(store, merge, func(string, *Commit) error {
+		count++
+		return ErrStopWalk
+	})
+	if err != nil || count != 1 {
+		t.Errorf("WalkHistory() with ErrStopWalk = %v after %d commits", err, count)
+	}
+
+	if err := WalkHistory(store, tree, func(string, *Commit) error { return nil }); err == nil {
+		t.Error("WalkHistory() from a tree hash succeeded")
+	}
+	if err := WalkHistory(store, "00", func(string, *Commit) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("WalkHistory() from a missing commit returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestVerifyHistory(t *testing.T) {
+	storage := NewMockStorage()
+	store := NewObjectStore(storage)
+	ws, _ := NewWorkspace(store)
+	ws.Add("a", &mockObject{ID: "a", Data: "first"})
+	first, _ := ws.Commit("first", nil)
+	ws.Add("b", &mockObject{ID: "b", Data: "second"})
+	second, err := ws.Commit("second", []string{first})
+	if err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	if err := VerifyHistory(store, second); err != nil {
+		t.Fatalf("VerifyHistory() of an intact history failed: %v", err)
+	}
+
+	// Tamper with an object referenced by both commits' trees.
+	objHash, _ := store.WriteObject(&mockObject{ID: "a", Data: "first"})
+	key, _ := hex.DecodeString(objHash)
+	storage.data[string(key)] = []byte("tampered")
+	if err := VerifyHistory(store, second); !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("VerifyHistory() with a tampered object returned %v, want ErrCorruptObject", err)
+	}
+
+	delete(storage.data, string(key))
+	if err := VerifyHistory(store, first); !errors.Is(err, ErrNotFound) {
+		t.Errorf("VerifyHistory() with a missing object returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,327 @@
+package merkledb
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Storage operations recorded by InstrumentedStorage.
+const (
+	OpPut     = "put"
+	OpGet     = "get"
+	OpExists  = "exists"
+	OpDelete  = "delete"
+	OpIterate = "iterate"
+	OpCreate  = "create"
+	OpOpen    = "open"
+)
+
+var instrumentedOps = []string{OpPut, OpGet, OpExists, OpDelete, OpIterate, OpCreate, OpOpen}
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency histogram
+// buckets kept for every operation.
+var LatencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01,
+	0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// opMetrics holds the counters of one operation.
+type opMetrics struct {
+	calls    atomic.Uint64
+	errors   atomic.Uint64
+	notFound atomic.Uint64
+	bytes    atomic.Uint64
+	nanos    atomic.Uint64
+	buckets  []atomic.Uint64 // one per LatencyBuckets entry, plus +Inf
+}
+
+func (m *opMetrics) observe(start time.Time, n int, err error) {
+	d := time.Since(start)
+	m.calls.Add(1)
+	m.bytes.Add(uint64(n))
+	m.nanos.Add(uint64(d))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		m.notFound.Add(1)
+	case err != nil:
+		m.errors.Add(1)
+	}
+	i := sort.SearchFloat64s(LatencyBuckets, d.Seconds())
+	m.buckets[i].Add(1)
+}
+
+// OpStats is a snapshot of the metrics of one storage operation.
+type OpStats struct {
+	// Calls is the number of times the operation was invoked.
+	Calls uint64
+	// Errors counts failed calls. A Get of a missing key is not an error;
+	// it is counted in NotFound.
+	Errors   uint64
+	NotFound uint64
+	// Bytes is the volume of values written (put, create), read (get, open)
+	// or visited (iterate).
+	Bytes uint64
+	// Duration is the total time spent in the operation.
+	Duration time.Duration
+	// Buckets holds, for each entry of LatencyBuckets, the number of calls
+	// that took at most that many seconds. It is cumulative, like a
+	// Prometheus histogram; Calls is the count of the implicit +Inf bucket.
+	Buckets []uint64
+}
+
+// InstrumentedStorage is a Storage decorator that records call counts, byte
+// volumes, error counts and latency histograms for every operation. The
+// metrics can be read with Stats, served in the Prometheus text format by
+// Handler, or published through expvar with Publish.
+//
+// It has the methods of every Storage extension but supports one only if the
+// wrapped storage does; use AsDeleter, AsIterator or AsStreaming to find out.
+type InstrumentedStorage struct {
+	storage Storage
+	ops     map[string]*opMetrics
+}
+
+// NewInstrumentedStorage wraps storage with metrics collection.
+func NewInstrumentedStorage(storage Storage) *InstrumentedStorage {
+	s := &InstrumentedStorage{storage: storage, ops: make(map[string]*opMetrics)}
+	for _, op := range instrumentedOps {
+		s.ops[op] = &opMetrics{buckets: make([]atomic.Uint64, len(LatencyBuckets)+1)}
+	}
+	return s
+}
+
+// Put implements the Storage interface.
+func (s *InstrumentedStorage) Put(key []byte, value []byte) error {
+	start := time.Now()
+	err := s.storage.Put(key, value)
+	s.ops[OpPut].observe(start, len(value), err)
+	return err
+}
+
+// Get implements the Storage interface.
+func (s *InstrumentedStorage) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	value, err := s.storage.Get(key)
+	s.ops[OpGet].observe(start, len(value), err)
+	return value, err
+}
+
+// Exists implements the Storage interface.
+func (s *InstrumentedStorage) Exists(key []byte) (bool, error) {
+	start := time.Now()
+	ok, err := s.storage.Exists(key)
+	s.ops[OpExists].observe(start, 0, err)
+	return ok, err
+}
+
+// Delete implements the Deleter interface if the wrapped storage does.
+func (s *InstrumentedStorage) Delete(key []byte) error {
+	d, ok := AsDeleter(s.storage)
+	if !ok {
+		return fmt.Errorf("storage does not support deletion")
+	}
+	start := time.Now()
+	err := d.Delete(key)
+	s.ops[OpDelete].observe(start, 0, err)
+	return err
+}
+
+// Iterate implements the Iterator interface if the wrapped storage does.
+// A whole iteration is recorded as one call.
+func (s *InstrumentedStorage) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	it, ok := AsIterator(s.storage)
+	if !ok {
+		return fmt.Errorf("storage does not support iteration")
+	}
+	began := time.Now()
+	n := 0
+	err := it.Iterate(start, end, func(key, value []byte) error {
+		n += len(value)
+		return fn(key, value)
+	})
+	s.ops[OpIterate].observe(began, n, err)
+	return err
+}
+
+// Supports implements the ExtensionReporter interface: every extension works
+// if the wrapped storage supports it.
+func (s *InstrumentedStorage) Supports(ext Extension) bool {
+	return Supports(s.storage, ext)
+}
+
+// Create implements the StreamingStorage interface if the wrapped storage
+// does. A streamed value is recorded as one call when it is committed or
+// aborted, with the time since Create.
+func (s *InstrumentedStorage) Create() (PendingValue, error) {
+	ss, ok := AsStreaming(s.storage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not support streaming")
+	}
+	start := time.Now()
+	pending, err := ss.Create()
+	if err != nil {
+		s.ops[OpCreate].observe(start, 0, err)
+		return nil, err
+	}
+	return &instrumentedPending{PendingValue: pending, m: s.ops[OpCreate], start: start}, nil
+}
+
+// Open implements the StreamingStorage interface if the wrapped storage
+// does. A read is recorded as one call when the reader is closed, with the
+// time since Open.
+func (s *InstrumentedStorage) Open(key []byte) (io.ReadCloser, error) {
+	ss, ok := AsStreaming(s.storage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not support streaming")
+	}
+	start := time.Now()
+	r, err := ss.Open(key)
+	if err != nil {
+		s.ops[OpOpen].observe(start, 0, err)
+		return nil, err
+	}
+	return &instrumentedReader{ReadCloser: r, m: s.ops[OpOpen], start: start}, nil
+}
+
+// instrumentedPending counts the bytes written to a PendingValue.
+type instrumentedPending struct {
+	PendingValue
+	m     *opMetrics
+	start time.Time
+	n     int
+	done  bool
+}
+
+func (p *instrumentedPending) Write(b []byte) (int, error) {
+	n, err := p.PendingValue.Write(b)
+	p.n += n
+	return n, err
+}
+
+func (p *instrumentedPending) Commit(key []byte) error {
+	err := p.PendingValue.Commit(key)
+	p.observe(err)
+	return err
+}
+
+func (p *instrumentedPending) Abort() error {
+	err := p.PendingValue.Abort()
+	p.observe(err)
+	return err
+}
+
+func (p *instrumentedPending) observe(err error) {
+	if !p.done {
+		p.done = true
+		p.m.observe(p.start, p.n, err)
+	}
+}
+
+// instrumentedReader counts the bytes read from a streamed value.
+type instrumentedReader struct {
+	io.ReadCloser
+	m     *opMetrics
+	start time.Time
+	n     int
+	err   error
+	done  bool
+}
+
+func (r *instrumentedReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.n += n
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *instrumentedReader) Close() error {
+	err := r.ReadCloser.Close()
+	if !r.done {
+		r.done = true
+		r.m.observe(r.start, r.n, errors.Join(r.err, err))
+	}
+	return err
+}
+
+// Stats returns a snapshot of the metrics of every operation, by operation
+// name (OpPut, OpGet, ...).
+func (s *InstrumentedStorage) Stats() map[string]OpStats {
+	stats := make(map[string]OpStats, len(s.ops))
+	for op, m := range s.ops {
+		st := OpStats{
+			Calls:    m.calls.Load(),
+			Errors:   m.errors.Load(),
+			NotFound: m.notFound.Load(),
+			Bytes:    m.bytes.Load(),
+			Duration: time.Duration(m.nanos.Load()),
+			Buckets:  make([]uint64, len(LatencyBuckets)),
+		}
+		var cumulative uint64
+		for i := range LatencyBuckets {
+			cumulative += m.buckets[i].Load()
+			st.Buckets[i] = cumulative
+		}
+		stats[op] = st
+	}
+	return stats
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (s *InstrumentedStorage) WritePrometheus(w io.Writer) error {
+	stats := s.Stats()
+	var b []byte
+	counter := func(name, help string, value func(OpStats) uint64) {
+		b = fmt.Appendf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, op := range instrumentedOps {
+			b = fmt.Appendf(b, "%s{op=%q} %d\n", name, op, value(stats[op]))
+		}
+	}
+	counter("merkledb_storage_operations_total", "Storage operations performed.",
+		func(st OpStats) uint64 { return st.Calls })
+	counter("merkledb_storage_errors_total", "Storage operations that failed.",
+		func(st OpStats) uint64 { return st.Errors })
+	counter("merkledb_storage_not_found_total", "Storage reads of missing keys.",
+		func(st OpStats) uint64 { return st.NotFound })
+	counter("merkledb_storage_bytes_total", "Bytes of values written, read or iterated.",
+		func(st OpStats) uint64 { return st.Bytes })
+
+	const hist = "merkledb_storage_duration_seconds"
+	b = fmt.Appendf(b, "# HELP %s Storage operation latency.\n# TYPE %s histogram\n", hist, hist)
+	for _, op := range instrumentedOps {
+		st := stats[op]
+		for i, le := range LatencyBuckets {
+			b = fmt.Appendf(b, "%s_bucket{op=%q,le=%q} %d\n", hist, op, strconv.FormatFloat(le, 'g', -1, 64), st.Buckets[i])
+		}
+		b = fmt.Appendf(b, "%s_bucket{op=%q,le=\"+Inf\"} %d\n", hist, op, st.Calls)
+		b = fmt.Appendf(b, "%s_sum{op=%q} %s\n", hist, op, strconv.FormatFloat(st.Duration.Seconds(), 'g', -1, 64))
+		b = fmt.Appendf(b, "%s_count{op=%q} %d\n", hist, op, st.Calls)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// Handler returns an http.Handler serving the metrics in the Prometheus text
+// exposition format, for use as a scrape endpoint.
+func (s *InstrumentedStorage) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.WritePrometheus(w)
+	})
+}
+
+// Publish exposes the metrics as an expvar variable with the given name, so
+// they appear under /debug/vars. Like expvar.Publish, it panics if the name
+// is already in use.
+func (s *InstrumentedStorage) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return s.Stats() }))
+}
@@ -0,0 +1,128 @@
+package merkledb
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentedStorage_InterfaceContracts(t *testing.T) {
+	var _ Storage = (*InstrumentedStorage)(nil)
+	var _ Deleter = (*InstrumentedStorage)(nil)
+	var _ Iterator = (*InstrumentedStorage)(nil)
+}
+
+func TestInstrumentedStorage_Stats(t *testing.T) {
+	backend := newLockedStorage()
+	s := NewInstrumentedStorage(backend)
+
+	s.Put([]byte("a"), []byte("hello"))
+	s.Put([]byte("b"), []byte("world!"))
+	s.Get([]byte("a"))
+	if _, err := s.Get([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() of a missing key returned %v, want ErrNotFound", err)
+	}
+	s.Exists([]byte("a"))
+	backend.setFailPuts(true)
+	s.Put([]byte("c"), []byte("x"))
+
+	stats := s.Stats()
+	put := stats[OpPut]
+	if put.Calls != 3 || put.Errors != 1 || put.Bytes != 12 {
+		t.Errorf("put stats = %+v, want 3 calls, 1 error, 12 bytes", put)
+	}
+	get := stats[OpGet]
+	if get.Calls != 2 || get.Errors != 0 || get.NotFound != 1 || get.Bytes != 5 {
+		t.Errorf("get stats = %+v, want 2 calls, 1 not found, 5 bytes", get)
+	}
+	if stats[OpExists].Calls != 1 {
+		t.Errorf("exists calls = %d, want 1", stats[OpExists].Calls)
+	}
+	// Every call falls in the last bucket at the latest.
+	if last := put.Buckets[len(put.Buckets)-1]; last > put.Calls {
+		t.Errorf("cumulative bucket count %d exceeds calls %d", last, put.Calls)
+	}
+}
+
+func TestInstrumentedStorage_Prometheus(t *testing.T) {
+	s := NewInstrumentedStorage(newLockedStorage())
+	s.Put([]byte("a"), []byte("hello"))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE merkledb_storage_operations_total counter\n",
+		`merkledb_storage_operations_total{op="put"} 1` + "\n",
+		`merkledb_storage_bytes_total{op="put"} 5` + "\n",
+		"# TYPE merkledb_storage_duration_seconds histogram\n",
+		`merkledb_storage_duration_seconds_bucket{op="put",le="+Inf"} 1` + "\n",
+		`merkledb_storage_duration_seconds_count{op="get"} 0` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output is missing %q\n%s", want, body)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+}
+
+func TestInstrumentedStorage_SupportsWrappedExtensions(t *testing.T) {
+	plain := NewInstrumentedStorage(NewMockStorage())
+	if _, ok := AsDeleter(plain); ok {
+		t.Error("AsDeleter() succeeded over a storage that cannot delete")
+	}
+	if _, ok := AsIterator(plain); ok {
+		t.Error("AsIterator() succeeded over a storage that cannot iterate")
+	}
+	if _, ok := AsStreaming(plain); ok {
+		t.Error("AsStreaming() succeeded over a storage that cannot stream")
+	}
+
+	full := NewInstrumentedStorage(newLockedStorage())
+	if _, ok := AsDeleter(full); !ok {
+		t.Error("AsDeleter() failed over a storage that can delete")
+	}
+	if _, ok := AsIterator(full); !ok {
+		t.Error("AsIterator() failed over a storage that can iterate")
+	}
+}
+
+func TestInstrumentedStorage_ForwardsStreaming(t *testing.T) {
+	backend := &streamingStorage{mockStorage: NewMockStorage()}
+	s := NewInstrumentedStorage(backend)
+	ss, ok := AsStreaming(s)
+	if !ok {
+		t.Fatal("AsStreaming() failed over a streaming storage")
+	}
+
+	pending, err := ss.Create()
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	pending.Write([]byte("hello"))
+	if err := pending.Commit([]byte("key")); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	r, err := ss.Open([]byte("key"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	io.ReadAll(r)
+	r.Close()
+
+	stats := s.Stats()
+	if c := stats[OpCreate]; c.Calls != 1 || c.Bytes != 5 {
+		t.Errorf("create stats = %+v, want 1 call, 5 bytes", c)
+	}
+	if o := stats[OpOpen]; o.Calls != 1 || o.Bytes != 5 {
+		t.Errorf("open stats = %+v, want 1 call, 5 bytes", o)
+	}
+	if backend.creates != 1 || backend.opens != 1 {
+		t.Errorf("backend saw %d creates and %d opens, want 1 each", backend.creates, backend.opens)
+	}
+}
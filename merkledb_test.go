@@ -2,6 +2,9 @@ package merkledb
 
 import (
 	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
 	"testing"
 )
 
@@ -20,6 +23,8 @@ func (m *mockObject) Serialize() ([]byte, error) {
 }
 
 // mockStorage is an in-memory map-based implementation of the Storage interface.
+// Outside this package, use storage/memstore instead; the root package's tests
+// cannot import it, since it imports the root package.
 type mockStorage struct {
 	data map[string][]byte
 }
@@ -52,6 +57,182 @@ func (s *mockStorage) Exists(key []byte) (bool, error) {
 	return ok, nil
 }
 
+// lockedStorage is a goroutine-safe mockStorage with deletion and iteration
+// support, and switches to make reads or writes fail. It backs the tests of
+// the Storage decorators, which call their backends from several goroutines.
+type lockedStorage struct {
+	mu       sync.Mutex
+	data     map[string][]byte
+	failPuts bool
+	failGets bool
+}
+
+func newLockedStorage() *lockedStorage {
+	return &lockedStorage{data: make(map[string][]byte)}
+}
+
+func (s *lockedStorage) Put(key []byte, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failPuts {
+		return errors.New("storage unavailable")
+	}
+	s.data[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *lockedStorage) Get(key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failGets {
+		return nil, errors.New("storage unavailable")
+	}
+	value, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *lockedStorage) Exists(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failGets {
+		return false, errors.New("storage unavailable")
+	}
+	_, ok := s.data[string(key)]
+	return ok, nil
+}
+
+func (s *lockedStorage) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *lockedStorage) setFailPuts(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failPuts = fail
+}
+
+func (s *lockedStorage) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		if (start == nil || k >= string(start)) && (end == nil || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value, err := s.Get([]byte(k))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn([]byte(k), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *lockedStorage) setFailGets(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failGets = fail
+}
+
+// commitGraph builds the objects, commits and refs of a test fixture over a
+// lockedStorage, failing the test on any error. It records the hashes it
+// writes in h, by name.
+type commitGraph struct {
+	t       *testing.T
+	store   *ObjectStore
+	storage *lockedStorage
+	refs    *MemoryRefStore
+	h       map[string]string
+}
+
+func newCommitGraph(t *testing.T, opts ...ObjectStoreOption) *commitGraph {
+	storage := newLockedStorage()
+	return &commitGraph{
+		t:       t,
+		store:   NewObjectStore(storage, opts...),
+		storage: storage,
+		refs:    NewMemoryRefStore(),
+		h:       make(map[string]string),
+	}
+}
+
+// write stores obj and returns its hash.
+func (g *commitGraph) write(obj Object) string {
+	g.t.Helper()
+	hash, err := g.store.WriteObject(obj)
+	if err != nil {
+		g.t.Fatalf("WriteObject() failed: %v", err)
+	}
+	return hash
+}
+
+// tree stores a tree of entries and returns its hash.
+func (g *commitGraph) tree(entries map[string]TreeEntry) string {
+	g.t.Helper()
+	tree := NewTree()
+	for name, e := range entries {
+		tree.Entries[name] = e
+	}
+	return g.write(tree)
+}
+
+// commit commits files, stored as mockObjects holding the given data, and
+// entries on top of parents. It records the commit in h under name, which is
+// also its message.
+func (g *commitGraph) commit(name string, files map[string]string, entries map[string]TreeEntry, parents []string, opts *CommitOptions) string {
+	g.t.Helper()
+	ws, err := NewWorkspace(g.store)
+	if err != nil {
+		g.t.Fatalf("NewWorkspace() failed: %v", err)
+	}
+	for path, data := range files {
+		if err := ws.Add(path, &mockObject{Data: data}); err != nil {
+			g.t.Fatalf("Add(%q) failed: %v", path, err)
+		}
+	}
+	for path, e := range entries {
+		if err := ws.AddEntry(path, e); err != nil {
+			g.t.Fatalf("AddEntry(%q) failed: %v", path, err)
+		}
+	}
+	hash, err := ws.CommitWithOptions(name, parents, opts)
+	if err != nil {
+		g.t.Fatalf("Commit() failed: %v", err)
+	}
+	g.h[name] = hash
+	return hash
+}
+
+// advance commits on top of the branch ref, if it exists, and points the ref
+// at the new commit.
+func (g *commitGraph) advance(ref, name string, files map[string]string, opts *CommitOptions) string {
+	g.t.Helper()
+	var parents []string
+	if head, err := g.refs.ReadRef(ref); err == nil {
+		parents = []string{head}
+	}
+	hash := g.commit(name, files, nil, parents, opts)
+	if err := g.refs.WriteRef(ref, hash); err != nil {
+		g.t.Fatalf("WriteRef() failed: %v", err)
+	}
+	return hash
+}
+
 // --- Test Cases ---
 
 // TestInterfaceContracts is a compile-time check to ensure our mock types
@@ -59,6 +240,9 @@ func (s *mockStorage) Exists(key []byte) (bool, error) {
 func TestInterfaceContracts(t *testing.T) {
 	var _ Object = (*mockObject)(nil)
 	var _ Storage = (*mockStorage)(nil)
+	var _ Storage = (*lockedStorage)(nil)
+	var _ Deleter = (*lockedStorage)(nil)
+	var _ Iterator = (*lockedStorage)(nil)
 }
 
 // TestMockStorage provides a basic test for the mock storage implementation
@@ -6,6 +6,7 @@ type Object interface {
 	// Serialize converts the object's data into a stable byte slice for hashing.
 	// "Stable" means that for the same object content, the output byte
 	// slice must be identical every time. This is crucial for consistent hashing.
-	// We achieve this by serializing to JSON with sorted keys.
+	// Canonical produces such an encoding for any JSON-marshalable value, and
+	// JSONObject wraps a value to make it an Object.
 	Serialize() ([]byte, error)
 }
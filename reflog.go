@@ -0,0 +1,284 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ReflogEntry records one update of a ref.
+type ReflogEntry struct {
+	// Old is the hash the ref pointed to before the update, empty if it did
+	// not exist.
+	Old string `json:"old,omitempty"`
+	// New is the hash the ref points to after the update, empty if it was
+	// deleted.
+	New    string    `json:"new,omitempty"`
+	Actor  string    `json:"actor,omitempty"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// ReflogStore stores the reflogs of refs: the history of where each ref
+// pointed. A reflog outlives its ref, so a deleted branch can be recovered.
+// Implementations must be safe for concurrent use.
+type ReflogStore interface {
+	// AppendReflog adds entry at the end of the reflog of name.
+	AppendReflog(name string, entry ReflogEntry) error
+	// ReadReflog returns the reflog of name, oldest entry first. A ref
+	// without a reflog has an empty one.
+	ReadReflog(name string) ([]ReflogEntry, error)
+	// WriteReflog replaces the reflog of name. Writing no entries deletes
+	// it.
+	WriteReflog(name string, entries []ReflogEntry) error
+	// ExpireReflog replaces the reflog of name with the entries keep returns
+	// for its current ones, as one atomic update: no entry appended in the
+	// meantime is lost. Keeping no entries deletes the reflog.
+	ExpireReflog(name string, keep func(entries []ReflogEntry) []ReflogEntry) error
+	// ListReflogs returns the names of the refs that have a reflog, in name
+	// order.
+	ListReflogs() ([]string, error)
+}
+
+// Reflog returns the reflog of the ref name, newest entry first, as
+// "git reflog" shows it.
+func Reflog(logs ReflogStore, name string) ([]ReflogEntry, error) {
+	entries, err := logs.ReadReflog(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reflog of %s: %w", name, err)
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// ReflogOptions configures a LoggedRefStore.
+type ReflogOptions struct {
+	// Actor is recorded as the author of updates, unless replaced with
+	// LoggedRefStore.With.
+	Actor string
+	// Clock tells the time of updates. It defaults to the system clock.
+	Clock Clock
+}
+
+// LoggedRefStore is a RefStore recording every update of its refs in a
+// ReflogStore. It is also a ReflogStore, reading the reflogs it records.
+type LoggedRefStore struct {
+	refs   RefStore
+	logs   ReflogStore
+	clock  Clock
+	actor  string
+	reason string
+	// updates serializes the update of each ref with its reflog entry, so
+	// entries are recorded in the order the updates happened.
+	updates *keyLocks
+}
+
+// NewLoggedRefStore returns a LoggedRefStore updating refs and recording the
+// updates in logs, which is often refs itself. opts may be nil.
+func NewLoggedRefStore(refs RefStore, logs ReflogStore, opts *ReflogOptions) *LoggedRefStore {
+	l := &LoggedRefStore{refs: refs, logs: logs, clock: systemClock{}, updates: new(keyLocks)}
+	if opts != nil {
+		l.actor = opts.Actor
+		if opts.Clock != nil {
+			l.clock = opts.Clock
+		}
+	}
+	return l
+}
+
+// With returns a view of l recording actor and reason on the updates made
+// through it, as in:
+//
+//	refs.With("import-bot", "import feed 2024-05").WriteRef("refs/heads/main", hash)
+func (l *LoggedRefStore) With(actor, reason string) *LoggedRefStore {
+	view := *l
+	view.actor, view.reason = actor, reason
+	return &view
+}
+
+// ReadRef implements RefStore.
+func (l *LoggedRefStore) ReadRef(name string) (string, error) {
+	return l.refs.ReadRef(name)
+}
+
+// ListRefs implements RefStore.
+func (l *LoggedRefStore) ListRefs(prefix string) ([]Ref, error) {
+	return l.refs.ListRefs(prefix)
+}
+
+// WriteRef implements RefStore.
+func (l *LoggedRefStore) WriteRef(name, hash string) error {
+	if hash == "" {
+		return fmt.Errorf("ref %s: empty hash", name)
+	}
+	return l.set(name, hash)
+}
+
+// DeleteRef implements RefStore. The reflog of the ref is kept.
+func (l *LoggedRefStore) DeleteRef(name string) error {
+	return l.set(name, "")
+}
+
+// set points name at hash whatever it pointed to, through compare-and-swap
+// so the recorded old hash is exact.
+func (l *LoggedRefStore) set(name, hash string) error {
+	for {
+		old, err := l.refs.ReadRef(name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if old == hash {
+			return nil
+		}
+		err = l.CompareAndSwapRef(name, old, hash)
+		if !errors.Is(err, ErrRefConflict) || !l.changed(name, old) {
+			return err
+		}
+	}
+}
+
+// changed reports whether name no longer holds old, which tells a lost race
+// from a name clash.
+func (l *LoggedRefStore) changed(name, old string) bool {
+	current, err := l.refs.ReadRef(name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false
+	}
+	return current != old
+}
+
+// CompareAndSwapRef implements RefStore. If the ref is updated but the
+// update cannot be recorded, the error says so. Updates made through the
+// same LoggedRefStore, or views of it, are recorded in the order they
+// happened.
+func (l *LoggedRefStore) CompareAndSwapRef(name, oldHash, newHash string) error {
+	unlock := l.updates.lock([]byte(name))
+	defer unlock()
+	if err := l.refs.CompareAndSwapRef(name, oldHash, newHash); err != nil {
+		return err
+	}
+	if oldHash == newHash {
+		return nil
+	}
+	entry := ReflogEntry{Old: oldHash, New: newHash, Actor: l.actor, Time: l.clock.Now().UTC(), Reason: l.reason}
+	if err := l.logs.AppendReflog(name, entry); err != nil {
+		return fmt.Errorf("ref %s updated, but not recorded in its reflog: %w", name, err)
+	}
+	return nil
+}
+
+// AppendReflog implements ReflogStore.
+func (l *LoggedRefStore) AppendReflog(name string, entry ReflogEntry) error {
+	return l.logs.AppendReflog(name, entry)
+}
+
+// ReadReflog implements ReflogStore.
+func (l *LoggedRefStore) ReadReflog(name string) ([]ReflogEntry, error) {
+	return l.logs.ReadReflog(name)
+}
+
+// WriteReflog implements ReflogStore.
+func (l *LoggedRefStore) WriteReflog(name string, entries []ReflogEntry) error {
+	return l.logs.WriteReflog(name, entries)
+}
+
+// ExpireReflog implements ReflogStore.
+func (l *LoggedRefStore) ExpireReflog(name string, keep func(entries []ReflogEntry) []ReflogEntry) error {
+	return l.logs.ExpireReflog(name, keep)
+}
+
+// ListReflogs implements ReflogStore.
+func (l *LoggedRefStore) ListReflogs() ([]string, error) {
+	return l.logs.ListReflogs()
+}
+
+// ReflogExpiry is a policy for dropping old reflog entries. Until they
+// expire, the objects reflog entries refer to are kept by CollectGarbage.
+type ReflogExpiry struct {
+	// MaxAge expires entries older than it. Zero keeps entries of any age.
+	MaxAge time.Duration
+	// MaxEntries keeps at most that many of the latest entries of each
+	// reflog, in the order they were recorded. Zero keeps any number.
+	MaxEntries int
+}
+
+// DefaultReflogExpiry keeps entries for 90 days, as Git does.
+var DefaultReflogExpiry = ReflogExpiry{MaxAge: 90 * 24 * time.Hour}
+
+// live returns the entries of a reflog, in the order they were recorded,
+// that have not expired at now. Each entry is aged by its own time, since
+// clocks can step back between updates.
+func (p ReflogExpiry) live(entries []ReflogEntry, now time.Time) []ReflogEntry {
+	if p.MaxAge > 0 {
+		cutoff := now.Add(-p.MaxAge)
+		var kept []ReflogEntry
+		for _, e := range entries {
+			if !e.Time.Before(cutoff) {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+	if p.MaxEntries > 0 && len(entries) > p.MaxEntries {
+		entries = entries[len(entries)-p.MaxEntries:]
+	}
+	return entries
+}
+
+// ExpireReflogs drops the entries of every reflog in logs that have expired
+// at now under policy, and returns how many it dropped.
+func ExpireReflogs(logs ReflogStore, policy ReflogExpiry, now time.Time) (int, error) {
+	names, err := logs.ListReflogs()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list reflogs: %w", err)
+	}
+	expired := 0
+	for _, name := range names {
+		err := logs.ExpireReflog(name, func(entries []ReflogEntry) []ReflogEntry {
+			live := policy.live(entries, now)
+			expired += len(entries) - len(live)
+			return live
+		})
+		if err != nil {
+			return expired, fmt.Errorf("failed to expire reflog of %s: %w", name, err)
+		}
+	}
+	return expired, nil
+}
+
+// reflogAt returns the hash the ref name pointed to as selected by sel.
+func reflogAt(logs ReflogStore, name string, sel reflogSelector) (string, error) {
+	entries, err := logs.ReadReflog(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read reflog of %s: %w", name, err)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no reflog for %s: %w", name, ErrNotFound)
+	}
+
+	var hash string
+	if sel.n >= 0 {
+		// @{0} is the latest value, @{1} the one before, and so on.
+		switch i := len(entries) - 1 - sel.n; {
+		case i >= 0:
+			hash = entries[i].New
+		case i == -1:
+			hash = entries[0].Old
+		}
+		if hash == "" {
+			return "", fmt.Errorf("reflog of %s has no entry @{%d}: %w", name, sel.n, ErrNotFound)
+		}
+		return hash, nil
+	}
+
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Time.After(sel.at) })
+	if i == 0 {
+		return "", fmt.Errorf("reflog of %s only goes back to %s: %w", name, entries[0].Time.Format(time.RFC3339), ErrNotFound)
+	}
+	if hash = entries[i-1].New; hash == "" {
+		return "", fmt.Errorf("ref %s was deleted at %s: %w", name, sel.at.Format(time.RFC3339), ErrNotFound)
+	}
+	return hash, nil
+}
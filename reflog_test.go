@@ -0,0 +1,197 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoggedRefStore(t *testing.T) {
+	mem := NewMemoryRefStore()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	refs := NewLoggedRefStore(mem, mem, &ReflogOptions{Actor: "alice", Clock: StepClock(start, time.Hour)})
+	const name = HeadsPrefix + "main"
+
+	if err := refs.WriteRef(name, "aa"); err != nil {
+		t.Fatalf("WriteRef() failed: %v", err)
+	}
+	if err := refs.With("import-bot", "import feed").CompareAndSwapRef(name, "aa", "bb"); err != nil {
+		t.Fatalf("CompareAndSwapRef() failed: %v", err)
+	}
+	if err := refs.CompareAndSwapRef(name, "aa", "cc"); !errors.Is(err, ErrRefConflict) {
+		t.Fatalf("CompareAndSwapRef() from a stale hash returned %v, want ErrRefConflict", err)
+	}
+	if err := refs.WriteRef(name, "bb"); err != nil {
+		t.Fatalf("WriteRef() of the current hash failed: %v", err)
+	}
+	if err := refs.DeleteRef(name); err != nil {
+		t.Fatalf("DeleteRef() failed: %v", err)
+	}
+	if _, err := refs.ReadRef(name); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ReadRef() of a deleted ref returned %v, want ErrNotFound", err)
+	}
+
+	entries, err := Reflog(refs, name)
+	if err != nil {
+		t.Fatalf("Reflog() failed: %v", err)
+	}
+	want := []ReflogEntry{
+		{Old: "bb", New: "", Actor: "alice", Time: start.Add(2 * time.Hour)},
+		{Old: "aa", New: "bb", Actor: "import-bot", Time: start.Add(time.Hour), Reason: "import feed"},
+		{Old: "", New: "aa", Actor: "alice", Time: start},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Reflog() returned %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("Reflog()[%d] = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+// slowReflogStore delays its appends, widening the window between a ref
+// update and its reflog entry.
+type slowReflogStore struct {
+	*MemoryRefStore
+}
+
+func (s slowReflogStore) AppendReflog(name string, entry ReflogEntry) error {
+	time.Sleep(time.Millisecond)
+	return s.MemoryRefStore.AppendReflog(name, entry)
+}
+
+func TestLoggedRefStore_ConcurrentUpdatesAreLoggedInOrder(t *testing.T) {
+	mem := NewMemoryRefStore()
+	refs := NewLoggedRefStore(mem, slowReflogStore{mem}, nil)
+	const name, writers = HeadsPrefix + "main", 16
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := refs.With(fmt.Sprint(i), "").WriteRef(name, fmt.Sprintf("%02x", i)); err != nil {
+				t.Errorf("WriteRef() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, _ := mem.ReadReflog(name)
+	if len(entries) != writers {
+		t.Fatalf("reflog has %d entries, want %d", len(entries), writers)
+	}
+	// Each entry starts where the previous one left the ref.
+	prev := ""
+	for i, e := range entries {
+		if e.Old != prev {
+			t.Errorf("entry %d moves the ref from %q, want %q", i, e.Old, prev)
+		}
+		prev = e.New
+	}
+	if current, _ := refs.ReadRef(name); current != prev {
+		t.Errorf("ref holds %q, but the reflog ends at %q", current, prev)
+	}
+}
+
+func TestExpireReflogs(t *testing.T) {
+	logs := NewMemoryRefStore()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 10 {
+		logs.AppendReflog(HeadsPrefix+"main", ReflogEntry{New: string(rune('a' + i)), Time: start.AddDate(0, 0, 10*i)})
+	}
+	logs.AppendReflog(HeadsPrefix+"old", ReflogEntry{New: "zz", Time: start})
+	now := start.AddDate(0, 0, 95)
+
+	expired, err := ExpireReflogs(logs, ReflogExpiry{MaxAge: 30 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatalf("ExpireReflogs() failed: %v", err)
+	}
+	// Entries of days 70, 80 and 90 are younger than 30 days.
+	if expired != 8 {
+		t.Errorf("ExpireReflogs() expired %d entries, want 8", expired)
+	}
+	if entries, _ := logs.ReadReflog(HeadsPrefix + "main"); len(entries) != 3 || entries[0].New != "h" {
+		t.Errorf("reflog after expiry = %+v, want entries h to j", entries)
+	}
+	if names, _ := logs.ListReflogs(); len(names) != 1 {
+		t.Errorf("ListReflogs() = %v, want the fully expired reflog gone", names)
+	}
+
+	if expired, err = ExpireReflogs(logs, ReflogExpiry{MaxEntries: 1}, now); err != nil || expired != 2 {
+		t.Errorf("ExpireReflogs(MaxEntries: 1) = %d, %v; want 2", expired, err)
+	}
+	if entries, _ := logs.ReadReflog(HeadsPrefix + "main"); len(entries) != 1 || entries[0].New != "j" {
+		t.Errorf("reflog after expiry = %+v, want entry j", entries)
+	}
+}
+
+func TestExpireReflogs_OutOfOrder(t *testing.T) {
+	// The clock stepped back between updates, so a recent entry follows an
+	// old one.
+	logs := NewMemoryRefStore()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i, age := range []int{1, 100, 2, 200, 3} {
+		logs.AppendReflog(HeadsPrefix+"main", ReflogEntry{New: string(rune('a' + i)), Time: now.AddDate(0, 0, -age)})
+	}
+
+	expired, err := ExpireReflogs(logs, ReflogExpiry{MaxAge: 30 * 24 * time.Hour}, now)
+	if err != nil || expired != 2 {
+		t.Errorf("ExpireReflogs() = %d, %v; want 2", expired, err)
+	}
+	entries, _ := logs.ReadReflog(HeadsPrefix + "main")
+	var got string
+	for _, e := range entries {
+		got += e.New
+	}
+	if got != "ace" {
+		t.Errorf("reflog after expiry holds %q, want the entries a, c and e in order", got)
+	}
+}
+
+func TestResolveRevision_Reflog(t *testing.T) {
+	store, mem, h := revisionFixture(t)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	refs := NewLoggedRefStore(mem, mem, &ReflogOptions{Clock: StepClock(start, time.Hour)})
+	const name = HeadsPrefix + "topic"
+	for _, c := range []string{"c0", "c1", "c2"} {
+		if err := refs.WriteRef(name, h[c]); err != nil {
+			t.Fatalf("WriteRef() failed: %v", err)
+		}
+	}
+
+	for rev, want := range map[string]string{
+		"topic@{0}":                    h["c2"],
+		"topic@{1}":                    h["c1"],
+		"topic@{2}":                    h["c0"],
+		"topic@{1}~1":                  h["c0"],
+		"topic@{2024-03-01 13:30:00}":  h["c1"],
+		"topic@{2024-03-01T14:00:00Z}": h["c2"],
+	} {
+		got, err := ResolveRevision(store, refs, rev)
+		if err != nil || got != want {
+			t.Errorf("ResolveRevision(%q) = %q, %v; want %q", rev, got, err, want)
+		}
+	}
+	for _, rev := range []string{"topic@{3}", "topic@{2024-02-01}", "main@{1}"} {
+		if _, err := ResolveRevision(store, refs, rev); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ResolveRevision(%q) returned %v, want ErrNotFound", rev, err)
+		}
+	}
+
+	// A deleted branch is recovered from its reflog.
+	if err := refs.DeleteRef(name); err != nil {
+		t.Fatalf("DeleteRef() failed: %v", err)
+	}
+	if _, err := ResolveRevision(store, refs, "topic"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveRevision(topic) of a deleted branch returned %v, want ErrNotFound", err)
+	}
+	if got, err := ResolveRevision(store, refs, "topic@{1}"); err != nil || got != h["c2"] {
+		t.Errorf("ResolveRevision(topic@{1}) = %q, %v; want %q", got, err, h["c2"])
+	}
+	if _, err := ResolveRevision(store, refs, "topic@{0}"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveRevision(topic@{0}) of a deleted branch returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,265 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Ref name prefixes.
+const (
+	HeadsPrefix = "refs/heads/"
+	TagsPrefix  = "refs/tags/"
+)
+
+// ErrRefConflict is returned by RefStore.CompareAndSwapRef when the ref does
+// not hold the expected hash, and when a ref name clashes with an existing
+// one, such as "refs/heads/a" with "refs/heads/a/b".
+var ErrRefConflict = errors.New("ref does not hold the expected hash")
+
+// Ref is a named pointer to an object, such as a branch or a tag.
+type Ref struct {
+	Name string
+	Hash string
+}
+
+// RefStore stores refs: mutable names of immutable objects. Names are checked
+// with CheckRefName. Implementations must be safe for concurrent use.
+type RefStore interface {
+	// ReadRef returns the hash name points to, or an error matching
+	// ErrNotFound.
+	ReadRef(name string) (string, error)
+	// WriteRef points name at hash, whatever it pointed to before.
+	WriteRef(name, hash string) error
+	// CompareAndSwapRef points name at newHash if it points at oldHash, and
+	// fails with an error matching ErrRefConflict otherwise. An empty
+	// oldHash means the ref must not exist; an empty newHash deletes it.
+	CompareAndSwapRef(name, oldHash, newHash string) error
+	// DeleteRef deletes name. Deleting a missing ref is not an error.
+	DeleteRef(name string) error
+	// ListRefs returns the refs whose names start with prefix, in name
+	// order.
+	ListRefs(prefix string) ([]Ref, error)
+}
+
+// CheckRefName reports whether name is a valid ref name: "HEAD", or a
+// "refs/" path of non-empty components that do not start with a dot or end
+// with ".lock", without "..", spaces, control characters or any of ~^:?*[\@{.
+// The rules are a subset of Git's, and keep names usable as file paths and
+// in revision expressions.
+func CheckRefName(name string) error {
+	if name == "HEAD" {
+		return nil
+	}
+	if !strings.HasPrefix(name, "refs/") {
+		return fmt.Errorf("invalid ref name %q: must be HEAD or start with refs/", name)
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return fmt.Errorf("invalid ref name %q", name)
+	}
+	for _, r := range name {
+		if r <= ' ' || r == 0x7f || strings.ContainsRune(`~^:?*[\`, r) {
+			return fmt.Errorf("invalid ref name %q: bad character %q", name, r)
+		}
+	}
+	for _, part := range strings.Split(name, "/")[1:] {
+		if part == "" || strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return fmt.Errorf("invalid ref name %q: bad component %q", name, part)
+		}
+	}
+	return nil
+}
+
+// ResolveRef finds the ref a possibly abbreviated name refers to, trying, in
+// order, name itself, "refs/<name>", "refs/tags/<name>" and
+// "refs/heads/<name>", as Git does. It returns an error matching ErrNotFound
+// if none exists.
+func ResolveRef(refs RefStore, name string) (Ref, error) {
+	for _, candidate := range refCandidates(name) {
+		hash, err := refs.ReadRef(candidate)
+		if err == nil {
+			return Ref{Name: candidate, Hash: hash}, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return Ref{}, err
+		}
+	}
+	return Ref{}, fmt.Errorf("ref %q: %w", name, ErrNotFound)
+}
+
+// refCandidates returns the valid ref names an abbreviated name may stand
+// for, in the order ResolveRef tries them.
+func refCandidates(name string) []string {
+	var names []string
+	for _, candidate := range []string{name, "refs/" + name, TagsPrefix + name, HeadsPrefix + name} {
+		if CheckRefName(candidate) == nil {
+			names = append(names, candidate)
+		}
+	}
+	return names
+}
+
+// MemoryRefStore is a RefStore holding refs in memory. It is also a
+// ReflogStore, so it can keep the reflogs of a LoggedRefStore wrapping it.
+type MemoryRefStore struct {
+	mu   sync.RWMutex
+	refs map[string]string
+	logs map[string][]ReflogEntry
+}
+
+// NewMemoryRefStore returns an empty MemoryRefStore.
+func NewMemoryRefStore() *MemoryRefStore {
+	return &MemoryRefStore{refs: make(map[string]string), logs: make(map[string][]ReflogEntry)}
+}
+
+// ReadRef implements RefStore.
+func (m *MemoryRefStore) ReadRef(name string) (string, error) {
+	if err := CheckRefName(name); err != nil {
+		return "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hash, ok := m.refs[name]
+	if !ok {
+		return "", fmt.Errorf("ref %s: %w", name, ErrNotFound)
+	}
+	return hash, nil
+}
+
+// WriteRef implements RefStore.
+func (m *MemoryRefStore) WriteRef(name, hash string) error {
+	if hash == "" {
+		return fmt.Errorf("ref %s: empty hash", name)
+	}
+	if err := CheckRefName(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkClash(name); err != nil {
+		return err
+	}
+	m.refs[name] = hash
+	return nil
+}
+
+// CompareAndSwapRef implements RefStore.
+func (m *MemoryRefStore) CompareAndSwapRef(name, oldHash, newHash string) error {
+	if err := CheckRefName(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current := m.refs[name]; current != oldHash {
+		return fmt.Errorf("ref %s holds %q, not %q: %w", name, current, oldHash, ErrRefConflict)
+	}
+	if newHash == "" {
+		delete(m.refs, name)
+		return nil
+	}
+	if err := m.checkClash(name); err != nil {
+		return err
+	}
+	m.refs[name] = newHash
+	return nil
+}
+
+// checkClash fails if name is a directory of an existing ref, or an
+// existing ref is a directory of name, which a file-based store could not
+// hold.
+func (m *MemoryRefStore) checkClash(name string) error {
+	for other := range m.refs {
+		if strings.HasPrefix(other, name+"/") || strings.HasPrefix(name, other+"/") {
+			return fmt.Errorf("ref %s clashes with %s: %w", name, other, ErrRefConflict)
+		}
+	}
+	return nil
+}
+
+// DeleteRef implements RefStore.
+func (m *MemoryRefStore) DeleteRef(name string) error {
+	if err := CheckRefName(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.refs, name)
+	return nil
+}
+
+// ListRefs implements RefStore.
+func (m *MemoryRefStore) ListRefs(prefix string) ([]Ref, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var refs []Ref
+	for name, hash := range m.refs {
+		if strings.HasPrefix(name, prefix) {
+			refs = append(refs, Ref{Name: name, Hash: hash})
+		}
+	}
+	slices.SortFunc(refs, func(a, b Ref) int { return strings.Compare(a.Name, b.Name) })
+	return refs, nil
+}
+
+// AppendReflog implements ReflogStore.
+func (m *MemoryRefStore) AppendReflog(name string, entry ReflogEntry) error {
+	if err := CheckRefName(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logs[name] = append(m.logs[name], entry)
+	return nil
+}
+
+// ReadReflog implements ReflogStore.
+func (m *MemoryRefStore) ReadReflog(name string) ([]ReflogEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.logs[name]), nil
+}
+
+// WriteReflog implements ReflogStore.
+func (m *MemoryRefStore) WriteReflog(name string, entries []ReflogEntry) error {
+	if err := CheckRefName(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(entries) == 0 {
+		delete(m.logs, name)
+	} else {
+		m.logs[name] = slices.Clone(entries)
+	}
+	return nil
+}
+
+// ExpireReflog implements ReflogStore.
+func (m *MemoryRefStore) ExpireReflog(name string, keep func(entries []ReflogEntry) []ReflogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries, ok := m.logs[name]
+	if !ok {
+		return nil
+	}
+	if live := keep(slices.Clone(entries)); len(live) == 0 {
+		delete(m.logs, name)
+	} else {
+		m.logs[name] = slices.Clone(live)
+	}
+	return nil
+}
+
+// ListReflogs implements ReflogStore.
+func (m *MemoryRefStore) ListReflogs() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.logs))
+	for name := range m.logs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
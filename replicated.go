@@ -0,0 +1,471 @@
+package merkledb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQuorumNotReached is returned by ReplicatedStorage when too few replicas
+// acknowledged a write, or answered a read, to give a reliable result.
+var ErrQuorumNotReached = errors.New("replica quorum not reached")
+
+// unhealthyAfter is the number of consecutive failures after which a replica
+// is reported unhealthy and tried last on reads.
+const unhealthyAfter = 3
+
+// VerifySHA256 reports whether value is the content addressed by key, that is
+// whether key is the SHA-256 hash of value. Keys that are not 32 bytes long
+// are not hashes and are accepted as is. Chunk manifests are stored under the
+// hash of the content they describe rather than their own, so they fail this
+// check; use VerifyContent to accept them.
+func VerifySHA256(key, value []byte) bool {
+	if len(key) != sha256.Size {
+		return true
+	}
+	sum := sha256.Sum256(value)
+	return bytes.Equal(sum[:], key)
+}
+
+// VerifyContent returns a verification function like VerifySHA256 that also
+// checks chunk manifests, by reading their chunks from s and hashing the
+// reassembled content.
+func VerifyContent(s Storage) func(key, value []byte) bool {
+	return func(key, value []byte) bool {
+		if len(key) == sha256.Size {
+			if m, ok := parseChunkManifest(value); ok {
+				return verifyChunks(s, key, m)
+			}
+		}
+		return VerifySHA256(key, value)
+	}
+}
+
+// ReplicatedOptions configures a ReplicatedStorage.
+type ReplicatedOptions struct {
+	// WriteQuorum is the number of replicas that must acknowledge a Put for
+	// it to succeed. Defaults to a majority of the replicas.
+	WriteQuorum int
+	// Verify checks a value read from a replica before it is returned.
+	// Values that fail are treated as missing on that replica and repaired.
+	// Defaults to VerifyContent over the ReplicatedStorage itself.
+	Verify func(key, value []byte) bool
+	// RepairInterval is how often the background repairer copies objects to
+	// the replicas known to miss them. Defaults to 30 seconds.
+	RepairInterval time.Duration
+}
+
+// ReplicaHealth describes the state of one replica of a ReplicatedStorage.
+type ReplicaHealth struct {
+	// Index is the position of the replica in the list given to
+	// NewReplicatedStorage.
+	Index int
+	// Healthy is false once the replica failed several operations in a row.
+	Healthy bool
+	// Successes and Failures count the operations sent to the replica.
+	Successes uint64
+	Failures  uint64
+	// ConsecutiveFailures is the number of failures since the last success.
+	ConsecutiveFailures int
+	// Corrupt counts the values that failed verification.
+	Corrupt uint64
+	// LastError is the most recent failure, and LastErrorAt its time.
+	LastError   error
+	LastErrorAt time.Time
+	// PendingRepairs is the number of objects queued to be copied to the
+	// replica.
+	PendingRepairs int
+}
+
+// ReplicatedStorage writes every object to several Storage backends and reads
+// it back from any of them.
+//
+// A Put succeeds once WriteQuorum replicas acknowledge it; replicas that
+// failed are queued for repair. A Get tries replicas in turn, healthy ones
+// first, verifies what it reads and fails over to the next replica on an
+// error, a miss or a corrupt value. Replicas found missing an object are
+// queued for repair as well. A key is only reported missing when enough
+// replicas say so that no successful Put can have written it.
+type ReplicatedStorage struct {
+	replicas []Storage
+	quorum   int
+	verify   func(key, value []byte) bool
+
+	mu     sync.Mutex
+	health []ReplicaHealth
+	repair map[string]map[int]struct{} // key -> replicas missing it
+
+	repairMu sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReplicatedStorage creates a ReplicatedStorage over the given replicas and
+// starts its background repairer; call Close to stop it.
+func NewReplicatedStorage(replicas []Storage, opts ReplicatedOptions) (*ReplicatedStorage, error) {
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("at least one replica is required")
+	}
+	for i, r := range replicas {
+		if r == nil {
+			return nil, fmt.Errorf("replica %d is nil", i)
+		}
+	}
+	if opts.WriteQuorum == 0 {
+		opts.WriteQuorum = len(replicas)/2 + 1
+	}
+	if opts.WriteQuorum < 1 || opts.WriteQuorum > len(replicas) {
+		return nil, fmt.Errorf("write quorum %d is out of range for %d replicas", opts.WriteQuorum, len(replicas))
+	}
+	if opts.RepairInterval <= 0 {
+		opts.RepairInterval = 30 * time.Second
+	}
+
+	r := &ReplicatedStorage{
+		replicas: replicas,
+		quorum:   opts.WriteQuorum,
+		verify:   opts.Verify,
+		health:   make([]ReplicaHealth, len(replicas)),
+		repair:   make(map[string]map[int]struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if r.verify == nil {
+		r.verify = VerifyContent(r)
+	}
+	for i := range r.health {
+		r.health[i].Index = i
+	}
+	go r.repairer(opts.RepairInterval)
+	return r, nil
+}
+
+func (r *ReplicatedStorage) repairer(interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.Repair()
+		}
+	}
+}
+
+// Close stops the background repairer. It does not close the replicas.
+func (r *ReplicatedStorage) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+	return nil
+}
+
+// record updates the health of replica i after an operation.
+func (r *ReplicatedStorage) record(i int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := &r.health[i]
+	if err == nil {
+		h.Successes++
+		h.ConsecutiveFailures = 0
+		return
+	}
+	h.Failures++
+	h.ConsecutiveFailures++
+	h.LastError = err
+	h.LastErrorAt = time.Now()
+}
+
+// queueRepair records that the given replicas miss key.
+func (r *ReplicatedStorage) queueRepair(key []byte, replicas []int) {
+	if len(replicas) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	missing := r.repair[string(key)]
+	if missing == nil {
+		missing = make(map[int]struct{})
+		r.repair[string(key)] = missing
+	}
+	for _, i := range replicas {
+		missing[i] = struct{}{}
+	}
+}
+
+// readOrder returns the replica indexes with healthy replicas first.
+func (r *ReplicatedStorage) readOrder() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order := make([]int, len(r.replicas))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return r.health[order[a]].ConsecutiveFailures < unhealthyAfter &&
+			r.health[order[b]].ConsecutiveFailures >= unhealthyAfter
+	})
+	return order
+}
+
+// Put implements the Storage interface. It writes to all replicas in parallel
+// and fails with ErrQuorumNotReached if fewer than WriteQuorum succeed.
+func (r *ReplicatedStorage) Put(key []byte, value []byte) error {
+	errs := make([]error, len(r.replicas))
+	var wg sync.WaitGroup
+	for i, replica := range r.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = replica.Put(key, value)
+			r.record(i, errs[i])
+		}()
+	}
+	wg.Wait()
+
+	var failed []int
+	var failures []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, i)
+			failures = append(failures, fmt.Errorf("replica %d: %w", i, err))
+		}
+	}
+	if acked := len(r.replicas) - len(failed); acked < r.quorum {
+		return fmt.Errorf("%w: %d of %d replicas acknowledged the write: %w",
+			ErrQuorumNotReached, acked, r.quorum, errors.Join(failures...))
+	}
+	r.queueRepair(key, failed)
+	return nil
+}
+
+// Get implements the Storage interface.
+func (r *ReplicatedStorage) Get(key []byte) ([]byte, error) {
+	value, _, err := r.read(key)
+	return value, err
+}
+
+// read returns a verified value for key and the replica it came from.
+// Replicas tried before it that miss the key, or hold a corrupt copy, are
+// queued for repair.
+func (r *ReplicatedStorage) read(key []byte) ([]byte, int, error) {
+	var missing []int
+	var failures []error
+	for _, i := range r.readOrder() {
+		value, err := r.replicas[i].Get(key)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			r.record(i, nil)
+			missing = append(missing, i)
+		case err != nil:
+			r.record(i, err)
+			failures = append(failures, fmt.Errorf("replica %d: %w", i, err))
+		case !r.verify(key, value):
+			err = fmt.Errorf("replica %d holds a corrupt copy of key %x", i, key)
+			r.record(i, err)
+			r.mu.Lock()
+			r.health[i].Corrupt++
+			r.mu.Unlock()
+			missing = append(missing, i)
+			failures = append(failures, err)
+		default:
+			r.record(i, nil)
+			r.queueRepair(key, missing)
+			return value, i, nil
+		}
+	}
+	if r.absent(len(missing)) && len(failures) == 0 {
+		return nil, -1, ErrNotFound
+	}
+	return nil, -1, fmt.Errorf("%w: failed to read key %x: %w", ErrQuorumNotReached, key, errors.Join(failures...))
+}
+
+// absent reports whether n replicas missing a key prove that no successful
+// Put wrote it, because fewer than WriteQuorum replicas remain.
+func (r *ReplicatedStorage) absent(n int) bool {
+	return len(r.replicas)-n < r.quorum
+}
+
+// Exists implements the Storage interface.
+func (r *ReplicatedStorage) Exists(key []byte) (bool, error) {
+	var missing int
+	var failures []error
+	for _, i := range r.readOrder() {
+		ok, err := r.replicas[i].Exists(key)
+		r.record(i, err)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("replica %d: %w", i, err))
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+		missing++
+		if r.absent(missing) {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("%w: failed to check key %x: %w", ErrQuorumNotReached, key, errors.Join(failures...))
+}
+
+// Supports implements the ExtensionReporter interface: deletion works if
+// every replica supports it.
+func (r *ReplicatedStorage) Supports(ext Extension) bool {
+	if ext != ExtDeleter {
+		return false
+	}
+	for _, replica := range r.replicas {
+		if !Supports(replica, ExtDeleter) {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete implements the Deleter interface. Every replica must implement
+// Deleter and acknowledge the deletion; a replica that keeps a copy could
+// otherwise bring the object back through Scrub.
+func (r *ReplicatedStorage) Delete(key []byte) error {
+	// Check every replica first, so none is deleted from if one cannot be.
+	deleters := make([]Deleter, len(r.replicas))
+	for i, replica := range r.replicas {
+		d, ok := AsDeleter(replica)
+		if !ok {
+			return fmt.Errorf("replica %d does not support deletion", i)
+		}
+		deleters[i] = d
+	}
+	r.mu.Lock()
+	delete(r.repair, string(key))
+	r.mu.Unlock()
+
+	var failures []error
+	for i, d := range deleters {
+		err := d.Delete(key)
+		r.record(i, err)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("replica %d: %w", i, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to delete key %x: %w", key, errors.Join(failures...))
+	}
+	return nil
+}
+
+// Repair copies every queued object to the replicas that miss it. Objects
+// that cannot be repaired stay queued; the first error is returned.
+func (r *ReplicatedStorage) Repair() error {
+	r.repairMu.Lock()
+	defer r.repairMu.Unlock()
+
+	r.mu.Lock()
+	queued := r.repair
+	r.repair = make(map[string]map[int]struct{})
+	r.mu.Unlock()
+
+	var firstErr error
+	for k, missing := range queued {
+		key := []byte(k)
+		value, source, err := r.read(key)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since it was queued.
+			continue
+		}
+		if err != nil {
+			r.requeue(key, missing)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to read key %x for repair: %w", key, err)
+			}
+			continue
+		}
+		var failed []int
+		for i := range missing {
+			if i == source {
+				continue
+			}
+			err := r.replicas[i].Put(key, value)
+			r.record(i, err)
+			if err != nil {
+				failed = append(failed, i)
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to repair key %x on replica %d: %w", key, i, err)
+				}
+			}
+		}
+		r.queueRepair(key, failed)
+	}
+	return firstErr
+}
+
+func (r *ReplicatedStorage) requeue(key []byte, missing map[int]struct{}) {
+	replicas := make([]int, 0, len(missing))
+	for i := range missing {
+		replicas = append(replicas, i)
+	}
+	r.queueRepair(key, replicas)
+}
+
+// Scrub walks every replica, queues the objects that other replicas miss and
+// repairs them. All replicas must implement Iterator.
+func (r *ReplicatedStorage) Scrub() error {
+	iterators := make([]Iterator, len(r.replicas))
+	for i, replica := range r.replicas {
+		it, ok := AsIterator(replica)
+		if !ok {
+			return fmt.Errorf("replica %d does not support iteration", i)
+		}
+		iterators[i] = it
+	}
+
+	for i, it := range iterators {
+		err := it.Iterate(nil, nil, func(key, value []byte) error {
+			var missing []int
+			for j, other := range r.replicas {
+				if j == i {
+					continue
+				}
+				ok, err := other.Exists(key)
+				r.record(j, err)
+				if err == nil && !ok {
+					missing = append(missing, j)
+				}
+			}
+			if !r.verify(key, value) {
+				missing = append(missing, i)
+			}
+			r.queueRepair(key, missing)
+			return nil
+		})
+		r.record(i, err)
+		if err != nil {
+			return fmt.Errorf("failed to scan replica %d: %w", i, err)
+		}
+	}
+	return r.Repair()
+}
+
+// Health returns the state of every replica.
+func (r *ReplicatedStorage) Health() []ReplicaHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	health := make([]ReplicaHealth, len(r.health))
+	copy(health, r.health)
+	for i := range health {
+		health[i].Healthy = health[i].ConsecutiveFailures < unhealthyAfter
+	}
+	for _, missing := range r.repair {
+		for i := range missing {
+			health[i].PendingRepairs++
+		}
+	}
+	return health
+}
@@ -0,0 +1,236 @@
+package merkledb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func newTestReplicas(n int) ([]*lockedStorage, []Storage) {
+	locked := make([]*lockedStorage, n)
+	replicas := make([]Storage, n)
+	for i := range locked {
+		locked[i] = newLockedStorage()
+		replicas[i] = locked[i]
+	}
+	return locked, replicas
+}
+
+func newTestReplicated(t *testing.T, replicas []Storage, opts ReplicatedOptions) *ReplicatedStorage {
+	t.Helper()
+	r, err := NewReplicatedStorage(replicas, opts)
+	if err != nil {
+		t.Fatalf("NewReplicatedStorage() failed: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// contentKey returns the SHA-256 key of value, as used by the ObjectStore.
+func contentKey(value string) []byte {
+	sum := sha256.Sum256([]byte(value))
+	return sum[:]
+}
+
+func TestReplicatedStorage_InterfaceContracts(t *testing.T) {
+	var _ Storage = (*ReplicatedStorage)(nil)
+	var _ Deleter = (*ReplicatedStorage)(nil)
+}
+
+func TestReplicatedStorage_WriteQuorum(t *testing.T) {
+	locked, replicas := newTestReplicas(3)
+	r := newTestReplicated(t, replicas, ReplicatedOptions{})
+	key := contentKey("value")
+
+	// One replica down: the majority still acknowledges the write.
+	locked[2].setFailPuts(true)
+	if err := r.Put(key, []byte("value")); err != nil {
+		t.Fatalf("Put() with one replica down failed: %v", err)
+	}
+	if got := r.Health()[2].PendingRepairs; got != 1 {
+		t.Errorf("failed replica has %d pending repairs, want 1", got)
+	}
+
+	// Two replicas down: the write fails.
+	locked[1].setFailPuts(true)
+	err := r.Put(contentKey("other"), []byte("other"))
+	if !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("Put() with two replicas down returned %v, want ErrQuorumNotReached", err)
+	}
+}
+
+func TestReplicatedStorage_ReadFailover(t *testing.T) {
+	locked, replicas := newTestReplicas(3)
+	r := newTestReplicated(t, replicas, ReplicatedOptions{})
+	key := contentKey("value")
+	if err := r.Put(key, []byte("value")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	// The first replica is down and the second holds a corrupt copy.
+	locked[0].setFailGets(true)
+	locked[1].Put(key, []byte("corrupted"))
+
+	value, err := r.Get(key)
+	if err != nil || string(value) != "value" {
+		t.Fatalf("Get() = %q, %v; want %q", value, err, "value")
+	}
+	health := r.Health()
+	if health[1].Corrupt != 1 || health[1].PendingRepairs != 1 {
+		t.Errorf("corrupt replica health = %+v, want one corrupt value queued for repair", health[1])
+	}
+
+	if err := r.Repair(); err != nil {
+		t.Fatalf("Repair() failed: %v", err)
+	}
+	if stored, _ := locked[1].Get(key); string(stored) != "value" {
+		t.Errorf("Repair() left %q on the corrupt replica, want %q", stored, "value")
+	}
+}
+
+func TestReplicatedStorage_NotFoundNeedsQuorum(t *testing.T) {
+	locked, replicas := newTestReplicas(3)
+	r := newTestReplicated(t, replicas, ReplicatedOptions{})
+	key := contentKey("value")
+
+	if _, err := r.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing key returned %v, want ErrNotFound", err)
+	}
+	if ok, err := r.Exists(key); ok || err != nil {
+		t.Errorf("Exists() of a missing key = %v, %v; want false", ok, err)
+	}
+
+	// With two replicas unreachable, one miss does not prove the key absent.
+	locked[0].setFailGets(true)
+	locked[1].setFailGets(true)
+	if _, err := r.Get(key); errors.Is(err, ErrNotFound) || !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("Get() with two replicas down returned %v, want ErrQuorumNotReached", err)
+	}
+	if _, err := r.Exists(key); !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("Exists() with two replicas down returned %v, want ErrQuorumNotReached", err)
+	}
+}
+
+func TestReplicatedStorage_Health(t *testing.T) {
+	locked, replicas := newTestReplicas(2)
+	r := newTestReplicated(t, replicas, ReplicatedOptions{WriteQuorum: 1})
+
+	locked[0].setFailPuts(true)
+	for i := 0; i < unhealthyAfter; i++ {
+		r.Put(contentKey(string(rune('a'+i))), []byte{byte('a' + i)})
+	}
+	health := r.Health()
+	if health[0].Healthy || health[0].LastError == nil || health[0].Failures != unhealthyAfter {
+		t.Errorf("failing replica health = %+v, want unhealthy with %d failures", health[0], unhealthyAfter)
+	}
+	if !health[1].Healthy || health[1].Successes != unhealthyAfter {
+		t.Errorf("working replica health = %+v, want healthy", health[1])
+	}
+	if order := r.readOrder(); order[0] != 1 {
+		t.Errorf("readOrder() = %v, want the healthy replica first", order)
+	}
+}
+
+func TestReplicatedStorage_Scrub(t *testing.T) {
+	locked, replicas := newTestReplicas(3)
+	r := newTestReplicated(t, replicas, ReplicatedOptions{})
+
+	// Objects written behind the wrapper's back, each on a single replica.
+	for i, value := range []string{"one", "two", "three"} {
+		locked[i].Put(contentKey(value), []byte(value))
+	}
+	if err := r.Scrub(); err != nil {
+		t.Fatalf("Scrub() failed: %v", err)
+	}
+	for i, replica := range locked {
+		for _, value := range []string{"one", "two", "three"} {
+			if ok, _ := replica.Exists(contentKey(value)); !ok {
+				t.Errorf("replica %d is missing %q after Scrub()", i, value)
+			}
+		}
+	}
+}
+
+func TestReplicatedStorage_Delete(t *testing.T) {
+	locked, replicas := newTestReplicas(3)
+	r := newTestReplicated(t, replicas, ReplicatedOptions{})
+	key := contentKey("value")
+	r.Put(key, []byte("value"))
+
+	if err := r.Delete(key); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	for i, replica := range locked {
+		if ok, _ := replica.Exists(key); ok {
+			t.Errorf("replica %d still holds the key after Delete()", i)
+		}
+	}
+}
+
+func TestReplicatedStorage_DeleteChecksEveryReplicaFirst(t *testing.T) {
+	locked, replicas := newTestReplicas(2)
+	replicas = append(replicas, NewMockStorage())
+	r := newTestReplicated(t, replicas, ReplicatedOptions{})
+	key := contentKey("value")
+	r.Put(key, []byte("value"))
+
+	if err := r.Delete(key); err == nil {
+		t.Fatal("Delete() succeeded although a replica cannot delete")
+	}
+	for i, replica := range locked {
+		if ok, _ := replica.Exists(key); !ok {
+			t.Errorf("replica %d lost the key although Delete() failed", i)
+		}
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	if !VerifySHA256(contentKey("value"), []byte("value")) {
+		t.Error("VerifySHA256() rejected a matching value")
+	}
+	if VerifySHA256(contentKey("value"), []byte("other")) {
+		t.Error("VerifySHA256() accepted a mismatching value")
+	}
+	if !VerifySHA256([]byte("short key"), []byte("anything")) {
+		t.Error("VerifySHA256() rejected a key that is not a hash")
+	}
+}
+
+func TestReplicatedStorage_VerifiesChunkedObjects(t *testing.T) {
+	locked, replicas := newTestReplicas(2)
+	r := newTestReplicated(t, replicas, ReplicatedOptions{WriteQuorum: 2})
+	store := NewObjectStore(r, WithChunking(ChunkingOptions{Threshold: 16 << 10, AvgSize: 4 << 10}))
+	data := randomBytes(6, 64<<10)
+	hash, err := store.WriteObject(rawObject(data))
+	if err != nil {
+		t.Fatalf("WriteObject() failed: %v", err)
+	}
+
+	// A manifest listing other chunks is corrupt although it parses.
+	key, _ := hex.DecodeString(hash)
+	m, _, _ := store.ReadChunkManifest(hash)
+	m.Chunks = m.Chunks[1:]
+	encoded, _ := json.Marshal(m)
+	locked[0].Put(key, append(bytes.Clone(chunkManifestMagic), encoded...))
+
+	if got, err := store.ReadRawObject(hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadRawObject() = %d bytes, %v; want the object", len(got), err)
+	}
+	if corrupt := r.Health()[0].Corrupt; corrupt != 1 {
+		t.Errorf("replica 0 has %d corrupt values, want 1", corrupt)
+	}
+}
+
+func TestReplicatedStorage_SupportsDeletionOfEveryReplica(t *testing.T) {
+	_, replicas := newTestReplicas(2)
+	if _, ok := AsDeleter(newTestReplicated(t, replicas, ReplicatedOptions{})); !ok {
+		t.Error("AsDeleter() failed although every replica can delete")
+	}
+	replicas = append(replicas, NewMockStorage())
+	if _, ok := AsDeleter(newTestReplicated(t, replicas, ReplicatedOptions{})); ok {
+		t.Error("AsDeleter() succeeded with a replica that cannot delete")
+	}
+}
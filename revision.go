@@ -0,0 +1,353 @@
+package merkledb
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrAmbiguousRevision is returned by ResolveRevision when a short hash
+// matches more than one object.
+var ErrAmbiguousRevision = errors.New("ambiguous revision")
+
+// minShortHash is the shortest hash prefix ResolveRevision accepts.
+const minShortHash = 4
+
+// RevisionError describes a revision expression that could not be resolved.
+type RevisionError struct {
+	Rev string
+	Err error
+}
+
+func (e *RevisionError) Error() string {
+	return fmt.Sprintf("revision %q: %v", e.Rev, e.Err)
+}
+
+func (e *RevisionError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveRevision resolves a revision expression to the hash of the object
+// it names. refs may be nil, in which case only hashes are accepted. An
+// expression is made of:
+//
+//   - a base: a ref name, abbreviated as for ResolveRef, "@" for HEAD, a full
+//     hash, or a unique prefix of at least 4 hex digits of one, which needs
+//     the store's storage to implement Iterator;
+//   - optionally, "@{N}" for the Nth previous value of the ref in its reflog,
+//     or "@{time}" for its value at an RFC 3339 time, a "2006-01-02 15:04:05"
+//     or a "2006-01-02" UTC time;
+//   - any number of ancestry operators: "~N" for the Nth first-parent
+//     ancestor and "^N" for the Nth parent, N defaulting to 1 and "^0" naming
+//     the commit itself;
+//   - optionally, ":path" for the object at path in the commit's tree, or
+//     the tree itself for an empty path.
+//
+// Tags are peeled to their commits where a commit is needed. For example,
+// "main~3", "HEAD^2", "v2024-10:stops/S1" and "main@{2024-03-03}" are valid
+// revisions. Errors are *RevisionError values wrapping ErrNotFound or
+// ErrAmbiguousRevision where appropriate.
+func ResolveRevision(store *ObjectStore, refs RefStore, rev string) (string, error) {
+	if store == nil {
+		return "", fmt.Errorf("object store cannot be nil")
+	}
+	hash, err := resolveRevision(store, refs, rev)
+	if err != nil {
+		return "", &RevisionError{Rev: rev, Err: err}
+	}
+	return hash, nil
+}
+
+func resolveRevision(store *ObjectStore, refs RefStore, rev string) (string, error) {
+	expr, path, hasPath := splitPath(rev)
+
+	// The base ends at the first ancestry operator outside braces.
+	end := len(expr)
+	depth := 0
+	for i, c := range expr {
+		if c == '{' {
+			depth++
+		} else if c == '}' {
+			depth--
+		} else if depth == 0 && (c == '~' || c == '^') {
+			end = i
+			break
+		}
+	}
+	hash, err := resolveBase(store, refs, expr[:end])
+	if err != nil {
+		return "", err
+	}
+
+	for ops := expr[end:]; ops != ""; {
+		op := ops[0]
+		ops = ops[1:]
+		digits := len(ops) - len(strings.TrimLeft(ops, "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(ops[:digits]); err != nil {
+				return "", fmt.Errorf("invalid count %q", ops[:digits])
+			}
+			ops = ops[digits:]
+		}
+		if op != '~' && op != '^' {
+			return "", fmt.Errorf("unexpected %q", string(op))
+		}
+		if hash, err = ancestor(store, hash, op, n); err != nil {
+			return "", err
+		}
+	}
+
+	if !hasPath {
+		return hash, nil
+	}
+	commitHash, err := Peel(store, hash)
+	if err != nil {
+		return "", err
+	}
+	commit, err := store.ReadCommit(commitHash)
+	if err != nil {
+		return "", err
+	}
+	return lookupPath(store, commit.TreeHash, path)
+}
+
+// splitPath splits rev at its first colon outside "@{...}".
+func splitPath(rev string) (expr, path string, ok bool) {
+	depth := 0
+	for i, c := range rev {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		case c == ':' && depth == 0:
+			return rev[:i], rev[i+1:], true
+		}
+	}
+	return rev, "", false
+}
+
+// resolveBase resolves a ref, hash or short hash, with an optional reflog
+// selector.
+func resolveBase(store *ObjectStore, refs RefStore, base string) (string, error) {
+	selector := ""
+	if i := strings.Index(base, "@{"); i >= 0 {
+		if !strings.HasSuffix(base, "}") {
+			return "", fmt.Errorf("unterminated @{")
+		}
+		base, selector = base[:i], base[i+2:len(base)-1]
+	}
+	if base == "" || base == "@" {
+		base = "HEAD"
+	}
+
+	if refs != nil {
+		ref, err := ResolveRef(refs, base)
+		if err == nil {
+			if selector != "" {
+				return resolveReflog(refs, ref, selector)
+			}
+			return ref.Hash, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+	}
+	if selector != "" {
+		// A deleted ref can still be recovered from its reflog.
+		if logs, ok := refs.(ReflogStore); ok {
+			for _, name := range refCandidates(base) {
+				if entries, err := logs.ReadReflog(name); err == nil && len(entries) > 0 {
+					return resolveReflog(refs, Ref{Name: name}, selector)
+				}
+			}
+		}
+		return "", fmt.Errorf("ref %q: %w", base, ErrNotFound)
+	}
+	return resolveHash(store, base)
+}
+
+// resolveHash resolves a full or abbreviated hash to the object it names.
+func resolveHash(store *ObjectStore, prefix string) (string, error) {
+	if len(prefix) < minShortHash || len(prefix) > hex.EncodedLen(32) || strings.Trim(strings.ToLower(prefix), "0123456789abcdef") != "" {
+		return "", fmt.Errorf("unknown ref or hash %q: %w", prefix, ErrNotFound)
+	}
+	prefix = strings.ToLower(prefix)
+	if len(prefix) == hex.EncodedLen(32) {
+		key, _ := hex.DecodeString(prefix)
+		ok, err := store.storage.Exists(key)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("object %s: %w", prefix, ErrNotFound)
+		}
+		return prefix, nil
+	}
+
+	it, ok := AsIterator(store.storage)
+	if !ok {
+		return "", fmt.Errorf("short hash %q: storage cannot list objects, use the full hash", prefix)
+	}
+	start, end := prefixRange(prefix)
+	var matches []string
+	errStop := errors.New("stop")
+	err := it.Iterate(start, end, func(key, value []byte) error {
+		if h := hex.EncodeToString(key); strings.HasPrefix(h, prefix) {
+			matches = append(matches, h)
+			if len(matches) > 1 {
+				return errStop
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return "", fmt.Errorf("failed to list objects: %w", err)
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("short hash %q: %w", prefix, ErrNotFound)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("short hash %q matches %s and %s: %w", prefix, matches[0], matches[1], ErrAmbiguousRevision)
+}
+
+// prefixRange returns the range of keys whose hex encoding starts with the
+// hex digits of prefix. A nil end is unbounded.
+func prefixRange(prefix string) (start, end []byte) {
+	lo := prefix
+	if len(lo)%2 == 1 {
+		lo += "0"
+	}
+	start, _ = hex.DecodeString(lo)
+
+	// The end is the prefix plus one, as a hex number.
+	digits := []byte(prefix)
+	i := len(digits) - 1
+	for ; i >= 0 && digits[i] == 'f'; i-- {
+		digits[i] = '0'
+	}
+	if i < 0 {
+		return start, nil
+	}
+	digits[i] = "123456789abcdef"[strings.IndexByte("0123456789abcde", digits[i])]
+	hi := string(digits[:i+1])
+	if len(hi)%2 == 1 {
+		hi += "0"
+	}
+	end, _ = hex.DecodeString(hi)
+	return start, end
+}
+
+// ancestor applies the ancestry operator op with count n to hash.
+func ancestor(store *ObjectStore, hash string, op byte, n int) (string, error) {
+	hash, err := Peel(store, hash)
+	if err != nil {
+		return "", err
+	}
+	if op == '^' {
+		commit, err := store.ReadCommit(hash)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			return hash, nil
+		}
+		if n > len(commit.ParentHashes) {
+			return "", fmt.Errorf("commit %s has %d parents, no parent %d", hash, len(commit.ParentHashes), n)
+		}
+		return commit.ParentHashes[n-1], nil
+	}
+	for i := 0; i < n; i++ {
+		commit, err := store.ReadCommit(hash)
+		if err != nil {
+			return "", err
+		}
+		if len(commit.ParentHashes) == 0 {
+			return "", fmt.Errorf("commit %s has no parent, cannot go back %d", hash, n)
+		}
+		hash = commit.ParentHashes[0]
+	}
+	return hash, nil
+}
+
+// lookupPath returns the hash of the object at path below the tree with the
+// given hash.
+func lookupPath(store *ObjectStore, treeHash, path string) (string, error) {
+	entry, err := findEntry(store.ReadTree, treeHash, path)
+	if err != nil {
+		return "", err
+	}
+	if entry.Kind == KindLink {
+		return "", fmt.Errorf("path %q is a link to %q", path, entry.Target)
+	}
+	return entry.Hash, nil
+}
+
+// findEntry returns the entry at path below the tree with the given hash,
+// reading trees with readTree. An empty path is the tree itself. Since entry
+// names may themselves contain slashes, each prefix of the path is tried
+// both as an entry name and as a subtree.
+func findEntry(readTree func(hash string) (*Tree, error), treeHash, path string) (TreeEntry, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return TreeEntryOf(treeHash), nil
+	}
+	tree, err := readTree(treeHash)
+	if err != nil {
+		return TreeEntry{}, err
+	}
+	if entry, ok := tree.Entries[path]; ok {
+		return entry, nil
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+		if entry, ok := tree.Entries[path[:i]]; ok && entry.Kind == KindTree {
+			if entry, err := findEntry(readTree, entry.Hash, path[i+1:]); err == nil || !errors.Is(err, ErrNotFound) {
+				return entry, err
+			}
+		}
+	}
+	return TreeEntry{}, fmt.Errorf("path %q: %w", path, ErrNotFound)
+}
+
+// reflogSelector is a parsed "@{...}" selector: the Nth previous value of a
+// ref, or its value at a time.
+type reflogSelector struct {
+	n  int
+	at time.Time
+}
+
+// parseReflogSelector parses the inside of "@{...}".
+func parseReflogSelector(s string) (reflogSelector, error) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return reflogSelector{n: n}, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return reflogSelector{n: -1, at: t}, nil
+		}
+	}
+	return reflogSelector{}, fmt.Errorf("invalid reflog selector @{%s}: want a count or a time", s)
+}
+
+// resolveReflog resolves ref@{selector}, which needs refs to implement
+// ReflogStore.
+func resolveReflog(refs RefStore, ref Ref, selector string) (string, error) {
+	sel, err := parseReflogSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	logs, ok := refs.(ReflogStore)
+	if !ok {
+		return "", fmt.Errorf("no reflog for %s: ref store keeps no reflogs: %w", ref.Name, ErrNotFound)
+	}
+	return reflogAt(logs, ref.Name, sel)
+}
@@ -0,0 +1,163 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// revisionFixture builds the history
+//
+//	c0 - c1 - c2 - m (main)
+//	       \       /
+//	        f1 ---    (feature)
+//
+// with a subtree "dir" in m, and tags v1 on c1 and v1-final on v1.
+func revisionFixture(t *testing.T) (*ObjectStore, *MemoryRefStore, map[string]string) {
+	t.Helper()
+	g := newCommitGraph(t, WithClock(StepClock(time.Unix(0, 0), time.Minute)))
+	h := g.h
+	commit := func(name string, entries map[string]TreeEntry, parents ...string) {
+		g.commit(name, map[string]string{"stops/S1": "S1 in " + name, "name": name}, entries, parents, nil)
+	}
+	commit("c0", nil)
+	commit("c1", nil, h["c0"])
+	commit("c2", nil, h["c1"])
+	commit("f1", nil, h["c1"])
+	commit("m", map[string]TreeEntry{
+		"dir":  TreeEntryOf(g.tree(map[string]TreeEntry{"file": BlobEntry(h["c0"])})),
+		"link": LinkEntry("name"),
+	}, h["c2"], h["f1"])
+	g.refs.WriteRef(HeadsPrefix+"main", h["m"])
+	g.refs.WriteRef(HeadsPrefix+"feature", h["f1"])
+	g.refs.WriteRef("HEAD", h["m"])
+	h["v1"], _ = CreateTag(g.store, g.refs, "v1", h["c1"], "", nil)
+	CreateTag(g.store, g.refs, "v1-final", h["v1"], "", &TagOptions{Kind: TargetTag})
+	return g.store, g.refs, h
+}
+
+func TestResolveRevision(t *testing.T) {
+	store, refs, h := revisionFixture(t)
+	m, _ := store.ReadCommit(h["m"])
+	mTree, _ := store.ReadTree(m.TreeHash)
+
+	for rev, want := range map[string]string{
+		"main":              h["m"],
+		"refs/heads/main":   h["m"],
+		"HEAD":              h["m"],
+		"@":                 h["m"],
+		"main~":             h["c2"],
+		"main~2":            h["c1"],
+		"main~3":            h["c0"],
+		"HEAD^2":            h["f1"],
+		"HEAD^":             h["c2"],
+		"main^2~1":          h["c1"],
+		"main^^":            h["c1"],
+		"main~0":            h["m"],
+		"v1":                h["v1"],
+		"v1^0":              h["c1"],
+		"v1-final~1":        h["c0"],
+		h["c2"]:             h["c2"],
+		h["c2"][:10]:        h["c2"],
+		h["c2"][:12] + "~1": h["c1"],
+		"main:":             m.TreeHash,
+		"main:stops/S1":     mTree.Entries["stops/S1"].Hash,
+		"main:/name":        mTree.Entries["name"].Hash,
+		"main:dir/file":     h["c0"],
+		"main:dir":          mTree.Entries["dir"].Hash,
+		"v1:name":           mustRevision(t, store, refs, "c1-name", h["c1"]),
+	} {
+		got, err := ResolveRevision(store, refs, rev)
+		if err != nil || got != want {
+			t.Errorf("ResolveRevision(%q) = %s, %v; want %s", rev, got, err, want)
+		}
+	}
+}
+
+// mustRevision returns the hash of the "name" entry of commit.
+func mustRevision(t *testing.T, store *ObjectStore, refs RefStore, label, commit string) string {
+	t.Helper()
+	hash, err := ResolveRevision(store, refs, commit+":name")
+	if err != nil {
+		t.Fatalf("%s: %v", label, err)
+	}
+	return hash
+}
+
+func TestResolveRevision_Errors(t *testing.T) {
+	store, refs, h := revisionFixture(t)
+	for rev, want := range map[string]error{
+		"missing":        ErrNotFound,
+		"main~9":         nil,
+		"main^3":         nil,
+		"main~x":         nil,
+		"main:nope":      ErrNotFound,
+		"main:link":      nil,
+		"abc":            ErrNotFound,
+		"main@{1}":       ErrNotFound,
+		"main@{bad}":     nil,
+		"main@{1":        nil,
+		h["c0"] + "00":   ErrNotFound,
+		"0000" + h["c0"]: ErrNotFound,
+	} {
+		_, err := ResolveRevision(store, refs, rev)
+		var revErr *RevisionError
+		if !errors.As(err, &revErr) || revErr.Rev != rev {
+			t.Errorf("ResolveRevision(%q) = %v, want a *RevisionError", rev, err)
+			continue
+		}
+		if want != nil && !errors.Is(err, want) {
+			t.Errorf("ResolveRevision(%q) = %v, want an error matching %v", rev, err, want)
+		}
+	}
+}
+
+func TestResolveRevision_ShortHashes(t *testing.T) {
+	store := NewObjectStore(newLockedStorage())
+	// Find two objects sharing a 4-digit prefix.
+	seen := make(map[string]string)
+	var a, b string
+	for i := 0; a == ""; i++ {
+		hash, _ := store.WriteObject(&mockObject{Data: fmt.Sprint(i)})
+		if other, ok := seen[hash[:4]]; ok {
+			a, b = other, hash
+		}
+		seen[hash[:4]] = hash
+	}
+	if _, err := ResolveRevision(store, nil, a[:4]); !errors.Is(err, ErrAmbiguousRevision) {
+		t.Errorf("ResolveRevision() of a shared prefix = %v, want ErrAmbiguousRevision", err)
+	}
+	n := 5
+	for a[:n] == b[:n] {
+		n++
+	}
+	if got, err := ResolveRevision(store, nil, a[:n]); err != nil || got != a {
+		t.Errorf("ResolveRevision(%s) = %s, %v; want %s", a[:n], got, err, a)
+	}
+
+	// Without an Iterator, only full hashes work.
+	plain := NewObjectStore(NewMockStorage())
+	hash, _ := plain.WriteObject(&mockObject{Data: "x"})
+	if _, err := ResolveRevision(plain, nil, hash[:8]); err == nil {
+		t.Error("ResolveRevision() of a short hash succeeded without an Iterator")
+	}
+	if got, err := ResolveRevision(plain, nil, hash); err != nil || got != hash {
+		t.Errorf("ResolveRevision() of a full hash = %s, %v", got, err)
+	}
+}
+
+func TestPrefixRange(t *testing.T) {
+	for prefix, want := range map[string][2]string{
+		"abcd": {"abcd", "abce"},
+		"abc":  {"abc0", "abd0"},
+		"abf":  {"abf0", "ac"},
+		"ff":   {"ff", ""},
+		"0fff": {"0fff", "10"},
+	} {
+		start, end := prefixRange(prefix)
+		if fmt.Sprintf("%x", start) != want[0] || fmt.Sprintf("%x", end) != want[1] {
+			t.Errorf("prefixRange(%s) = %x, %x; want %s, %s", prefix, start, end, want[0], want[1])
+		}
+	}
+}
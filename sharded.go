@@ -0,0 +1,460 @@
+package merkledb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Shard is one backend of a ShardedStorage. The name fixes the shard's
+// position on the hash ring, so it must stay the same across restarts.
+type Shard struct {
+	Name    string
+	Storage Storage
+}
+
+// ShardedOptions configures a ShardedStorage.
+type ShardedOptions struct {
+	// VirtualNodes is the number of points each shard owns on the hash ring.
+	// More points spread keys more evenly. Defaults to 128.
+	VirtualNodes int
+}
+
+// RebalanceStats summarizes a ShardedStorage.Rebalance run.
+type RebalanceStats struct {
+	// Scanned is the number of keys read from the shards.
+	Scanned int
+	// Moved is the number of keys copied to the shard that now owns them.
+	Moved int
+}
+
+// ring is a consistent-hash ring mapping key positions to shards.
+type ring struct {
+	points []uint64
+	owners []int // shard index of each point
+}
+
+func newRing(shards []Shard, vnodes int) *ring {
+	r := &ring{}
+	type point struct {
+		pos   uint64
+		owner int
+	}
+	var points []point
+	for i, s := range shards {
+		for v := 0; v < vnodes; v++ {
+			sum := sha256.Sum256([]byte(s.Name + "#" + strconv.Itoa(v)))
+			points = append(points, point{binary.BigEndian.Uint64(sum[:8]), i})
+		}
+	}
+	sort.Slice(points, func(a, b int) bool { return points[a].pos < points[b].pos })
+	for _, p := range points {
+		r.points = append(r.points, p.pos)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// owner returns the index of the shard that owns key: the first point at or
+// after the key's position, wrapping around the ring.
+func (r *ring) owner(key []byte) int {
+	pos := keyPosition(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= pos })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// keyPosition places a key on the ring. Content hashes are already uniformly
+// distributed and are used as is; other keys are hashed first.
+func keyPosition(key []byte) uint64 {
+	if len(key) == sha256.Size {
+		return binary.BigEndian.Uint64(key[:8])
+	}
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// ShardedStorage spreads keys over several Storage backends using consistent
+// hashing, so that adding a shard only moves the keys it takes over.
+//
+// After AddShard, keys written earlier may still live on their previous
+// shard. Reads fall back to that shard until Rebalance has moved every key
+// to its new owner, so the storage stays fully usable while rebalancing.
+type ShardedStorage struct {
+	vnodes int
+
+	mu     sync.RWMutex
+	shards []Shard
+	ring   *ring
+	// older holds the rings keys were placed with since the last completed
+	// rebalance, newest first. It is empty when no rebalance is pending.
+	older []*ring
+
+	rebalanceMu sync.Mutex
+	// keys serializes Put and Delete with the moves of Rebalance.
+	keys keyLocks
+}
+
+// NewShardedStorage creates a ShardedStorage over the given shards.
+func NewShardedStorage(shards []Shard, opts ShardedOptions) (*ShardedStorage, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("at least one shard is required")
+	}
+	if opts.VirtualNodes <= 0 {
+		opts.VirtualNodes = 128
+	}
+	s := &ShardedStorage{vnodes: opts.VirtualNodes}
+	for _, shard := range shards {
+		if err := s.checkShard(shard); err != nil {
+			return nil, err
+		}
+		s.shards = append(s.shards, shard)
+	}
+	s.ring = newRing(s.shards, s.vnodes)
+	return s, nil
+}
+
+func (s *ShardedStorage) checkShard(shard Shard) error {
+	if shard.Storage == nil {
+		return fmt.Errorf("shard %q has no storage", shard.Name)
+	}
+	for _, existing := range s.shards {
+		if existing.Name == shard.Name {
+			return fmt.Errorf("duplicate shard name %q", shard.Name)
+		}
+	}
+	return nil
+}
+
+// AddShard adds a shard to the ring. New writes are routed with the new ring
+// straight away; call Rebalance to move existing keys.
+func (s *ShardedStorage) AddShard(shard Shard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkShard(shard); err != nil {
+		return err
+	}
+	s.older = append([]*ring{s.ring}, s.older...)
+	s.shards = append(s.shards, shard)
+	s.ring = newRing(s.shards, s.vnodes)
+	return nil
+}
+
+// Rebalancing reports whether some keys may still live on a shard other than
+// their owner.
+func (s *ShardedStorage) Rebalancing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.older) > 0
+}
+
+// rebalanceBatch is the number of keys Rebalance collects from a shard
+// before moving them.
+const rebalanceBatch = 1024
+
+// Rebalance copies every key that lives on the wrong shard to its owner and
+// deletes the old copy when the shard implements Deleter. Every shard must
+// implement Iterator. It is safe to run while the storage is in use, and to
+// run again after a failure.
+func (s *ShardedStorage) Rebalance() (RebalanceStats, error) {
+	s.rebalanceMu.Lock()
+	defer s.rebalanceMu.Unlock()
+
+	s.mu.RLock()
+	shards := append([]Shard(nil), s.shards...)
+	current := s.ring
+	s.mu.RUnlock()
+
+	// Adding a shard only moves keys to the new shard, so a key always moves
+	// to a shard added after the one holding it. Scanning the newest shards
+	// first means a moved key is never scanned a second time.
+	var stats RebalanceStats
+	for i := len(shards) - 1; i >= 0; i-- {
+		shard := shards[i]
+		it, ok := AsIterator(shard.Storage)
+		if !ok {
+			return stats, fmt.Errorf("shard %q does not support iteration", shard.Name)
+		}
+		var start []byte
+		for {
+			var moves [][]byte
+			var last []byte
+			full := false
+			err := it.Iterate(start, nil, func(key, value []byte) error {
+				stats.Scanned++
+				last = key
+				if current.owner(key) != i {
+					moves = append(moves, key)
+				}
+				if len(moves) == rebalanceBatch {
+					full = true
+					return errStopIteration
+				}
+				return nil
+			})
+			if err != nil && !errors.Is(err, errStopIteration) {
+				return stats, fmt.Errorf("failed to scan shard %q: %w", shard.Name, err)
+			}
+			for _, key := range moves {
+				moved, err := s.move(key, shard, shards[current.owner(key)])
+				if err != nil {
+					return stats, err
+				}
+				if moved {
+					stats.Moved++
+				}
+			}
+			if !full {
+				break
+			}
+			start = append(bytes.Clone(last), 0)
+		}
+	}
+
+	s.mu.Lock()
+	// Shards added while this run was in progress still need another one.
+	if s.ring == current {
+		s.older = nil
+	}
+	s.mu.Unlock()
+	return stats, nil
+}
+
+// move copies key from one shard to another and deletes it from the first.
+// It holds the key's lock, so that a concurrent Put or Delete happens either
+// before the move, and is carried over, or after it. A key deleted since the
+// scan is not moved, and a value already written to the new shard is kept.
+func (s *ShardedStorage) move(key []byte, from, to Shard) (bool, error) {
+	unlock := s.keys.lock(key)
+	defer unlock()
+	value, err := from.Storage.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read key %x from shard %q: %w", key, from.Name, err)
+	}
+	ok, err := to.Storage.Exists(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check key %x on shard %q: %w", key, to.Name, err)
+	}
+	if !ok {
+		if err := to.Storage.Put(key, value); err != nil {
+			return false, fmt.Errorf("failed to move key %x to shard %q: %w", key, to.Name, err)
+		}
+	}
+	if d, ok := AsDeleter(from.Storage); ok {
+		if err := d.Delete(key); err != nil {
+			return false, fmt.Errorf("failed to delete moved key %x from shard %q: %w", key, from.Name, err)
+		}
+	}
+	return true, nil
+}
+
+// locate returns the shard owning key and, while rebalancing, the other
+// shards that may still hold it, most recent placement first.
+func (s *ShardedStorage) locate(key []byte) (owner Storage, previous []Storage) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := map[int]bool{s.ring.owner(key): true}
+	for _, r := range s.older {
+		if i := r.owner(key); !seen[i] {
+			seen[i] = true
+			previous = append(previous, s.shards[i].Storage)
+		}
+	}
+	return s.shards[s.ring.owner(key)].Storage, previous
+}
+
+// Put implements the Storage interface.
+func (s *ShardedStorage) Put(key []byte, value []byte) error {
+	unlock := s.keys.lock(key)
+	defer unlock()
+	owner, _ := s.locate(key)
+	return owner.Put(key, value)
+}
+
+// Get implements the Storage interface.
+func (s *ShardedStorage) Get(key []byte) ([]byte, error) {
+	owner, previous := s.locate(key)
+	value, err := owner.Get(key)
+	for _, shard := range previous {
+		if !errors.Is(err, ErrNotFound) {
+			break
+		}
+		value, err = shard.Get(key)
+	}
+	return value, err
+}
+
+// Exists implements the Storage interface.
+func (s *ShardedStorage) Exists(key []byte) (bool, error) {
+	owner, previous := s.locate(key)
+	ok, err := owner.Exists(key)
+	for _, shard := range previous {
+		if err != nil || ok {
+			break
+		}
+		ok, err = shard.Exists(key)
+	}
+	return ok, err
+}
+
+// Supports implements the ExtensionReporter interface: deletion and
+// iteration work if every shard supports them.
+func (s *ShardedStorage) Supports(ext Extension) bool {
+	if ext == ExtStreaming {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, shard := range s.shards {
+		if !Supports(shard.Storage, ext) {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete implements the Deleter interface. While rebalancing, the key is also
+// deleted from the shards that may still hold it.
+func (s *ShardedStorage) Delete(key []byte) error {
+	unlock := s.keys.lock(key)
+	defer unlock()
+	owner, previous := s.locate(key)
+	for _, shard := range append([]Storage{owner}, previous...) {
+		d, ok := AsDeleter(shard)
+		if !ok {
+			return fmt.Errorf("shard does not support deletion")
+		}
+		if err := d.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Iterate implements the Iterator interface by merging the ordered key
+// streams of all shards, each of which must implement Iterator. A key found
+// on two shards while rebalancing is visited once.
+func (s *ShardedStorage) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	s.mu.RLock()
+	shards := append([]Shard(nil), s.shards...)
+	s.mu.RUnlock()
+
+	type cursor struct {
+		next       func() ([]byte, []byte, bool)
+		stop       func()
+		key, value []byte
+		err        error
+	}
+	cursors := make([]*cursor, len(shards))
+	for i, shard := range shards {
+		it, ok := AsIterator(shard.Storage)
+		if !ok {
+			return fmt.Errorf("shard %q does not support iteration", shard.Name)
+		}
+		c := &cursor{}
+		next, stop := iter.Pull2(iter.Seq2[[]byte, []byte](func(yield func([]byte, []byte) bool) {
+			c.err = it.Iterate(start, end, func(key, value []byte) error {
+				if !yield(key, value) {
+					return errStopIteration
+				}
+				return nil
+			})
+			if errors.Is(c.err, errStopIteration) {
+				c.err = nil
+			}
+		}))
+		c.next, c.stop = next, stop
+		defer stop()
+		cursors[i] = c
+	}
+
+	// advance moves a cursor to its next key, or drops it when exhausted.
+	active := cursors[:0]
+	advance := func(c *cursor) (bool, error) {
+		key, value, ok := c.next()
+		if !ok {
+			return false, c.err
+		}
+		c.key, c.value = key, value
+		return true, nil
+	}
+	for _, c := range cursors {
+		ok, err := advance(c)
+		if err != nil {
+			return err
+		}
+		if ok {
+			active = append(active, c)
+		}
+	}
+
+	for len(active) > 0 {
+		min := active[0]
+		for _, c := range active[1:] {
+			if bytes.Compare(c.key, min.key) < 0 {
+				min = c
+			}
+		}
+		key, value := min.key, min.value
+		if err := fn(key, value); err != nil {
+			return err
+		}
+
+		// Move past this key on every shard that holds it.
+		remaining := active[:0]
+		for _, c := range active {
+			if bytes.Equal(c.key, key) {
+				ok, err := advance(c)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+			}
+			remaining = append(remaining, c)
+		}
+		active = remaining
+	}
+	return nil
+}
+
+// errStopIteration ends a shard's iteration early once the merge is done
+// with it.
+var errStopIteration = errors.New("iteration stopped")
+
+// Distribution returns the number of keys on each shard, by name. Every
+// shard must implement Iterator.
+func (s *ShardedStorage) Distribution() (map[string]int, error) {
+	s.mu.RLock()
+	shards := append([]Shard(nil), s.shards...)
+	s.mu.RUnlock()
+
+	counts := make(map[string]int, len(shards))
+	for _, shard := range shards {
+		it, ok := AsIterator(shard.Storage)
+		if !ok {
+			return nil, fmt.Errorf("shard %q does not support iteration", shard.Name)
+		}
+		n := 0
+		if err := it.Iterate(nil, nil, func(key, value []byte) error {
+			n++
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to scan shard %q: %w", shard.Name, err)
+		}
+		counts[shard.Name] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,305 @@
+package merkledb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestShards(names ...string) ([]*lockedStorage, []Shard) {
+	locked := make([]*lockedStorage, len(names))
+	shards := make([]Shard, len(names))
+	for i, name := range names {
+		locked[i] = newLockedStorage()
+		shards[i] = Shard{Name: name, Storage: locked[i]}
+	}
+	return locked, shards
+}
+
+func newTestSharded(t *testing.T, shards []Shard) *ShardedStorage {
+	t.Helper()
+	s, err := NewShardedStorage(shards, ShardedOptions{})
+	if err != nil {
+		t.Fatalf("NewShardedStorage() failed: %v", err)
+	}
+	return s
+}
+
+func TestShardedStorage_InterfaceContracts(t *testing.T) {
+	var _ Storage = (*ShardedStorage)(nil)
+	var _ Deleter = (*ShardedStorage)(nil)
+	var _ Iterator = (*ShardedStorage)(nil)
+}
+
+func TestShardedStorage_RoutesByHash(t *testing.T) {
+	_, shards := newTestShards("a", "b", "c", "d")
+	s := newTestSharded(t, shards)
+
+	const n = 2000
+	for i := 0; i < n; i++ {
+		v := fmt.Sprint(i)
+		if err := s.Put(contentKey(v), []byte(v)); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		v := fmt.Sprint(i)
+		if got, err := s.Get(contentKey(v)); err != nil || string(got) != v {
+			t.Fatalf("Get() = %q, %v; want %q", got, err, v)
+		}
+	}
+
+	counts, err := s.Distribution()
+	if err != nil {
+		t.Fatalf("Distribution() failed: %v", err)
+	}
+	total := 0
+	for name, c := range counts {
+		total += c
+		// With 128 virtual nodes each shard should hold roughly a quarter.
+		if c < n/8 || c > n/2 {
+			t.Errorf("shard %q holds %d of %d keys", name, c, n)
+		}
+	}
+	if total != n {
+		t.Errorf("shards hold %d keys in total, want %d", total, n)
+	}
+}
+
+func TestShardedStorage_AddShardAndRebalance(t *testing.T) {
+	_, shards := newTestShards("a", "b")
+	s := newTestSharded(t, shards)
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		v := fmt.Sprint(i)
+		s.Put(contentKey(v), []byte(v))
+	}
+
+	extra := newLockedStorage()
+	if err := s.AddShard(Shard{Name: "c", Storage: extra}); err != nil {
+		t.Fatalf("AddShard() failed: %v", err)
+	}
+	if err := s.AddShard(Shard{Name: "a", Storage: newLockedStorage()}); err == nil {
+		t.Error("AddShard() accepted a duplicate name")
+	}
+	if !s.Rebalancing() {
+		t.Error("Rebalancing() = false after AddShard()")
+	}
+
+	// Before rebalancing, every key is still readable through the fallback.
+	for i := 0; i < n; i++ {
+		v := fmt.Sprint(i)
+		if ok, err := s.Exists(contentKey(v)); !ok || err != nil {
+			t.Fatalf("Exists() before Rebalance() = %v, %v; want true", ok, err)
+		}
+	}
+
+	stats, err := s.Rebalance()
+	if err != nil {
+		t.Fatalf("Rebalance() failed: %v", err)
+	}
+	if stats.Scanned != n {
+		t.Errorf("Rebalance() scanned %d keys, want %d", stats.Scanned, n)
+	}
+	// Consistent hashing moves only the keys the new shard takes over.
+	if stats.Moved == 0 || stats.Moved > n/2 {
+		t.Errorf("Rebalance() moved %d of %d keys", stats.Moved, n)
+	}
+	if s.Rebalancing() {
+		t.Error("Rebalancing() = true after Rebalance()")
+	}
+
+	counts, _ := s.Distribution()
+	if counts["c"] != stats.Moved || counts["a"]+counts["b"]+counts["c"] != n {
+		t.Errorf("Distribution() after Rebalance() = %v, moved %d", counts, stats.Moved)
+	}
+	for i := 0; i < n; i++ {
+		v := fmt.Sprint(i)
+		if got, err := s.Get(contentKey(v)); err != nil || string(got) != v {
+			t.Fatalf("Get() after Rebalance() = %q, %v; want %q", got, err, v)
+		}
+	}
+}
+
+func TestShardedStorage_IterateMergesShards(t *testing.T) {
+	locked, shards := newTestShards("a", "b", "c")
+	s := newTestSharded(t, shards)
+	for i := 0; i < 300; i++ {
+		v := fmt.Sprint(i)
+		s.Put(contentKey(v), []byte(v))
+	}
+	// A key present on two shards, as during a rebalance, is visited once.
+	dup := contentKey("0")
+	for _, l := range locked {
+		l.Put(dup, []byte("0"))
+	}
+
+	var prev []byte
+	count := 0
+	err := s.Iterate(nil, nil, func(key, value []byte) error {
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			t.Fatalf("Iterate() visited %x after %x", key, prev)
+		}
+		prev = key
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate() failed: %v", err)
+	}
+	if count != 300 {
+		t.Errorf("Iterate() visited %d keys, want 300", count)
+	}
+
+	// Stopping early returns the callback's error.
+	stop := errors.New("stop")
+	count = 0
+	err = s.Iterate(nil, nil, func(key, value []byte) error {
+		count++
+		if count == 10 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 10 {
+		t.Errorf("Iterate() with an early stop = %v after %d keys", err, count)
+	}
+}
+
+func TestShardedStorage_ObjectStore(t *testing.T) {
+	_, shards := newTestShards("a", "b")
+	store := NewObjectStore(newTestSharded(t, shards))
+
+	hash, err := store.WriteObject(&mockObject{ID: "1", Data: "sharded"})
+	if err != nil {
+		t.Fatalf("WriteObject() failed: %v", err)
+	}
+	if _, err := store.ReadRawObject(hash); err != nil {
+		t.Errorf("ReadRawObject() failed: %v", err)
+	}
+}
+
+func TestShardedStorage_TwoAddsBeforeRebalance(t *testing.T) {
+	_, shards := newTestShards("a")
+	s := newTestSharded(t, shards)
+
+	s.AddShard(Shard{Name: "b", Storage: newLockedStorage()})
+	// Written with the intermediate ring: neither the first nor the final
+	// placement, so reads must look at every ring since the last rebalance.
+	for i := 0; i < 200; i++ {
+		v := fmt.Sprint(i)
+		s.Put(contentKey(v), []byte(v))
+	}
+	s.AddShard(Shard{Name: "c", Storage: newLockedStorage()})
+
+	for i := 0; i < 200; i++ {
+		v := fmt.Sprint(i)
+		if got, err := s.Get(contentKey(v)); err != nil || string(got) != v {
+			t.Fatalf("Get() = %q, %v; want %q", got, err, v)
+		}
+	}
+	if _, err := s.Rebalance(); err != nil {
+		t.Fatalf("Rebalance() failed: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		v := fmt.Sprint(i)
+		if ok, _ := s.Exists(contentKey(v)); !ok {
+			t.Fatalf("Exists(%q) after Rebalance() = false", v)
+		}
+	}
+}
+
+func TestShardedStorage_SupportsExtensionsOfEveryShard(t *testing.T) {
+	_, shards := newTestShards("a", "b")
+	s := newTestSharded(t, shards)
+	if _, ok := AsDeleter(s); !ok {
+		t.Error("AsDeleter() failed although every shard can delete")
+	}
+
+	if err := s.AddShard(Shard{Name: "plain", Storage: NewMockStorage()}); err != nil {
+		t.Fatalf("AddShard() failed: %v", err)
+	}
+	if _, ok := AsDeleter(s); ok {
+		t.Error("AsDeleter() succeeded with a shard that cannot delete")
+	}
+	if _, ok := AsIterator(s); ok {
+		t.Error("AsIterator() succeeded with a shard that cannot iterate")
+	}
+}
+
+func TestShardedStorage_RebalanceInBatches(t *testing.T) {
+	_, shards := newTestShards("a")
+	s := newTestSharded(t, shards)
+	n := 3*rebalanceBatch + 1
+	for i := 0; i < n; i++ {
+		v := fmt.Sprint(i)
+		s.Put(contentKey(v), []byte(v))
+	}
+	s.AddShard(Shard{Name: "b", Storage: newLockedStorage()})
+
+	stats, err := s.Rebalance()
+	if err != nil {
+		t.Fatalf("Rebalance() failed: %v", err)
+	}
+	if stats.Scanned != n {
+		t.Errorf("Rebalance() scanned %d keys, want %d", stats.Scanned, n)
+	}
+	counts, _ := s.Distribution()
+	if counts["b"] != stats.Moved || counts["a"]+counts["b"] != n {
+		t.Errorf("Distribution() after Rebalance() = %v, moved %d", counts, stats.Moved)
+	}
+}
+
+// deletingStorage deletes a key through the ShardedStorage just before
+// Rebalance writes it, as a caller racing with the move would.
+type deletingStorage struct {
+	*lockedStorage
+	sharded *ShardedStorage
+	deletes sync.WaitGroup
+	deleted sync.Map
+}
+
+func (s *deletingStorage) Put(key, value []byte) error {
+	s.deleted.Store(string(key), true)
+	s.deletes.Add(1)
+	go func() {
+		defer s.deletes.Done()
+		s.sharded.Delete(key)
+	}()
+	time.Sleep(time.Millisecond)
+	return s.lockedStorage.Put(key, value)
+}
+
+func TestShardedStorage_RebalanceDoesNotResurrectDeletedKeys(t *testing.T) {
+	_, shards := newTestShards("a")
+	s := newTestSharded(t, shards)
+	const n = 50
+	for i := 0; i < n; i++ {
+		v := fmt.Sprint(i)
+		s.Put(contentKey(v), []byte(v))
+	}
+	target := &deletingStorage{lockedStorage: newLockedStorage(), sharded: s}
+	s.AddShard(Shard{Name: "b", Storage: target})
+
+	stats, err := s.Rebalance()
+	if err != nil {
+		t.Fatalf("Rebalance() failed: %v", err)
+	}
+	if stats.Moved == 0 {
+		t.Fatal("Rebalance() moved no keys")
+	}
+	target.deletes.Wait()
+	for i := 0; i < n; i++ {
+		key := contentKey(fmt.Sprint(i))
+		if _, deleted := target.deleted.Load(string(key)); !deleted {
+			continue
+		}
+		if ok, _ := s.Exists(key); ok {
+			t.Errorf("key %x deleted during Rebalance() came back", key)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package merkledb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Signature algorithms.
+const (
+	// SigEd25519 is a raw Ed25519 signature of the signing payload.
+	SigEd25519 = "ed25519"
+	// SigSSH is an SSHSIG blob, as made by "ssh-keygen -Y sign -n merkledb"
+	// with an Ed25519 key, over the signing payload.
+	SigSSH = "sshsig"
+)
+
+// SSHNamespace is the namespace SSH signatures of commits are made in.
+const SSHNamespace = "merkledb"
+
+var (
+	// ErrUnsigned is returned by VerifyCommit and VerifyTag for an object
+	// with no signature.
+	ErrUnsigned = errors.New("object is not signed")
+	// ErrBadSignature is returned by VerifyCommit and VerifyTag when a
+	// signature does not match the object.
+	ErrBadSignature = errors.New("bad signature")
+	// ErrUntrustedKey is returned by VerifyCommit and VerifyTag when an object
+	// is correctly signed by a key that is not in the trust store.
+	ErrUntrustedKey = errors.New("signed by an untrusted key")
+)
+
+// Signature is the signature of a commit or tag. It covers the object's
+// signing payload, so it vouches for every other field of the object.
+type Signature struct {
+	Algorithm string `json:"alg"`
+	// PublicKey is the Ed25519 public key of the signer.
+	PublicKey []byte `json:"key"`
+	// Value is the signature itself, in the encoding of the algorithm.
+	Value []byte `json:"sig"`
+}
+
+// Signer signs commits and tags.
+type Signer interface {
+	Sign(payload []byte) (*Signature, error)
+}
+
+// SigningPayload returns the bytes a signature of c covers: the JSON encoding
+// of c without its signature. It does not depend on the format c is stored
+// in.
+func (c *Commit) SigningPayload() ([]byte, error) {
+	unsigned := *c
+	unsigned.Signature = nil
+	return unsigned.Serialize()
+}
+
+// Ed25519Signer returns a Signer making SigEd25519 signatures with key.
+func Ed25519Signer(key ed25519.PrivateKey) Signer {
+	return ed25519Signer{key}
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s ed25519Signer) Sign(payload []byte) (*Signature, error) {
+	return &Signature{
+		Algorithm: SigEd25519,
+		PublicKey: s.key.Public().(ed25519.PublicKey),
+		Value:     ed25519.Sign(s.key, payload),
+	}, nil
+}
+
+// SSHSigner returns a Signer making SigSSH signatures with key, in the same
+// format as "ssh-keygen -Y sign -n merkledb", so OpenSSH tooling can check
+// them.
+func SSHSigner(key ed25519.PrivateKey) Signer {
+	return sshSigner{key}
+}
+
+type sshSigner struct {
+	key ed25519.PrivateKey
+}
+
+func (s sshSigner) Sign(payload []byte) (*Signature, error) {
+	pub := s.key.Public().(ed25519.PublicKey)
+	sig := ed25519.Sign(s.key, sshSignedData(payload))
+
+	var blob sshBuffer
+	blob.Write([]byte(sshSigMagic))
+	binary.Write(&blob, binary.BigEndian, uint32(1))
+	blob.writeString(sshPublicKey(pub))
+	blob.writeString([]byte(SSHNamespace))
+	blob.writeString(nil)
+	blob.writeString([]byte(sshHashAlgorithm))
+	var wrapped sshBuffer
+	wrapped.writeString([]byte(sshKeyType))
+	wrapped.writeString(sig)
+	blob.writeString(wrapped.Bytes())
+
+	return &Signature{Algorithm: SigSSH, PublicKey: pub, Value: blob.Bytes()}, nil
+}
+
+// ParseSSHSignature parses an armored SSH signature, as written by
+// "ssh-keygen -Y sign", made with an Ed25519 key. The result can be set as
+// the Signature of the commit whose SigningPayload was signed.
+func ParseSSHSignature(armored []byte) (*Signature, error) {
+	text := strings.TrimSpace(string(armored))
+	const begin, end = "-----BEGIN SSH SIGNATURE-----", "-----END SSH SIGNATURE-----"
+	if !strings.HasPrefix(text, begin) || !strings.HasSuffix(text, end) {
+		return nil, fmt.Errorf("not an armored SSH signature")
+	}
+	body := strings.Join(strings.Fields(text[len(begin):len(text)-len(end)]), "")
+	blob, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode SSH signature: %w", err)
+	}
+	sig, err := parseSSHSig(blob)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{Algorithm: SigSSH, PublicKey: sig.publicKey, Value: blob}, nil
+}
+
+// verify checks that s is a valid signature of payload.
+func (s *Signature) verify(payload []byte) error {
+	if len(s.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: invalid public key", ErrBadSignature)
+	}
+	pub := ed25519.PublicKey(s.PublicKey)
+	switch s.Algorithm {
+	case SigEd25519:
+		if !ed25519.Verify(pub, payload, s.Value) {
+			return ErrBadSignature
+		}
+	case SigSSH:
+		sig, err := parseSSHSig(s.Value)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBadSignature, err)
+		}
+		if !pub.Equal(sig.publicKey) {
+			return fmt.Errorf("%w: SSH signature made by another key", ErrBadSignature)
+		}
+		if sig.namespace != SSHNamespace {
+			return fmt.Errorf("%w: SSH signature in namespace %q", ErrBadSignature, sig.namespace)
+		}
+		if !ed25519.Verify(pub, sshSignedData(payload), sig.signature) {
+			return ErrBadSignature
+		}
+	default:
+		return fmt.Errorf("%w: unknown algorithm %q", ErrBadSignature, s.Algorithm)
+	}
+	return nil
+}
+
+// --- SSH wire format ---
+
+const (
+	sshKeyType       = "ssh-ed25519"
+	sshSigMagic      = "SSHSIG"
+	sshHashAlgorithm = "sha512"
+)
+
+type sshBuffer struct {
+	bytes.Buffer
+}
+
+func (b *sshBuffer) writeString(s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
+
+// readString reads a length-prefixed string from data, returning it and the
+// rest of data.
+func readString(data []byte) (s, rest []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(len(data)-4) < uint64(n) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	return data[4 : 4+n], data[4+n:], nil
+}
+
+// sshPublicKey returns the wire encoding of an Ed25519 public key.
+func sshPublicKey(pub ed25519.PublicKey) []byte {
+	var b sshBuffer
+	b.writeString([]byte(sshKeyType))
+	b.writeString(pub)
+	return b.Bytes()
+}
+
+// parseSSHPublicKey decodes the wire encoding of an Ed25519 public key.
+func parseSSHPublicKey(data []byte) (ed25519.PublicKey, error) {
+	keyType, rest, err := readString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH public key: %w", err)
+	}
+	if string(keyType) != sshKeyType {
+		return nil, fmt.Errorf("unsupported SSH key type %q", keyType)
+	}
+	key, rest, err := readString(rest)
+	if err != nil || len(key) != ed25519.PublicKeySize || len(rest) != 0 {
+		return nil, fmt.Errorf("invalid SSH public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// sshSignedData returns the data an SSHSIG signature of payload signs.
+func sshSignedData(payload []byte) []byte {
+	sum := sha512.Sum512(payload)
+	var b sshBuffer
+	b.Write([]byte(sshSigMagic))
+	b.writeString([]byte(SSHNamespace))
+	b.writeString(nil)
+	b.writeString([]byte(sshHashAlgorithm))
+	b.writeString(sum[:])
+	return b.Bytes()
+}
+
+type sshSig struct {
+	publicKey ed25519.PublicKey
+	namespace string
+	signature []byte
+}
+
+// parseSSHSig decodes an SSHSIG blob.
+func parseSSHSig(blob []byte) (*sshSig, error) {
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) || len(blob) < len(sshSigMagic)+4 {
+		return nil, fmt.Errorf("not an SSH signature")
+	}
+	rest := blob[len(sshSigMagic):]
+	if version := binary.BigEndian.Uint32(rest); version != 1 {
+		return nil, fmt.Errorf("unsupported SSH signature version %d", version)
+	}
+	rest = rest[4:]
+
+	var fields [5][]byte
+	for i := range fields {
+		var err error
+		if fields[i], rest, err = readString(rest); err != nil {
+			return nil, fmt.Errorf("invalid SSH signature: %w", err)
+		}
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("invalid SSH signature: trailing data")
+	}
+	pub, err := parseSSHPublicKey(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	if string(fields[3]) != sshHashAlgorithm {
+		return nil, fmt.Errorf("unsupported SSH signature hash %q", fields[3])
+	}
+	keyType, rest, err := readString(fields[4])
+	if err != nil || string(keyType) != sshKeyType {
+		return nil, fmt.Errorf("invalid SSH signature: unsupported signature type")
+	}
+	sig, rest, err := readString(rest)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("invalid SSH signature: malformed signature")
+	}
+	return &sshSig{publicKey: pub, namespace: string(fields[1]), signature: sig}, nil
+}
+
+// --- Trust ---
+
+// TrustStore holds the public keys allowed to sign commits, each under a
+// name such as the owner's email. Its methods are not safe for concurrent
+// use with Add.
+type TrustStore struct {
+	keys map[string]string
+}
+
+// NewTrustStore returns an empty TrustStore.
+func NewTrustStore() *TrustStore {
+	return &TrustStore{keys: make(map[string]string)}
+}
+
+// Add trusts key, under the given name.
+func (t *TrustStore) Add(name string, key ed25519.PublicKey) {
+	t.keys[string(key)] = name
+}
+
+// AddAuthorizedKeys trusts the ssh-ed25519 keys of an authorized_keys or
+// allowed_signers style file: one "ssh-ed25519 <base64> [comment]" key per
+// line, optionally preceded by a principal, which is used as the key's name
+// in place of the comment. Blank lines and lines starting with # are skipped.
+func (t *TrustStore) AddAuthorizedKeys(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		name := ""
+		if fields[0] != sshKeyType && len(fields) > 1 {
+			name, fields = fields[0], fields[1:]
+		}
+		if fields[0] != sshKeyType || len(fields) < 2 {
+			return fmt.Errorf("line %d: not an ssh-ed25519 key", line)
+		}
+		data, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		key, err := parseSSHPublicKey(data)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if name == "" && len(fields) > 2 {
+			name = strings.Join(fields[2:], " ")
+		}
+		t.Add(name, key)
+	}
+	return scanner.Err()
+}
+
+// lookup returns the name key is trusted under.
+func (t *TrustStore) lookup(key []byte) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	name, ok := t.keys[string(key)]
+	return name, ok
+}
+
+// Verify checks the signature of c against trust, and returns the name of
+// the trusted key that made it. The error wraps ErrUnsigned, ErrBadSignature
+// or ErrUntrustedKey.
+func (c *Commit) Verify(trust *TrustStore) (string, error) {
+	return checkSignature(c.Signature, c.SigningPayload, trust)
+}
+
+// checkSignature checks sig against the payload returned by payload, and
+// returns the name of the trusted key that made it.
+func checkSignature(sig *Signature, payload func() ([]byte, error), trust *TrustStore) (string, error) {
+	if sig == nil {
+		return "", ErrUnsigned
+	}
+	data, err := payload()
+	if err != nil {
+		return "", err
+	}
+	if err := sig.verify(data); err != nil {
+		return "", err
+	}
+	name, ok := trust.lookup(sig.PublicKey)
+	if !ok {
+		return "", ErrUntrustedKey
+	}
+	return name, nil
+}
+
+// SignatureStatus is the outcome of checking the signature of a commit.
+type SignatureStatus int
+
+const (
+	// SignatureUnchecked means the signature was not checked.
+	SignatureUnchecked SignatureStatus = iota
+	// SignatureGood means the commit is correctly signed by a trusted key.
+	SignatureGood
+	// SignatureUnsigned means the commit has no signature.
+	SignatureUnsigned
+	// SignatureBad means the signature does not match the commit.
+	SignatureBad
+	// SignatureUntrusted means the commit is correctly signed by a key that
+	// is not trusted.
+	SignatureUntrusted
+)
+
+func (s SignatureStatus) String() string {
+	switch s {
+	case SignatureUnchecked:
+		return "unchecked"
+	case SignatureGood:
+		return "good"
+	case SignatureUnsigned:
+		return "unsigned"
+	case SignatureBad:
+		return "bad"
+	case SignatureUntrusted:
+		return "untrusted"
+	}
+	return fmt.Sprintf("SignatureStatus(%d)", int(s))
+}
+
+// signatureStatus returns the status matching an error of Commit.Verify.
+func signatureStatus(err error) SignatureStatus {
+	switch {
+	case err == nil:
+		return SignatureGood
+	case errors.Is(err, ErrUnsigned):
+		return SignatureUnsigned
+	case errors.Is(err, ErrUntrustedKey):
+		return SignatureUntrusted
+	}
+	return SignatureBad
+}
+
+// VerifyCommit reads the commit with the given hash and checks its signature
+// like Commit.Verify.
+func VerifyCommit(store *ObjectStore, hash string, trust *TrustStore) (string, error) {
+	commit, err := store.ReadCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	name, err := commit.Verify(trust)
+	if err != nil {
+		return "", fmt.Errorf("commit %s: %w", hash, err)
+	}
+	return name, nil
+}
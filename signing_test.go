@@ -0,0 +1,144 @@
+package merkledb
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Made with "ssh-keygen -Y sign -n merkledb" over the bytes "payload".
+const (
+	fixturePublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIF43BtwqkDFy2BFiSzwTiqH8hSSqSlHo7MHaiTiWj948 pipeline@example.com"
+	fixtureSignature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgXjcG3CqQMXLYEWJLPBOKofyFJK
+pKUejswdqJOJaP3jwAAAAIbWVya2xlZGIAAAAAAAAABnNoYTUxMgAAAFMAAAALc3NoLWVk
+MjU1MTkAAABAu/9JQD8LiyvNvf7NlweLx+UyPTYTi28qqr7flV3yvklH6AoXZRswuYiaBJ
+NViX/dyFxy4WFg3PtF6LkjCSF7Ag==
+-----END SSH SIGNATURE-----
+`
+)
+
+func testKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed([]byte(strings.Repeat(string(rune('a'+seed)), ed25519.SeedSize)))
+}
+
+func TestSignedCommits(t *testing.T) {
+	key := testKey(0)
+	trust := NewTrustStore()
+	trust.Add("pipeline", key.Public().(ed25519.PublicKey))
+
+	for _, signer := range []Signer{Ed25519Signer(key), SSHSigner(key)} {
+		for _, f := range []Format{FormatJSON, FormatCBOR} {
+			store := NewObjectStore(NewMockStorage(), WithFormat(f))
+			ws, _ := NewWorkspace(store)
+			ws.Add("stops.txt", &mockObject{Data: "stops"})
+			hash, err := ws.CommitWithOptions("signed", nil, &CommitOptions{
+				Signer:   signer,
+				Metadata: map[string]string{"feed-version": "7"},
+			})
+			if err != nil {
+				t.Fatalf("Commit() failed: %v", err)
+			}
+			name, err := VerifyCommit(store, hash, trust)
+			if err != nil || name != "pipeline" {
+				t.Errorf("%s/%T: VerifyCommit() = %q, %v; want pipeline", f, signer, name, err)
+			}
+
+			// Any change to a signed field breaks the signature.
+			commit, _ := store.ReadCommit(hash)
+			commit.Metadata["feed-version"] = "8"
+			if _, err := commit.Verify(trust); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("%s/%T: Verify() of a tampered commit = %v, want ErrBadSignature", f, signer, err)
+			}
+		}
+	}
+}
+
+func TestVerifyCommit_Failures(t *testing.T) {
+	store := NewObjectStore(NewMockStorage())
+	trust := NewTrustStore()
+	trust.Add("pipeline", testKey(0).Public().(ed25519.PublicKey))
+
+	ws, _ := NewWorkspace(store)
+	unsigned, _ := ws.Commit("unsigned", nil)
+	if _, err := VerifyCommit(store, unsigned, trust); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("VerifyCommit() of an unsigned commit = %v, want ErrUnsigned", err)
+	}
+	stranger, _ := ws.CommitWithOptions("stranger", nil, &CommitOptions{Signer: Ed25519Signer(testKey(1))})
+	if _, err := VerifyCommit(store, stranger, trust); !errors.Is(err, ErrUntrustedKey) {
+		t.Errorf("VerifyCommit() of a commit by an unknown key = %v, want ErrUntrustedKey", err)
+	}
+
+	// A signature copied onto another commit does not verify.
+	signed, _ := ws.CommitWithOptions("signed", nil, &CommitOptions{Signer: SSHSigner(testKey(0))})
+	good, _ := store.ReadCommit(signed)
+	forged := &Commit{TreeHash: good.TreeHash, Message: "forged", Timestamp: good.Timestamp, Signature: good.Signature}
+	if _, err := forged.Verify(trust); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify() of a forged commit = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestSSHInterop(t *testing.T) {
+	trust := NewTrustStore()
+	keys := "# allowed signers\n\n" + fixturePublicKey + "\n"
+	if err := trust.AddAuthorizedKeys(strings.NewReader(keys)); err != nil {
+		t.Fatalf("AddAuthorizedKeys() failed: %v", err)
+	}
+	sig, err := ParseSSHSignature([]byte(fixtureSignature))
+	if err != nil {
+		t.Fatalf("ParseSSHSignature() failed: %v", err)
+	}
+	if err := sig.verify([]byte("payload")); err != nil {
+		t.Errorf("verify() of an ssh-keygen signature failed: %v", err)
+	}
+	if err := sig.verify([]byte("payload!")); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("verify() of other data = %v, want ErrBadSignature", err)
+	}
+	if name, ok := trust.lookup(sig.PublicKey); !ok || name != "pipeline@example.com" {
+		t.Errorf("signing key trusted as %q, %v; want the key's comment", name, ok)
+	}
+
+	// allowed_signers lines name the key by their principal.
+	trust = NewTrustStore()
+	trust.AddAuthorizedKeys(strings.NewReader("ci@example.com " + fixturePublicKey))
+	if name, _ := trust.lookup(sig.PublicKey); name != "ci@example.com" {
+		t.Errorf("principal key trusted as %q, want ci@example.com", name)
+	}
+	if err := trust.AddAuthorizedKeys(strings.NewReader("ssh-rsa AAAAB3NzaC1yc2E= rsa")); err == nil {
+		t.Error("AddAuthorizedKeys() accepted an RSA key")
+	}
+}
+
+func TestLog_FlagsSignatures(t *testing.T) {
+	store := NewObjectStore(NewMockStorage())
+	trust := NewTrustStore()
+	trust.Add("pipeline", testKey(0).Public().(ed25519.PublicKey))
+
+	ws, _ := NewWorkspace(store)
+	first, _ := ws.Commit("unsigned", nil)
+	second, _ := ws.CommitWithOptions("stranger", []string{first}, &CommitOptions{Signer: Ed25519Signer(testKey(1))})
+	third, _ := ws.CommitWithOptions("trusted", []string{second}, &CommitOptions{Signer: Ed25519Signer(testKey(0))})
+
+	var got []string
+	err := Log(store, third, &LogOptions{Trust: trust}, func(e LogEntry) error {
+		got = append(got, e.Commit.Message+":"+e.Signature.String()+":"+e.Signer)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Log() failed: %v", err)
+	}
+	if s := strings.Join(got, " "); s != "trusted:good:pipeline stranger:untrusted: unsigned:unsigned:" {
+		t.Errorf("Log() = %s", s)
+	}
+
+	err = Log(store, third, nil, func(e LogEntry) error {
+		if e.Signature != SignatureUnchecked {
+			t.Errorf("Log() without trust checked %s", e.Hash)
+		}
+		return ErrStopWalk
+	})
+	if err != nil {
+		t.Errorf("Log() with ErrStopWalk = %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Snapshot is a read-only view of the tree of a commit, for reading a
+// committed version without a Workspace. Trees are loaded when a path first
+// goes through them and cached for the life of the Snapshot. It is safe for
+// concurrent use.
+type Snapshot struct {
+	store  *ObjectStore
+	hash   string
+	commit *Commit
+
+	mu    sync.RWMutex
+	trees map[string]*Tree
+}
+
+// SnapshotEntry is an entry of a Snapshot, with its path from the root.
+type SnapshotEntry struct {
+	Path string
+	TreeEntry
+}
+
+// NewSnapshot returns a Snapshot of the commit with the given hash.
+func NewSnapshot(store *ObjectStore, commitHash string) (*Snapshot, error) {
+	if store == nil {
+		return nil, fmt.Errorf("object store cannot be nil")
+	}
+	commit, err := store.ReadCommit(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{store: store, hash: commitHash, commit: commit, trees: make(map[string]*Tree)}, nil
+}
+
+// OpenSnapshot returns a Snapshot of the commit rev names, peeling tags. rev
+// is a revision expression as accepted by ResolveRevision, such as "main",
+// "v2024-10" or a hash; refs may be nil to accept hashes only.
+func OpenSnapshot(store *ObjectStore, refs RefStore, rev string) (*Snapshot, error) {
+	hash, err := ResolveRevision(store, refs, rev)
+	if err != nil {
+		return nil, err
+	}
+	if hash, err = Peel(store, hash); err != nil {
+		return nil, err
+	}
+	return NewSnapshot(store, hash)
+}
+
+// Hash returns the hash of the snapshot's commit.
+func (s *Snapshot) Hash() string {
+	return s.hash
+}
+
+// Commit returns the snapshot's commit. It must not be modified.
+func (s *Snapshot) Commit() *Commit {
+	return s.commit
+}
+
+// tree returns the tree with the given hash, reading it on first use.
+func (s *Snapshot) tree(hash string) (*Tree, error) {
+	s.mu.RLock()
+	tree, ok := s.trees[hash]
+	s.mu.RUnlock()
+	if ok {
+		return tree, nil
+	}
+	tree, err := s.store.ReadTree(hash)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.trees[hash] = tree
+	s.mu.Unlock()
+	return tree, nil
+}
+
+// Stat returns the entry at path. Paths are resolved as in revision
+// expressions, so "stops/S1" may name an entry of that name or an entry
+// "S1" of a subtree "stops"; the empty path is the root tree. It returns an
+// error matching ErrNotFound if there is no such entry.
+func (s *Snapshot) Stat(path string) (SnapshotEntry, error) {
+	entry, err := findEntry(s.tree, s.commit.TreeHash, path)
+	if err != nil {
+		return SnapshotEntry{}, err
+	}
+	return SnapshotEntry{Path: strings.Trim(path, "/"), TreeEntry: entry}, nil
+}
+
+// Get returns the content of the object at path, resolved as by Stat.
+func (s *Snapshot) Get(path string) ([]byte, error) {
+	entry, err := s.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	switch entry.Kind {
+	case KindTree:
+		return nil, fmt.Errorf("path %q is a tree", path)
+	case KindLink:
+		return nil, fmt.Errorf("path %q is a link to %q", path, entry.Target)
+	}
+	return s.store.ReadRawObject(entry.Hash)
+}
+
+// ReadDir returns the entries directly below the directory dir, sorted by
+// name; the empty dir is the root. Since entry names may contain slashes, a
+// directory holds both the entries of a subtree at dir and the entries
+// named dir + "/" + name. An entry whose remaining name has further slashes
+// shows as an implicit directory: a KindTree entry without a hash. ReadDir
+// returns an error matching ErrNotFound if there is no such directory.
+func (s *Snapshot) ReadDir(dir string) ([]SnapshotEntry, error) {
+	dir = strings.Trim(dir, "/")
+	found := make(map[string]TreeEntry)
+	if err := s.readDir(s.commit.TreeHash, dir, found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 && dir != "" {
+		// dir may still be an empty subtree, or not a directory at all.
+		entry, err := s.Stat(dir)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return nil, fmt.Errorf("directory %q: %w", dir, ErrNotFound)
+		case err != nil:
+			return nil, err
+		case entry.Kind != KindTree:
+			return nil, fmt.Errorf("path %q is not a directory", dir)
+		}
+	}
+
+	entries := make([]SnapshotEntry, 0, len(found))
+	for name, entry := range found {
+		path := name
+		if dir != "" {
+			path = dir + "/" + name
+		}
+		entries = append(entries, SnapshotEntry{Path: path, TreeEntry: entry})
+	}
+	slices.SortFunc(entries, func(a, b SnapshotEntry) int { return strings.Compare(a.Path, b.Path) })
+	return entries, nil
+}
+
+// readDir adds to found the entries directly below dir in the tree with the
+// given hash, by name.
+func (s *Snapshot) readDir(treeHash, dir string, found map[string]TreeEntry) error {
+	tree, err := s.tree(treeHash)
+	if err != nil {
+		return err
+	}
+	for name, entry := range tree.Entries {
+		rest := name
+		switch {
+		case dir == "":
+		case strings.HasPrefix(name, dir+"/"):
+			rest = name[len(dir)+1:]
+		case entry.Kind == KindTree && name == dir:
+			if err := s.readDir(entry.Hash, "", found); err != nil {
+				return err
+			}
+			continue
+		case entry.Kind == KindTree && strings.HasPrefix(dir, name+"/"):
+			if err := s.readDir(entry.Hash, dir[len(name)+1:], found); err != nil {
+				return err
+			}
+			continue
+		default:
+			continue
+		}
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			// An implicit directory never hides a real entry.
+			if _, ok := found[rest[:i]]; !ok {
+				found[rest[:i]] = TreeEntry{Kind: KindTree}
+			}
+			continue
+		}
+		found[rest] = entry
+	}
+	return nil
+}
+
+// Walk calls fn for every entry of the snapshot, in name order within each
+// tree, a subtree before its entries. Paths are the names of the entries
+// joined with slashes. If fn returns ErrStopWalk the walk ends and Walk
+// returns nil; any other error ends the walk and is returned.
+func (s *Snapshot) Walk(fn func(path string, entry TreeEntry) error) error {
+	err := s.walk(s.commit.TreeHash, "", fn)
+	if errors.Is(err, ErrStopWalk) {
+		return nil
+	}
+	return err
+}
+
+func (s *Snapshot) walk(treeHash, prefix string, fn func(path string, entry TreeEntry) error) error {
+	tree, err := s.tree(treeHash)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(tree.Entries))
+	for name := range tree.Entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		entry := tree.Entries[name]
+		path := prefix + name
+		if err := fn(path, entry); err != nil {
+			return err
+		}
+		if entry.Kind == KindTree {
+			if err := s.walk(entry.Hash, path+"/", fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
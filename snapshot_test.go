@@ -0,0 +1,204 @@
+package merkledb
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// snapshotFixture commits a tree holding flat names with slashes, a subtree
+// "dir" with a nested subtree "sub", an empty subtree and a link, and points
+// main and the tag v1 at it.
+func snapshotFixture(t *testing.T) (*ObjectStore, *lockedStorage, *MemoryRefStore, map[string]string) {
+	t.Helper()
+	g := newCommitGraph(t)
+	h := g.h
+	h["sub"] = g.tree(map[string]TreeEntry{"deep": BlobEntry(g.write(&mockObject{Data: "deep"}))})
+	h["dir"] = g.tree(map[string]TreeEntry{
+		"file": BlobEntry(g.write(&mockObject{Data: "file"})),
+		"sub":  TreeEntryOf(h["sub"]),
+	})
+
+	files := make(map[string]string)
+	for _, name := range []string{"stops/S1", "stops/S2", "routes/R1", "routes/north/R2", "name"} {
+		files[name] = name
+	}
+	g.commit("snapshot", files, map[string]TreeEntry{
+		"dir":    TreeEntryOf(h["dir"]),
+		"empty":  TreeEntryOf(g.tree(nil)),
+		"latest": LinkEntry("stops/S2"),
+	}, nil, nil)
+	g.refs.WriteRef(HeadsPrefix+"main", h["snapshot"])
+	if _, err := CreateTag(g.store, g.refs, "v1", h["snapshot"], "", nil); err != nil {
+		t.Fatalf("CreateTag() failed: %v", err)
+	}
+	return g.store, g.storage, g.refs, h
+}
+
+func paths(entries []SnapshotEntry) string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Path)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestSnapshot_GetAndStat(t *testing.T) {
+	store, _, refs, h := snapshotFixture(t)
+	for _, rev := range []string{"main", "v1", h["snapshot"]} {
+		snap, err := OpenSnapshot(store, refs, rev)
+		if err != nil {
+			t.Fatalf("OpenSnapshot(%s) failed: %v", rev, err)
+		}
+		if snap.Hash() != h["snapshot"] || snap.Commit().Message != "snapshot" {
+			t.Errorf("OpenSnapshot(%s) = %s, want %s", rev, snap.Hash(), h["snapshot"])
+		}
+	}
+
+	snap, _ := OpenSnapshot(store, refs, "main")
+	for path, want := range map[string]string{"stops/S1": "stops/S1", "/name": "name", "dir/file": "file", "dir/sub/deep": "deep"} {
+		if data, err := snap.Get(path); err != nil || !strings.Contains(string(data), want) {
+			t.Errorf("Get(%q) = %s, %v; want %q", path, data, err, want)
+		}
+	}
+	for _, path := range []string{"dir", "latest", ""} {
+		if _, err := snap.Get(path); err == nil {
+			t.Errorf("Get(%q) of a tree or link succeeded", path)
+		}
+	}
+	if _, err := snap.Get("stops/S3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(stops/S3) returned %v, want ErrNotFound", err)
+	}
+
+	for path, want := range map[string]TreeEntry{
+		"dir/sub": TreeEntryOf(h["sub"]),
+		"dir/":    TreeEntryOf(h["dir"]),
+		"latest":  LinkEntry("stops/S2"),
+		"":        TreeEntryOf(snap.Commit().TreeHash),
+	} {
+		entry, err := snap.Stat(path)
+		if err != nil || entry.TreeEntry != want || entry.Path != strings.Trim(path, "/") {
+			t.Errorf("Stat(%q) = %+v, %v; want %+v", path, entry, err, want)
+		}
+	}
+}
+
+func TestSnapshot_ReadDir(t *testing.T) {
+	store, _, refs, _ := snapshotFixture(t)
+	snap, _ := OpenSnapshot(store, refs, "main")
+	for dir, want := range map[string]string{
+		"":             "dir empty latest name routes stops",
+		"stops":        "stops/S1 stops/S2",
+		"routes":       "routes/R1 routes/north",
+		"routes/north": "routes/north/R2",
+		"dir":          "dir/file dir/sub",
+		"/dir/sub/":    "dir/sub/deep",
+		"empty":        "",
+	} {
+		entries, err := snap.ReadDir(dir)
+		if err != nil || paths(entries) != want {
+			t.Errorf("ReadDir(%q) = %q, %v; want %q", dir, paths(entries), err, want)
+		}
+	}
+
+	root, _ := snap.ReadDir("")
+	for _, e := range root {
+		if e.Path == "stops" && (e.Kind != KindTree || e.Hash != "") {
+			t.Errorf("implicit directory stops = %+v, want a tree entry without a hash", e)
+		}
+		if e.Path == "dir" && e.Hash == "" {
+			t.Errorf("subtree dir = %+v, want its hash", e)
+		}
+	}
+	if _, err := snap.ReadDir("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadDir(missing) returned %v, want ErrNotFound", err)
+	}
+	if _, err := snap.ReadDir("name"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadDir(name) of a blob returned %v, want a not-a-directory error", err)
+	}
+}
+
+func TestSnapshot_Walk(t *testing.T) {
+	store, _, refs, _ := snapshotFixture(t)
+	snap, _ := OpenSnapshot(store, refs, "main")
+	var visited []string
+	err := snap.Walk(func(path string, entry TreeEntry) error {
+		visited = append(visited, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() failed: %v", err)
+	}
+	want := "dir dir/file dir/sub dir/sub/deep empty latest name routes/R1 routes/north/R2 stops/S1 stops/S2"
+	if got := strings.Join(visited, " "); got != want {
+		t.Errorf("Walk() visited %q, want %q", got, want)
+	}
+
+	visited = nil
+	err = snap.Walk(func(path string, entry TreeEntry) error {
+		visited = append(visited, path)
+		if path == "dir/file" {
+			return ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil || len(visited) != 2 {
+		t.Errorf("Walk() stopped with %v after %v, want nil after 2 entries", err, visited)
+	}
+	errBoom := errors.New("boom")
+	if err := snap.Walk(func(string, TreeEntry) error { return errBoom }); !errors.Is(err, errBoom) {
+		t.Errorf("Walk() returned %v, want the callback's error", err)
+	}
+}
+
+func TestSnapshot_LazyLoading(t *testing.T) {
+	store, storage, refs, h := snapshotFixture(t)
+	snap, err := OpenSnapshot(store, refs, "main")
+	if err != nil {
+		t.Fatalf("OpenSnapshot() failed: %v", err)
+	}
+	if len(snap.trees) != 0 {
+		t.Errorf("OpenSnapshot() loaded %d trees, want none", len(snap.trees))
+	}
+	if _, err := snap.Get("dir/file"); err != nil {
+		t.Fatalf("Get(dir/file) failed: %v", err)
+	}
+
+	// Loaded trees are served from the cache; others are read on demand.
+	for _, tree := range []string{"dir", "sub"} {
+		key, _ := hex.DecodeString(h[tree])
+		storage.Delete(key)
+	}
+	if _, err := snap.Get("dir/file"); err != nil {
+		t.Errorf("Get(dir/file) of a cached tree failed: %v", err)
+	}
+	if _, err := snap.Get("dir/sub/deep"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(dir/sub/deep) of a missing tree returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestSnapshot_ConcurrentReaders(t *testing.T) {
+	store, _, refs, _ := snapshotFixture(t)
+	snap, _ := OpenSnapshot(store, refs, "main")
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := []string{"dir/file", "dir/sub/deep", "stops/S1", "routes/north/R2"}[i%4]
+			if _, err := snap.Get(path); err != nil {
+				t.Errorf("Get(%s) failed: %v", path, err)
+			}
+			if _, err := snap.ReadDir("dir/sub"); err != nil {
+				t.Errorf("ReadDir() failed: %v", err)
+			}
+			n := 0
+			if err := snap.Walk(func(string, TreeEntry) error { n++; return nil }); err != nil || n != 11 {
+				t.Errorf("Walk() visited %d entries, %v; want 11", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
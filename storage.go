@@ -1,18 +1,140 @@
 package merkledb
 
-import "errors"
+import (
+	"errors"
+	"io"
+)
 
 // ErrNotFound is a standard error returned by a Storage backend when a key is not found.
 var ErrNotFound = errors.New("key not found")
 
 // Storage is the interface for the physical key-value storage backend.
 // This abstraction allows MerkleDB to be agnostic about where the data is stored.
+//
+// Implementations must follow these rules, which the storage/storagetest
+// package checks:
+//   - Keys are arbitrary, non-empty byte strings; the ObjectStore uses raw
+//     32-byte SHA-256 hashes.
+//   - Values may be empty. An empty value is stored like any other and is
+//     distinct from a missing key.
+//   - Put must not retain the value slice, and the slice returned by Get is
+//     owned by the caller; modifying either must not change the stored data.
+//   - All methods must be safe for concurrent use by multiple goroutines.
 type Storage interface {
-	// Put stores a value associated with a key.
+	// Put stores a value associated with a key, replacing any previous value.
 	Put(key []byte, value []byte) error
 	// Get retrieves a value associated with a key.
-	// it should return ErrNotFound if the key is not found.
+	// It must return an error matching ErrNotFound (as reported by
+	// errors.Is) if the key is not found.
 	Get(key []byte) ([]byte, error)
 	// Exists checks if a key exists in the storage.
+	// A missing key is reported as false with a nil error.
 	Exists(key []byte) (bool, error)
 }
+
+// Deleter is an optional extension implemented by Storage backends that can
+// remove keys, for example to reclaim the space of unreachable objects.
+type Deleter interface {
+	// Delete removes the value associated with a key.
+	// Deleting a key that does not exist is not an error.
+	Delete(key []byte) error
+}
+
+// Iterator is an optional extension implemented by Storage backends that can
+// enumerate their keys in ascending byte order.
+type Iterator interface {
+	// Iterate calls fn for every key in the half-open range [start, end),
+	// in ascending byte order. A nil start begins at the first key and a nil
+	// end continues to the last one. If fn returns an error, iteration stops
+	// and that error is returned.
+	// The key and value passed to fn are owned by the callee.
+	Iterate(start, end []byte, fn func(key, value []byte) error) error
+}
+
+// StreamingStorage is an optional extension implemented by Storage backends
+// that can store and return values without holding them in memory. The
+// ObjectStore uses it to write and read objects larger than memory.
+type StreamingStorage interface {
+	// Create starts a new value whose key is not known yet, since the key of
+	// an object is the hash of its content. The value becomes visible only
+	// once it is committed.
+	Create() (PendingValue, error)
+	// Open returns a reader for the value associated with a key. It must
+	// return an error matching ErrNotFound if the key is not found.
+	Open(key []byte) (io.ReadCloser, error)
+}
+
+// PendingValue is a value being written to a StreamingStorage.
+type PendingValue interface {
+	io.Writer
+	// Commit stores the data written so far under key, replacing any
+	// previous value, and releases the pending value.
+	Commit(key []byte) error
+	// Abort discards the data written so far. Calling it after Commit is a
+	// no-op.
+	Abort() error
+}
+
+// Extension names an optional Storage extension.
+type Extension int
+
+const (
+	// ExtDeleter is the Deleter extension.
+	ExtDeleter Extension = iota
+	// ExtIterator is the Iterator extension.
+	ExtIterator
+	// ExtStreaming is the StreamingStorage extension.
+	ExtStreaming
+)
+
+// ExtensionReporter is implemented by Storage decorators, such as
+// InstrumentedStorage, that have the methods of an extension whatever they
+// wrap but support it only when the storages they wrap do. Code looking for
+// an extension should use Supports or the As functions, which consult it,
+// rather than a bare type assertion.
+type ExtensionReporter interface {
+	// Supports reports whether the extension ext works.
+	Supports(ext Extension) bool
+}
+
+// Supports reports whether s implements the extension ext and, if it is an
+// ExtensionReporter, supports it.
+func Supports(s Storage, ext Extension) bool {
+	var ok bool
+	switch ext {
+	case ExtDeleter:
+		_, ok = s.(Deleter)
+	case ExtIterator:
+		_, ok = s.(Iterator)
+	case ExtStreaming:
+		_, ok = s.(StreamingStorage)
+	}
+	if r, isReporter := s.(ExtensionReporter); ok && isReporter {
+		return r.Supports(ext)
+	}
+	return ok
+}
+
+// AsDeleter returns s as a Deleter if it supports deletion.
+func AsDeleter(s Storage) (Deleter, bool) {
+	if !Supports(s, ExtDeleter) {
+		return nil, false
+	}
+	return s.(Deleter), true
+}
+
+// AsIterator returns s as an Iterator if it supports iteration.
+func AsIterator(s Storage) (Iterator, bool) {
+	if !Supports(s, ExtIterator) {
+		return nil, false
+	}
+	return s.(Iterator), true
+}
+
+// AsStreaming returns s as a StreamingStorage if it supports streaming.
+func AsStreaming(s Storage) (StreamingStorage, bool) {
+	if !Supports(s, ExtStreaming) {
+		return nil, false
+	}
+	return s.(StreamingStorage), true
+}
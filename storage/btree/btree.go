@@ -0,0 +1,462 @@
+// Package btree implements a persistent Storage backend backed by a
+// copy-on-write B+tree kept in a single file.
+//
+// The file starts with two meta slots followed by an append-only data area.
+// A write transaction never modifies data that is already on disk: it appends
+// the new values and copies of every node on the path from the root to the
+// changed leaves, syncs them, and only then publishes the new root by writing
+// a checksummed meta record into the older of the two slots. When the file is
+// opened, the valid meta record with the highest transaction id wins and any
+// bytes written after it are discarded, so a crash at any point leaves the
+// tree exactly as it was after the last successful commit.
+//
+// Transactions follow a single-writer, multiple-reader model. Readers work on
+// the root that was current when they started and are never blocked by the
+// writer. Because the data area only grows, space used by old versions of
+// nodes and values is reclaimed by Compact.
+//
+// Keys are kept in byte order, so the store implements merkledb.Iterator and
+// supports ordered range scans, such as listing every hash that starts with a
+// given prefix.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// metaSize is the size of an encoded meta record:
+	// magic (8) | txid (8) | root (8) | end (8) | count (8) | crc32 (4).
+	metaSize = 44
+	// metaSlotSize is the distance between the two meta slots.
+	metaSlotSize = 512
+	// dataStart is the offset of the first record in the data area.
+	dataStart = 4096
+
+	// recordHeaderSize is the size of a data record header:
+	// crc32 (4) | kind (1) | payload length (4).
+	recordHeaderSize = 9
+
+	recordNode  byte = 1
+	recordValue byte = 2
+
+	// MaxKeySize is the largest key accepted by the store.
+	MaxKeySize = 4096
+
+	// cacheSize bounds the number of decoded nodes kept in memory.
+	cacheSize = 8192
+)
+
+var magic = [8]byte{'M', 'K', 'D', 'B', 'B', 'T', '0', '1'}
+
+// ErrCorrupt is returned when the file or one of its records fails validation.
+var ErrCorrupt = errors.New("btree: corrupt data")
+
+// ErrClosed is returned by operations on a closed database.
+var ErrClosed = errors.New("btree: database is closed")
+
+// ErrTxNotWritable is returned when a read-only transaction attempts a write.
+var ErrTxNotWritable = errors.New("btree: transaction is read-only")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures a DB.
+type Options struct {
+	// NoSync skips the fsync calls that make a commit durable. A crash may
+	// then lose recent transactions. Intended for tests and bulk loads.
+	NoSync bool
+}
+
+// meta is the committed state of the tree.
+type meta struct {
+	txid  uint64
+	root  int64 // offset of the root node, 0 for an empty tree
+	end   int64 // size of the committed part of the file
+	count uint64
+}
+
+func (m *meta) encode() []byte {
+	buf := make([]byte, metaSize)
+	copy(buf[0:8], magic[:])
+	binary.BigEndian.PutUint64(buf[8:16], m.txid)
+	binary.BigEndian.PutUint64(buf[16:24], uint64(m.root))
+	binary.BigEndian.PutUint64(buf[24:32], uint64(m.end))
+	binary.BigEndian.PutUint64(buf[32:40], m.count)
+	binary.BigEndian.PutUint32(buf[40:44], crc32.Checksum(buf[:40], crcTable))
+	return buf
+}
+
+func decodeMeta(buf []byte) (*meta, bool) {
+	if len(buf) < metaSize || [8]byte(buf[0:8]) != magic {
+		return nil, false
+	}
+	if crc32.Checksum(buf[:40], crcTable) != binary.BigEndian.Uint32(buf[40:44]) {
+		return nil, false
+	}
+	return &meta{
+		txid:  binary.BigEndian.Uint64(buf[8:16]),
+		root:  int64(binary.BigEndian.Uint64(buf[16:24])),
+		end:   int64(binary.BigEndian.Uint64(buf[24:32])),
+		count: binary.BigEndian.Uint64(buf[32:40]),
+	}, true
+}
+
+// Stats describes the state of a DB.
+type Stats struct {
+	// Keys is the number of keys in the tree.
+	Keys uint64
+	// FileSize is the committed size of the data file.
+	FileSize int64
+	// TxID is the id of the last committed transaction.
+	TxID uint64
+}
+
+// DB is a single-file, copy-on-write B+tree.
+// It is safe for concurrent use.
+type DB struct {
+	path string
+	opts Options
+
+	// writeMu serializes write transactions.
+	writeMu sync.Mutex
+	// mu is held for reading by every transaction and for writing while the
+	// file is replaced by Compact or closed.
+	mu   sync.RWMutex
+	file *os.File
+	meta atomic.Pointer[meta]
+
+	cacheMu sync.Mutex
+	cache   map[int64]*node
+}
+
+// Open opens the database file at path, creating it if it does not exist.
+// A nil opts uses the default options.
+func Open(path string, opts *Options) (*DB, error) {
+	db := &DB{path: path}
+	if opts != nil {
+		db.opts = *opts
+	}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// load opens the file, initializing it if it is new, and recovers the last
+// committed meta record.
+func (db *DB) load() error {
+	f, err := os.OpenFile(db.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	var m *meta
+	if info.Size() == 0 {
+		m = &meta{end: dataStart}
+		if err := f.Truncate(dataStart); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		if _, err := f.WriteAt(m.encode(), 0); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+	} else {
+		for slot := range int64(2) {
+			buf := make([]byte, metaSize)
+			if _, err := f.ReadAt(buf, slot*metaSlotSize); err != nil {
+				continue
+			}
+			if candidate, ok := decodeMeta(buf); ok && (m == nil || candidate.txid > m.txid) {
+				m = candidate
+			}
+		}
+		if m == nil || m.end > info.Size() {
+			f.Close()
+			return fmt.Errorf("btree: no valid meta record in %s: %w", db.path, ErrCorrupt)
+		}
+		// Drop anything written by a transaction that never committed.
+		if info.Size() > m.end {
+			if err := f.Truncate(m.end); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to discard uncommitted data: %w", err)
+			}
+		}
+	}
+
+	db.file = f
+	db.meta.Store(m)
+	db.cache = make(map[int64]*node)
+	return nil
+}
+
+// View runs fn in a read-only transaction that sees the tree as it was when
+// the transaction started.
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+
+	m := db.meta.Load()
+	tx := &Tx{db: db, root: child{off: m.root}, count: m.count}
+	return fn(tx)
+}
+
+// Update runs fn in a read-write transaction. If fn returns nil the
+// transaction is committed atomically; otherwise every change it made is
+// discarded and fn's error is returned. Only one write transaction runs at a
+// time.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+
+	m := db.meta.Load()
+	tx := &Tx{db: db, writable: true, root: child{off: m.root}, count: m.count, end: m.end}
+	if err := fn(tx); err != nil {
+		db.rollback(m)
+		return err
+	}
+	if err := tx.commit(m); err != nil {
+		db.rollback(m)
+		return err
+	}
+	return nil
+}
+
+// rollback drops data appended by a transaction that did not commit. The
+// node cache is reset because it may hold nodes written at offsets that the
+// next transaction will reuse.
+func (db *DB) rollback(m *meta) {
+	db.file.Truncate(m.end)
+	db.cacheMu.Lock()
+	db.cache = make(map[int64]*node)
+	db.cacheMu.Unlock()
+}
+
+// sync flushes the file to stable storage unless NoSync is set.
+func (db *DB) sync() error {
+	if db.opts.NoSync {
+		return nil
+	}
+	return db.file.Sync()
+}
+
+// readRecord reads and verifies the record of the given kind at off. A
+// negative size is read from the record header.
+func (db *DB) readRecord(off int64, kind byte, size int) ([]byte, error) {
+	if size < 0 {
+		header := make([]byte, recordHeaderSize)
+		if _, err := db.file.ReadAt(header, off); err != nil {
+			return nil, fmt.Errorf("failed to read record header: %w", err)
+		}
+		size = int(binary.BigEndian.Uint32(header[5:9]))
+	}
+	if err := db.checkBounds(off, int64(size)); err != nil {
+		return nil, err
+	}
+
+	rec := make([]byte, recordHeaderSize+size)
+	if _, err := db.file.ReadAt(rec, off); err != nil {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	if rec[4] != kind || int(binary.BigEndian.Uint32(rec[5:9])) != size {
+		return nil, fmt.Errorf("btree: unexpected record at offset %d: %w", off, ErrCorrupt)
+	}
+	if crc32.Checksum(rec[4:], crcTable) != binary.BigEndian.Uint32(rec[0:4]) {
+		return nil, fmt.Errorf("btree: checksum mismatch at offset %d: %w", off, ErrCorrupt)
+	}
+	return rec[recordHeaderSize:], nil
+}
+
+// checkBounds fails unless a record with a payload of size bytes at off lies
+// within the file. Sizes and offsets come from disk, so they are checked
+// before anything is allocated for them. Records in the committed part of
+// the file pass without a system call.
+func (db *DB) checkBounds(off, size int64) error {
+	end := off + recordHeaderSize + size
+	if off >= 0 && size >= 0 && end >= off {
+		if m := db.meta.Load(); m != nil && end <= m.end {
+			return nil
+		}
+	}
+	info, err := db.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if off < 0 || size < 0 || end < off || end > info.Size() {
+		return fmt.Errorf("btree: record of %d bytes at offset %d runs past the end of the file: %w", size, off, ErrCorrupt)
+	}
+	return nil
+}
+
+// readNode returns the node stored at off, using the node cache.
+func (db *DB) readNode(off int64) (*node, error) {
+	db.cacheMu.Lock()
+	n, ok := db.cache[off]
+	db.cacheMu.Unlock()
+	if ok {
+		return n, nil
+	}
+
+	payload, err := db.readRecord(off, recordNode, -1)
+	if err != nil {
+		return nil, err
+	}
+	n, err = decodeNode(payload)
+	if err != nil {
+		return nil, err
+	}
+	db.cacheNode(off, n)
+	return n, nil
+}
+
+// cacheNode remembers a decoded node. The cache is simply reset when it is
+// full; nodes are cheap to decode again.
+func (db *DB) cacheNode(off int64, n *node) {
+	db.cacheMu.Lock()
+	defer db.cacheMu.Unlock()
+	if len(db.cache) >= cacheSize {
+		db.cache = make(map[int64]*node)
+	}
+	db.cache[off] = n
+}
+
+// readValue returns a copy of the value referenced by ref.
+func (db *DB) readValue(ref valueRef) ([]byte, error) {
+	return db.readRecord(ref.off, recordValue, int(ref.size))
+}
+
+// Put implements the merkledb.Storage interface.
+func (db *DB) Put(key []byte, value []byte) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.Put(key, value)
+	})
+}
+
+// Get implements the merkledb.Storage interface.
+func (db *DB) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := db.View(func(tx *Tx) error {
+		var err error
+		value, err = tx.Get(key)
+		return err
+	})
+	return value, err
+}
+
+// Exists implements the merkledb.Storage interface.
+func (db *DB) Exists(key []byte) (bool, error) {
+	var exists bool
+	err := db.View(func(tx *Tx) error {
+		var err error
+		exists, err = tx.Exists(key)
+		return err
+	})
+	return exists, err
+}
+
+// Delete implements the merkledb.Deleter interface.
+func (db *DB) Delete(key []byte) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.Delete(key)
+	})
+}
+
+// Iterate implements the merkledb.Iterator interface.
+// fn runs inside a read-only transaction and must not call Compact or Close.
+func (db *DB) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	return db.View(func(tx *Tx) error {
+		return tx.Iterate(start, end, fn)
+	})
+}
+
+// Stats reports the number of keys and the committed file size.
+func (db *DB) Stats() Stats {
+	m := db.meta.Load()
+	return Stats{Keys: m.count, FileSize: m.end, TxID: m.txid}
+}
+
+// Compact rewrites the database into a new file that contains only the
+// current version of the tree, then atomically replaces the old file.
+// It waits for running transactions to finish and blocks new ones meanwhile.
+func (db *DB) Compact() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+
+	tmpPath := db.path + ".compact"
+	os.Remove(tmpPath)
+	tmp, err := Open(tmpPath, &db.opts)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted database: %w", err)
+	}
+
+	m := db.meta.Load()
+	src := &Tx{db: db, root: child{off: m.root}}
+	err = tmp.Update(func(dst *Tx) error {
+		return src.Iterate(nil, nil, func(key, value []byte) error {
+			return dst.Put(key, value)
+		})
+	})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write compacted database: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, db.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+	if d, err := os.Open(filepath.Dir(db.path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	db.file.Close()
+	db.file = nil
+	return db.load()
+}
+
+// Close closes the database file. Running transactions are allowed to finish.
+func (db *DB) Close() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
@@ -0,0 +1,319 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AureClai/merkledb"
+	"github.com/AureClai/merkledb/storage/storagetest"
+)
+
+func newTestStorage(tb testing.TB) merkledb.Storage {
+	s, err := Open(filepath.Join(tb.TempDir(), "data.db"), &Options{NoSync: true})
+	if err != nil {
+		tb.Fatalf("Open() failed: %v", err)
+	}
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, newTestStorage)
+}
+
+func BenchmarkStorage(b *testing.B) {
+	storagetest.RunBenchmarks(b, newTestStorage)
+}
+
+func TestInterfaceContracts(t *testing.T) {
+	var _ merkledb.Storage = (*DB)(nil)
+	var _ merkledb.Deleter = (*DB)(nil)
+	var _ merkledb.Iterator = (*DB)(nil)
+}
+
+func openTestDB(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := Open(path, &Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key-%05d", i))
+}
+
+func TestDB_PutGetManyKeys(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "data.db"))
+
+	// Enough keys to split leaves and grow the tree to several levels.
+	const n = 5000
+	err := db.Update(func(tx *Tx) error {
+		for i := 0; i < n; i += 2 {
+			if err := tx.Put(key(i), []byte(fmt.Sprint(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	for i := 1; i < n; i += 2 {
+		if err := db.Put(key(i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		value, err := db.Get(key(i))
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %v", key(i), err)
+		}
+		if string(value) != fmt.Sprint(i) {
+			t.Fatalf("Get(%s) = %q, want %q", key(i), value, fmt.Sprint(i))
+		}
+	}
+	if got := db.Stats().Keys; got != n {
+		t.Errorf("Stats().Keys = %d, want %d", got, n)
+	}
+	if _, err := db.Get([]byte("missing")); !errors.Is(err, merkledb.ErrNotFound) {
+		t.Errorf("Get() on missing key returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestDB_IterateRange(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "data.db"))
+	for i := 999; i >= 0; i-- {
+		db.Put(key(i), []byte("v"))
+	}
+
+	var got [][]byte
+	err := db.Iterate(key(250), key(750), func(k, v []byte) error {
+		got = append(got, k)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate() failed: %v", err)
+	}
+	if len(got) != 500 {
+		t.Fatalf("Iterate() visited %d keys, want 500", len(got))
+	}
+	for i, k := range got {
+		if string(k) != string(key(250+i)) {
+			t.Fatalf("Iterate() key %d = %s, want %s", i, k, key(250+i))
+		}
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = db.Iterate(nil, nil, func(k, v []byte) error {
+		count++
+		if count == 10 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 10 {
+		t.Errorf("Iterate() did not stop early: count=%d err=%v", count, err)
+	}
+}
+
+func TestDB_Delete(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "data.db"))
+	for i := 0; i < 1000; i++ {
+		db.Put(key(i), []byte("v"))
+	}
+	for i := 0; i < 1000; i++ {
+		if i%3 != 0 {
+			if err := db.Delete(key(i)); err != nil {
+				t.Fatalf("Delete() failed: %v", err)
+			}
+		}
+	}
+	if err := db.Delete([]byte("missing")); err != nil {
+		t.Errorf("Delete() of a missing key failed: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		exists, err := db.Exists(key(i))
+		if err != nil {
+			t.Fatalf("Exists() failed: %v", err)
+		}
+		if exists != (i%3 == 0) {
+			t.Fatalf("Exists(%s) = %v after deletes", key(i), exists)
+		}
+	}
+
+	for i := 0; i < 1000; i += 3 {
+		db.Delete(key(i))
+	}
+	if got := db.Stats().Keys; got != 0 {
+		t.Errorf("Stats().Keys = %d after deleting everything, want 0", got)
+	}
+	db.Iterate(nil, nil, func(k, v []byte) error {
+		t.Errorf("Iterate() on an empty tree visited %s", k)
+		return nil
+	})
+}
+
+func TestDB_UpdateRollsBackOnError(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "data.db"))
+	db.Put([]byte("kept"), []byte("v1"))
+
+	failure := errors.New("abort")
+	err := db.Update(func(tx *Tx) error {
+		tx.Put([]byte("kept"), []byte("v2"))
+		tx.Put([]byte("discarded"), []byte("v"))
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Update() returned %v, want %v", err, failure)
+	}
+
+	if value, _ := db.Get([]byte("kept")); string(value) != "v1" {
+		t.Errorf("rolled back transaction changed a value to %q", value)
+	}
+	if exists, _ := db.Exists([]byte("discarded")); exists {
+		t.Error("rolled back transaction left a key behind")
+	}
+}
+
+func TestDB_ViewIsReadOnlySnapshot(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "data.db"))
+	db.Put([]byte("a"), []byte("1"))
+
+	err := db.View(func(tx *Tx) error {
+		if err := tx.Put([]byte("b"), nil); !errors.Is(err, ErrTxNotWritable) {
+			t.Errorf("Put() in a read-only transaction returned %v", err)
+		}
+		// A commit made while the view is open is not visible to it.
+		done := make(chan struct{})
+		go func() {
+			db.Put([]byte("a"), []byte("2"))
+			close(done)
+		}()
+		<-done
+		value, err := tx.Get([]byte("a"))
+		if err != nil || string(value) != "1" {
+			t.Errorf("view saw %q, %v; want the value from its snapshot", value, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View() failed: %v", err)
+	}
+}
+
+func TestDB_RecoversUncommittedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	db.Put([]byte("a"), []byte("committed"))
+	committed := db.Stats().FileSize
+	db.Close()
+
+	// Simulate a crash after a transaction appended data but before it
+	// published a new meta record.
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open database file: %v", err)
+	}
+	f.Write([]byte("garbage from a transaction that never committed"))
+	f.Close()
+
+	db = openTestDB(t, path)
+	if got := db.Stats().FileSize; got != committed {
+		t.Errorf("file size after recovery = %d, want %d", got, committed)
+	}
+	if value, err := db.Get([]byte("a")); err != nil || string(value) != "committed" {
+		t.Errorf("Get() after recovery = %q, %v", value, err)
+	}
+	if err := db.Put([]byte("b"), []byte("new")); err != nil {
+		t.Fatalf("Put() after recovery failed: %v", err)
+	}
+}
+
+func TestDB_FallsBackToPreviousMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("a"), []byte("2"))
+	latest := db.Stats().TxID
+	db.Close()
+
+	// Corrupt the meta slot written by the latest transaction, as if the
+	// crash happened while it was being written.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open database file: %v", err)
+	}
+	f.WriteAt([]byte{0xff}, int64(latest%2)*metaSlotSize+10)
+	f.Close()
+
+	db = openTestDB(t, path)
+	if value, err := db.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("Get() after meta corruption = %q, %v; want the previous commit", value, err)
+	}
+}
+
+func TestDB_RejectsOversizedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	db := openTestDB(t, path)
+	db.Put([]byte("a"), []byte("1"))
+	root := db.meta.Load().root
+
+	// A corrupt size field claiming a 4 GiB node must fail, not allocate.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open database file: %v", err)
+	}
+	f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, root+5)
+	f.Close()
+	db.cacheMu.Lock()
+	db.cache = make(map[int64]*node)
+	db.cacheMu.Unlock()
+
+	if _, err := db.Get([]byte("a")); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Get() through an oversized record returned %v, want ErrCorrupt", err)
+	}
+	if err := db.checkBounds(-1, 10); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("checkBounds() of a negative offset returned %v, want ErrCorrupt", err)
+	}
+}
+
+func TestDB_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	db := openTestDB(t, path)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			db.Put(key(i), []byte(fmt.Sprintf("round-%d", round)))
+		}
+	}
+	before := db.Stats().FileSize
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact() failed: %v", err)
+	}
+	if after := db.Stats().FileSize; after >= before {
+		t.Errorf("Compact() did not shrink the file: %d -> %d bytes", before, after)
+	}
+	for i := 0; i < 200; i++ {
+		value, err := db.Get(key(i))
+		if err != nil || string(value) != "round-4" {
+			t.Fatalf("Get(%s) after compaction = %q, %v", key(i), value, err)
+		}
+	}
+	if got := db.Stats().Keys; got != 200 {
+		t.Errorf("Stats().Keys after compaction = %d, want 200", got)
+	}
+}
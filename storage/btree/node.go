@@ -0,0 +1,162 @@
+package btree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// maxEntries is the number of keys a node may hold before it is split.
+const maxEntries = 128
+
+// valueRef locates a value record in the data file.
+type valueRef struct {
+	off  int64
+	size uint32
+}
+
+// child is a reference from a branch node to one of its children.
+// A clean child lives on disk at off; a child modified by the current write
+// transaction is held in memory in n until the transaction commits.
+type child struct {
+	off int64
+	n   *node
+}
+
+// isEmpty reports whether c refers to no node at all, which is how the root of
+// an empty tree is represented.
+func (c child) isEmpty() bool {
+	return c.n == nil && c.off == 0
+}
+
+// node is a B+tree node. Leaf nodes map keys to value locations. Branch nodes
+// hold one key per child: keys[i] is the smallest key stored under children[i].
+//
+// Nodes read from disk are shared between transactions and must never be
+// modified; a write transaction works on copies obtained through Tx.mutable.
+type node struct {
+	leaf     bool
+	keys     [][]byte
+	values   []valueRef // leaf nodes only
+	children []child    // branch nodes only
+}
+
+// clone returns a copy of n whose slices can be modified independently.
+func (n *node) clone() *node {
+	c := &node{leaf: n.leaf, keys: append([][]byte(nil), n.keys...)}
+	if n.leaf {
+		c.values = append([]valueRef(nil), n.values...)
+	} else {
+		c.children = append([]child(nil), n.children...)
+	}
+	return c
+}
+
+// search returns the position of the first key that is >= key, and whether
+// that key is an exact match.
+func (n *node) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool { return string(n.keys[i]) >= string(key) })
+	return i, i < len(n.keys) && string(n.keys[i]) == string(key)
+}
+
+// childIndex returns the index of the child of a branch node that covers key.
+func (n *node) childIndex(key []byte) int {
+	i := sort.Search(len(n.keys), func(i int) bool { return string(n.keys[i]) > string(key) }) - 1
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+// split moves the upper half of n into a new sibling node and returns it.
+func (n *node) split() *node {
+	mid := len(n.keys) / 2
+	right := &node{leaf: n.leaf, keys: append([][]byte(nil), n.keys[mid:]...)}
+	n.keys = append([][]byte(nil), n.keys[:mid]...)
+	if n.leaf {
+		right.values = append([]valueRef(nil), n.values[mid:]...)
+		n.values = append([]valueRef(nil), n.values[:mid]...)
+	} else {
+		right.children = append([]child(nil), n.children[mid:]...)
+		n.children = append([]child(nil), n.children[:mid]...)
+	}
+	return right
+}
+
+// encode serializes a node whose children have all been written to disk.
+//
+// Layout: leaf flag (1 byte) | entry count (uvarint) | entries, where a leaf
+// entry is key length, key, value offset and value size, and a branch entry
+// is key length, key and child offset, all lengths and offsets as uvarints.
+func (n *node) encode() []byte {
+	buf := make([]byte, 0, 64+len(n.keys)*48)
+	if n.leaf {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(n.keys)))
+	for i, key := range n.keys {
+		buf = binary.AppendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+		if n.leaf {
+			buf = binary.AppendUvarint(buf, uint64(n.values[i].off))
+			buf = binary.AppendUvarint(buf, uint64(n.values[i].size))
+		} else {
+			buf = binary.AppendUvarint(buf, uint64(n.children[i].off))
+		}
+	}
+	return buf
+}
+
+// decodeNode parses a node written by encode.
+func decodeNode(data []byte) (*node, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("btree: empty node: %w", ErrCorrupt)
+	}
+	n := &node{leaf: data[0] == 1}
+	pos := 1
+	next := func() (uint64, error) {
+		v, size := binary.Uvarint(data[pos:])
+		if size <= 0 {
+			return 0, fmt.Errorf("btree: truncated node: %w", ErrCorrupt)
+		}
+		pos += size
+		return v, nil
+	}
+
+	count, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(data)) {
+		return nil, fmt.Errorf("btree: invalid node entry count: %w", ErrCorrupt)
+	}
+	n.keys = make([][]byte, 0, count)
+	for range count {
+		keySize, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if keySize > uint64(len(data)-pos) {
+			return nil, fmt.Errorf("btree: truncated node key: %w", ErrCorrupt)
+		}
+		n.keys = append(n.keys, data[pos:pos+int(keySize)])
+		pos += int(keySize)
+
+		off, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if n.leaf {
+			size, err := next()
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, valueRef{off: int64(off), size: uint32(size)})
+		} else {
+			n.children = append(n.children, child{off: int64(off)})
+		}
+	}
+	return n, nil
+}
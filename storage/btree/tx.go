@@ -0,0 +1,351 @@
+package btree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/AureClai/merkledb"
+)
+
+// Tx is a transaction on a DB, obtained through DB.View or DB.Update.
+// A Tx must not be used after the function it was passed to returns, and it
+// is not safe for concurrent use.
+type Tx struct {
+	db       *DB
+	writable bool
+	root     child
+	count    uint64
+	end      int64 // end of the file, including uncommitted appends
+}
+
+// load returns the node referenced by c.
+func (tx *Tx) load(c child) (*node, error) {
+	if c.n != nil {
+		return c.n, nil
+	}
+	return tx.db.readNode(c.off)
+}
+
+// mutable returns a copy of the node referenced by c that the transaction may
+// modify. Nodes already copied by this transaction are returned as is.
+func (tx *Tx) mutable(c child) (*node, error) {
+	if c.n != nil {
+		return c.n, nil
+	}
+	n, err := tx.db.readNode(c.off)
+	if err != nil {
+		return nil, err
+	}
+	return n.clone(), nil
+}
+
+// lookup finds the location of the value stored under key.
+func (tx *Tx) lookup(key []byte) (valueRef, bool, error) {
+	if tx.root.isEmpty() {
+		return valueRef{}, false, nil
+	}
+	n, err := tx.load(tx.root)
+	if err != nil {
+		return valueRef{}, false, err
+	}
+	for !n.leaf {
+		n, err = tx.load(n.children[n.childIndex(key)])
+		if err != nil {
+			return valueRef{}, false, err
+		}
+	}
+	i, found := n.search(key)
+	if !found {
+		return valueRef{}, false, nil
+	}
+	return n.values[i], true, nil
+}
+
+// Get returns a copy of the value stored under key, or merkledb.ErrNotFound.
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	ref, found, err := tx.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, merkledb.ErrNotFound
+	}
+	return tx.db.readValue(ref)
+}
+
+// Exists reports whether key is present in the tree.
+func (tx *Tx) Exists(key []byte) (bool, error) {
+	_, found, err := tx.lookup(key)
+	return found, err
+}
+
+// Len returns the number of keys in the tree as seen by the transaction.
+func (tx *Tx) Len() uint64 {
+	return tx.count
+}
+
+// Put stores value under key, replacing any previous value.
+func (tx *Tx) Put(key []byte, value []byte) error {
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("btree: key of %d bytes exceeds the maximum of %d", len(key), MaxKeySize)
+	}
+
+	off, err := tx.append(recordValue, value)
+	if err != nil {
+		return err
+	}
+	ref := valueRef{off: off, size: uint32(len(value))}
+
+	var root *node
+	if tx.root.isEmpty() {
+		root = &node{leaf: true}
+	} else if root, err = tx.mutable(tx.root); err != nil {
+		return err
+	}
+
+	added, err := tx.insert(root, append([]byte(nil), key...), ref)
+	if err != nil {
+		return err
+	}
+	if len(root.keys) > maxEntries {
+		right := root.split()
+		root = &node{
+			keys:     [][]byte{root.keys[0], right.keys[0]},
+			children: []child{{n: root}, {n: right}},
+		}
+	}
+	tx.root = child{n: root}
+	if added {
+		tx.count++
+	}
+	return nil
+}
+
+// insert adds key to the subtree rooted at the mutable node n, splitting
+// children that grow too large. It reports whether the key is new.
+func (tx *Tx) insert(n *node, key []byte, ref valueRef) (bool, error) {
+	if n.leaf {
+		i, found := n.search(key)
+		if found {
+			n.values[i] = ref
+			return false, nil
+		}
+		n.keys = append(n.keys, nil)
+		copy(n.keys[i+1:], n.keys[i:])
+		n.keys[i] = key
+		n.values = append(n.values, valueRef{})
+		copy(n.values[i+1:], n.values[i:])
+		n.values[i] = ref
+		return true, nil
+	}
+
+	i := n.childIndex(key)
+	c, err := tx.mutable(n.children[i])
+	if err != nil {
+		return false, err
+	}
+	added, err := tx.insert(c, key, ref)
+	if err != nil {
+		return false, err
+	}
+	n.children[i] = child{n: c}
+	n.keys[i] = c.keys[0]
+
+	if len(c.keys) > maxEntries {
+		right := c.split()
+		n.keys = append(n.keys, nil)
+		copy(n.keys[i+2:], n.keys[i+1:])
+		n.keys[i+1] = right.keys[0]
+		n.children = append(n.children, child{})
+		copy(n.children[i+2:], n.children[i+1:])
+		n.children[i+1] = child{n: right}
+	}
+	return added, nil
+}
+
+// Delete removes key from the tree. Deleting a missing key is not an error.
+//
+// Nodes that become empty are removed from their parent; partially filled
+// nodes are not merged, which keeps deletes cheap at the cost of some space
+// that Compact recovers.
+func (tx *Tx) Delete(key []byte) error {
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+	if exists, err := tx.Exists(key); err != nil || !exists {
+		return err
+	}
+
+	root, err := tx.mutable(tx.root)
+	if err != nil {
+		return err
+	}
+	if err := tx.remove(root, key); err != nil {
+		return err
+	}
+	// Collapse the root while it has a single child.
+	for !root.leaf && len(root.children) == 1 {
+		if root, err = tx.mutable(root.children[0]); err != nil {
+			return err
+		}
+	}
+	if len(root.keys) == 0 {
+		tx.root = child{}
+	} else {
+		tx.root = child{n: root}
+	}
+	tx.count--
+	return nil
+}
+
+// remove deletes key, which must exist, from the subtree rooted at the
+// mutable node n.
+func (tx *Tx) remove(n *node, key []byte) error {
+	if n.leaf {
+		i, _ := n.search(key)
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.values = append(n.values[:i], n.values[i+1:]...)
+		return nil
+	}
+
+	i := n.childIndex(key)
+	c, err := tx.mutable(n.children[i])
+	if err != nil {
+		return err
+	}
+	if err := tx.remove(c, key); err != nil {
+		return err
+	}
+	if len(c.keys) == 0 {
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.children = append(n.children[:i], n.children[i+1:]...)
+		return nil
+	}
+	n.children[i] = child{n: c}
+	n.keys[i] = c.keys[0]
+	return nil
+}
+
+// Iterate calls fn for every key in [start, end) in ascending order.
+// A nil start or end leaves that side of the range open.
+func (tx *Tx) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	if tx.root.isEmpty() {
+		return nil
+	}
+	_, err := tx.iterate(tx.root, start, end, fn)
+	return err
+}
+
+// iterate walks the subtree referenced by c. It reports whether the walk
+// reached the end of the range, so callers can stop visiting siblings.
+func (tx *Tx) iterate(c child, start, end []byte, fn func(key, value []byte) error) (bool, error) {
+	n, err := tx.load(c)
+	if err != nil {
+		return false, err
+	}
+
+	if !n.leaf {
+		first := 0
+		if start != nil {
+			first = n.childIndex(start)
+		}
+		for i := first; i < len(n.children); i++ {
+			if end != nil && string(n.keys[i]) >= string(end) {
+				return true, nil
+			}
+			done, err := tx.iterate(n.children[i], start, end, fn)
+			if err != nil || done {
+				return done, err
+			}
+		}
+		return false, nil
+	}
+
+	first := 0
+	if start != nil {
+		first, _ = n.search(start)
+	}
+	for i := first; i < len(n.keys); i++ {
+		if end != nil && string(n.keys[i]) >= string(end) {
+			return true, nil
+		}
+		value, err := tx.db.readValue(n.values[i])
+		if err != nil {
+			return false, err
+		}
+		if err := fn(append([]byte(nil), n.keys[i]...), value); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// append writes a record at the end of the file and returns its offset.
+// The record only becomes reachable once the transaction commits.
+func (tx *Tx) append(kind byte, payload []byte) (int64, error) {
+	rec := make([]byte, recordHeaderSize+len(payload))
+	rec[4] = kind
+	binary.BigEndian.PutUint32(rec[5:9], uint32(len(payload)))
+	copy(rec[recordHeaderSize:], payload)
+	binary.BigEndian.PutUint32(rec[0:4], crc32.Checksum(rec[4:], crcTable))
+
+	off := tx.end
+	if _, err := tx.db.file.WriteAt(rec, off); err != nil {
+		return 0, fmt.Errorf("failed to append record: %w", err)
+	}
+	tx.end += int64(len(rec))
+	return off, nil
+}
+
+// flush writes every modified node under c to disk, children first, and
+// returns the offset of c.
+func (tx *Tx) flush(c child) (int64, error) {
+	if c.n == nil {
+		return c.off, nil
+	}
+	n := c.n
+	if !n.leaf {
+		for i := range n.children {
+			off, err := tx.flush(n.children[i])
+			if err != nil {
+				return 0, err
+			}
+			n.children[i] = child{off: off}
+		}
+	}
+	off, err := tx.append(recordNode, n.encode())
+	if err != nil {
+		return 0, err
+	}
+	tx.db.cacheNode(off, n)
+	return off, nil
+}
+
+// commit makes the transaction's changes durable and visible.
+func (tx *Tx) commit(prev *meta) error {
+	if tx.root.n == nil && tx.root.off == prev.root && tx.end == prev.end {
+		return nil
+	}
+
+	root, err := tx.flush(tx.root)
+	if err != nil {
+		return err
+	}
+	if err := tx.db.sync(); err != nil {
+		return fmt.Errorf("failed to sync data: %w", err)
+	}
+
+	m := &meta{txid: prev.txid + 1, root: root, end: tx.end, count: tx.count}
+	if _, err := tx.db.file.WriteAt(m.encode(), int64(m.txid%2)*metaSlotSize); err != nil {
+		return fmt.Errorf("failed to write meta: %w", err)
+	}
+	if err := tx.db.sync(); err != nil {
+		return fmt.Errorf("failed to sync meta: %w", err)
+	}
+	tx.db.meta.Store(m)
+	return nil
+}
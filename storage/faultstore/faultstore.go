@@ -0,0 +1,345 @@
+// Package faultstore implements a merkledb.Storage wrapper that injects
+// failures, for testing how code built on merkledb copes with an unreliable
+// backend.
+//
+// A Store forwards every call to the wrapped storage and consults its rules
+// first. A rule matches some operations and fires either on a fixed schedule
+// (every nth matching call, after a number of calls) or with a probability.
+// Randomness comes from a generator seeded by the caller, so a failing run can
+// be reproduced exactly from its seed, provided the calls are made in the same
+// order.
+//
+// The faults mirror what real backends do wrong: calls that fail outright,
+// slow calls, writes that are cut short, bits that flip in transit or at
+// rest, and objects that silently go missing. After a test has hammered an
+// ObjectStore through a Store, merkledb.VerifyHistory tells whether every
+// commit that was reported as written is complete and intact.
+package faultstore
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AureClai/merkledb"
+)
+
+// ErrInjected is the error returned by injected failures unless a rule sets
+// its own.
+var ErrInjected = errors.New("faultstore: injected failure")
+
+// Operations a Rule can match.
+const (
+	OpPut     = "put"
+	OpGet     = "get"
+	OpExists  = "exists"
+	OpDelete  = "delete"
+	OpIterate = "iterate"
+)
+
+// Fault is the kind of failure a Rule injects.
+type Fault int
+
+const (
+	// Error fails the call with the rule's error without reaching the
+	// wrapped storage.
+	Error Fault = iota
+	// Latency delays the call by the rule's latency, then performs it.
+	Latency
+	// TornWrite stores a truncated prefix of the value and fails the Put,
+	// as a crash in the middle of a write would. It applies to Put only.
+	TornWrite
+	// BitFlip flips one random bit of the value: on Put the corrupted value
+	// is stored and the call succeeds, on Get the stored value is intact
+	// but the caller receives a corrupted copy.
+	BitFlip
+	// NotFound makes Get report ErrNotFound and Exists report false, as if
+	// the key had been lost.
+	NotFound
+)
+
+func (f Fault) String() string {
+	switch f {
+	case Error:
+		return "error"
+	case Latency:
+		return "latency"
+	case TornWrite:
+		return "torn write"
+	case BitFlip:
+		return "bit flip"
+	case NotFound:
+		return "not found"
+	}
+	return fmt.Sprintf("Fault(%d)", int(f))
+}
+
+// Rule describes when and how to inject a fault.
+type Rule struct {
+	// Fault is the kind of failure to inject.
+	Fault Fault
+	// Ops lists the operations the rule applies to. Empty means all.
+	Ops []string
+
+	// After skips the first After matching calls.
+	After int
+	// Every fires the rule on every nth matching call after the skipped
+	// ones. When zero, Probability is used instead.
+	Every int
+	// Probability is the chance, between 0 and 1, that the rule fires on a
+	// matching call.
+	Probability float64
+	// Limit caps the number of times the rule fires. Zero means no limit.
+	Limit int
+
+	// Err is the error returned by Error and TornWrite faults. Defaults to
+	// ErrInjected.
+	Err error
+	// Latency is the delay added by Latency faults.
+	Latency time.Duration
+}
+
+// Event records one injected fault.
+type Event struct {
+	// Call is the sequence number of the call, counting from 1 across all
+	// operations.
+	Call int
+	Op   string
+	Key  []byte
+	// Rule is the index of the rule that fired.
+	Rule  int
+	Fault Fault
+}
+
+// ruleState tracks how often a rule matched and fired.
+type ruleState struct {
+	Rule
+	matched int
+	fired   int
+}
+
+// Store is a merkledb.Storage that injects faults into calls to the storage
+// it wraps. It is safe for concurrent use if the wrapped storage is.
+type Store struct {
+	storage merkledb.Storage
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	rules    []*ruleState
+	calls    int
+	events   []Event
+	disabled bool
+}
+
+// New wraps storage with fault injection. The seed drives every random
+// decision; the same seed and call sequence inject the same faults.
+func New(storage merkledb.Storage, seed uint64, rules ...Rule) *Store {
+	s := &Store{storage: storage, rng: rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))}
+	for _, r := range rules {
+		if r.Err == nil {
+			r.Err = ErrInjected
+		}
+		s.rules = append(s.rules, &ruleState{Rule: r})
+	}
+	return s
+}
+
+// SetEnabled turns fault injection on or off. While disabled, calls go
+// straight to the wrapped storage and rules do not count them, which is
+// useful to check the store's state after a chaotic run.
+func (s *Store) SetEnabled(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disabled = !enabled
+}
+
+// Events returns the faults injected so far, in order.
+func (s *Store) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Unwrap returns the wrapped storage.
+func (s *Store) Unwrap() merkledb.Storage {
+	return s.storage
+}
+
+// Supports implements the merkledb.ExtensionReporter interface: deletion and
+// iteration work if the wrapped storage supports them.
+func (s *Store) Supports(ext merkledb.Extension) bool {
+	return ext != merkledb.ExtStreaming && merkledb.Supports(s.storage, ext)
+}
+
+// fault decides which fault, if any, to inject into a call. The first rule
+// that fires wins. The returned number is a random value for the fault to use.
+func (s *Store) fault(op string, key []byte) (*ruleState, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.disabled {
+		return nil, 0
+	}
+	s.calls++
+	for i, r := range s.rules {
+		if !r.applies(op) {
+			continue
+		}
+		r.matched++
+		if r.matched <= r.After || (r.Limit > 0 && r.fired >= r.Limit) {
+			continue
+		}
+		var fire bool
+		if r.Every > 0 {
+			fire = (r.matched-r.After)%r.Every == 0
+		} else {
+			fire = s.rng.Float64() < r.Probability
+		}
+		if !fire {
+			continue
+		}
+		r.fired++
+		s.events = append(s.events, Event{
+			Call:  s.calls,
+			Op:    op,
+			Key:   append([]byte(nil), key...),
+			Rule:  i,
+			Fault: r.Fault,
+		})
+		return r, s.rng.Uint64()
+	}
+	return nil, 0
+}
+
+// applies reports whether the rule matches op. Faults that only make sense
+// for some operations never match the others.
+func (r *ruleState) applies(op string) bool {
+	switch r.Fault {
+	case TornWrite:
+		if op != OpPut {
+			return false
+		}
+	case NotFound:
+		if op != OpGet && op != OpExists {
+			return false
+		}
+	case BitFlip:
+		if op != OpGet && op != OpPut {
+			return false
+		}
+	}
+	return len(r.Ops) == 0 || slices.Contains(r.Ops, op)
+}
+
+// flip returns a copy of value with one bit flipped, chosen by n.
+func flip(value []byte, n uint64) []byte {
+	out := append([]byte(nil), value...)
+	if len(out) > 0 {
+		bit := n % uint64(len(out)*8)
+		out[bit/8] ^= 1 << (bit % 8)
+	}
+	return out
+}
+
+// Put implements the merkledb.Storage interface.
+func (s *Store) Put(key []byte, value []byte) error {
+	r, n := s.fault(OpPut, key)
+	if r != nil {
+		switch r.Fault {
+		case Error:
+			return r.Err
+		case Latency:
+			time.Sleep(r.Latency)
+		case TornWrite:
+			cut := 0
+			if len(value) > 0 {
+				cut = int(n % uint64(len(value)))
+			}
+			if err := s.storage.Put(key, value[:cut]); err != nil {
+				return err
+			}
+			return r.Err
+		case BitFlip:
+			return s.storage.Put(key, flip(value, n))
+		}
+	}
+	return s.storage.Put(key, value)
+}
+
+// Get implements the merkledb.Storage interface.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	r, n := s.fault(OpGet, key)
+	if r != nil {
+		switch r.Fault {
+		case Error:
+			return nil, r.Err
+		case Latency:
+			time.Sleep(r.Latency)
+		case NotFound:
+			return nil, merkledb.ErrNotFound
+		case BitFlip:
+			value, err := s.storage.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			return flip(value, n), nil
+		}
+	}
+	return s.storage.Get(key)
+}
+
+// Exists implements the merkledb.Storage interface.
+func (s *Store) Exists(key []byte) (bool, error) {
+	r, _ := s.fault(OpExists, key)
+	if r != nil {
+		switch r.Fault {
+		case Error:
+			return false, r.Err
+		case Latency:
+			time.Sleep(r.Latency)
+		case NotFound:
+			return false, nil
+		}
+	}
+	return s.storage.Exists(key)
+}
+
+// Delete implements the merkledb.Deleter interface if the wrapped storage
+// does.
+func (s *Store) Delete(key []byte) error {
+	d, ok := merkledb.AsDeleter(s.storage)
+	if !ok {
+		return fmt.Errorf("faultstore: wrapped storage does not support deletion")
+	}
+	r, _ := s.fault(OpDelete, key)
+	if r != nil {
+		switch r.Fault {
+		case Error:
+			return r.Err
+		case Latency:
+			time.Sleep(r.Latency)
+		}
+	}
+	return d.Delete(key)
+}
+
+// Iterate implements the merkledb.Iterator interface if the wrapped storage
+// does. Faults are decided once per iteration.
+func (s *Store) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	it, ok := merkledb.AsIterator(s.storage)
+	if !ok {
+		return fmt.Errorf("faultstore: wrapped storage does not support iteration")
+	}
+	r, _ := s.fault(OpIterate, start)
+	if r != nil {
+		switch r.Fault {
+		case Error:
+			return r.Err
+		case Latency:
+			time.Sleep(r.Latency)
+		}
+	}
+	return it.Iterate(start, end, fn)
+}
@@ -0,0 +1,219 @@
+package faultstore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AureClai/merkledb"
+	"github.com/AureClai/merkledb/storage/memstore"
+	"github.com/AureClai/merkledb/storage/storagetest"
+)
+
+func TestInterfaceContracts(t *testing.T) {
+	var _ merkledb.Storage = (*Store)(nil)
+	var _ merkledb.Deleter = (*Store)(nil)
+	var _ merkledb.Iterator = (*Store)(nil)
+}
+
+func newBackend(tb testing.TB) *memstore.Store {
+	return memstore.New(nil)
+}
+
+// Without rules, a Store is a transparent wrapper.
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+		return New(newBackend(tb), 1)
+	})
+}
+
+func TestStore_Schedule(t *testing.T) {
+	s := New(newBackend(t), 1, Rule{Fault: Error, Ops: []string{OpPut}, After: 1, Every: 2, Limit: 2})
+
+	var failed []int
+	for i := 1; i <= 8; i++ {
+		if err := s.Put([]byte{byte(i)}, []byte("v")); err != nil {
+			if !errors.Is(err, ErrInjected) {
+				t.Fatalf("Put() returned %v, want ErrInjected", err)
+			}
+			failed = append(failed, i)
+		}
+	}
+	// Skip one call, then fail every second one, twice.
+	if fmt.Sprint(failed) != "[3 5]" {
+		t.Errorf("failed calls = %v, want [3 5]", failed)
+	}
+	if events := s.Events(); len(events) != 2 || events[0].Call != 3 || events[0].Op != OpPut {
+		t.Errorf("Events() = %+v, want two put faults starting at call 3", events)
+	}
+	// Gets are not matched by the rule.
+	if _, err := s.Get([]byte{1}); err != nil {
+		t.Errorf("Get() failed: %v", err)
+	}
+}
+
+func TestStore_SeedIsDeterministic(t *testing.T) {
+	run := func(seed uint64) string {
+		s := New(newBackend(t), seed, Rule{Fault: Error, Probability: 0.3})
+		for i := 0; i < 100; i++ {
+			s.Put([]byte{byte(i)}, []byte("v"))
+			s.Get([]byte{byte(i)})
+		}
+		var calls []int
+		for _, e := range s.Events() {
+			calls = append(calls, e.Call)
+		}
+		return fmt.Sprint(calls)
+	}
+
+	if a, b := run(42), run(42); a != b {
+		t.Errorf("same seed injected different faults:\n%s\n%s", a, b)
+	}
+	if a, b := run(42), run(43); a == b {
+		t.Error("different seeds injected the same faults")
+	}
+}
+
+func TestStore_TornWrite(t *testing.T) {
+	backend := newBackend(t)
+	s := New(backend, 7, Rule{Fault: TornWrite, Every: 1})
+
+	value := []byte("a value long enough to be torn")
+	if err := s.Put([]byte("key"), value); !errors.Is(err, ErrInjected) {
+		t.Fatalf("Put() returned %v, want ErrInjected", err)
+	}
+	stored, err := backend.Get([]byte("key"))
+	if err != nil {
+		t.Fatalf("backend Get() failed: %v", err)
+	}
+	if len(stored) >= len(value) || string(stored) != string(value[:len(stored)]) {
+		t.Errorf("torn write stored %q, want a strict prefix of %q", stored, value)
+	}
+}
+
+func TestStore_BitFlipAndNotFound(t *testing.T) {
+	backend := newBackend(t)
+	backend.Put([]byte("key"), []byte("value"))
+	s := New(backend, 7,
+		Rule{Fault: BitFlip, Ops: []string{OpGet}, Limit: 1, Every: 1},
+		Rule{Fault: NotFound, Every: 1},
+	)
+
+	// The first Get is corrupted in transit; the stored value is intact.
+	got, err := s.Get([]byte("key"))
+	if err != nil || string(got) == "value" || len(got) != len("value") {
+		t.Errorf("Get() with a bit flip = %q, %v; want a corrupted copy", got, err)
+	}
+	if stored, _ := backend.Get([]byte("key")); string(stored) != "value" {
+		t.Errorf("bit flip on Get changed the stored value to %q", stored)
+	}
+
+	// Then the key goes missing.
+	if _, err := s.Get([]byte("key")); !errors.Is(err, merkledb.ErrNotFound) {
+		t.Errorf("Get() returned %v, want ErrNotFound", err)
+	}
+	if ok, err := s.Exists([]byte("key")); ok || err != nil {
+		t.Errorf("Exists() = %v, %v; want false", ok, err)
+	}
+
+	s.SetEnabled(false)
+	if got, err := s.Get([]byte("key")); err != nil || string(got) != "value" {
+		t.Errorf("Get() while disabled = %q, %v; want the stored value", got, err)
+	}
+}
+
+func TestStore_Latency(t *testing.T) {
+	s := New(newBackend(t), 1, Rule{Fault: Latency, Latency: 20 * time.Millisecond, Every: 1})
+	start := time.Now()
+	if err := s.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Put() took %v, want at least the injected 20ms", elapsed)
+	}
+}
+
+// TestCommitsSurviveFaults drives a workspace through a backend that fails
+// and tears writes, and checks that every commit reported as successful is
+// complete and intact once the faults stop.
+func TestCommitsSurviveFaults(t *testing.T) {
+	for seed := uint64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprint("seed=", seed), func(t *testing.T) {
+			s := New(newBackend(t), seed,
+				Rule{Fault: Error, Probability: 0.1},
+				Rule{Fault: TornWrite, Probability: 0.1},
+				Rule{Fault: NotFound, Probability: 0.05},
+			)
+			store := merkledb.NewObjectStore(s)
+			ws, err := merkledb.NewWorkspace(store)
+			if err != nil {
+				t.Fatalf("NewWorkspace() failed: %v", err)
+			}
+
+			var commits []string
+			var parents []string
+			for i := 0; i < 100; i++ {
+				obj := &blob{data: fmt.Sprintf("record %d", i)}
+				if err := ws.Add(fmt.Sprint("file", i%10), obj); err != nil {
+					continue
+				}
+				hash, err := ws.Commit(fmt.Sprint("commit ", i), parents)
+				if err != nil {
+					continue
+				}
+				commits = append(commits, hash)
+				parents = []string{hash}
+			}
+			if len(s.Events()) == 0 || len(commits) == 0 {
+				t.Fatalf("run injected %d faults and made %d commits; want both", len(s.Events()), len(commits))
+			}
+
+			s.SetEnabled(false)
+			for _, hash := range commits {
+				if err := merkledb.VerifyHistory(store, hash); err != nil {
+					t.Errorf("commit %s is inconsistent: %v", hash, err)
+				}
+			}
+		})
+	}
+}
+
+// TestVerifyHistoryDetectsBitRot checks that silent corruption at rest is
+// caught by the integrity check.
+func TestVerifyHistoryDetectsBitRot(t *testing.T) {
+	s := New(newBackend(t), 3, Rule{Fault: BitFlip, Ops: []string{OpPut}, Every: 1, Limit: 1})
+	store := merkledb.NewObjectStore(s)
+	ws, _ := merkledb.NewWorkspace(store)
+	ws.Add("file", &blob{data: "corrupted at rest"})
+	hash, err := ws.Commit("commit", nil)
+	if err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	if err := merkledb.VerifyHistory(store, hash); !errors.Is(err, merkledb.ErrCorruptObject) {
+		t.Errorf("VerifyHistory() returned %v, want ErrCorruptObject", err)
+	}
+}
+
+type blob struct{ data string }
+
+func (b *blob) Serialize() ([]byte, error) { return []byte(b.data), nil }
+
+// plainStorage hides every extension of the storage it embeds.
+type plainStorage struct {
+	merkledb.Storage
+}
+
+func TestStore_SupportsWrappedExtensions(t *testing.T) {
+	s := New(newBackend(t), 1)
+	if _, ok := merkledb.AsDeleter(s); !ok {
+		t.Error("AsDeleter() failed over a storage that can delete")
+	}
+	s = New(plainStorage{newBackend(t)}, 1)
+	if _, ok := merkledb.AsDeleter(s); ok {
+		t.Error("AsDeleter() succeeded over a storage that cannot delete")
+	}
+	if _, ok := merkledb.AsIterator(s); ok {
+		t.Error("AsIterator() succeeded over a storage that cannot iterate")
+	}
+}
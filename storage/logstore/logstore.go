@@ -0,0 +1,478 @@
+// Package logstore implements a persistent, append-only Storage backend that
+// keeps every object in a single data file.
+//
+// The design follows Bitcask: every Put or Delete appends a checksummed record
+// to the end of the log, and an in-memory hash index maps each live key to the
+// position of its latest record. The index is rebuilt by scanning the log when
+// the store is opened. A record that was only partially written when the
+// process crashed, which can only be the last one, is truncated away, so the
+// log always ends on a complete record. The header of each record has its own
+// checksum, so the record sizes it holds can be trusted to find the end of
+// the record: a damaged record followed by others is corruption rather than
+// a torn write, and Open reports it instead of discarding the valid records
+// after it.
+//
+// Deleted and overwritten records stay in the log until Compact rewrites it
+// with only the live entries. Ordered iteration is supported by sorting the
+// index on demand, which is fine for occasional scans; backends that need
+// frequent range queries should use the btree package instead.
+package logstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/AureClai/merkledb"
+)
+
+const (
+	// headerSize is the size of a record header:
+	// record crc32 (4) | header crc32 (4) | kind (1) | key length (4) | value length (4).
+	// The record checksum covers everything after it, and the header
+	// checksum the kind and lengths.
+	headerSize = 17
+
+	recordPut    byte = 1
+	recordDelete byte = 2
+
+	// MaxKeySize is the largest key accepted by the store.
+	MaxKeySize = 1 << 16
+	// MaxValueSize is the largest value accepted by the store.
+	MaxValueSize = math.MaxUint32
+)
+
+// ErrCorrupt is returned when a record read from the log fails its checksum.
+var ErrCorrupt = errors.New("logstore: corrupt record")
+
+// errTorn is returned by readRecord for a damaged record that reaches the end
+// of the log, as one cut short by a crash does.
+var errTorn = errors.New("logstore: torn record")
+
+// ErrClosed is returned by operations on a closed store.
+var ErrClosed = errors.New("logstore: store is closed")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures a Store.
+type Options struct {
+	// SyncWrites makes every Put and Delete call fsync before returning.
+	// Without it, a crash may lose the most recent writes, but never
+	// corrupts the records that were already synced.
+	SyncWrites bool
+}
+
+// entry locates the latest record for a key in the log.
+type entry struct {
+	offset   int64  // offset of the record header
+	keySize  uint32 // length of the key
+	dataSize uint32 // length of the value
+}
+
+func (e entry) recordSize() int64 {
+	return headerSize + int64(e.keySize) + int64(e.dataSize)
+}
+
+// Stats describes the space usage of a Store.
+type Stats struct {
+	// Keys is the number of live keys.
+	Keys int
+	// LiveBytes is the size of the records that hold live values.
+	LiveBytes int64
+	// TotalBytes is the size of the log file.
+	TotalBytes int64
+}
+
+// Store is an append-only, log-structured Storage backend.
+// It is safe for concurrent use.
+type Store struct {
+	mu    sync.RWMutex
+	path  string
+	opts  Options
+	file  *os.File
+	size  int64
+	index map[string]entry
+	live  int64
+}
+
+// Open opens the log at path, creating it if it does not exist, and rebuilds
+// the index by scanning it. A nil opts uses the default options.
+func Open(path string, opts *Options) (*Store, error) {
+	s := &Store{path: path}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load opens the data file and replays it into a fresh index.
+func (s *Store) load() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log: %w", err)
+	}
+	index := make(map[string]entry)
+	var live int64
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		kind, key, value, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errTorn) {
+			// The last record was cut short; it never completed, so it is
+			// discarded.
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to truncate log: %w", err)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to load log: %w at offset %d", err, offset)
+		}
+
+		e := entry{offset: offset, keySize: uint32(len(key)), dataSize: uint32(len(value))}
+		if old, ok := index[string(key)]; ok {
+			live -= old.recordSize()
+		}
+		switch kind {
+		case recordPut:
+			index[string(key)] = e
+			live += e.recordSize()
+		case recordDelete:
+			delete(index, string(key))
+		}
+		offset += e.recordSize()
+	}
+
+	s.file = f
+	s.size = offset
+	s.index = index
+	s.live = live
+	return nil
+}
+
+// readRecord reads and verifies the next record from r, which holds remaining
+// bytes. It returns io.EOF at a clean end of the log, errTorn for a damaged
+// record reaching the end of the log and ErrCorrupt for one followed by
+// more data. Sizes are only trusted once the header checksum matches, and
+// are checked against remaining before anything is allocated.
+func readRecord(r io.Reader, remaining int64) (kind byte, key, value []byte, err error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, nil, io.EOF
+		}
+		return 0, nil, nil, errTorn
+	}
+	if crc32.Checksum(header[8:], crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		// Without a valid header the end of the record is unknown, so it
+		// is only known to be the last one if nothing follows it.
+		if remaining == headerSize {
+			return 0, nil, nil, errTorn
+		}
+		return 0, nil, nil, ErrCorrupt
+	}
+	kind = header[8]
+	keySize := binary.BigEndian.Uint32(header[9:13])
+	dataSize := binary.BigEndian.Uint32(header[13:17])
+	size := headerSize + int64(keySize) + int64(dataSize)
+	if size > remaining {
+		// The header is intact, so the body was cut short.
+		return 0, nil, nil, errTorn
+	}
+	damaged := ErrCorrupt
+	if size == remaining {
+		damaged = errTorn
+	}
+	if (kind != recordPut && kind != recordDelete) || keySize > MaxKeySize {
+		return 0, nil, nil, damaged
+	}
+
+	body := make([]byte, int(keySize)+int(dataSize))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, nil, errTorn
+	}
+	crc := crc32.Update(crc32.Checksum(header[4:], crcTable), crcTable, body)
+	if crc != binary.BigEndian.Uint32(header[0:4]) {
+		return 0, nil, nil, damaged
+	}
+	return kind, body[:keySize], body[keySize:], nil
+}
+
+// encodeRecord builds a complete record ready to be appended to the log.
+// The caller keeps the lengths within MaxKeySize and MaxValueSize.
+func encodeRecord(kind byte, key, value []byte) []byte {
+	rec := make([]byte, headerSize+len(key)+len(value))
+	rec[8] = kind
+	binary.BigEndian.PutUint32(rec[9:13], uint32(len(key)))
+	binary.BigEndian.PutUint32(rec[13:17], uint32(len(value)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.Checksum(rec[8:headerSize], crcTable))
+	copy(rec[headerSize:], key)
+	copy(rec[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(rec[0:4], crc32.Checksum(rec[4:], crcTable))
+	return rec
+}
+
+// append writes a record at the end of the log. The caller must hold the write lock.
+func (s *Store) append(rec []byte) (int64, error) {
+	offset := s.size
+	if _, err := s.file.WriteAt(rec, offset); err != nil {
+		// Drop whatever part of the record made it to disk so the next
+		// append starts on a record boundary.
+		s.file.Truncate(offset)
+		return 0, fmt.Errorf("failed to append record: %w", err)
+	}
+	if s.opts.SyncWrites {
+		if err := s.file.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync log: %w", err)
+		}
+	}
+	s.size += int64(len(rec))
+	return offset, nil
+}
+
+// Put implements the merkledb.Storage interface.
+// Writing a value identical to the one already stored is a no-op, which keeps
+// repeated writes of the same content-addressed object from growing the log.
+func (s *Store) Put(key []byte, value []byte) error {
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("logstore: key of %d bytes exceeds the maximum of %d", len(key), MaxKeySize)
+	}
+	if uint64(len(value)) > MaxValueSize {
+		return fmt.Errorf("logstore: value of %d bytes exceeds the maximum of %d", len(value), uint64(MaxValueSize))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+
+	if old, ok := s.index[string(key)]; ok && old.dataSize == uint32(len(value)) {
+		current, err := s.readValue(old)
+		if err == nil && string(current) == string(value) {
+			return nil
+		}
+	}
+
+	offset, err := s.append(encodeRecord(recordPut, key, value))
+	if err != nil {
+		return err
+	}
+	e := entry{offset: offset, keySize: uint32(len(key)), dataSize: uint32(len(value))}
+	if old, ok := s.index[string(key)]; ok {
+		s.live -= old.recordSize()
+	}
+	s.index[string(key)] = e
+	s.live += e.recordSize()
+	return nil
+}
+
+// Get implements the merkledb.Storage interface.
+// The returned slice is owned by the caller.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.file == nil {
+		return nil, ErrClosed
+	}
+
+	e, ok := s.index[string(key)]
+	if !ok {
+		return nil, merkledb.ErrNotFound
+	}
+	return s.readValue(e)
+}
+
+// readValue reads and verifies the record described by e and returns its value.
+// The caller must hold the lock.
+func (s *Store) readValue(e entry) ([]byte, error) {
+	rec := make([]byte, e.recordSize())
+	if _, err := s.file.ReadAt(rec, e.offset); err != nil {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	if crc32.Checksum(rec[4:], crcTable) != binary.BigEndian.Uint32(rec[0:4]) {
+		return nil, ErrCorrupt
+	}
+	return rec[headerSize+int64(e.keySize):], nil
+}
+
+// Exists implements the merkledb.Storage interface.
+func (s *Store) Exists(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.file == nil {
+		return false, ErrClosed
+	}
+
+	_, ok := s.index[string(key)]
+	return ok, nil
+}
+
+// Delete implements the merkledb.Deleter interface by appending a tombstone.
+// The space used by the deleted value is reclaimed by Compact.
+func (s *Store) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+
+	old, ok := s.index[string(key)]
+	if !ok {
+		return nil
+	}
+	if _, err := s.append(encodeRecord(recordDelete, key, nil)); err != nil {
+		return err
+	}
+	delete(s.index, string(key))
+	s.live -= old.recordSize()
+	return nil
+}
+
+// Iterate implements the merkledb.Iterator interface.
+// The keys in range are collected up front and sorted, so fn may safely
+// modify the store; keys deleted before fn reaches them are skipped.
+func (s *Store) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	s.mu.RLock()
+	if s.file == nil {
+		s.mu.RUnlock()
+		return ErrClosed
+	}
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		if (start == nil || k >= string(start)) && (end == nil || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value, err := s.Get([]byte(k))
+		if errors.Is(err, merkledb.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn([]byte(k), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats reports the number of live keys and the space they use.
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Stats{Keys: len(s.index), LiveBytes: s.live, TotalBytes: s.size}
+}
+
+// Compact rewrites the log so that it contains only the latest record of each
+// live key, dropping tombstones and the values they deleted.
+//
+// The new log is written next to the old one and atomically renamed over it,
+// so a crash during compaction leaves the original log intact.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted log: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	for _, k := range keys {
+		value, err := s.readValue(s.index[k])
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to read key during compaction: %w", err)
+		}
+		if _, err := w.Write(encodeRecord(recordPut, []byte(k), value)); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to write compacted log: %w", err)
+		}
+	}
+	if err := w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write compacted log: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace log: %w", err)
+	}
+	syncDir(filepath.Dir(s.path))
+
+	s.file.Close()
+	s.file = nil
+	if err := s.load(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// syncDir makes a rename in dir durable. Errors are ignored because some
+// platforms do not support syncing directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Close syncs and closes the log. The store cannot be used afterwards.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
@@ -0,0 +1,284 @@
+package logstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AureClai/merkledb"
+	"github.com/AureClai/merkledb/storage/storagetest"
+)
+
+func newTestStorage(tb testing.TB) merkledb.Storage {
+	s, err := Open(filepath.Join(tb.TempDir(), "data.log"), nil)
+	if err != nil {
+		tb.Fatalf("Open() failed: %v", err)
+	}
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, newTestStorage)
+}
+
+func BenchmarkStorage(b *testing.B) {
+	storagetest.RunBenchmarks(b, newTestStorage)
+}
+
+func TestInterfaceContracts(t *testing.T) {
+	var _ merkledb.Storage = (*Store)(nil)
+	var _ merkledb.Deleter = (*Store)(nil)
+	var _ merkledb.Iterator = (*Store)(nil)
+}
+
+func openTestStore(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStore_PutGetExists(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "data.log"))
+
+	if err := s.Put([]byte("hello"), []byte("world")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	value, err := s.Get([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if string(value) != "world" {
+		t.Errorf("Get() returned wrong value: got %q, want %q", value, "world")
+	}
+
+	exists, err := s.Exists([]byte("hello"))
+	if err != nil || !exists {
+		t.Errorf("Exists() = %v, %v; want true, nil", exists, err)
+	}
+
+	if _, err := s.Get([]byte("missing")); !errors.Is(err, merkledb.ErrNotFound) {
+		t.Errorf("Get() on missing key returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestStore_ReopenRebuildsIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s, err := Open(path, &Options{SyncWrites: true})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	s.Put([]byte("a"), []byte("1"))
+	s.Put([]byte("b"), []byte("2"))
+	s.Put([]byte("a"), []byte("3"))
+	s.Delete([]byte("b"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	s = openTestStore(t, path)
+	value, err := s.Get([]byte("a"))
+	if err != nil || string(value) != "3" {
+		t.Errorf("Get(a) after reopen = %q, %v; want %q", value, err, "3")
+	}
+	if exists, _ := s.Exists([]byte("b")); exists {
+		t.Error("deleted key b reappeared after reopen")
+	}
+}
+
+func TestStore_PutSameValueDoesNotGrowLog(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "data.log"))
+
+	s.Put([]byte("k"), []byte("same"))
+	before := s.Stats().TotalBytes
+	s.Put([]byte("k"), []byte("same"))
+	if after := s.Stats().TotalBytes; after != before {
+		t.Errorf("rewriting an identical value grew the log from %d to %d bytes", before, after)
+	}
+}
+
+func TestStore_CompactDropsDeletedEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s := openTestStore(t, path)
+
+	s.Put([]byte("keep"), []byte("value"))
+	s.Put([]byte("drop"), bytes.Repeat([]byte("x"), 1024))
+	s.Delete([]byte("drop"))
+
+	before := s.Stats()
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact() failed: %v", err)
+	}
+	after := s.Stats()
+
+	if after.TotalBytes >= before.TotalBytes {
+		t.Errorf("Compact() did not shrink the log: %d -> %d bytes", before.TotalBytes, after.TotalBytes)
+	}
+	if after.TotalBytes != after.LiveBytes {
+		t.Errorf("compacted log has %d dead bytes", after.TotalBytes-after.LiveBytes)
+	}
+	value, err := s.Get([]byte("keep"))
+	if err != nil || string(value) != "value" {
+		t.Errorf("Get(keep) after compaction = %q, %v", value, err)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Error("temporary compaction file was left behind")
+	}
+}
+
+func TestStore_RecoversFromTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	s.Put([]byte("first"), []byte("complete"))
+	s.Put([]byte("second"), []byte("torn"))
+	size := s.Stats().TotalBytes
+	s.Close()
+
+	// Simulate a crash in the middle of writing the second record.
+	if err := os.Truncate(path, size-2); err != nil {
+		t.Fatalf("failed to truncate log: %v", err)
+	}
+
+	s = openTestStore(t, path)
+	if value, err := s.Get([]byte("first")); err != nil || string(value) != "complete" {
+		t.Errorf("Get(first) after recovery = %q, %v", value, err)
+	}
+	if exists, _ := s.Exists([]byte("second")); exists {
+		t.Error("torn record was not discarded")
+	}
+
+	// The log must accept new writes on a clean record boundary.
+	if err := s.Put([]byte("third"), []byte("after recovery")); err != nil {
+		t.Fatalf("Put() after recovery failed: %v", err)
+	}
+	s.Close()
+	s = openTestStore(t, path)
+	if value, err := s.Get([]byte("third")); err != nil || string(value) != "after recovery" {
+		t.Errorf("Get(third) after second reopen = %q, %v", value, err)
+	}
+}
+
+func TestStore_RejectsCorruptionMidLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s := openTestStore(t, path)
+	s.Put([]byte("first"), []byte("damaged"))
+	s.Put([]byte("second"), []byte("intact"))
+	size := s.Stats().TotalBytes
+	s.Close()
+
+	// Flip a bit in the first record: a later record proves it is not torn.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	f.WriteAt([]byte{'D'}, headerSize+5)
+	f.Close()
+
+	if _, err := Open(path, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open() of a log corrupt in the middle returned %v, want ErrCorrupt", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Errorf("log was truncated to %d bytes from %d, want it left intact", info.Size(), size)
+	}
+}
+
+func TestStore_RejectsDamagedSizeMidLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s := openTestStore(t, path)
+	s.Put([]byte("first"), []byte("damaged"))
+	s.Put([]byte("second"), []byte("intact"))
+	size := s.Stats().TotalBytes
+	s.Close()
+
+	// A flipped bit in the value length of the first record makes it reach
+	// past the end of the log, which must not pass for a torn write.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	f.WriteAt([]byte{0x80}, 13)
+	f.Close()
+
+	if _, err := Open(path, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open() of a log with a damaged size in the middle returned %v, want ErrCorrupt", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Errorf("log was truncated to %d bytes from %d, want it left intact", info.Size(), size)
+	}
+}
+
+func TestStore_RecoversFromTornHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s := openTestStore(t, path)
+	s.Put([]byte("first"), []byte("complete"))
+	size := s.Stats().TotalBytes
+	s.Close()
+
+	// An intact header claiming a 4 GiB value must not be allocated for.
+	header := encodeRecord(recordPut, []byte("big"), nil)[:headerSize]
+	binary.BigEndian.PutUint32(header[13:17], 1<<32-1)
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(header[8:], crcTable))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	f.Write(header)
+	f.Close()
+
+	s = openTestStore(t, path)
+	if value, err := s.Get([]byte("first")); err != nil || string(value) != "complete" {
+		t.Errorf("Get(first) after recovery = %q, %v", value, err)
+	}
+	if got := s.Stats().TotalBytes; got != size {
+		t.Errorf("log is %d bytes after recovery, want %d", got, size)
+	}
+}
+
+func TestStore_DetectsCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s := openTestStore(t, path)
+	s.Put([]byte("key"), []byte("value"))
+
+	// Flip a bit in the value on disk.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	f.WriteAt([]byte{'V'}, headerSize+3)
+	f.Close()
+
+	if _, err := s.Get([]byte("key")); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Get() on corrupted record returned %v, want ErrCorrupt", err)
+	}
+}
+
+func TestStore_IterateInOrder(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "data.log"))
+	for _, k := range []string{"c", "a", "d", "b"} {
+		s.Put([]byte(k), []byte("v"+k))
+	}
+
+	var got []string
+	err := s.Iterate([]byte("b"), []byte("d"), func(key, value []byte) error {
+		got = append(got, string(key)+"="+string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate() failed: %v", err)
+	}
+	want := []string{"b=vb", "c=vc"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Iterate() visited %v, want %v", got, want)
+	}
+}
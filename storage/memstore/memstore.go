@@ -0,0 +1,171 @@
+// Package memstore implements a merkledb.Storage backend that keeps every
+// object in memory.
+//
+// It is the reference implementation of the Storage contract: it is safe for
+// concurrent use, copies values on the way in and out so callers can never
+// alias stored data, and supports deletion and ordered iteration. It suits
+// tests, examples and ephemeral datasets that fit in memory; nothing survives
+// the process.
+//
+// A store can be bounded by a number of keys or a number of bytes, in which
+// case writes that would exceed the limit fail with ErrFull. Clone takes a
+// cheap point-in-time copy, for example to snapshot a dataset before a risky
+// import.
+package memstore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/AureClai/merkledb"
+)
+
+// ErrFull is returned by Put when storing the value would exceed the store's
+// limits.
+var ErrFull = errors.New("memstore: store is full")
+
+// Options configures a Store.
+type Options struct {
+	// MaxKeys is the maximum number of keys held. Zero means no limit.
+	MaxKeys int
+	// MaxBytes is the maximum total size of keys and values held. Zero
+	// means no limit.
+	MaxBytes int64
+}
+
+// Store is an in-memory merkledb.Storage. It is safe for concurrent use.
+type Store struct {
+	maxKeys  int
+	maxBytes int64
+
+	mu    sync.RWMutex
+	data  map[string][]byte
+	bytes int64
+}
+
+// New returns an empty Store. opts may be nil.
+func New(opts *Options) *Store {
+	s := &Store{data: make(map[string][]byte)}
+	if opts != nil {
+		s.maxKeys = opts.MaxKeys
+		s.maxBytes = opts.MaxBytes
+	}
+	return s
+}
+
+// Put implements the merkledb.Storage interface.
+func (s *Store) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return fmt.Errorf("memstore: empty key")
+	}
+	// Stored slices are never modified, so Clone and Get can share them.
+	stored := append(make([]byte, 0, len(value)), value...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, exists := s.data[string(key)]
+	size := s.bytes + int64(len(value))
+	if exists {
+		size -= int64(len(old))
+	} else {
+		size += int64(len(key))
+		if s.maxKeys > 0 && len(s.data) >= s.maxKeys {
+			return fmt.Errorf("%w: key limit of %d reached", ErrFull, s.maxKeys)
+		}
+	}
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return fmt.Errorf("%w: storing %d bytes would exceed the limit of %d", ErrFull, len(value), s.maxBytes)
+	}
+	s.data[string(key)] = stored
+	s.bytes = size
+	return nil
+}
+
+// Get implements the merkledb.Storage interface.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	value, ok := s.data[string(key)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, merkledb.ErrNotFound
+	}
+	return append(make([]byte, 0, len(value)), value...), nil
+}
+
+// Exists implements the merkledb.Storage interface.
+func (s *Store) Exists(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.data[string(key)]
+	return ok, nil
+}
+
+// Delete implements the merkledb.Deleter interface.
+func (s *Store) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.data[string(key)]; ok {
+		s.bytes -= int64(len(key) + len(old))
+		delete(s.data, string(key))
+	}
+	return nil
+}
+
+// Iterate implements the merkledb.Iterator interface. It works on a snapshot
+// of the keys taken when it starts, so fn may modify the store.
+func (s *Store) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	type entry struct {
+		key   string
+		value []byte
+	}
+	s.mu.RLock()
+	entries := make([]entry, 0, len(s.data))
+	for k, v := range s.data {
+		if (start == nil || k >= string(start)) && (end == nil || k < string(end)) {
+			entries = append(entries, entry{k, v})
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	for _, e := range entries {
+		if err := fn([]byte(e.key), append([]byte(nil), e.value...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of keys held.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.data)
+}
+
+// Size returns the total size of the keys and values held, in bytes.
+func (s *Store) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bytes
+}
+
+// Clone returns an independent copy of the store with the same contents and
+// limits. Values are shared rather than copied, since neither store ever
+// modifies them, so cloning costs one map copy.
+func (s *Store) Clone() *Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := &Store{
+		maxKeys:  s.maxKeys,
+		maxBytes: s.maxBytes,
+		data:     make(map[string][]byte, len(s.data)),
+		bytes:    s.bytes,
+	}
+	for k, v := range s.data {
+		c.data[k] = v
+	}
+	return c
+}
@@ -0,0 +1,90 @@
+package memstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AureClai/merkledb"
+	"github.com/AureClai/merkledb/storage/storagetest"
+)
+
+func TestInterfaceContracts(t *testing.T) {
+	var _ merkledb.Storage = (*Store)(nil)
+	var _ merkledb.Deleter = (*Store)(nil)
+	var _ merkledb.Iterator = (*Store)(nil)
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+		return New(nil)
+	})
+}
+
+func BenchmarkStorage(b *testing.B) {
+	storagetest.RunBenchmarks(b, func(tb testing.TB) merkledb.Storage {
+		return New(nil)
+	})
+}
+
+func TestStore_KeyLimit(t *testing.T) {
+	s := New(&Options{MaxKeys: 2})
+	s.Put([]byte("a"), []byte("1"))
+	s.Put([]byte("b"), []byte("2"))
+
+	if err := s.Put([]byte("c"), []byte("3")); !errors.Is(err, ErrFull) {
+		t.Errorf("Put() beyond the key limit returned %v, want ErrFull", err)
+	}
+	// Overwriting an existing key does not add one.
+	if err := s.Put([]byte("a"), []byte("updated")); err != nil {
+		t.Errorf("Put() overwriting a key at the limit failed: %v", err)
+	}
+	s.Delete([]byte("b"))
+	if err := s.Put([]byte("c"), []byte("3")); err != nil {
+		t.Errorf("Put() after Delete() freed a slot failed: %v", err)
+	}
+}
+
+func TestStore_ByteLimit(t *testing.T) {
+	s := New(&Options{MaxBytes: 10})
+	if err := s.Put([]byte("k"), []byte("12345")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if got := s.Size(); got != 6 {
+		t.Errorf("Size() = %d, want 6", got)
+	}
+	if err := s.Put([]byte("x"), []byte("12345")); !errors.Is(err, ErrFull) {
+		t.Errorf("Put() beyond the byte limit returned %v, want ErrFull", err)
+	}
+	// Replacing a value only counts the difference.
+	if err := s.Put([]byte("k"), []byte("123456789")); err != nil {
+		t.Errorf("Put() growing a value within the limit failed: %v", err)
+	}
+	if got := s.Size(); got != 10 {
+		t.Errorf("Size() = %d, want 10", got)
+	}
+}
+
+func TestStore_Clone(t *testing.T) {
+	s := New(nil)
+	s.Put([]byte("a"), []byte("1"))
+
+	c := s.Clone()
+	s.Put([]byte("a"), []byte("changed"))
+	s.Put([]byte("b"), []byte("2"))
+	c.Delete([]byte("a"))
+
+	if got, _ := s.Get([]byte("a")); string(got) != "changed" {
+		t.Errorf("original Get() = %q after the clone was modified", got)
+	}
+	if c.Len() != 0 || s.Len() != 2 {
+		t.Errorf("Len() = %d (original), %d (clone); want 2 and 0", s.Len(), c.Len())
+	}
+
+	c2 := s.Clone()
+	if got, _ := c2.Get([]byte("b")); string(got) != "2" {
+		t.Errorf("clone Get() = %q, want %q", got, "2")
+	}
+	if c2.Size() != s.Size() {
+		t.Errorf("clone Size() = %d, want %d", c2.Size(), s.Size())
+	}
+}
@@ -0,0 +1,356 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AureClai/merkledb"
+)
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// HTTPClient is used to send requests. If nil, a client with a pooled
+	// transport sized by MaxIdleConnsPerHost and a 30 second timeout is used.
+	HTTPClient *http.Client
+	// MaxIdleConnsPerHost sets the size of the connection pool of the default
+	// HTTP client. Defaults to 32.
+	MaxIdleConnsPerHost int
+	// MaxRetries is the number of times a failed request is retried.
+	// Defaults to 3; a negative value disables retries.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry. It doubles after
+	// every attempt. Defaults to 100ms.
+	RetryBackoff time.Duration
+	// Header is added to every request, for example to carry credentials.
+	Header http.Header
+	// PageSize is the number of keys fetched per request by Iterate.
+	// Defaults to 1000.
+	PageSize int
+}
+
+// Entry is a key and value pair used by batch operations.
+type Entry struct {
+	Key   []byte
+	Value []byte
+}
+
+// StatusError is returned when the server answers with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("remote storage returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client is a merkledb.Storage that talks to a server created by NewHandler.
+// It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+	header     http.Header
+	pageSize   int
+}
+
+// NewClient returns a client for the server at baseURL, for example
+// "http://objects.internal:8080". A nil opts uses the default options.
+func NewClient(baseURL string, opts *ClientOptions) *Client {
+	var o ClientOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = 32
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 100 * time.Millisecond
+	}
+	if o.PageSize <= 0 {
+		o.PageSize = defaultListLimit
+	}
+	if o.HTTPClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = o.MaxIdleConnsPerHost * 4
+		transport.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+		o.HTTPClient = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	}
+
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: o.HTTPClient,
+		maxRetries: o.MaxRetries,
+		backoff:    o.RetryBackoff,
+		header:     o.Header,
+		pageSize:   o.PageSize,
+	}
+}
+
+// retryable reports whether a response status indicates a transient failure.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500 && status != http.StatusNotImplemented
+}
+
+// do sends a request, retrying transport errors and transient statuses with
+// exponential backoff. The returned response has a fully read body.
+func (c *Client) do(method, path string, body []byte) (int, []byte, error) {
+	backoff := c.backoff
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		for k, values := range c.header {
+			req.Header[k] = values
+		}
+		if body != nil && strings.HasPrefix(path, "/batch/") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("%s %s failed: %w", method, path, err)
+			continue
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("failed to read response of %s %s: %w", method, path, err)
+			continue
+		}
+		if retryable(resp.StatusCode) {
+			lastErr = &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+			continue
+		}
+		return resp.StatusCode, data, nil
+	}
+	return 0, nil, lastErr
+}
+
+// expect turns any status other than want into an error; 404 becomes
+// merkledb.ErrNotFound.
+func expect(status int, data []byte, want int) error {
+	switch status {
+	case want:
+		return nil
+	case http.StatusNotFound:
+		return merkledb.ErrNotFound
+	default:
+		return &StatusError{StatusCode: status, Message: strings.TrimSpace(string(data))}
+	}
+}
+
+func objectPath(key []byte) string {
+	return "/objects/" + hex.EncodeToString(key)
+}
+
+// Put implements the merkledb.Storage interface.
+func (c *Client) Put(key []byte, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	status, data, err := c.do(http.MethodPut, objectPath(key), value)
+	if err != nil {
+		return err
+	}
+	return expect(status, data, http.StatusNoContent)
+}
+
+// Get implements the merkledb.Storage interface.
+func (c *Client) Get(key []byte) ([]byte, error) {
+	status, data, err := c.do(http.MethodGet, objectPath(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := expect(status, data, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Exists implements the merkledb.Storage interface.
+func (c *Client) Exists(key []byte) (bool, error) {
+	status, data, err := c.do(http.MethodHead, objectPath(key), nil)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if err := expect(status, data, http.StatusOK); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete implements the merkledb.Deleter interface. It fails with a
+// StatusError if the server's backend does not support deletion.
+func (c *Client) Delete(key []byte) error {
+	status, data, err := c.do(http.MethodDelete, objectPath(key), nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return expect(status, data, http.StatusNoContent)
+}
+
+// postJSON sends a batch request and decodes its response into out, if any.
+func (c *Client) postJSON(path string, in, out any, want int) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	status, data, err := c.do(http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	if err := expect(status, data, want); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func hexKeys(keys [][]byte) []string {
+	out := make([]string, len(keys))
+	for i, key := range keys {
+		out[i] = hex.EncodeToString(key)
+	}
+	return out
+}
+
+// GetMany fetches several values in one round trip. The result is keyed by
+// string(key); keys that do not exist are absent from it.
+func (c *Client) GetMany(keys [][]byte) (map[string][]byte, error) {
+	var resp batchObjects
+	if err := c.postJSON("/batch/get", batchKeys{Keys: hexKeys(keys)}, &resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(resp.Objects))
+	for k, value := range resp.Objects {
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("server returned invalid key %q", k)
+		}
+		if value == nil {
+			value = []byte{}
+		}
+		out[string(key)] = value
+	}
+	return out, nil
+}
+
+// PutMany stores several values in one round trip.
+func (c *Client) PutMany(entries []Entry) error {
+	req := batchObjects{Objects: make(map[string][]byte, len(entries))}
+	for _, e := range entries {
+		value := e.Value
+		if value == nil {
+			value = []byte{}
+		}
+		req.Objects[hex.EncodeToString(e.Key)] = value
+	}
+	return c.postJSON("/batch/put", req, nil, http.StatusNoContent)
+}
+
+// ExistsMany checks several keys in one round trip. The result is in the same
+// order as keys.
+func (c *Client) ExistsMany(keys [][]byte) ([]bool, error) {
+	var resp batchExists
+	hk := hexKeys(keys)
+	if err := c.postJSON("/batch/exists", batchKeys{Keys: hk}, &resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	out := make([]bool, len(keys))
+	for i, k := range hk {
+		out[i] = resp.Exists[k]
+	}
+	return out, nil
+}
+
+// Iterate implements the merkledb.Iterator interface by listing keys a page
+// at a time and fetching each page's values with a batch request. It fails
+// with a StatusError if the server's backend does not support iteration.
+func (c *Client) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	next := hex.EncodeToString(start)
+	for {
+		query := url.Values{"limit": {strconv.Itoa(c.pageSize)}}
+		if next != "" {
+			query.Set("start", next)
+		}
+		if end != nil {
+			query.Set("end", hex.EncodeToString(end))
+		}
+		status, data, err := c.do(http.MethodGet, "/keys?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(status, data, http.StatusOK); err != nil {
+			return err
+		}
+		var page keyList
+		if err := json.Unmarshal(data, &page); err != nil {
+			return fmt.Errorf("failed to decode key list: %w", err)
+		}
+
+		keys := make([][]byte, len(page.Keys))
+		for i, k := range page.Keys {
+			if keys[i], err = hex.DecodeString(k); err != nil {
+				return fmt.Errorf("server returned invalid key %q", k)
+			}
+		}
+		values, err := c.GetMany(keys)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			value, ok := values[string(key)]
+			if !ok {
+				// Deleted since the page was listed.
+				continue
+			}
+			if err := fn(key, value); err != nil {
+				return err
+			}
+		}
+
+		if page.Next == "" {
+			return nil
+		}
+		next = page.Next
+	}
+}
+
+// Close releases idle connections held by the client's connection pool.
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,206 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AureClai/merkledb"
+	"github.com/AureClai/merkledb/storage/logstore"
+	"github.com/AureClai/merkledb/storage/storagetest"
+)
+
+func TestInterfaceContracts(t *testing.T) {
+	var _ merkledb.Storage = (*Client)(nil)
+	var _ merkledb.Deleter = (*Client)(nil)
+	var _ merkledb.Iterator = (*Client)(nil)
+}
+
+// newBackend returns a fresh on-disk storage to serve in tests.
+func newBackend(tb testing.TB) *logstore.Store {
+	s, err := logstore.Open(filepath.Join(tb.TempDir(), "data.log"), nil)
+	if err != nil {
+		tb.Fatalf("logstore.Open() failed: %v", err)
+	}
+	tb.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestClient serves a fresh backend through handler wrappers and returns a
+// client for it.
+func newTestClient(tb testing.TB, wrap func(http.Handler) http.Handler) *Client {
+	var h http.Handler = NewHandler(newBackend(tb), nil)
+	if wrap != nil {
+		h = wrap(h)
+	}
+	server := httptest.NewServer(h)
+	tb.Cleanup(server.Close)
+	return NewClient(server.URL, &ClientOptions{RetryBackoff: time.Millisecond})
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+		return newTestClient(tb, nil)
+	})
+}
+
+func BenchmarkStorage(b *testing.B) {
+	storagetest.RunBenchmarks(b, func(tb testing.TB) merkledb.Storage {
+		return newTestClient(tb, nil)
+	})
+}
+
+func TestClient_RetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Fail the first two attempts of every request.
+			if calls.Add(1)%3 != 0 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	if err := c.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put() failed despite retries: %v", err)
+	}
+	value, err := c.Get([]byte("key"))
+	if err != nil || string(value) != "value" {
+		t.Errorf("Get() = %q, %v", value, err)
+	}
+	if got := calls.Load(); got != 6 {
+		t.Errorf("server saw %d requests, want 6", got)
+	}
+}
+
+func TestClient_GivesUpAfterMaxRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	c := NewClient(server.URL, &ClientOptions{MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+	_, err := c.Get([]byte("key"))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Get() returned %v, want a 502 StatusError", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("server saw %d requests, want 3", got)
+	}
+}
+
+func TestClient_Batch(t *testing.T) {
+	c := newTestClient(t, nil)
+
+	err := c.PutMany([]Entry{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("empty"), Value: nil},
+	})
+	if err != nil {
+		t.Fatalf("PutMany() failed: %v", err)
+	}
+
+	values, err := c.GetMany([][]byte{[]byte("a"), []byte("b"), []byte("empty"), []byte("missing")})
+	if err != nil {
+		t.Fatalf("GetMany() failed: %v", err)
+	}
+	if len(values) != 3 || string(values["a"]) != "1" || string(values["b"]) != "2" {
+		t.Errorf("GetMany() returned %q", values)
+	}
+	if v, ok := values["empty"]; !ok || len(v) != 0 {
+		t.Errorf("GetMany() lost the empty value: %q, %v", v, ok)
+	}
+
+	exists, err := c.ExistsMany([][]byte{[]byte("missing"), []byte("a")})
+	if err != nil {
+		t.Fatalf("ExistsMany() failed: %v", err)
+	}
+	if exists[0] || !exists[1] {
+		t.Errorf("ExistsMany() = %v, want [false true]", exists)
+	}
+}
+
+func TestClient_IteratePages(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newBackend(t), nil))
+	defer server.Close()
+	c := NewClient(server.URL, &ClientOptions{PageSize: 3})
+
+	for _, k := range []string{"e", "b", "g", "a", "d", "c", "f"} {
+		c.Put([]byte(k), []byte(k))
+	}
+	var got string
+	err := c.Iterate([]byte("b"), []byte("g"), func(key, value []byte) error {
+		got += string(key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate() failed: %v", err)
+	}
+	if got != "bcdef" {
+		t.Errorf("Iterate() visited %q, want %q", got, "bcdef")
+	}
+}
+
+func TestHandler_UnsupportedCapabilities(t *testing.T) {
+	// A backend without Delete or Iterate support.
+	type plainStorage struct{ merkledb.Storage }
+	server := httptest.NewServer(NewHandler(plainStorage{newBackend(t)}, nil))
+	defer server.Close()
+	c := NewClient(server.URL, nil)
+
+	var statusErr *StatusError
+	if err := c.Delete([]byte("key")); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Delete() returned %v, want a 501 StatusError", err)
+	}
+	err := c.Iterate(nil, nil, func(key, value []byte) error { return nil })
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Iterate() returned %v, want a 501 StatusError", err)
+	}
+}
+
+func TestHandler_LimitsBodySize(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newBackend(t), &HandlerOptions{MaxBodySize: 16}))
+	defer server.Close()
+	c := NewClient(server.URL, nil)
+
+	if err := c.Put([]byte("small"), bytes.Repeat([]byte("x"), 16)); err != nil {
+		t.Fatalf("Put() of a value at the limit failed: %v", err)
+	}
+	var statusErr *StatusError
+	err := c.Put([]byte("large"), bytes.Repeat([]byte("x"), 17))
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Put() of a value over the limit returned %v, want a 413 StatusError", err)
+	}
+	if exists, _ := c.Exists([]byte("large")); exists {
+		t.Error("value over the limit was stored")
+	}
+	err = c.PutMany([]Entry{{Key: []byte("batch"), Value: bytes.Repeat([]byte("x"), 17)}})
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("PutMany() over the limit returned %v, want a 413 StatusError", err)
+	}
+}
+
+func TestHandler_RejectsInvalidKeys(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newBackend(t), nil))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/objects/not-hex")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET with an invalid key returned %d, want 400", resp.StatusCode)
+	}
+}
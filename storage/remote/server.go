@@ -0,0 +1,321 @@
+// Package remote exposes a merkledb.Storage over HTTP and provides the
+// matching client, so that several hosts can share one object store.
+//
+// The protocol is a small REST API in which keys travel as lowercase hex:
+//
+//	GET    /objects/{key}   fetch a value (404 if missing)
+//	HEAD   /objects/{key}   check that a key exists (404 if missing)
+//	PUT    /objects/{key}   store the request body
+//	DELETE /objects/{key}   remove a key, if the backend supports it
+//	POST   /batch/get       fetch many values at once
+//	POST   /batch/put       store many values at once
+//	POST   /batch/exists    check many keys at once
+//	GET    /keys            list keys in order, if the backend supports it
+//
+// Batch requests and responses are JSON documents in which values are
+// base64-encoded. Optional backend capabilities that are missing are
+// reported with 501 Not Implemented, and request bodies over the size limit
+// with 413 Request Entity Too Large.
+package remote
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/AureClai/merkledb"
+)
+
+// defaultMaxBodySize bounds the size of a request body when no limit is given.
+const defaultMaxBodySize = 256 << 20
+
+// defaultListLimit is the number of keys returned by /keys when no limit is given.
+const defaultListLimit = 1000
+
+// batchKeys is the body of /batch/get and /batch/exists requests.
+type batchKeys struct {
+	Keys []string `json:"keys"`
+}
+
+// batchObjects is the body of /batch/put requests and /batch/get responses.
+// Values are base64-encoded by encoding/json.
+type batchObjects struct {
+	Objects map[string][]byte `json:"objects"`
+	Missing []string          `json:"missing,omitempty"`
+}
+
+// batchExists is the body of /batch/exists responses.
+type batchExists struct {
+	Exists map[string]bool `json:"exists"`
+}
+
+// keyList is the body of /keys responses. Next is set when more keys remain
+// and is the start key to request the following page.
+type keyList struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// HandlerOptions configures the handler returned by NewHandler.
+type HandlerOptions struct {
+	// MaxBodySize bounds the size of the body of PUT and batch requests.
+	// Defaults to 256 MiB.
+	MaxBodySize int64
+}
+
+type handler struct {
+	storage     merkledb.Storage
+	maxBodySize int64
+}
+
+// NewHandler returns an http.Handler that serves storage over the protocol
+// described in the package documentation. A nil opts uses the default
+// options.
+func NewHandler(storage merkledb.Storage, opts *HandlerOptions) http.Handler {
+	h := &handler{storage: storage, maxBodySize: defaultMaxBodySize}
+	if opts != nil && opts.MaxBodySize > 0 {
+		h.maxBodySize = opts.MaxBodySize
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /objects/{key}", h.get)
+	mux.HandleFunc("PUT /objects/{key}", h.put)
+	mux.HandleFunc("DELETE /objects/{key}", h.delete)
+	mux.HandleFunc("POST /batch/get", h.batchGet)
+	mux.HandleFunc("POST /batch/put", h.batchPut)
+	mux.HandleFunc("POST /batch/exists", h.batchExists)
+	mux.HandleFunc("GET /keys", h.keys)
+	return mux
+}
+
+// pathKey decodes the {key} path parameter, reporting a 400 if it is invalid.
+func pathKey(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	key, err := hex.DecodeString(r.PathValue("key"))
+	if err != nil || len(key) == 0 {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return nil, false
+	}
+	return key, true
+}
+
+// writeError maps a storage error to an HTTP response.
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, merkledb.ErrNotFound) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// bodyError reports an error reading a request body: a 413 if the body is
+// over the size limit, and a 400 otherwise.
+func bodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+}
+
+// readJSON decodes a batch request body, reporting it with bodyError if it
+// cannot be read.
+func (h *handler) readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.maxBodySize)).Decode(v); err != nil {
+		bodyError(w, err)
+		return false
+	}
+	return true
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		exists, err := h.storage.Exists(key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	value, err := h.storage.Get(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.Write(value)
+}
+
+func (h *handler) put(w http.ResponseWriter, r *http.Request) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+	if err := h.storage.Put(key, value); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	d, ok := merkledb.AsDeleter(h.storage)
+	if !ok {
+		http.Error(w, "storage does not support deletion", http.StatusNotImplemented)
+		return
+	}
+	if err := d.Delete(key); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) batchGet(w http.ResponseWriter, r *http.Request) {
+	var req batchKeys
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	resp := batchObjects{Objects: make(map[string][]byte, len(req.Keys))}
+	for _, k := range req.Keys {
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			http.Error(w, "invalid key "+strconv.Quote(k), http.StatusBadRequest)
+			return
+		}
+		value, err := h.storage.Get(key)
+		if errors.Is(err, merkledb.ErrNotFound) {
+			resp.Missing = append(resp.Missing, k)
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		resp.Objects[k] = value
+	}
+	writeJSON(w, resp)
+}
+
+func (h *handler) batchPut(w http.ResponseWriter, r *http.Request) {
+	var req batchObjects
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	for k, value := range req.Objects {
+		key, err := hex.DecodeString(k)
+		if err != nil || len(key) == 0 {
+			http.Error(w, "invalid key "+strconv.Quote(k), http.StatusBadRequest)
+			return
+		}
+		if err := h.storage.Put(key, value); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) batchExists(w http.ResponseWriter, r *http.Request) {
+	var req batchKeys
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	resp := batchExists{Exists: make(map[string]bool, len(req.Keys))}
+	for _, k := range req.Keys {
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			http.Error(w, "invalid key "+strconv.Quote(k), http.StatusBadRequest)
+			return
+		}
+		exists, err := h.storage.Exists(key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		resp.Exists[k] = exists
+	}
+	writeJSON(w, resp)
+}
+
+// errPageFull stops an iteration once a page of keys has been collected.
+var errPageFull = errors.New("page full")
+
+// keys lists keys in [start, end) a page at a time.
+// Query parameters: start and end (hex, optional) and limit.
+func (h *handler) keys(w http.ResponseWriter, r *http.Request) {
+	it, ok := merkledb.AsIterator(h.storage)
+	if !ok {
+		http.Error(w, "storage does not support iteration", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	var start, end []byte
+	var err error
+	if s := query.Get("start"); s != "" {
+		if start, err = hex.DecodeString(s); err != nil {
+			http.Error(w, "invalid start key", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := query.Get("end"); s != "" {
+		if end, err = hex.DecodeString(s); err != nil {
+			http.Error(w, "invalid end key", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultListLimit
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := keyList{Keys: []string{}}
+	err = it.Iterate(start, end, func(key, value []byte) error {
+		if len(resp.Keys) == limit {
+			resp.Next = hex.EncodeToString(key)
+			return errPageFull
+		}
+		resp.Keys = append(resp.Keys, hex.EncodeToString(key))
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, resp)
+}
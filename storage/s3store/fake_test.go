@@ -0,0 +1,177 @@
+package s3store
+
+import (
+	"crypto/hmac"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeS3 is a minimal in-memory S3 server for a single bucket, addressed in
+// path style. It verifies Signature Version 4 on every request, so tests
+// exercise the real signing code.
+type fakeS3 struct {
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	// pageSize is the number of keys returned per ListObjectsV2 page.
+	pageSize int
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	failNext int // number of upcoming requests answered with 503
+	requests int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		bucket:    "test-bucket",
+		region:    "us-east-1",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		pageSize:  1000,
+		objects:   make(map[string][]byte),
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+// verify checks the request's signature and payload hash.
+func (f *fakeS3) verify(r *http.Request, body []byte) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, signingAlgorithm+" ") {
+		return "missing or malformed Authorization header", false
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, signingAlgorithm+" "), ", ") {
+		k, v, _ := strings.Cut(part, "=")
+		fields[k] = v
+	}
+
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != f.accessKey {
+		return "unknown access key", false
+	}
+	t, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil || credential[1] != t.Format("20060102") || credential[2] != f.region {
+		return "invalid credential scope", false
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != hashHex(body) {
+		return "payload hash does not match body", false
+	}
+
+	headers := strings.Split(fields["SignedHeaders"], ";")
+	expected := signature(f.secretKey, f.region, t, canonicalRequest(r, headers, payloadHash))
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return "signature does not match", false
+	}
+	return "", true
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.failNext > 0 {
+		f.failNext--
+		writeS3Error(w, http.StatusServiceUnavailable, "SlowDown", "please reduce your request rate")
+		return
+	}
+	if msg, ok := f.verify(r, body); !ok {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", msg)
+		return
+	}
+
+	bucket, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist")
+		return
+	}
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		f.objects[name] = body
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		value, ok := f.objects[name]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "the key does not exist")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(value)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// list implements ListObjectsV2. The continuation token is the last key of
+// the previous page.
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "only ListObjectsV2 is supported")
+		return
+	}
+	prefix := query.Get("prefix")
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		after = token
+	}
+
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, prefix) && name > after {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	type content struct {
+		Key string `xml:"Key"`
+	}
+	result := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		Contents              []content `xml:"Contents"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+	}{}
+	if len(names) > f.pageSize {
+		names = names[:f.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = names[len(names)-1]
+	}
+	for _, name := range names {
+		result.Contents = append(result.Contents, content{Key: name})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,349 @@
+// Package s3store implements a merkledb.Storage backend on top of any
+// service that speaks the Amazon S3 API, such as AWS S3, MinIO or Ceph.
+//
+// Each key is stored as one object named after its hex encoding, below a
+// configurable prefix. With a fan-out of n, the first n pairs of hex digits
+// become directory levels, as in Git's object store: with a fan-out of 1 the
+// key ab12cd… is stored as "<prefix>ab/12cd…". Because the directory levels
+// are carved out of the hex string rather than added to it, the lexicographic
+// order of object names matches the byte order of keys, and Iterate can be
+// served by ListObjectsV2 directly.
+//
+// Requests are signed with AWS Signature Version 4. No SDK is required.
+package s3store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AureClai/merkledb"
+)
+
+// Config describes the bucket used by a Store.
+type Config struct {
+	// Endpoint is the base URL of the service, for example
+	// "https://s3.eu-west-3.amazonaws.com" or "http://localhost:9000".
+	Endpoint string
+	// Region is the signing region, for example "eu-west-3".
+	Region string
+	// Bucket is the name of the bucket holding the objects.
+	Bucket string
+	// PathStyle addresses the bucket as the first path segment
+	// (endpoint/bucket/key) instead of as a subdomain (bucket.endpoint/key).
+	// Most S3-compatible servers require it.
+	PathStyle bool
+
+	// AccessKeyID, SecretAccessKey and the optional SessionToken are the
+	// credentials used to sign requests.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Prefix is prepended to every object name, for example "merkledb/".
+	Prefix string
+	// FanOut is the number of two-hex-digit directory levels used to
+	// spread objects over the key space. Zero stores all objects directly
+	// under Prefix.
+	FanOut int
+
+	// HTTPClient is used to send requests. Defaults to a client with a
+	// 60 second timeout.
+	HTTPClient *http.Client
+	// MaxRetries is the number of times a request failing with a 5xx
+	// status or a transport error is retried. Defaults to 3; a negative
+	// value disables retries.
+	MaxRetries int
+}
+
+// Error is an error response returned by the S3 service.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s (status %d)", e.Code, e.Message, e.StatusCode)
+}
+
+// Store is a merkledb.Storage backed by an S3 bucket.
+// It is safe for concurrent use.
+type Store struct {
+	base       *url.URL // URL of the bucket root, ending with '/'
+	creds      credentials
+	prefix     string
+	fanOut     int
+	httpClient *http.Client
+	maxRetries int
+	now        func() time.Time
+}
+
+// New returns a Store for the bucket described by cfg.
+func New(cfg Config) (*Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3store: bucket is required")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("s3store: region is required")
+	}
+	if cfg.FanOut < 0 {
+		return nil, errors.New("s3store: fan-out cannot be negative")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3store: invalid endpoint %q", cfg.Endpoint)
+	}
+
+	base := &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host, Path: strings.TrimRight(endpoint.Path, "/") + "/"}
+	if cfg.PathStyle {
+		base.Path += cfg.Bucket + "/"
+	} else {
+		base.Host = cfg.Bucket + "." + base.Host
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	maxRetries := cfg.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 3
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	return &Store{
+		base: base,
+		creds: credentials{
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			sessionToken:    cfg.SessionToken,
+			region:          cfg.Region,
+		},
+		prefix:     cfg.Prefix,
+		fanOut:     cfg.FanOut,
+		httpClient: httpClient,
+		maxRetries: maxRetries,
+		now:        time.Now,
+	}, nil
+}
+
+// objectName returns the name of the object holding key.
+func (s *Store) objectName(key []byte) string {
+	h := hex.EncodeToString(key)
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	for level := 0; level < s.fanOut && len(h) > 2; level++ {
+		b.WriteString(h[:2])
+		b.WriteByte('/')
+		h = h[2:]
+	}
+	b.WriteString(h)
+	return b.String()
+}
+
+// keyFromName is the inverse of objectName.
+func (s *Store) keyFromName(name string) ([]byte, error) {
+	if !strings.HasPrefix(name, s.prefix) {
+		return nil, fmt.Errorf("s3store: object %q is outside the prefix", name)
+	}
+	return hex.DecodeString(strings.ReplaceAll(name[len(s.prefix):], "/", ""))
+}
+
+// objectURL returns the URL of the named object, with the query attached.
+func (s *Store) objectURL(name string, query url.Values) *url.URL {
+	u := *s.base
+	u.Path += name
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do signs and sends a request, retrying transient failures. The response
+// body is fully read and returned.
+func (s *Store) do(method string, u *url.URL, body []byte) (*http.Response, []byte, error) {
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		payloadHash = hashHex(body)
+	}
+
+	backoff := 100 * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.URL = u
+		req.Host = u.Host
+		if body != nil {
+			req.ContentLength = int64(len(body))
+		}
+		s.creds.sign(req, payloadHash, s.now())
+
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("s3: %s %s failed: %w", method, u.Path, err)
+			continue
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("s3: failed to read response of %s %s: %w", method, u.Path, err)
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			lastErr = parseError(resp.StatusCode, data)
+			continue
+		}
+		return resp, data, nil
+	}
+	return nil, nil, lastErr
+}
+
+// parseError decodes an S3 error document.
+func parseError(status int, data []byte) error {
+	e := &Error{StatusCode: status}
+	xml.Unmarshal(data, e)
+	return e
+}
+
+// Put implements the merkledb.Storage interface.
+func (s *Store) Put(key []byte, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	resp, data, err := s.do(http.MethodPut, s.objectURL(s.objectName(key), nil), value)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return parseError(resp.StatusCode, data)
+	}
+	return nil
+}
+
+// Get implements the merkledb.Storage interface.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	resp, data, err := s.do(http.MethodGet, s.objectURL(s.objectName(key), nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusNotFound:
+		return nil, merkledb.ErrNotFound
+	default:
+		return nil, parseError(resp.StatusCode, data)
+	}
+}
+
+// Exists implements the merkledb.Storage interface.
+func (s *Store) Exists(key []byte) (bool, error) {
+	resp, data, err := s.do(http.MethodHead, s.objectURL(s.objectName(key), nil), nil)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, parseError(resp.StatusCode, data)
+	}
+}
+
+// Delete implements the merkledb.Deleter interface.
+func (s *Store) Delete(key []byte) error {
+	resp, data, err := s.do(http.MethodDelete, s.objectURL(s.objectName(key), nil), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return parseError(resp.StatusCode, data)
+	}
+	return nil
+}
+
+// listResult is the subset of a ListObjectsV2 response used by Iterate.
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Iterate implements the merkledb.Iterator interface using ListObjectsV2.
+// Values are fetched with one GET per key.
+func (s *Store) Iterate(start, end []byte, fn func(key, value []byte) error) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+	if len(start) > 0 {
+		// StartAfter is exclusive; listing from just before start's name
+		// and filtering below keeps start itself in the range.
+		name := s.objectName(start)
+		query.Set("start-after", name[:len(name)-1])
+	}
+
+	for {
+		resp, data, err := s.do(http.MethodGet, s.objectURL("", query), nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return parseError(resp.StatusCode, data)
+		}
+		var page listResult
+		if err := xml.Unmarshal(data, &page); err != nil {
+			return fmt.Errorf("s3: failed to decode object listing: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			key, err := s.keyFromName(obj.Key)
+			if err != nil {
+				// Not one of ours; other data may share the prefix.
+				continue
+			}
+			if start != nil && string(key) < string(start) {
+				continue
+			}
+			if end != nil && string(key) >= string(end) {
+				return nil
+			}
+			value, err := s.Get(key)
+			if errors.Is(err, merkledb.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(key, value); err != nil {
+				return err
+			}
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+		query.Del("start-after")
+	}
+}
@@ -0,0 +1,157 @@
+package s3store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+	service          = "s3"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+var emptyPayloadHash = hashHex(nil)
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode percent-encodes s as required by Signature Version 4: every byte
+// except the unreserved characters A-Z, a-z, 0-9, '-', '.', '_' and '~' is
+// encoded, and '/' is kept as is when encodeSlash is false.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery returns the query parameters sorted and encoded as required
+// by Signature Version 4. The result is also used as the request's raw query
+// so that what is signed is exactly what is sent.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// signedHeaderNames returns the lowercase names of the headers of req that
+// are covered by the signature, in sorted order: the host, the content type
+// and range if present, and every x-amz-* header.
+func signedHeaderNames(req *http.Request) []string {
+	names := []string{"host"}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// canonicalRequest builds the canonical form of req over the given headers.
+func canonicalRequest(req *http.Request, headers []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte('\n')
+	b.WriteString(req.URL.EscapedPath())
+	b.WriteByte('\n')
+	b.WriteString(canonicalQuery(req.URL.Query()))
+	b.WriteByte('\n')
+	for _, name := range headers {
+		value := req.Host
+		if name != "host" {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.TrimSpace(value))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.WriteString(strings.Join(headers, ";"))
+	b.WriteByte('\n')
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+// signature computes the Signature Version 4 signature of a canonical request.
+func signature(secretKey, region string, t time.Time, canonical string) string {
+	amzDate := t.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := signingAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// credentials identify the signer of requests.
+type credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+}
+
+// sign adds the date, payload hash, session token and Authorization headers
+// to req. req.Host must be set.
+func (c credentials) sign(req *http.Request, payloadHash string, t time.Time) {
+	req.Header.Set("X-Amz-Date", t.UTC().Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := signedHeaderNames(req)
+	sig := signature(c.secretAccessKey, c.region, t, canonicalRequest(req, headers, payloadHash))
+	scope := t.UTC().Format(amzDateFormat)[:8] + "/" + c.region + "/" + service + "/aws4_request"
+	req.Header.Set("Authorization", signingAlgorithm+
+		" Credential="+c.accessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(headers, ";")+
+		", Signature="+sig)
+}
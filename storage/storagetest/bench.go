@@ -0,0 +1,120 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/AureClai/merkledb"
+)
+
+// benchValueSize is the size of the values written by the benchmarks, close
+// to that of a typical serialized record.
+const benchValueSize = 1024
+
+// RunBenchmarks runs a standard set of benchmarks against storages created by
+// factory, so that backends can be compared on equal terms.
+func RunBenchmarks(b *testing.B, factory Factory) {
+	value := make([]byte, benchValueSize)
+	for i := range value {
+		value[i] = byte(i)
+	}
+
+	b.Run("Put", func(b *testing.B) {
+		s := open(b, factory)
+		b.SetBytes(benchValueSize)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.Put(hashKey(i), value); err != nil {
+				b.Fatalf("Put() failed: %v", err)
+			}
+		}
+	})
+
+	b.Run("PutExisting", func(b *testing.B) {
+		s := open(b, factory)
+		if err := s.Put(hashKey(0), value); err != nil {
+			b.Fatalf("Put() failed: %v", err)
+		}
+		b.SetBytes(benchValueSize)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.Put(hashKey(0), value); err != nil {
+				b.Fatalf("Put() failed: %v", err)
+			}
+		}
+	})
+
+	b.Run("Get", func(b *testing.B) {
+		s := open(b, factory)
+		const n = 1000
+		populate(b, s, n, value)
+		b.SetBytes(benchValueSize)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Get(hashKey(i % n)); err != nil {
+				b.Fatalf("Get() failed: %v", err)
+			}
+		}
+	})
+
+	b.Run("GetParallel", func(b *testing.B) {
+		s := open(b, factory)
+		const n = 1000
+		populate(b, s, n, value)
+		b.SetBytes(benchValueSize)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				if _, err := s.Get(hashKey(i % n)); err != nil {
+					b.Errorf("Get() failed: %v", err)
+					return
+				}
+				i++
+			}
+		})
+	})
+
+	b.Run("Exists", func(b *testing.B) {
+		s := open(b, factory)
+		const n = 1000
+		populate(b, s, n, value)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// Half of the lookups miss.
+			if _, err := s.Exists(hashKey(i % (2 * n))); err != nil {
+				b.Fatalf("Exists() failed: %v", err)
+			}
+		}
+	})
+
+	b.Run("Iterate", func(b *testing.B) {
+		s := open(b, factory)
+		it, ok := merkledb.AsIterator(s)
+		if !ok {
+			b.Skip("storage does not implement merkledb.Iterator")
+		}
+		const n = 1000
+		populate(b, s, n, value)
+		b.SetBytes(n * benchValueSize)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			count := 0
+			err := it.Iterate(nil, nil, func(key, value []byte) error {
+				count++
+				return nil
+			})
+			if err != nil || count != n {
+				b.Fatalf("Iterate() visited %d keys, err=%v", count, err)
+			}
+		}
+	})
+}
+
+func populate(b *testing.B, s merkledb.Storage, n int, value []byte) {
+	b.Helper()
+	for i := range n {
+		if err := s.Put(hashKey(i), value); err != nil {
+			b.Fatalf("Put() failed: %v", err)
+		}
+	}
+}
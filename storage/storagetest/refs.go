@@ -0,0 +1,394 @@
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AureClai/merkledb"
+)
+
+// RefFactory creates a new, empty RefStore for a single test. As with
+// Factory, an io.Closer is closed when the test finishes.
+type RefFactory func(tb testing.TB) merkledb.RefStore
+
+func openRefs(tb testing.TB, factory RefFactory) merkledb.RefStore {
+	tb.Helper()
+	r := factory(tb)
+	if r == nil {
+		tb.Fatal("factory returned a nil RefStore")
+	}
+	if c, ok := r.(io.Closer); ok {
+		tb.Cleanup(func() {
+			if err := c.Close(); err != nil {
+				tb.Errorf("Close() failed: %v", err)
+			}
+		})
+	}
+	return r
+}
+
+// RunRefConformance runs the conformance suite for the contract documented
+// on merkledb.RefStore against ref stores created by factory. If they also
+// implement merkledb.ReflogStore, that contract is checked too.
+func RunRefConformance(t *testing.T, factory RefFactory) {
+	t.Run("WriteRead", func(t *testing.T) { testRefWriteRead(t, factory) })
+	t.Run("ReadMissing", func(t *testing.T) { testRefReadMissing(t, factory) })
+	t.Run("CompareAndSwap", func(t *testing.T) { testRefCompareAndSwap(t, factory) })
+	t.Run("Delete", func(t *testing.T) { testRefDelete(t, factory) })
+	t.Run("List", func(t *testing.T) { testRefList(t, factory) })
+	t.Run("InvalidNames", func(t *testing.T) { testRefInvalidNames(t, factory) })
+	t.Run("NameClash", func(t *testing.T) { testRefNameClash(t, factory) })
+	t.Run("ConcurrentSwaps", func(t *testing.T) { testRefConcurrentSwaps(t, factory) })
+	t.Run("Reflog", func(t *testing.T) { testReflog(t, factory) })
+	t.Run("ReflogRewrite", func(t *testing.T) { testReflogRewrite(t, factory) })
+	t.Run("ConcurrentReflogAppends", func(t *testing.T) { testReflogConcurrentAppends(t, factory) })
+	t.Run("ReflogExpire", func(t *testing.T) { testReflogExpire(t, factory) })
+	t.Run("ConcurrentReflogExpire", func(t *testing.T) { testReflogConcurrentExpire(t, factory) })
+}
+
+func refHash(i int) string {
+	return fmt.Sprintf("%x", hashKey(i))
+}
+
+func testRefWriteRead(t *testing.T, factory RefFactory) {
+	r := openRefs(t, factory)
+	for _, name := range []string{"HEAD", "refs/heads/main", "refs/tags/v2024-10", "refs/heads/feature/x"} {
+		if err := r.WriteRef(name, refHash(1)); err != nil {
+			t.Fatalf("WriteRef(%s) failed: %v", name, err)
+		}
+		if got, err := r.ReadRef(name); err != nil || got != refHash(1) {
+			t.Errorf("ReadRef(%s) = %q, %v; want %q", name, got, err, refHash(1))
+		}
+	}
+	if err := r.WriteRef("refs/heads/main", refHash(2)); err != nil {
+		t.Fatalf("WriteRef() over an existing ref failed: %v", err)
+	}
+	if got, _ := r.ReadRef("refs/heads/main"); got != refHash(2) {
+		t.Errorf("ReadRef() after overwrite = %q, want %q", got, refHash(2))
+	}
+}
+
+func testRefReadMissing(t *testing.T, factory RefFactory) {
+	r := openRefs(t, factory)
+	r.WriteRef("refs/heads/main", refHash(1))
+	for _, name := range []string{"refs/heads/other", "refs/heads", "HEAD"} {
+		if _, err := r.ReadRef(name); !errors.Is(err, merkledb.ErrNotFound) {
+			t.Errorf("ReadRef(%s) returned %v, want an error matching ErrNotFound", name, err)
+		}
+	}
+}
+
+func testRefCompareAndSwap(t *testing.T, factory RefFactory) {
+	r := openRefs(t, factory)
+	const name = "refs/heads/main"
+	if err := r.CompareAndSwapRef(name, "", refHash(1)); err != nil {
+		t.Fatalf("CompareAndSwapRef() creating a ref failed: %v", err)
+	}
+	if err := r.CompareAndSwapRef(name, "", refHash(2)); !errors.Is(err, merkledb.ErrRefConflict) {
+		t.Errorf("CompareAndSwapRef() creating an existing ref returned %v, want ErrRefConflict", err)
+	}
+	if err := r.CompareAndSwapRef(name, refHash(3), refHash(2)); !errors.Is(err, merkledb.ErrRefConflict) {
+		t.Errorf("CompareAndSwapRef() from a stale hash returned %v, want ErrRefConflict", err)
+	}
+	if got, _ := r.ReadRef(name); got != refHash(1) {
+		t.Errorf("failed swaps changed the ref to %q", got)
+	}
+	if err := r.CompareAndSwapRef(name, refHash(1), refHash(2)); err != nil {
+		t.Fatalf("CompareAndSwapRef() failed: %v", err)
+	}
+	if err := r.CompareAndSwapRef(name, refHash(2), ""); err != nil {
+		t.Fatalf("CompareAndSwapRef() deleting the ref failed: %v", err)
+	}
+	if _, err := r.ReadRef(name); !errors.Is(err, merkledb.ErrNotFound) {
+		t.Errorf("ReadRef() after a deleting swap returned %v, want ErrNotFound", err)
+	}
+}
+
+func testRefDelete(t *testing.T, factory RefFactory) {
+	r := openRefs(t, factory)
+	r.WriteRef("refs/heads/a/b", refHash(1))
+	if err := r.DeleteRef("refs/heads/a/b"); err != nil {
+		t.Fatalf("DeleteRef() failed: %v", err)
+	}
+	if err := r.DeleteRef("refs/heads/a/b"); err != nil {
+		t.Errorf("DeleteRef() of a missing ref returned %v", err)
+	}
+	// The deleted ref does not keep its name from being used as a prefix.
+	if err := r.WriteRef("refs/heads/a", refHash(2)); err != nil {
+		t.Errorf("WriteRef() over a deleted ref's directory failed: %v", err)
+	}
+}
+
+func testRefList(t *testing.T, factory RefFactory) {
+	r := openRefs(t, factory)
+	names := []string{"refs/tags/v2", "refs/heads/main", "HEAD", "refs/tags/v1", "refs/heads/dev/x"}
+	for i, name := range names {
+		r.WriteRef(name, refHash(i))
+	}
+	all, err := r.ListRefs("")
+	if err != nil {
+		t.Fatalf("ListRefs() failed: %v", err)
+	}
+	want := []string{"HEAD", "refs/heads/dev/x", "refs/heads/main", "refs/tags/v1", "refs/tags/v2"}
+	if len(all) != len(want) {
+		t.Fatalf("ListRefs() returned %d refs, want %d", len(all), len(want))
+	}
+	for i, ref := range all {
+		if ref.Name != want[i] {
+			t.Errorf("ListRefs()[%d] = %s, want %s", i, ref.Name, want[i])
+		}
+	}
+	tags, _ := r.ListRefs("refs/tags/")
+	if len(tags) != 2 || tags[0].Name != "refs/tags/v1" || tags[0].Hash != refHash(3) {
+		t.Errorf("ListRefs(refs/tags/) = %v", tags)
+	}
+}
+
+func testRefInvalidNames(t *testing.T, factory RefFactory) {
+	r := openRefs(t, factory)
+	for _, name := range []string{"", "main", "refs/heads/../x", "refs/heads/a b", "refs//x", "refs/heads/x.lock", "refs/heads/.hidden", "refs/heads/x~1", "refs/heads/a@{1}"} {
+		if err := r.WriteRef(name, refHash(1)); err == nil {
+			t.Errorf("WriteRef(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func testRefNameClash(t *testing.T, factory RefFactory) {
+	r := openRefs(t, factory)
+	r.WriteRef("refs/heads/a", refHash(1))
+	if err := r.WriteRef("refs/heads/a/b", refHash(2)); !errors.Is(err, merkledb.ErrRefConflict) {
+		t.Errorf("WriteRef() below an existing ref returned %v, want ErrRefConflict", err)
+	}
+	r.WriteRef("refs/heads/c/d", refHash(1))
+	if err := r.WriteRef("refs/heads/c", refHash(2)); !errors.Is(err, merkledb.ErrRefConflict) {
+		t.Errorf("WriteRef() above an existing ref returned %v, want ErrRefConflict", err)
+	}
+}
+
+func testRefConcurrentSwaps(t *testing.T, factory RefFactory) {
+	r := openRefs(t, factory)
+	const name, writers = "refs/heads/main", 16
+	var wg sync.WaitGroup
+	wins := make(chan int, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r.CompareAndSwapRef(name, "", refHash(i)) == nil {
+				wins <- i
+			}
+		}()
+	}
+	wg.Wait()
+	close(wins)
+	if len(wins) != 1 {
+		t.Fatalf("%d concurrent swaps from the same hash succeeded, want 1", len(wins))
+	}
+	if got, _ := r.ReadRef(name); got != refHash(<-wins) {
+		t.Errorf("ref holds %q, not the winner's hash", got)
+	}
+}
+
+func openReflogs(t *testing.T, factory RefFactory) merkledb.ReflogStore {
+	t.Helper()
+	logs, ok := openRefs(t, factory).(merkledb.ReflogStore)
+	if !ok {
+		t.Skip("ref store keeps no reflogs")
+	}
+	return logs
+}
+
+func reflogEntry(i int) merkledb.ReflogEntry {
+	return merkledb.ReflogEntry{
+		Old:    refHash(i - 1),
+		New:    refHash(i),
+		Actor:  "tester",
+		Time:   time.Date(2024, 3, 1, 12, i, 0, 0, time.UTC),
+		Reason: fmt.Sprintf("update %d", i),
+	}
+}
+
+func testReflog(t *testing.T, factory RefFactory) {
+	logs := openReflogs(t, factory)
+	if entries, err := logs.ReadReflog("refs/heads/main"); err != nil || len(entries) != 0 {
+		t.Fatalf("ReadReflog() of a ref without a reflog = %v, %v; want none", entries, err)
+	}
+	// Reflogs outlive their refs, so names that could not coexist as refs
+	// must be able to have reflogs at the same time.
+	names := []string{"HEAD", "refs/heads/a", "refs/heads/a/b"}
+	for i := 1; i <= 3; i++ {
+		for _, name := range names {
+			if err := logs.AppendReflog(name, reflogEntry(i)); err != nil {
+				t.Fatalf("AppendReflog(%s) failed: %v", name, err)
+			}
+		}
+	}
+	for _, name := range names {
+		entries, err := logs.ReadReflog(name)
+		if err != nil {
+			t.Fatalf("ReadReflog(%s) failed: %v", name, err)
+		}
+		if len(entries) != 3 {
+			t.Fatalf("ReadReflog(%s) returned %d entries, want 3", name, len(entries))
+		}
+		for i, e := range entries {
+			if want := reflogEntry(i + 1); e.Old != want.Old || e.New != want.New || e.Actor != want.Actor || e.Reason != want.Reason || !e.Time.Equal(want.Time) {
+				t.Errorf("ReadReflog(%s)[%d] = %+v, want %+v", name, i, e, want)
+			}
+		}
+	}
+	got, err := logs.ListReflogs()
+	if err != nil {
+		t.Fatalf("ListReflogs() failed: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(names) {
+		t.Errorf("ListReflogs() = %v, want %v", got, names)
+	}
+	if err := logs.AppendReflog("refs/heads/bad..name", reflogEntry(1)); err == nil {
+		t.Error("AppendReflog() accepted an invalid ref name")
+	}
+}
+
+func testReflogRewrite(t *testing.T, factory RefFactory) {
+	logs := openReflogs(t, factory)
+	const name = "refs/heads/main"
+	for i := 1; i <= 4; i++ {
+		if err := logs.AppendReflog(name, reflogEntry(i)); err != nil {
+			t.Fatalf("AppendReflog() failed: %v", err)
+		}
+	}
+	if err := logs.WriteReflog(name, []merkledb.ReflogEntry{reflogEntry(3), reflogEntry(4)}); err != nil {
+		t.Fatalf("WriteReflog() failed: %v", err)
+	}
+	if entries, err := logs.ReadReflog(name); err != nil || len(entries) != 2 || entries[0].New != refHash(3) {
+		t.Fatalf("ReadReflog() after WriteReflog() = %v, %v; want entries 3 and 4", entries, err)
+	}
+	if err := logs.AppendReflog(name, reflogEntry(5)); err != nil {
+		t.Fatalf("AppendReflog() after WriteReflog() failed: %v", err)
+	}
+	if entries, _ := logs.ReadReflog(name); len(entries) != 3 || entries[2].New != refHash(5) {
+		t.Errorf("ReadReflog() = %v, want entries 3 to 5", entries)
+	}
+
+	if err := logs.WriteReflog(name, nil); err != nil {
+		t.Fatalf("WriteReflog(nil) failed: %v", err)
+	}
+	if entries, err := logs.ReadReflog(name); err != nil || len(entries) != 0 {
+		t.Errorf("ReadReflog() of a deleted reflog = %v, %v; want none", entries, err)
+	}
+	if names, err := logs.ListReflogs(); err != nil || len(names) != 0 {
+		t.Errorf("ListReflogs() after deleting the only reflog = %v, %v; want none", names, err)
+	}
+}
+
+func testReflogConcurrentAppends(t *testing.T, factory RefFactory) {
+	logs := openReflogs(t, factory)
+	const name, writers = "refs/heads/main", 16
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := logs.AppendReflog(name, reflogEntry(i+1)); err != nil {
+				t.Errorf("AppendReflog() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	entries, err := logs.ReadReflog(name)
+	if err != nil {
+		t.Fatalf("ReadReflog() failed: %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		seen[e.New] = true
+	}
+	if len(entries) != writers || len(seen) != writers {
+		t.Errorf("ReadReflog() returned %d entries, %d distinct, want %d", len(entries), len(seen), writers)
+	}
+}
+
+func testReflogExpire(t *testing.T, factory RefFactory) {
+	logs := openReflogs(t, factory)
+	const name = "refs/heads/main"
+	for i := 1; i <= 4; i++ {
+		if err := logs.AppendReflog(name, reflogEntry(i)); err != nil {
+			t.Fatalf("AppendReflog() failed: %v", err)
+		}
+	}
+	var seen int
+	err := logs.ExpireReflog(name, func(entries []merkledb.ReflogEntry) []merkledb.ReflogEntry {
+		seen = len(entries)
+		return entries[2:]
+	})
+	if err != nil {
+		t.Fatalf("ExpireReflog() failed: %v", err)
+	}
+	if seen != 4 {
+		t.Errorf("ExpireReflog() passed %d entries to keep, want 4", seen)
+	}
+	if entries, err := logs.ReadReflog(name); err != nil || len(entries) != 2 || entries[0].New != refHash(3) {
+		t.Fatalf("ReadReflog() after ExpireReflog() = %v, %v; want entries 3 and 4", entries, err)
+	}
+
+	err = logs.ExpireReflog(name, func([]merkledb.ReflogEntry) []merkledb.ReflogEntry { return nil })
+	if err != nil {
+		t.Fatalf("ExpireReflog() of every entry failed: %v", err)
+	}
+	if names, err := logs.ListReflogs(); err != nil || len(names) != 0 {
+		t.Errorf("ListReflogs() after expiring every entry = %v, %v; want none", names, err)
+	}
+	if err := logs.ExpireReflog("refs/heads/missing", func(e []merkledb.ReflogEntry) []merkledb.ReflogEntry { return e }); err != nil {
+		t.Errorf("ExpireReflog() of a missing reflog failed: %v", err)
+	}
+}
+
+// Appends racing with expiry are never lost: expiry reads and rewrites the
+// reflog as one update.
+func testReflogConcurrentExpire(t *testing.T, factory RefFactory) {
+	logs := openReflogs(t, factory)
+	const name, writers = "refs/heads/main", 16
+	old := merkledb.ReflogEntry{New: refHash(0), Reason: "expired"}
+	if err := logs.AppendReflog(name, old); err != nil {
+		t.Fatalf("AppendReflog() failed: %v", err)
+	}
+	dropOld := func(entries []merkledb.ReflogEntry) []merkledb.ReflogEntry {
+		var live []merkledb.ReflogEntry
+		for _, e := range entries {
+			if e.Reason != old.Reason {
+				live = append(live, e)
+			}
+		}
+		return live
+	}
+
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := logs.AppendReflog(name, reflogEntry(i+1)); err != nil {
+				t.Errorf("AppendReflog() failed: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := logs.ExpireReflog(name, dropOld); err != nil {
+				t.Errorf("ExpireReflog() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	logs.ExpireReflog(name, dropOld)
+	entries, err := logs.ReadReflog(name)
+	if err != nil {
+		t.Fatalf("ReadReflog() failed: %v", err)
+	}
+	if len(entries) != writers {
+		t.Errorf("ReadReflog() returned %d entries, want the %d appended ones", len(entries), writers)
+	}
+}
@@ -0,0 +1,520 @@
+// Package storagetest provides a conformance suite and benchmarks for
+// implementations of merkledb.Storage.
+//
+// Backend authors call RunConformance from a test in their own package:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.RunConformance(t, func(tb testing.TB) merkledb.Storage {
+//			s, err := mybackend.Open(tb.TempDir())
+//			if err != nil {
+//				tb.Fatal(err)
+//			}
+//			return s
+//		})
+//	}
+//
+// The suite checks the contract documented on merkledb.Storage and, when the
+// backend implements them, the merkledb.Deleter, merkledb.Iterator and
+// merkledb.StreamingStorage extensions. RunRefConformance does the same for
+// implementations of merkledb.RefStore.
+package storagetest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/AureClai/merkledb"
+)
+
+// Factory creates a new, empty Storage for a single test or benchmark.
+// If the returned value implements io.Closer, the suite closes it when the
+// test finishes; any other cleanup should be registered with tb.Cleanup.
+type Factory func(tb testing.TB) merkledb.Storage
+
+// open creates a storage through the factory and schedules it to be closed.
+func open(tb testing.TB, factory Factory) merkledb.Storage {
+	tb.Helper()
+	s := factory(tb)
+	if s == nil {
+		tb.Fatal("factory returned a nil Storage")
+	}
+	if c, ok := s.(io.Closer); ok {
+		tb.Cleanup(func() {
+			if err := c.Close(); err != nil {
+				tb.Errorf("Close() failed: %v", err)
+			}
+		})
+	}
+	return s
+}
+
+// hashKey returns a 32-byte key shaped like the keys used by ObjectStore.
+func hashKey(i int) []byte {
+	sum := sha256.Sum256([]byte(fmt.Sprint(i)))
+	return sum[:]
+}
+
+func mustPut(t *testing.T, s merkledb.Storage, key, value []byte) {
+	t.Helper()
+	if err := s.Put(key, value); err != nil {
+		t.Fatalf("Put(%x) failed: %v", key, err)
+	}
+}
+
+func mustGet(t *testing.T, s merkledb.Storage, key []byte) []byte {
+	t.Helper()
+	value, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%x) failed: %v", key, err)
+	}
+	return value
+}
+
+// RunConformance runs the full conformance suite against storages created
+// by factory. Each subtest gets a fresh storage.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("PutGet", func(t *testing.T) { testPutGet(t, factory) })
+	t.Run("GetMissing", func(t *testing.T) { testGetMissing(t, factory) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, factory) })
+	t.Run("EmptyValue", func(t *testing.T) { testEmptyValue(t, factory) })
+	t.Run("BinaryKeys", func(t *testing.T) { testBinaryKeys(t, factory) })
+	t.Run("LargeValue", func(t *testing.T) { testLargeValue(t, factory) })
+	t.Run("PutDoesNotRetainValue", func(t *testing.T) { testPutDoesNotRetainValue(t, factory) })
+	t.Run("GetReturnsCopy", func(t *testing.T) { testGetReturnsCopy(t, factory) })
+	t.Run("ManyKeys", func(t *testing.T) { testManyKeys(t, factory) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, factory) })
+
+	t.Run("Deleter", func(t *testing.T) {
+		if !merkledb.Supports(open(t, factory), merkledb.ExtDeleter) {
+			t.Skip("storage does not implement merkledb.Deleter")
+		}
+		t.Run("Delete", func(t *testing.T) { testDelete(t, factory) })
+		t.Run("DeleteMissing", func(t *testing.T) { testDeleteMissing(t, factory) })
+		t.Run("PutAfterDelete", func(t *testing.T) { testPutAfterDelete(t, factory) })
+	})
+
+	t.Run("Iterator", func(t *testing.T) {
+		if !merkledb.Supports(open(t, factory), merkledb.ExtIterator) {
+			t.Skip("storage does not implement merkledb.Iterator")
+		}
+		t.Run("Ordered", func(t *testing.T) { testIterateOrdered(t, factory) })
+		t.Run("Range", func(t *testing.T) { testIterateRange(t, factory) })
+		t.Run("Empty", func(t *testing.T) { testIterateEmpty(t, factory) })
+		t.Run("StopEarly", func(t *testing.T) { testIterateStopEarly(t, factory) })
+		t.Run("KeysNotAliased", func(t *testing.T) { testIterateKeysNotAliased(t, factory) })
+	})
+
+	t.Run("StreamingStorage", func(t *testing.T) {
+		if !merkledb.Supports(open(t, factory), merkledb.ExtStreaming) {
+			t.Skip("storage does not implement merkledb.StreamingStorage")
+		}
+		t.Run("CommitOpen", func(t *testing.T) { testStreamCommitOpen(t, factory) })
+		t.Run("Abort", func(t *testing.T) { testStreamAbort(t, factory) })
+		t.Run("OpenMissing", func(t *testing.T) { testStreamOpenMissing(t, factory) })
+	})
+}
+
+func testPutGet(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	key, value := hashKey(1), []byte("hello world")
+	mustPut(t, s, key, value)
+
+	if got := mustGet(t, s, key); !bytes.Equal(got, value) {
+		t.Errorf("Get() returned %q, want %q", got, value)
+	}
+	exists, err := s.Exists(key)
+	if err != nil || !exists {
+		t.Errorf("Exists() = %v, %v; want true, nil", exists, err)
+	}
+}
+
+func testGetMissing(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	mustPut(t, s, hashKey(1), []byte("present"))
+
+	if _, err := s.Get(hashKey(2)); !errors.Is(err, merkledb.ErrNotFound) {
+		t.Errorf("Get() on a missing key returned %v, want an error matching ErrNotFound", err)
+	}
+	exists, err := s.Exists(hashKey(2))
+	if err != nil || exists {
+		t.Errorf("Exists() on a missing key = %v, %v; want false, nil", exists, err)
+	}
+}
+
+func testOverwrite(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	key := hashKey(1)
+	mustPut(t, s, key, []byte("first"))
+	mustPut(t, s, key, []byte("second, and longer"))
+
+	if got := mustGet(t, s, key); string(got) != "second, and longer" {
+		t.Errorf("Get() after overwrite returned %q", got)
+	}
+	mustPut(t, s, key, []byte("3rd"))
+	if got := mustGet(t, s, key); string(got) != "3rd" {
+		t.Errorf("Get() after shrinking overwrite returned %q", got)
+	}
+}
+
+func testEmptyValue(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	for i, value := range [][]byte{{}, nil} {
+		key := hashKey(i)
+		mustPut(t, s, key, value)
+
+		got, err := s.Get(key)
+		if err != nil {
+			t.Fatalf("Get() of an empty value failed: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("Get() of an empty value returned %q", got)
+		}
+		exists, err := s.Exists(key)
+		if err != nil || !exists {
+			t.Errorf("Exists() for an empty value = %v, %v; want true, nil", exists, err)
+		}
+	}
+}
+
+func testBinaryKeys(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	keys := [][]byte{
+		{0x00},
+		{0x00, 0x00},
+		{0xff},
+		{0xff, 0x00, 0xff},
+		[]byte("a"),
+		[]byte("ab"),
+		[]byte("a\x00"),
+		bytes.Repeat([]byte{0xab}, 256),
+	}
+	for i, key := range keys {
+		mustPut(t, s, key, []byte(fmt.Sprint(i)))
+	}
+	for i, key := range keys {
+		if got := mustGet(t, s, key); string(got) != fmt.Sprint(i) {
+			t.Errorf("Get(%x) returned %q, want %q", key, got, fmt.Sprint(i))
+		}
+	}
+}
+
+func testLargeValue(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	value := make([]byte, 4<<20)
+	for i := range value {
+		value[i] = byte(i * 31)
+	}
+	mustPut(t, s, hashKey(1), value)
+
+	if got := mustGet(t, s, hashKey(1)); !bytes.Equal(got, value) {
+		t.Errorf("Get() of a %d byte value returned %d different bytes", len(value), len(got))
+	}
+}
+
+func testPutDoesNotRetainValue(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	value := []byte("original")
+	mustPut(t, s, hashKey(1), value)
+	copy(value, "MODIFIED")
+
+	if got := mustGet(t, s, hashKey(1)); string(got) != "original" {
+		t.Errorf("modifying the slice passed to Put changed the stored value to %q", got)
+	}
+}
+
+func testGetReturnsCopy(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	mustPut(t, s, hashKey(1), []byte("original"))
+	copy(mustGet(t, s, hashKey(1)), "MODIFIED")
+
+	if got := mustGet(t, s, hashKey(1)); string(got) != "original" {
+		t.Errorf("modifying the slice returned by Get changed the stored value to %q", got)
+	}
+}
+
+func testManyKeys(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	const n = 2000
+	for i := range n {
+		mustPut(t, s, hashKey(i), []byte(fmt.Sprint(i)))
+	}
+	for i := range n {
+		if got := mustGet(t, s, hashKey(i)); string(got) != fmt.Sprint(i) {
+			t.Fatalf("Get(%x) returned %q, want %q", hashKey(i), got, fmt.Sprint(i))
+		}
+	}
+}
+
+func testConcurrent(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	const workers, perWorker = 8, 100
+	shared := hashKey(-1)
+	mustPut(t, s, shared, []byte("shared"))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWorker {
+				key := hashKey(w*perWorker + i)
+				value := []byte(fmt.Sprintf("%d/%d", w, i))
+				if err := s.Put(key, value); err != nil {
+					errs <- fmt.Errorf("Put() failed: %w", err)
+					return
+				}
+				got, err := s.Get(key)
+				if err != nil || !bytes.Equal(got, value) {
+					errs <- fmt.Errorf("Get() returned %q, %v; want %q", got, err, value)
+					return
+				}
+				// Concurrent writers of the same content-addressed object
+				// are common; they must not interfere with each other.
+				if err := s.Put(shared, []byte("shared")); err != nil {
+					errs <- fmt.Errorf("Put() of shared key failed: %w", err)
+					return
+				}
+				if _, err := s.Exists(shared); err != nil {
+					errs <- fmt.Errorf("Exists() failed: %w", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for i := range workers * perWorker {
+		if exists, err := s.Exists(hashKey(i)); err != nil || !exists {
+			t.Fatalf("Exists(%x) after concurrent writes = %v, %v", hashKey(i), exists, err)
+		}
+	}
+}
+
+func testDelete(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	d := s.(merkledb.Deleter)
+	mustPut(t, s, hashKey(1), []byte("doomed"))
+	mustPut(t, s, hashKey(2), []byte("survivor"))
+
+	if err := d.Delete(hashKey(1)); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err := s.Get(hashKey(1)); !errors.Is(err, merkledb.ErrNotFound) {
+		t.Errorf("Get() after Delete() returned %v, want ErrNotFound", err)
+	}
+	if exists, _ := s.Exists(hashKey(1)); exists {
+		t.Error("Exists() reports a deleted key")
+	}
+	if got := mustGet(t, s, hashKey(2)); string(got) != "survivor" {
+		t.Errorf("Delete() affected another key: got %q", got)
+	}
+}
+
+func testDeleteMissing(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	if err := s.(merkledb.Deleter).Delete(hashKey(1)); err != nil {
+		t.Errorf("Delete() of a missing key returned %v, want nil", err)
+	}
+}
+
+func testPutAfterDelete(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	mustPut(t, s, hashKey(1), []byte("first"))
+	if err := s.(merkledb.Deleter).Delete(hashKey(1)); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	mustPut(t, s, hashKey(1), []byte("second"))
+	if got := mustGet(t, s, hashKey(1)); string(got) != "second" {
+		t.Errorf("Get() after re-Put returned %q", got)
+	}
+}
+
+// collect returns the keys and values visited by Iterate over [start, end).
+func collect(t *testing.T, s merkledb.Storage, start, end []byte) (keys, values []string) {
+	t.Helper()
+	err := s.(merkledb.Iterator).Iterate(start, end, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		values = append(values, string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate() failed: %v", err)
+	}
+	return keys, values
+}
+
+// iterationKeys are stored in scrambled order by the iterator tests.
+var iterationKeys = []string{"b", "a\x00", "c", "ab", "a", "\xff", "\x00", "bb"}
+
+// sortedIterationKeys is iterationKeys in ascending byte order.
+var sortedIterationKeys = []string{"\x00", "a", "a\x00", "ab", "b", "bb", "c", "\xff"}
+
+func putIterationKeys(t *testing.T, s merkledb.Storage) {
+	for _, k := range iterationKeys {
+		mustPut(t, s, []byte(k), []byte("value of "+k))
+	}
+}
+
+func testIterateOrdered(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	putIterationKeys(t, s)
+
+	keys, values := collect(t, s, nil, nil)
+	if fmt.Sprintf("%q", keys) != fmt.Sprintf("%q", sortedIterationKeys) {
+		t.Errorf("Iterate() visited %q, want %q", keys, sortedIterationKeys)
+	}
+	for i, k := range keys {
+		if values[i] != "value of "+k {
+			t.Errorf("Iterate() passed value %q for key %q", values[i], k)
+		}
+	}
+}
+
+func testIterateRange(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	putIterationKeys(t, s)
+
+	tests := []struct {
+		start, end []byte
+		want       []string
+	}{
+		{[]byte("a"), []byte("b"), []string{"a", "a\x00", "ab"}},
+		{[]byte("a\x00"), []byte("bb"), []string{"a\x00", "ab", "b"}},
+		{nil, []byte("a"), []string{"\x00"}},
+		{[]byte("bb"), nil, []string{"bb", "c", "\xff"}},
+		{[]byte("aa"), []byte("ab"), nil},
+		{[]byte("d"), []byte("e"), nil},
+	}
+	for _, tt := range tests {
+		keys, _ := collect(t, s, tt.start, tt.end)
+		if fmt.Sprintf("%q", keys) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("Iterate(%q, %q) visited %q, want %q", tt.start, tt.end, keys, tt.want)
+		}
+	}
+}
+
+func testIterateEmpty(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	if keys, _ := collect(t, s, nil, nil); len(keys) != 0 {
+		t.Errorf("Iterate() on an empty storage visited %q", keys)
+	}
+}
+
+func testIterateStopEarly(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	putIterationKeys(t, s)
+
+	stop := errors.New("stop")
+	visited := 0
+	err := s.(merkledb.Iterator).Iterate(nil, nil, func(key, value []byte) error {
+		visited++
+		if visited == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Iterate() returned %v, want the callback's error", err)
+	}
+	if visited != 3 {
+		t.Errorf("Iterate() kept going after the callback failed: %d calls", visited)
+	}
+}
+
+func testIterateKeysNotAliased(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	putIterationKeys(t, s)
+
+	err := s.(merkledb.Iterator).Iterate(nil, nil, func(key, value []byte) error {
+		for i := range key {
+			key[i] = 'X'
+		}
+		for i := range value {
+			value[i] = 'X'
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate() failed: %v", err)
+	}
+	for _, k := range iterationKeys {
+		if got := mustGet(t, s, []byte(k)); string(got) != "value of "+k {
+			t.Errorf("modifying slices passed to Iterate changed key %q to %q", k, got)
+		}
+	}
+}
+
+func testStreamCommitOpen(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	ss := s.(merkledb.StreamingStorage)
+	want := bytes.Repeat([]byte("streamed "), 100000)
+
+	pending, err := ss.Create()
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	for chunk := want; len(chunk) > 0; chunk = chunk[min(len(chunk), 4096):] {
+		if _, err := pending.Write(chunk[:min(len(chunk), 4096)]); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	if exists, _ := s.Exists(hashKey(1)); exists {
+		t.Error("Exists() reports a value before it was committed")
+	}
+	if err := pending.Commit(hashKey(1)); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	if err := pending.Abort(); err != nil {
+		t.Errorf("Abort() after Commit() returned %v, want nil", err)
+	}
+
+	rc, err := ss.Open(hashKey(1))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Open() read %d bytes, %v; want the %d committed bytes", len(got), err, len(want))
+	}
+	if got := mustGet(t, s, hashKey(1)); !bytes.Equal(got, want) {
+		t.Errorf("Get() of a streamed value returned %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func testStreamAbort(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	mustPut(t, s, hashKey(1), []byte("original"))
+
+	pending, err := s.(merkledb.StreamingStorage).Create()
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	pending.Write([]byte("discarded"))
+	if err := pending.Abort(); err != nil {
+		t.Fatalf("Abort() failed: %v", err)
+	}
+	if err := pending.Commit(hashKey(1)); err == nil {
+		t.Error("Commit() after Abort() succeeded, want an error")
+	}
+	if got := mustGet(t, s, hashKey(1)); string(got) != "original" {
+		t.Errorf("aborted value replaced the stored one: got %q", got)
+	}
+}
+
+func testStreamOpenMissing(t *testing.T, factory Factory) {
+	s := open(t, factory)
+	if _, err := s.(merkledb.StreamingStorage).Open(hashKey(1)); !errors.Is(err, merkledb.ErrNotFound) {
+		t.Errorf("Open() of a missing key returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,34 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/AureClai/merkledb"
+	"github.com/AureClai/merkledb/storage/memstore"
+)
+
+// newReferenceStorage returns the reference implementation of the contract,
+// used to check that the suite itself accepts a correct backend.
+func newReferenceStorage(tb testing.TB) merkledb.Storage {
+	return memstore.New(nil)
+}
+
+func TestRunConformance(t *testing.T) {
+	RunConformance(t, newReferenceStorage)
+}
+
+func TestRunRefConformance(t *testing.T) {
+	RunRefConformance(t, func(tb testing.TB) merkledb.RefStore {
+		return merkledb.NewMemoryRefStore()
+	})
+}
+
+func BenchmarkRunBenchmarks(b *testing.B) {
+	RunBenchmarks(b, newReferenceStorage)
+}
+
+func TestRunConformance_Streaming(t *testing.T) {
+	RunConformance(t, func(tb testing.TB) merkledb.Storage {
+		return NewStreamingStore()
+	})
+}
@@ -10,22 +10,40 @@ import (
 // It is responsible for taking objects, hashing them and storing them
 type ObjectStore struct {
 	storage Storage
+	tracer  Tracer
+	chunker *chunker
+	format  Format
+	clock   Clock
 }
 
+// ObjectStoreOption configures optional behaviour of an ObjectStore.
+type ObjectStoreOption func(*ObjectStore)
+
 // New ObjectStore creates and returns a new ObjectStore that uses the provided storage backend.
-func NewObjectStore(storage Storage) *ObjectStore {
-	return &ObjectStore{storage: storage}
+func NewObjectStore(storage Storage, opts ...ObjectStoreOption) *ObjectStore {
+	s := &ObjectStore{storage: storage, tracer: noopTracer{}, clock: systemClock{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // WriteObject writes an object to the storage and returns its SHA-256 hash,
 // and stores the serialized data in the backend storage.
 // It returns the hex-encoded hash of the object, which serves as its unique ID.
-func (s *ObjectStore) WriteObject(obj Object) (string, error) {
+func (s *ObjectStore) WriteObject(obj Object) (hash string, err error) {
+	span := s.tracer.StartSpan("merkledb.WriteObject")
+	defer func() {
+		span.SetAttribute("hash", hash)
+		span.End(err)
+	}()
+
 	// 1. Serialize the object to get its raw data.
-	data, err := obj.Serialize()
+	data, err := s.serialize(obj)
 	if err != nil {
 		return "", fmt.Errorf("failed to serialize object: %w", err)
 	}
+	span.SetAttribute("size", len(data))
 
 	// 2. Hash the serialized data using SHA-256
 	hashBytes := sha256.Sum256(data)
@@ -33,6 +51,14 @@ func (s *ObjectStore) WriteObject(obj Object) (string, error) {
 
 	// 3. Store the data in the backend using the hask as the key
 	// We use the raw hash bytes as the key for efficiency in the storage layer.
+	if s.shouldChunk(data) {
+		chunks, err := s.writeChunked(data)
+		if err != nil {
+			return "", fmt.Errorf("failed to store object: %w", err)
+		}
+		span.SetAttribute("chunks", chunks)
+		return hashHex, nil
+	}
 	err = s.storage.Put(hashBytes[:], data)
 	if err != nil {
 		return "", fmt.Errorf("failed to store object: %w", err)
@@ -57,5 +83,10 @@ func (s *ObjectStore) ReadRawObject(hash string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read object: %w", err)
 	}
 
+	// 3. Reassemble objects that were stored as chunks.
+	if m, ok := parseChunkManifest(data); ok {
+		return s.reassemble(hashBytes, m)
+	}
+
 	return data, nil
 }
@@ -0,0 +1,214 @@
+package merkledb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// WriteStream stores the content read from r as an object and returns its
+// hex-encoded hash, without holding the content in memory. The hash is the
+// same WriteObject would return for an object serializing to that content.
+//
+// Content up to the chunking threshold is stored whole. Larger content is
+// streamed into the backend if it implements StreamingStorage and chunking is
+// not configured; otherwise it is stored as content-defined chunks, which any
+// backend supports.
+func (s *ObjectStore) WriteStream(r io.Reader) (hash string, err error) {
+	span := s.tracer.StartSpan("merkledb.WriteStream")
+	defer func() {
+		span.SetAttribute("hash", hash)
+		span.End(err)
+	}()
+
+	threshold := newChunker(ChunkingOptions{}).threshold
+	if s.chunker != nil {
+		threshold = s.chunker.threshold
+	}
+	head, err := io.ReadAll(io.LimitReader(r, int64(threshold)+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read stream: %w", err)
+	}
+	magic := bytes.HasPrefix(head, chunkManifestMagic)
+
+	// Small content: store it whole, as WriteObject does.
+	if len(head) <= threshold && !magic {
+		sum := sha256.Sum256(head)
+		if err := s.storage.Put(sum[:], head); err != nil {
+			return "", fmt.Errorf("failed to store object: %w", err)
+		}
+		span.SetAttribute("size", len(head))
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	content := io.MultiReader(bytes.NewReader(head), r)
+	if ss, ok := AsStreaming(s.storage); ok && s.chunker == nil && !magic {
+		key, n, err := streamInto(ss, content)
+		if err != nil {
+			return "", err
+		}
+		span.SetAttribute("size", n)
+		return hex.EncodeToString(key), nil
+	}
+
+	w := s.newChunkWriter()
+	n, err := io.Copy(w, content)
+	if err != nil {
+		return "", fmt.Errorf("failed to store stream: %w", err)
+	}
+	key, chunks, err := w.finish()
+	if err != nil {
+		return "", fmt.Errorf("failed to store object: %w", err)
+	}
+	span.SetAttribute("size", n)
+	span.SetAttribute("chunks", chunks)
+	return hex.EncodeToString(key), nil
+}
+
+// streamInto copies r into a new value of ss, hashing it on the way, and
+// commits the value under the hash.
+func streamInto(ss StreamingStorage, r io.Reader) ([]byte, int64, error) {
+	pending, err := ss.Create()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create object: %w", err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(pending, h), r)
+	if err != nil {
+		pending.Abort()
+		return nil, 0, fmt.Errorf("failed to store stream: %w", err)
+	}
+	key := h.Sum(nil)
+	if err := pending.Commit(key); err != nil {
+		pending.Abort()
+		return nil, 0, fmt.Errorf("failed to store object: %w", err)
+	}
+	return key, n, nil
+}
+
+// OpenObject returns a reader for the content of the object with the given
+// hex-encoded hash. Chunked objects are read one chunk at a time, and values
+// are streamed from backends that implement StreamingStorage, so the object
+// never has to fit in memory.
+//
+// The content is checked against the hash as it is read: if it does not
+// match, the final Read returns an error wrapping ErrCorruptObject instead of
+// io.EOF.
+func (s *ObjectStore) OpenObject(hash string) (io.ReadCloser, error) {
+	key, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode hash: %w", err)
+	}
+	rc, err := s.openValue(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	br := bufio.NewReader(rc)
+	if prefix, _ := br.Peek(len(chunkManifestMagic)); bytes.Equal(prefix, chunkManifestMagic) {
+		value, err := io.ReadAll(br)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object: %w", err)
+		}
+		if m, ok := parseChunkManifest(value); ok {
+			return newVerifyingReader(key, &chunkReader{store: s, chunks: m.Chunks}), nil
+		}
+		// Not a valid manifest: let the hash check report it.
+		return newVerifyingReader(key, io.NopCloser(bytes.NewReader(value))), nil
+	}
+	return newVerifyingReader(key, struct {
+		io.Reader
+		io.Closer
+	}{br, rc}), nil
+}
+
+// openValue opens the value stored under key, streaming it if the backend
+// supports it.
+func (s *ObjectStore) openValue(key []byte) (io.ReadCloser, error) {
+	if ss, ok := AsStreaming(s.storage); ok {
+		return ss.Open(key)
+	}
+	value, err := s.storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(value)), nil
+}
+
+// chunkReader reads the chunks of a manifest in order, opening each one only
+// when the previous one is exhausted.
+type chunkReader struct {
+	store   *ObjectStore
+	chunks  []ChunkRef
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			key, err := hex.DecodeString(r.chunks[0].Hash)
+			if err != nil {
+				return 0, fmt.Errorf("invalid chunk hash %q: %w", r.chunks[0].Hash, err)
+			}
+			rc, err := r.store.openValue(key)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read chunk %s: %w", r.chunks[0].Hash, err)
+			}
+			r.current = rc
+			r.chunks = r.chunks[1:]
+		}
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.current.Close()
+			r.current = nil
+			err = nil
+			if n == 0 {
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		err := r.current.Close()
+		r.current = nil
+		return err
+	}
+	return nil
+}
+
+// verifyingReader hashes what it reads and reports a mismatch with the
+// expected hash in place of io.EOF.
+type verifyingReader struct {
+	rc   io.ReadCloser
+	key  []byte
+	hash hash.Hash
+}
+
+func newVerifyingReader(key []byte, rc io.ReadCloser) *verifyingReader {
+	return &verifyingReader{rc: rc, key: key, hash: sha256.New()}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) && !bytes.Equal(r.hash.Sum(nil), r.key) {
+		return n, fmt.Errorf("object %x: %w", r.key, ErrCorruptObject)
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.rc.Close()
+}
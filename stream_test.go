@@ -0,0 +1,179 @@
+package merkledb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// streamingStorage adds StreamingStorage to mockStorage and counts how the
+// ObjectStore used it.
+type streamingStorage struct {
+	*mockStorage
+	creates, opens int
+}
+
+type pendingBuffer struct {
+	bytes.Buffer
+	storage *streamingStorage
+}
+
+func (p *pendingBuffer) Commit(key []byte) error {
+	return p.storage.Put(key, bytes.Clone(p.Bytes()))
+}
+
+func (p *pendingBuffer) Abort() error { return nil }
+
+func (s *streamingStorage) Create() (PendingValue, error) {
+	s.creates++
+	return &pendingBuffer{storage: s}, nil
+}
+
+func (s *streamingStorage) Open(key []byte) (io.ReadCloser, error) {
+	s.opens++
+	value, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(value)), nil
+}
+
+func readObject(t *testing.T, store *ObjectStore, hash string) []byte {
+	t.Helper()
+	rc, err := store.OpenObject(hash)
+	if err != nil {
+		t.Fatalf("OpenObject() failed: %v", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading object failed: %v", err)
+	}
+	return data
+}
+
+func TestObjectStore_WriteStreamMatchesWriteObject(t *testing.T) {
+	lookalike := append(append([]byte(nil), chunkManifestMagic...), "not a manifest"...)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"small", []byte("hello world")},
+		{"large", randomBytes(1, 3<<20)},
+		{"manifest lookalike", lookalike},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewObjectStore(NewMockStorage())
+			want, err := store.WriteObject(rawObject(tt.data))
+			if err != nil {
+				t.Fatalf("WriteObject() failed: %v", err)
+			}
+			// One byte per Read exercises the buffering.
+			got, err := store.WriteStream(iotest.OneByteReader(bytes.NewReader(tt.data)))
+			if err != nil {
+				t.Fatalf("WriteStream() failed: %v", err)
+			}
+			if got != want {
+				t.Errorf("WriteStream() = %s, WriteObject() = %s", got, want)
+			}
+			if data := readObject(t, store, got); !bytes.Equal(data, tt.data) {
+				t.Errorf("OpenObject() read %d bytes, want the %d written", len(data), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestObjectStore_WriteStreamChunksWithoutStreamingStorage(t *testing.T) {
+	store := NewObjectStore(NewMockStorage())
+	data := randomBytes(2, 2<<20)
+	hash, err := store.WriteStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteStream() failed: %v", err)
+	}
+	if _, chunked, _ := store.ReadChunkManifest(hash); !chunked {
+		t.Error("large stream into a plain Storage was not chunked")
+	}
+	if got, err := store.ReadRawObject(hash); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("ReadRawObject() of a streamed object returned %d bytes, %v", len(got), err)
+	}
+}
+
+func TestObjectStore_WriteStreamUsesStreamingStorage(t *testing.T) {
+	storage := &streamingStorage{mockStorage: NewMockStorage()}
+	store := NewObjectStore(storage)
+	data := randomBytes(3, 2<<20)
+
+	hash, err := store.WriteStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteStream() failed: %v", err)
+	}
+	if storage.creates != 1 {
+		t.Errorf("WriteStream() created %d pending values, want 1", storage.creates)
+	}
+	if _, chunked, _ := store.ReadChunkManifest(hash); chunked {
+		t.Error("stream into a StreamingStorage was chunked")
+	}
+	if got := readObject(t, store, hash); !bytes.Equal(got, data) {
+		t.Errorf("OpenObject() read %d bytes, want %d", len(got), len(data))
+	}
+	if storage.opens == 0 {
+		t.Error("OpenObject() did not stream from the StreamingStorage")
+	}
+
+	// Configured chunking takes precedence, for deduplication.
+	chunked := NewObjectStore(storage, WithChunking(ChunkingOptions{}))
+	storage.creates = 0
+	hash, _ = chunked.WriteStream(bytes.NewReader(randomBytes(4, 2<<20)))
+	if _, ok, _ := chunked.ReadChunkManifest(hash); !ok || storage.creates != 0 {
+		t.Errorf("WriteStream() with chunking: chunked = %v, creates = %d; want chunks only", ok, storage.creates)
+	}
+}
+
+func TestObjectStore_OpenObjectDetectsCorruption(t *testing.T) {
+	storage := NewMockStorage()
+	store := NewObjectStore(storage, WithChunking(ChunkingOptions{Threshold: 16 << 10, AvgSize: 4 << 10}))
+	small, _ := store.WriteObject(rawObject("small object"))
+	large, _ := store.WriteObject(rawObject(randomBytes(5, 64<<10)))
+
+	key, _ := hex.DecodeString(small)
+	storage.data[string(key)] = []byte("tampered")
+	m, _, _ := store.ReadChunkManifest(large)
+	chunkKey, _ := hex.DecodeString(m.Chunks[0].Hash)
+	storage.data[string(chunkKey)] = []byte("tampered")
+
+	for _, hash := range []string{small, large} {
+		rc, err := store.OpenObject(hash)
+		if err != nil {
+			t.Fatalf("OpenObject() failed: %v", err)
+		}
+		if _, err := io.ReadAll(rc); !errors.Is(err, ErrCorruptObject) {
+			t.Errorf("reading tampered object %s returned %v, want ErrCorruptObject", hash[:8], err)
+		}
+		rc.Close()
+	}
+
+	if _, err := store.OpenObject(strings.Repeat("00", sha256.Size)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("OpenObject() of a missing object returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestObjectStore_WriteStreamReadError(t *testing.T) {
+	storage := &streamingStorage{mockStorage: NewMockStorage()}
+	store := NewObjectStore(storage)
+	boom := errors.New("boom")
+	r := io.MultiReader(bytes.NewReader(randomBytes(6, 2<<20)), iotest.ErrReader(boom))
+
+	if _, err := store.WriteStream(r); !errors.Is(err, boom) {
+		t.Errorf("WriteStream() returned %v, want the reader's error", err)
+	}
+	if len(storage.data) != 0 {
+		t.Errorf("failed WriteStream() stored %d values, want none", len(storage.data))
+	}
+}
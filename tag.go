@@ -0,0 +1,244 @@
+package merkledb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TargetKind is the kind of object a tag points to.
+type TargetKind string
+
+const (
+	TargetCommit TargetKind = "commit"
+	TargetTree   TargetKind = "tree"
+	TargetBlob   TargetKind = "blob"
+	TargetTag    TargetKind = "tag"
+)
+
+// Tag is an annotated tag: a named, immutable object recording who released
+// which version of the data and why, optionally signed. A tag is published
+// by pointing the ref "refs/tags/<name>" at it.
+type Tag struct {
+	// Target is the hash of the tagged object, usually a commit.
+	Target     string     `json:"object"`
+	TargetKind TargetKind `json:"type"`
+	Name       string     `json:"tag"`
+	Tagger     *Identity  `json:"tagger,omitempty"`
+	Message    string     `json:"message"`
+	// Signature, if set, signs all the other fields. See Tag.Verify.
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// tagObjectType marks serialized tags, so that no other object with the
+// same fields is mistaken for a tag.
+const tagObjectType = "tag"
+
+// storedTag is the serialized form of a Tag.
+type storedTag struct {
+	ObjectType string `json:"objecttype"`
+	Tag
+}
+
+// Serialize implements the Object interface for Tag.
+// Like Commit, it uses standard JSON marshaling.
+func (t *Tag) Serialize() ([]byte, error) {
+	return json.Marshal(storedTag{tagObjectType, *t})
+}
+
+// SerializeFormat implements the FormatSerializer interface for Tag.
+func (t *Tag) SerializeFormat(f Format) ([]byte, error) {
+	if f == FormatJSON {
+		return t.Serialize()
+	}
+	return Encode(f, storedTag{tagObjectType, *t})
+}
+
+// SigningPayload returns the bytes a signature of t covers: the JSON encoding
+// of t without its signature.
+func (t *Tag) SigningPayload() ([]byte, error) {
+	unsigned := *t
+	unsigned.Signature = nil
+	return unsigned.Serialize()
+}
+
+// Verify checks the signature of t against trust, like Commit.Verify.
+func (t *Tag) Verify(trust *TrustStore) (string, error) {
+	return checkSignature(t.Signature, t.SigningPayload, trust)
+}
+
+// TagOptions holds the optional fields of a new tag.
+type TagOptions struct {
+	// Kind is the kind of the tagged object. It defaults to TargetCommit.
+	Kind TargetKind
+	// Tagger is recorded on the tag. A zero When gets the time told by the
+	// store's Clock.
+	Tagger *Identity
+	// Signer, if set, signs the tag.
+	Signer Signer
+	// Force replaces an existing tag of the same name.
+	Force bool
+}
+
+// CreateTag writes an annotated tag of target and points "refs/tags/<name>"
+// at it. It fails with an error matching ErrRefConflict if the tag already
+// exists, unless opts.Force is set. opts may be nil.
+func CreateTag(store *ObjectStore, refs RefStore, name, target, message string, opts *TagOptions) (hash string, err error) {
+	if store == nil || refs == nil {
+		return "", fmt.Errorf("object store and ref store cannot be nil")
+	}
+	ref := TagsPrefix + name
+	if err := CheckRefName(ref); err != nil {
+		return "", fmt.Errorf("invalid tag name: %w", err)
+	}
+	if opts == nil {
+		opts = &TagOptions{}
+	}
+	tag := &Tag{Target: target, TargetKind: opts.Kind, Name: name, Message: message}
+	if tag.TargetKind == "" {
+		tag.TargetKind = TargetCommit
+	}
+	if err := checkTarget(store, target, tag.TargetKind); err != nil {
+		return "", err
+	}
+	if opts.Tagger != nil {
+		tagger := *opts.Tagger
+		if tagger.Name == "" && tagger.Email == "" {
+			return "", fmt.Errorf("tagger has neither a name nor an email")
+		}
+		if tagger.When.IsZero() {
+			tagger.When = store.clock.Now()
+		}
+		tagger.When = tagger.When.UTC()
+		tag.Tagger = &tagger
+	}
+	if opts.Signer != nil {
+		payload, err := tag.SigningPayload()
+		if err != nil {
+			return "", fmt.Errorf("failed to encode tag: %w", err)
+		}
+		if tag.Signature, err = opts.Signer.Sign(payload); err != nil {
+			return "", fmt.Errorf("failed to sign tag: %w", err)
+		}
+	}
+
+	hash, err = store.WriteObject(tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to write tag: %w", err)
+	}
+	if opts.Force {
+		err = refs.WriteRef(ref, hash)
+	} else {
+		err = refs.CompareAndSwapRef(ref, "", hash)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to update %s: %w", ref, err)
+	}
+	return hash, nil
+}
+
+// checkTarget checks that target is an object of the given kind.
+func checkTarget(store *ObjectStore, target string, kind TargetKind) error {
+	var err error
+	switch kind {
+	case TargetCommit:
+		_, err = store.ReadCommit(target)
+	case TargetTree:
+		_, err = store.ReadTree(target)
+	case TargetTag:
+		_, err = store.ReadTag(target)
+	case TargetBlob:
+		_, err = store.ReadRawObject(target)
+	default:
+		return fmt.Errorf("unknown tag target kind %q", kind)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid tag target %s: %w", target, err)
+	}
+	return nil
+}
+
+// decodeTag decodes data as a tag, and reports whether it is one.
+func decodeTag(data []byte) (*Tag, bool) {
+	var stored storedTag
+	if err := Decode(data, &stored); err != nil || stored.ObjectType != tagObjectType || stored.Target == "" || stored.Name == "" {
+		return nil, false
+	}
+	return &stored.Tag, true
+}
+
+// ReadTag reads and decodes the tag with the given hex-encoded hash.
+func (s *ObjectStore) ReadTag(hash string) (*Tag, error) {
+	data, err := s.ReadRawObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	tag, ok := decodeTag(data)
+	if !ok {
+		return nil, fmt.Errorf("object %s is not a tag", hash)
+	}
+	return tag, nil
+}
+
+// maxTagDepth bounds the length of the tag chains Peel follows.
+const maxTagDepth = 32
+
+// Peel follows tags, and tags of tags, from hash to the object they tag. It
+// returns hash itself if it is not a tag.
+func Peel(store *ObjectStore, hash string) (string, error) {
+	for range maxTagDepth {
+		data, err := store.ReadRawObject(hash)
+		if err != nil {
+			return "", err
+		}
+		tag, ok := decodeTag(data)
+		if !ok {
+			return hash, nil
+		}
+		hash = tag.Target
+	}
+	return "", fmt.Errorf("tag chain longer than %d", maxTagDepth)
+}
+
+// TagRef is a tag listed by ListTags.
+type TagRef struct {
+	// Name is the tag name, without the refs/tags/ prefix.
+	Name string
+	// Hash is the hash the ref points to.
+	Hash string
+	// Tag is the annotated tag object, or nil for a lightweight tag pointing
+	// straight at its target.
+	Tag *Tag
+}
+
+// ListTags returns the tags in refs, in name order.
+func ListTags(store *ObjectStore, refs RefStore) ([]TagRef, error) {
+	list, err := refs.ListRefs(TagsPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	tags := make([]TagRef, 0, len(list))
+	for _, ref := range list {
+		data, err := store.ReadRawObject(ref.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tag %s: %w", ref.Name, err)
+		}
+		tag, _ := decodeTag(data)
+		tags = append(tags, TagRef{Name: strings.TrimPrefix(ref.Name, TagsPrefix), Hash: ref.Hash, Tag: tag})
+	}
+	return tags, nil
+}
+
+// VerifyTag reads the tag with the given hash and checks its signature like
+// Tag.Verify.
+func VerifyTag(store *ObjectStore, hash string, trust *TrustStore) (string, error) {
+	tag, err := store.ReadTag(hash)
+	if err != nil {
+		return "", err
+	}
+	name, err := tag.Verify(trust)
+	if err != nil {
+		return "", fmt.Errorf("tag %s: %w", hash, err)
+	}
+	return name, nil
+}
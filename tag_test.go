@@ -0,0 +1,164 @@
+package merkledb
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCreateTag(t *testing.T) {
+	for _, f := range []Format{FormatJSON, FormatCBOR} {
+		store := NewObjectStore(NewMockStorage(), WithFormat(f), WithClock(FixedClock(time.Unix(1700000000, 0))))
+		refs := NewMemoryRefStore()
+		ws, _ := NewWorkspace(store)
+		ws.Add("stops.txt", &mockObject{Data: "stops"})
+		commit, _ := ws.Commit("October feed", nil)
+
+		hash, err := CreateTag(store, refs, "v2024-10", commit, "October release", &TagOptions{
+			Tagger: &Identity{Name: "Release bot"},
+		})
+		if err != nil {
+			t.Fatalf("CreateTag() failed: %v", err)
+		}
+		tag, err := store.ReadTag(hash)
+		if err != nil {
+			t.Fatalf("ReadTag() failed: %v", err)
+		}
+		if tag.Target != commit || tag.TargetKind != TargetCommit || tag.Name != "v2024-10" || tag.Message != "October release" {
+			t.Errorf("%s: ReadTag() = %+v", f, tag)
+		}
+		if !tag.Tagger.When.Equal(time.Unix(1700000000, 0)) {
+			t.Errorf("%s: tagger time = %v, want the store's clock", f, tag.Tagger.When)
+		}
+
+		// The tag resolves through its ref, and peels to the commit.
+		ref, err := ResolveRef(refs, "v2024-10")
+		if err != nil || ref.Name != "refs/tags/v2024-10" || ref.Hash != hash {
+			t.Errorf("%s: ResolveRef() = %+v, %v", f, ref, err)
+		}
+		if peeled, err := Peel(store, ref.Hash); err != nil || peeled != commit {
+			t.Errorf("%s: Peel() = %s, %v; want %s", f, peeled, err, commit)
+		}
+		if _, err := store.ReadCommit(hash); err == nil {
+			t.Errorf("%s: ReadCommit() accepted a tag", f)
+		}
+		if _, err := store.ReadTag(commit); err == nil {
+			t.Errorf("%s: ReadTag() accepted a commit", f)
+		}
+	}
+}
+
+func TestReadTag_RequiresTypeMarker(t *testing.T) {
+	store := NewObjectStore(NewMockStorage())
+	target, _ := store.WriteObject(&mockObject{Data: "stops"})
+
+	// Data that only looks like a tag is not one.
+	lookalike, err := store.WriteObject(&JSONObject[map[string]string]{Value: map[string]string{
+		"object": target,
+		"type":   "blob",
+		"tag":    "v1",
+	}})
+	if err != nil {
+		t.Fatalf("WriteObject() failed: %v", err)
+	}
+	if _, err := store.ReadTag(lookalike); err == nil {
+		t.Error("ReadTag() accepted an object without the tag marker")
+	}
+	if peeled, err := Peel(store, lookalike); err != nil || peeled != lookalike {
+		t.Errorf("Peel() = %s, %v; want the object itself", peeled, err)
+	}
+}
+
+func TestCreateTag_Conflicts(t *testing.T) {
+	store := NewObjectStore(NewMockStorage())
+	refs := NewMemoryRefStore()
+	ws, _ := NewWorkspace(store)
+	first, _ := ws.Commit("first", nil)
+	second, _ := ws.Commit("second", []string{first})
+
+	CreateTag(store, refs, "v1", first, "", nil)
+	if _, err := CreateTag(store, refs, "v1", second, "", nil); !errors.Is(err, ErrRefConflict) {
+		t.Errorf("CreateTag() over an existing tag returned %v, want ErrRefConflict", err)
+	}
+	moved, err := CreateTag(store, refs, "v1", second, "", &TagOptions{Force: true})
+	if err != nil {
+		t.Fatalf("CreateTag() with Force failed: %v", err)
+	}
+	if peeled, _ := Peel(store, moved); peeled != second {
+		t.Errorf("forced tag peels to %s, want %s", peeled, second)
+	}
+
+	if _, err := CreateTag(store, refs, "bad name", first, "", nil); err == nil {
+		t.Error("CreateTag() accepted an invalid name")
+	}
+	if _, err := CreateTag(store, refs, "v2", "0000", "", nil); err == nil {
+		t.Error("CreateTag() accepted a missing target")
+	}
+	tree, _ := store.ReadCommit(first)
+	if _, err := CreateTag(store, refs, "v3", tree.TreeHash, "", nil); err == nil {
+		t.Error("CreateTag() accepted a tree as a commit")
+	}
+	if _, err := CreateTag(store, refs, "v3", tree.TreeHash, "", &TagOptions{Kind: TargetTree}); err != nil {
+		t.Errorf("CreateTag() of a tree failed: %v", err)
+	}
+}
+
+func TestListAndVerifyTags(t *testing.T) {
+	store := NewObjectStore(NewMockStorage())
+	refs := NewMemoryRefStore()
+	key := testKey(0)
+	trust := NewTrustStore()
+	trust.Add("release", key.Public().(ed25519.PublicKey))
+
+	ws, _ := NewWorkspace(store)
+	commit, _ := ws.Commit("feed", nil)
+	signed, _ := CreateTag(store, refs, "v2", commit, "signed", &TagOptions{Signer: SSHSigner(key)})
+	unsigned, _ := CreateTag(store, refs, "v1", commit, "unsigned", nil)
+	refs.WriteRef(TagsPrefix+"light", commit)
+	// A tag of a tag peels all the way to the commit.
+	nested, _ := CreateTag(store, refs, "v2-final", signed, "", &TagOptions{Kind: TargetTag})
+
+	tags, err := ListTags(store, refs)
+	if err != nil {
+		t.Fatalf("ListTags() failed: %v", err)
+	}
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	if len(tags) != 4 || names[0] != "light" || tags[0].Tag != nil || names[1] != "v1" || tags[1].Tag.Message != "unsigned" {
+		t.Errorf("ListTags() = %v", names)
+	}
+	if peeled, _ := Peel(store, nested); peeled != commit {
+		t.Errorf("Peel() of a nested tag = %s, want %s", peeled, commit)
+	}
+
+	if name, err := VerifyTag(store, signed, trust); err != nil || name != "release" {
+		t.Errorf("VerifyTag() = %q, %v; want release", name, err)
+	}
+	if _, err := VerifyTag(store, unsigned, trust); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("VerifyTag() of an unsigned tag = %v, want ErrUnsigned", err)
+	}
+	tag, _ := store.ReadTag(signed)
+	tag.Target = unsigned
+	if _, err := tag.Verify(trust); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify() of a retargeted tag = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestResolveRef_Order(t *testing.T) {
+	refs := NewMemoryRefStore()
+	refs.WriteRef(HeadsPrefix+"main", "branch")
+	refs.WriteRef(TagsPrefix+"main", "tag")
+	refs.WriteRef("HEAD", "head")
+
+	for name, want := range map[string]string{"main": "tag", "heads/main": "branch", "refs/heads/main": "branch", "HEAD": "head"} {
+		if ref, err := ResolveRef(refs, name); err != nil || ref.Hash != want {
+			t.Errorf("ResolveRef(%s) = %+v, %v; want %s", name, ref, err, want)
+		}
+	}
+	if _, err := ResolveRef(refs, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveRef() of a missing ref = %v, want ErrNotFound", err)
+	}
+}
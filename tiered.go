@@ -0,0 +1,569 @@
+package merkledb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrPendingWrite is returned by TieredStorage.Evict for a key that has not
+// been written to the cold tier yet.
+var ErrPendingWrite = errors.New("key has not been written to the cold tier yet")
+
+// WriteMode selects how a TieredStorage propagates writes to its cold tier.
+type WriteMode int
+
+const (
+	// WriteThrough writes every value to the cold tier before the hot tier,
+	// so a successful Put is immediately durable in the authoritative store.
+	WriteThrough WriteMode = iota
+	// WriteBack writes values to the hot tier only and records their keys
+	// in a PendingQueue. A background flusher copies them to the cold tier.
+	WriteBack
+)
+
+// PendingQueue records the keys a write-back TieredStorage still has to copy
+// to its cold tier. A durable queue lets pending writes survive a restart.
+type PendingQueue interface {
+	// Push adds a key to the queue. Pushing a key already queued is a no-op.
+	Push(key []byte) error
+	// Remove drops a key from the queue.
+	Remove(key []byte) error
+	// Keys returns the queued keys.
+	Keys() ([][]byte, error)
+}
+
+// TieredOptions configures a TieredStorage.
+type TieredOptions struct {
+	// Mode selects write-through (the default) or write-back.
+	Mode WriteMode
+	// Queue holds pending write-back keys. Defaults to an in-memory queue,
+	// which loses pending writes if the process stops before they are
+	// flushed; use OpenFileQueue for a durable one.
+	Queue PendingQueue
+	// FlushInterval is how often the background flusher runs in write-back
+	// mode. Defaults to one second.
+	FlushInterval time.Duration
+}
+
+// TieredStorage composes a small, fast hot tier (typically a local disk) with
+// a slow, authoritative cold tier (typically a shared remote store).
+//
+// Reads check the hot tier first and copy values found only in the cold tier
+// into it. Because the cold tier always ends up holding every value, any key
+// that is not pending can be evicted from the hot tier at any time.
+//
+// Values are assumed never to change once written, which holds for
+// content-addressed objects.
+type TieredStorage struct {
+	hot   Storage
+	cold  Storage
+	mode  WriteMode
+	queue PendingQueue
+
+	// keys serializes the operations on each key, so that Flush and Evict
+	// never see a key queued by a Put that has not written it yet.
+	keys keyLocks
+	// pending indexes the keys in queue, so that checking one does not
+	// list the whole queue. It changes with the queue, under the key lock.
+	pendingMu sync.Mutex
+	pending   map[string]struct{}
+	flushMu   sync.Mutex
+	kick      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeMu   sync.Once
+}
+
+// NewTieredStorage creates a TieredStorage over the given tiers. In write-back
+// mode it starts a background flusher, which also picks up keys left in a
+// durable queue by a previous run; call Close to stop it.
+func NewTieredStorage(hot, cold Storage, opts TieredOptions) (*TieredStorage, error) {
+	if hot == nil || cold == nil {
+		return nil, fmt.Errorf("hot and cold storage cannot be nil")
+	}
+	if opts.Queue == nil {
+		opts.Queue = NewMemoryQueue()
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+
+	t := &TieredStorage{hot: hot, cold: cold, mode: opts.Mode, queue: opts.Queue}
+	if t.mode == WriteBack {
+		if err := t.dropUnwritten(); err != nil {
+			return nil, err
+		}
+		keys, err := t.queue.Keys()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pending writes: %w", err)
+		}
+		t.pending = make(map[string]struct{}, len(keys))
+		for _, key := range keys {
+			t.pending[string(key)] = struct{}{}
+		}
+		t.kick = make(chan struct{}, 1)
+		t.stop = make(chan struct{})
+		t.done = make(chan struct{})
+		go t.flusher(opts.FlushInterval)
+		t.kick <- struct{}{}
+	}
+	return t, nil
+}
+
+// dropUnwritten unqueues the keys left in the queue by a Put that stopped
+// before writing to the hot tier in a previous run.
+func (t *TieredStorage) dropUnwritten() error {
+	keys, err := t.queue.Keys()
+	if err != nil {
+		return fmt.Errorf("failed to read pending writes: %w", err)
+	}
+	for _, key := range keys {
+		exists, err := t.hot.Exists(key)
+		if err != nil {
+			return fmt.Errorf("failed to check hot tier: %w", err)
+		}
+		if !exists {
+			if err := t.queue.Remove(key); err != nil {
+				return fmt.Errorf("failed to unqueue key: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// flusher periodically copies pending keys to the cold tier. Failed keys stay
+// queued and are retried on the next run.
+func (t *TieredStorage) flusher(interval time.Duration) {
+	defer close(t.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.kick:
+		}
+		t.Flush()
+	}
+}
+
+// Put implements the Storage interface.
+func (t *TieredStorage) Put(key []byte, value []byte) error {
+	if t.mode == WriteThrough {
+		if err := t.cold.Put(key, value); err != nil {
+			return fmt.Errorf("failed to write to cold tier: %w", err)
+		}
+		// The cold tier has the value, so failing to cache it is harmless.
+		t.hot.Put(key, value)
+		return nil
+	}
+
+	// Queue the key first: if the process dies before the hot write, the
+	// next NewTieredStorage finds nothing to copy and drops it.
+	unlock := t.keys.lock(key)
+	defer unlock()
+	if err := t.enqueue(key); err != nil {
+		return fmt.Errorf("failed to queue write-back: %w", err)
+	}
+	if err := t.hot.Put(key, value); err != nil {
+		// Unqueue the key, unless an earlier Put stored it.
+		if exists, _ := t.hot.Exists(key); !exists {
+			t.unqueue(key)
+		}
+		return fmt.Errorf("failed to write to hot tier: %w", err)
+	}
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Get implements the Storage interface.
+func (t *TieredStorage) Get(key []byte) ([]byte, error) {
+	value, err := t.hot.Get(key)
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("failed to read from hot tier: %w", err)
+	}
+
+	value, err = t.cold.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	t.hot.Put(key, value)
+	return value, nil
+}
+
+// Exists implements the Storage interface.
+func (t *TieredStorage) Exists(key []byte) (bool, error) {
+	exists, err := t.hot.Exists(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check hot tier: %w", err)
+	}
+	if exists {
+		return true, nil
+	}
+	return t.cold.Exists(key)
+}
+
+// Delete implements the Deleter interface. The key is removed from both tiers,
+// each of which must implement Deleter.
+func (t *TieredStorage) Delete(key []byte) error {
+	hot, hotOK := AsDeleter(t.hot)
+	cold, coldOK := AsDeleter(t.cold)
+	if !hotOK || !coldOK {
+		return fmt.Errorf("both tiers must support deletion")
+	}
+	unlock := t.keys.lock(key)
+	defer unlock()
+	if err := t.unqueue(key); err != nil {
+		return fmt.Errorf("failed to unqueue key: %w", err)
+	}
+	if err := cold.Delete(key); err != nil {
+		return fmt.Errorf("failed to delete from cold tier: %w", err)
+	}
+	if err := hot.Delete(key); err != nil {
+		return fmt.Errorf("failed to delete from hot tier: %w", err)
+	}
+	return nil
+}
+
+// Supports implements the ExtensionReporter interface: deletion works if both
+// tiers support it.
+func (t *TieredStorage) Supports(ext Extension) bool {
+	return ext == ExtDeleter && Supports(t.hot, ExtDeleter) && Supports(t.cold, ExtDeleter)
+}
+
+// Evict removes a key from the hot tier only, which must implement Deleter.
+// It fails with ErrPendingWrite if the key has not reached the cold tier yet.
+func (t *TieredStorage) Evict(key []byte) error {
+	hot, ok := AsDeleter(t.hot)
+	if !ok {
+		return fmt.Errorf("hot tier does not support deletion")
+	}
+	if t.mode == WriteBack {
+		unlock := t.keys.lock(key)
+		defer unlock()
+		if t.queued(key) {
+			return ErrPendingWrite
+		}
+	}
+	return hot.Delete(key)
+}
+
+// enqueue pushes key to the queue. The caller must hold the key lock.
+func (t *TieredStorage) enqueue(key []byte) error {
+	if err := t.queue.Push(key); err != nil {
+		return err
+	}
+	t.pendingMu.Lock()
+	t.pending[string(key)] = struct{}{}
+	t.pendingMu.Unlock()
+	return nil
+}
+
+// unqueue removes key from the queue. The caller must hold the key lock.
+func (t *TieredStorage) unqueue(key []byte) error {
+	if err := t.queue.Remove(key); err != nil {
+		return err
+	}
+	t.pendingMu.Lock()
+	delete(t.pending, string(key))
+	t.pendingMu.Unlock()
+	return nil
+}
+
+// queued reports whether key is in the queue.
+func (t *TieredStorage) queued(key []byte) bool {
+	t.pendingMu.Lock()
+	defer t.pendingMu.Unlock()
+	_, ok := t.pending[string(key)]
+	return ok
+}
+
+// Pending returns the number of keys waiting to be written to the cold tier.
+func (t *TieredStorage) Pending() (int, error) {
+	keys, err := t.queue.Keys()
+	return len(keys), err
+}
+
+// Flush copies every pending key to the cold tier. Keys that fail stay
+// queued; the first error is returned.
+func (t *TieredStorage) Flush() error {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	keys, err := t.queue.Keys()
+	if err != nil {
+		return fmt.Errorf("failed to read pending writes: %w", err)
+	}
+	var firstErr error
+	for _, key := range keys {
+		if err := t.flushKey(key); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to flush key %x: %w", key, err)
+		}
+	}
+	return firstErr
+}
+
+// flushKey copies a pending key to the cold tier and unqueues it.
+func (t *TieredStorage) flushKey(key []byte) error {
+	unlock := t.keys.lock(key)
+	defer unlock()
+	value, err := t.hot.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		// Deleted since it was listed, or lost by the hot tier, in which
+		// case it stays queued and the error is reported.
+		if !t.queued(key) {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := t.cold.Put(key, value); err != nil {
+		return err
+	}
+	return t.unqueue(key)
+}
+
+// Close stops the background flusher and flushes pending writes.
+func (t *TieredStorage) Close() error {
+	if t.mode != WriteBack {
+		return nil
+	}
+	t.closeMu.Do(func() {
+		close(t.stop)
+		<-t.done
+	})
+	return t.Flush()
+}
+
+// keyLocks is a fixed set of mutexes serializing the operations on each key
+// without keeping a mutex per key.
+type keyLocks [64]sync.Mutex
+
+// lock locks the mutex of key and returns its unlock function.
+func (l *keyLocks) lock(key []byte) func() {
+	h := fnv.New32a()
+	h.Write(key)
+	m := &l[h.Sum32()%uint32(len(l))]
+	m.Lock()
+	return m.Unlock
+}
+
+// --- Pending queues ---
+
+// memoryQueue is a PendingQueue kept in memory.
+type memoryQueue struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// NewMemoryQueue returns a PendingQueue that lives in memory only.
+func NewMemoryQueue() PendingQueue {
+	return &memoryQueue{keys: make(map[string]struct{})}
+}
+
+func (q *memoryQueue) Push(key []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.keys[string(key)] = struct{}{}
+	return nil
+}
+
+func (q *memoryQueue) Remove(key []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.keys, string(key))
+	return nil
+}
+
+func (q *memoryQueue) Keys() ([][]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return sortedKeys(q.keys), nil
+}
+
+func sortedKeys(set map[string]struct{}) [][]byte {
+	names := make([]string, 0, len(set))
+	for k := range set {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	keys := make([][]byte, len(names))
+	for i, k := range names {
+		keys[i] = []byte(k)
+	}
+	return keys
+}
+
+// FileQueue is a durable PendingQueue backed by an append-only journal file.
+// Every change is synced before it is acknowledged. The journal is rewritten
+// when removed entries start to dominate it.
+type FileQueue struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	keys    map[string]struct{}
+	removed int
+}
+
+// OpenFileQueue opens the journal at path, creating it if needed, and
+// restores the keys that were still pending.
+func OpenFileQueue(path string) (*FileQueue, error) {
+	q := &FileQueue{path: path, keys: make(map[string]struct{})}
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if len(line) < 1 {
+				continue
+			}
+			key, err := hex.DecodeString(line[1:])
+			if err != nil {
+				// A torn last line from a crash; everything before it is valid.
+				continue
+			}
+			switch line[0] {
+			case '+':
+				q.keys[string(key)] = struct{}{}
+			case '-':
+				delete(q.keys, string(key))
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read queue journal: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to open queue journal: %w", err)
+	}
+
+	// Start from a compact journal holding only the pending keys.
+	if err := q.rewrite(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// rewrite replaces the journal with one listing only the pending keys.
+func (q *FileQueue) rewrite() error {
+	tmpPath := q.path + ".tmp"
+	var b strings.Builder
+	for _, key := range sortedKeys(q.keys) {
+		b.WriteString("+" + hex.EncodeToString(key) + "\n")
+	}
+	if err := writeFileSync(tmpPath, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to rewrite queue journal: %w", err)
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		return fmt.Errorf("failed to replace queue journal: %w", err)
+	}
+
+	if q.file != nil {
+		q.file.Close()
+	}
+	f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open queue journal: %w", err)
+	}
+	q.file = f
+	q.removed = 0
+	return nil
+}
+
+// writeFileSync writes data to a new file at path and syncs it.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// appendLine writes one journal line and syncs it.
+func (q *FileQueue) appendLine(op byte, key []byte) error {
+	if q.file == nil {
+		return fmt.Errorf("queue journal is closed")
+	}
+	if _, err := q.file.WriteString(string(op) + hex.EncodeToString(key) + "\n"); err != nil {
+		return fmt.Errorf("failed to write queue journal: %w", err)
+	}
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync queue journal: %w", err)
+	}
+	return nil
+}
+
+// Push implements the PendingQueue interface.
+func (q *FileQueue) Push(key []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.keys[string(key)]; ok {
+		return nil
+	}
+	if err := q.appendLine('+', key); err != nil {
+		return err
+	}
+	q.keys[string(key)] = struct{}{}
+	return nil
+}
+
+// Remove implements the PendingQueue interface.
+func (q *FileQueue) Remove(key []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.keys[string(key)]; !ok {
+		return nil
+	}
+	if err := q.appendLine('-', key); err != nil {
+		return err
+	}
+	delete(q.keys, string(key))
+	q.removed++
+	if q.removed > 1024 && q.removed > 2*len(q.keys) {
+		return q.rewrite()
+	}
+	return nil
+}
+
+// Keys implements the PendingQueue interface.
+func (q *FileQueue) Keys() ([][]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return sortedKeys(q.keys), nil
+}
+
+// Close closes the journal file.
+func (q *FileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
@@ -0,0 +1,339 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTieredStorage_InterfaceContracts(t *testing.T) {
+	var _ Storage = (*TieredStorage)(nil)
+	var _ Deleter = (*TieredStorage)(nil)
+	var _ PendingQueue = (*FileQueue)(nil)
+}
+
+func TestTieredStorage_WriteThrough(t *testing.T) {
+	hot, cold := newLockedStorage(), newLockedStorage()
+	tiered, err := NewTieredStorage(hot, cold, TieredOptions{})
+	if err != nil {
+		t.Fatalf("NewTieredStorage() failed: %v", err)
+	}
+
+	if err := tiered.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	for name, tier := range map[string]Storage{"hot": hot, "cold": cold} {
+		if ok, _ := tier.Exists([]byte("key")); !ok {
+			t.Errorf("%s tier does not hold the written key", name)
+		}
+	}
+
+	// A failing cold tier fails the write and leaves the hot tier untouched.
+	cold.setFailPuts(true)
+	if err := tiered.Put([]byte("other"), []byte("value")); err == nil {
+		t.Fatal("Put() succeeded although the cold tier failed")
+	}
+	if ok, _ := hot.Exists([]byte("other")); ok {
+		t.Error("hot tier was written although the cold tier failed")
+	}
+}
+
+func TestTieredStorage_ReadPopulatesHotTier(t *testing.T) {
+	hot, cold := newLockedStorage(), newLockedStorage()
+	cold.Put([]byte("key"), []byte("value"))
+	tiered, _ := NewTieredStorage(hot, cold, TieredOptions{})
+
+	value, err := tiered.Get([]byte("key"))
+	if err != nil || string(value) != "value" {
+		t.Fatalf("Get() = %q, %v; want %q", value, err, "value")
+	}
+	if ok, _ := hot.Exists([]byte("key")); !ok {
+		t.Error("Get() did not populate the hot tier")
+	}
+
+	if _, err := tiered.Get([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing key returned %v, want ErrNotFound", err)
+	}
+	if ok, err := tiered.Exists([]byte("key")); !ok || err != nil {
+		t.Errorf("Exists() = %v, %v; want true", ok, err)
+	}
+}
+
+func TestTieredStorage_EvictReadsBackFromCold(t *testing.T) {
+	hot, cold := newLockedStorage(), newLockedStorage()
+	tiered, _ := NewTieredStorage(hot, cold, TieredOptions{})
+	tiered.Put([]byte("key"), []byte("value"))
+
+	if err := tiered.Evict([]byte("key")); err != nil {
+		t.Fatalf("Evict() failed: %v", err)
+	}
+	if ok, _ := hot.Exists([]byte("key")); ok {
+		t.Error("Evict() left the key in the hot tier")
+	}
+	if value, err := tiered.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("Get() after Evict() = %q, %v; want %q", value, err, "value")
+	}
+}
+
+func TestTieredStorage_WriteBack(t *testing.T) {
+	hot, cold := newLockedStorage(), newLockedStorage()
+	tiered, err := NewTieredStorage(hot, cold, TieredOptions{Mode: WriteBack, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewTieredStorage() failed: %v", err)
+	}
+	defer tiered.Close()
+
+	// Keep the cold tier down so the write stays pending.
+	cold.setFailPuts(true)
+	if err := tiered.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if value, err := tiered.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("Get() = %q, %v; want %q", value, err, "value")
+	}
+	if err := tiered.Flush(); err == nil {
+		t.Error("Flush() succeeded although the cold tier failed")
+	}
+	if n, _ := tiered.Pending(); n != 1 {
+		t.Errorf("Pending() = %d, want 1", n)
+	}
+	if err := tiered.Evict([]byte("key")); !errors.Is(err, ErrPendingWrite) {
+		t.Errorf("Evict() of a pending key returned %v, want ErrPendingWrite", err)
+	}
+
+	cold.setFailPuts(false)
+	if err := tiered.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if n, _ := tiered.Pending(); n != 0 {
+		t.Errorf("Pending() after Flush() = %d, want 0", n)
+	}
+	if value, err := cold.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("cold tier holds %q, %v; want %q", value, err, "value")
+	}
+	if err := tiered.Evict([]byte("key")); err != nil {
+		t.Errorf("Evict() after Flush() failed: %v", err)
+	}
+}
+
+// slowHotStorage delays its writes, widening the window between a write-back
+// Put queuing a key and storing its value.
+type slowHotStorage struct {
+	*lockedStorage
+}
+
+func (s slowHotStorage) Put(key, value []byte) error {
+	time.Sleep(time.Millisecond)
+	return s.lockedStorage.Put(key, value)
+}
+
+func TestTieredStorage_ConcurrentPutFlushEvict(t *testing.T) {
+	hot, cold := slowHotStorage{newLockedStorage()}, newLockedStorage()
+	tiered, err := NewTieredStorage(hot, cold, TieredOptions{Mode: WriteBack, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewTieredStorage() failed: %v", err)
+	}
+	defer tiered.Close()
+
+	const n = 64
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key-%02d", i)) }
+	stop := make(chan struct{})
+	var loops sync.WaitGroup
+	loop := func(fn func()) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					fn()
+				}
+			}
+		}()
+	}
+	loop(func() { tiered.Flush() })
+	loop(func() {
+		for i := range n {
+			if err := tiered.Evict(key(i)); err != nil && !errors.Is(err, ErrPendingWrite) {
+				t.Errorf("Evict() failed: %v", err)
+			}
+		}
+	})
+
+	var puts sync.WaitGroup
+	for i := range n {
+		puts.Add(1)
+		go func() {
+			defer puts.Done()
+			if err := tiered.Put(key(i), key(i)); err != nil {
+				t.Errorf("Put() failed: %v", err)
+			}
+		}()
+	}
+	puts.Wait()
+	close(stop)
+	loops.Wait()
+
+	if err := tiered.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	for i := range n {
+		if value, err := cold.Get(key(i)); err != nil || string(value) != string(key(i)) {
+			t.Errorf("cold tier holds %q, %v for %s; want the value", value, err, key(i))
+		}
+	}
+}
+
+func TestTieredStorage_DropsUnwrittenKeysOnOpen(t *testing.T) {
+	hot, cold := newLockedStorage(), newLockedStorage()
+	queue := NewMemoryQueue()
+	// A previous run queued a key but stopped before writing it.
+	queue.Push([]byte("unwritten"))
+	tiered, err := NewTieredStorage(hot, cold, TieredOptions{Mode: WriteBack, Queue: queue, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewTieredStorage() failed: %v", err)
+	}
+	defer tiered.Close()
+	if n, _ := tiered.Pending(); n != 0 {
+		t.Errorf("Pending() = %d, want the unwritten key dropped", n)
+	}
+}
+
+// listingQueue counts how often the queue is listed.
+type listingQueue struct {
+	PendingQueue
+	mu    sync.Mutex
+	lists int
+}
+
+func (q *listingQueue) Keys() ([][]byte, error) {
+	q.mu.Lock()
+	q.lists++
+	q.mu.Unlock()
+	return q.PendingQueue.Keys()
+}
+
+func (q *listingQueue) listed() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.lists
+}
+
+func TestTieredStorage_EvictDoesNotListQueue(t *testing.T) {
+	hot, cold := newLockedStorage(), newLockedStorage()
+	queue := &listingQueue{PendingQueue: NewMemoryQueue()}
+	tiered, err := NewTieredStorage(hot, cold, TieredOptions{Mode: WriteBack, Queue: queue, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewTieredStorage() failed: %v", err)
+	}
+	const n = 100
+	for i := range n {
+		tiered.Put([]byte(fmt.Sprint(i)), []byte("value"))
+	}
+	// Close stops the background flusher, so only the calls below list.
+	tiered.Close()
+	tiered.Put([]byte("pending"), []byte("value"))
+
+	before := queue.listed()
+	for i := range n {
+		if err := tiered.Evict([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Evict() of a flushed key failed: %v", err)
+		}
+	}
+	if err := tiered.Evict([]byte("pending")); !errors.Is(err, ErrPendingWrite) {
+		t.Errorf("Evict() of a pending key returned %v, want ErrPendingWrite", err)
+	}
+	if err := tiered.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if got := queue.listed() - before; got != 1 {
+		t.Errorf("Evict() and Flush() listed the queue %d times, want once for Flush", got)
+	}
+	if err := tiered.Evict([]byte("pending")); err != nil {
+		t.Errorf("Evict() of a flushed key failed: %v", err)
+	}
+}
+
+func TestTieredStorage_BackgroundFlush(t *testing.T) {
+	hot, cold := newLockedStorage(), newLockedStorage()
+	tiered, _ := NewTieredStorage(hot, cold, TieredOptions{Mode: WriteBack, FlushInterval: 10 * time.Millisecond})
+	defer tiered.Close()
+
+	tiered.Put([]byte("key"), []byte("value"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if ok, _ := cold.Exists([]byte("key")); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background flusher did not write the key to the cold tier")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTieredStorage_Delete(t *testing.T) {
+	hot, cold := newLockedStorage(), newLockedStorage()
+	tiered, _ := NewTieredStorage(hot, cold, TieredOptions{})
+	tiered.Put([]byte("key"), []byte("value"))
+
+	if err := tiered.Delete([]byte("key")); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if ok, _ := tiered.Exists([]byte("key")); ok {
+		t.Error("Delete() left the key in a tier")
+	}
+}
+
+func TestFileQueue_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending.journal")
+	hot, cold := newLockedStorage(), newLockedStorage()
+	cold.setFailPuts(true)
+
+	queue, err := OpenFileQueue(path)
+	if err != nil {
+		t.Fatalf("OpenFileQueue() failed: %v", err)
+	}
+	tiered, _ := NewTieredStorage(hot, cold, TieredOptions{Mode: WriteBack, Queue: queue, FlushInterval: time.Hour})
+	tiered.Put([]byte("a"), []byte("1"))
+	tiered.Put([]byte("b"), []byte("2"))
+	queue.Remove([]byte("b"))
+	tiered.Close()
+	queue.Close()
+
+	// A restarted process picks up the pending write and flushes it.
+	queue, err = OpenFileQueue(path)
+	if err != nil {
+		t.Fatalf("OpenFileQueue() on reopen failed: %v", err)
+	}
+	defer queue.Close()
+	keys, _ := queue.Keys()
+	if len(keys) != 1 || string(keys[0]) != "a" {
+		t.Fatalf("reopened queue holds %q, want [a]", keys)
+	}
+
+	cold.setFailPuts(false)
+	tiered, _ = NewTieredStorage(hot, cold, TieredOptions{Mode: WriteBack, Queue: queue, FlushInterval: time.Hour})
+	if err := tiered.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if value, err := cold.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("cold tier holds %q, %v; want %q", value, err, "1")
+	}
+}
+
+func TestTieredStorage_SupportsDeletionOfBothTiers(t *testing.T) {
+	tiered, _ := NewTieredStorage(newLockedStorage(), newLockedStorage(), TieredOptions{})
+	if _, ok := AsDeleter(tiered); !ok {
+		t.Error("AsDeleter() failed although both tiers can delete")
+	}
+	tiered, _ = NewTieredStorage(newLockedStorage(), NewMockStorage(), TieredOptions{})
+	if _, ok := AsDeleter(tiered); ok {
+		t.Error("AsDeleter() succeeded with a cold tier that cannot delete")
+	}
+}
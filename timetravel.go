@@ -0,0 +1,140 @@
+package merkledb
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TimeIndex answers time-travel queries, such as what a branch looked like
+// on a given day. It indexes the first-parent history of the commits it is
+// asked about, which never changes, so a query reads only the commits made
+// since the branch was last queried and then binary-searches their times.
+// It is safe for concurrent use.
+type TimeIndex struct {
+	store *ObjectStore
+	refs  RefStore
+
+	mu sync.Mutex
+	// pos locates every indexed commit on a timeline.
+	pos map[string]timelinePos
+}
+
+// timeline is a first-parent history, root commit first. times holds the
+// effective time of each commit: its timestamp, or that of a later ancestor
+// if it is earlier, so that times is sorted.
+type timeline struct {
+	hashes []string
+	times  []time.Time
+}
+
+type timelinePos struct {
+	line *timeline
+	i    int
+}
+
+// NewTimeIndex returns an empty TimeIndex over the commits of store and the
+// refs of refs, which may be nil to query hashes only.
+func NewTimeIndex(store *ObjectStore, refs RefStore) *TimeIndex {
+	return &TimeIndex{store: store, refs: refs, pos: make(map[string]timelinePos)}
+}
+
+// CommitAt returns the hash of the commit rev pointed to at the instant at:
+// the latest commit of its first-parent history whose timestamp is at or
+// before at. rev is a revision expression, such as "main" or a tag, which is
+// resolved now, not at that instant. Since a commit cannot be older than its
+// parent, a commit timestamped before one of its first-parent ancestors, by
+// a skewed clock, is taken to be as old as that ancestor. CommitAt returns
+// an error matching ErrNotFound if the history starts after at.
+func (x *TimeIndex) CommitAt(rev string, at time.Time) (hash string, err error) {
+	if x.store == nil {
+		return "", fmt.Errorf("object store cannot be nil")
+	}
+	span := x.store.tracer.StartSpan("merkledb.CommitAt")
+	span.SetAttribute("rev", rev)
+	defer func() { span.End(err) }()
+
+	head, err := ResolveRevision(x.store, x.refs, rev)
+	if err != nil {
+		return "", err
+	}
+	if head, err = Peel(x.store, head); err != nil {
+		return "", err
+	}
+	hashes, times, err := x.history(head)
+	if err != nil {
+		return "", err
+	}
+	i := sort.Search(len(times), func(i int) bool { return times[i].After(at) })
+	if i == 0 {
+		return "", fmt.Errorf("%s has no commit at or before %s: %w", rev, at.Format(time.RFC3339), ErrNotFound)
+	}
+	return hashes[i-1], nil
+}
+
+// AsOf returns a Snapshot of the commit rev pointed to at the instant at, as
+// found by CommitAt.
+func (x *TimeIndex) AsOf(rev string, at time.Time) (*Snapshot, error) {
+	hash, err := x.CommitAt(rev, at)
+	if err != nil {
+		return nil, err
+	}
+	return NewSnapshot(x.store, hash)
+}
+
+// history returns the first-parent history of head, root commit first, with
+// the effective time of each commit, indexing the commits not indexed yet.
+// The slices returned must not be modified.
+func (x *TimeIndex) history(head string) ([]string, []time.Time, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if p, ok := x.pos[head]; ok {
+		return p.line.hashes[:p.i+1], p.line.times[:p.i+1], nil
+	}
+
+	// Walk back to the first indexed commit, or the root.
+	var hashes []string
+	var times []time.Time
+	base, indexed := timelinePos{}, false
+	for hash := head; hash != ""; {
+		if base, indexed = x.pos[hash]; indexed {
+			break
+		}
+		commit, err := x.store.ReadCommit(hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashes = append(hashes, hash)
+		times = append(times, commit.Timestamp)
+		hash = ""
+		if len(commit.ParentHashes) > 0 {
+			hash = commit.ParentHashes[0]
+		}
+	}
+
+	// Extend the timeline of the indexed commit if it is its last commit,
+	// or fork a new one.
+	line := &timeline{}
+	if indexed {
+		line = base.line
+		if base.i < len(line.hashes)-1 {
+			line = &timeline{
+				hashes: slices.Clone(line.hashes[:base.i+1]),
+				times:  slices.Clone(line.times[:base.i+1]),
+			}
+		}
+	}
+	for i := len(hashes) - 1; i >= 0; i-- {
+		t := times[i]
+		if n := len(line.times); n > 0 && line.times[n-1].After(t) {
+			t = line.times[n-1]
+		}
+		line.hashes = append(line.hashes, hashes[i])
+		line.times = append(line.times, t)
+		x.pos[hashes[i]] = timelinePos{line, len(line.hashes) - 1}
+	}
+	n := len(line.hashes)
+	return line.hashes[:n:n], line.times[:n:n], nil
+}
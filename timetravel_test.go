@@ -0,0 +1,141 @@
+package merkledb
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// timeTravelFixture commits the days of March 2024 listed to main, one a
+// day at noon, each recording its day in "feed".
+func timeTravelFixture(t *testing.T, days ...int) (*commitGraph, map[int]string) {
+	t.Helper()
+	g := newCommitGraph(t)
+	h := make(map[int]string)
+	for _, day := range days {
+		h[day] = commitDay(g, HeadsPrefix+"main", day, march(day, 12))
+	}
+	return g, h
+}
+
+// commitDay commits day to the branch name with the given timestamp.
+func commitDay(g *commitGraph, name string, day int, when time.Time) string {
+	g.t.Helper()
+	feed := map[string]string{"feed": march(day, 0).Format(time.DateOnly)}
+	return g.advance(name, "day", feed, &CommitOptions{Timestamp: when})
+}
+
+func march(day, hour int) time.Time {
+	return time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestTimeIndex_AsOf(t *testing.T) {
+	g, h := timeTravelFixture(t, 1, 2, 5, 9)
+	index := NewTimeIndex(g.store, g.refs)
+
+	for at, want := range map[time.Time]string{
+		march(1, 12): h[1],
+		march(3, 0):  h[2],
+		march(5, 11): h[2],
+		march(5, 12): h[5],
+		march(8, 23): h[5],
+		march(30, 0): h[9],
+	} {
+		snap, err := index.AsOf("main", at)
+		if err != nil {
+			t.Fatalf("AsOf(main, %v) failed: %v", at, err)
+		}
+		if snap.Hash() != want {
+			t.Errorf("AsOf(main, %v) = %s, want %s", at, snap.Hash(), want)
+		}
+	}
+
+	snap, err := index.AsOf("refs/heads/main", march(3, 0))
+	if err != nil {
+		t.Fatalf("AsOf() failed: %v", err)
+	}
+	if data, err := snap.Get("feed"); err != nil || !strings.Contains(string(data), "2024-03-02") {
+		t.Errorf("Get(feed) = %s, %v; want the feed of March 2nd", data, err)
+	}
+	if _, err := snap.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) returned %v, want ErrNotFound", err)
+	}
+
+	if _, err := index.AsOf("main", march(1, 11)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AsOf() before the first commit returned %v, want ErrNotFound", err)
+	}
+	if _, err := index.AsOf("nope", march(3, 0)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AsOf() of an unknown ref returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestTimeIndex_Incremental(t *testing.T) {
+	g, h := timeTravelFixture(t, 1, 2, 3)
+	storage := g.storage
+	index := NewTimeIndex(g.store, g.refs)
+	if got, err := index.CommitAt("main", march(2, 12)); err != nil || got != h[2] {
+		t.Fatalf("CommitAt() = %s, %v; want %s", got, err, h[2])
+	}
+
+	// Once indexed, old commits are not read again.
+	for _, day := range []int{1, 2} {
+		key, _ := hex.DecodeString(h[day])
+		storage.Delete(key)
+	}
+	h[4] = commitDay(g, HeadsPrefix+"main", 4, march(4, 12))
+	for at, want := range map[time.Time]string{march(1, 12): h[1], march(3, 12): h[3], march(4, 12): h[4]} {
+		if got, err := index.CommitAt("main", at); err != nil || got != want {
+			t.Errorf("CommitAt(main, %v) = %s, %v; want %s", at, got, err, want)
+		}
+	}
+
+	// A branch forked from an indexed commit has its own timeline.
+	g.refs.WriteRef(HeadsPrefix+"fix", h[3])
+	h[10] = commitDay(g, HeadsPrefix+"fix", 10, march(10, 12))
+	if got, err := index.CommitAt("fix", march(20, 0)); err != nil || got != h[10] {
+		t.Errorf("CommitAt(fix) = %s, %v; want %s", got, err, h[10])
+	}
+	if got, err := index.CommitAt("main", march(20, 0)); err != nil || got != h[4] {
+		t.Errorf("CommitAt(main) after indexing fix = %s, %v; want %s", got, err, h[4])
+	}
+	if got, err := index.CommitAt(h[3], march(20, 0)); err != nil || got != h[3] {
+		t.Errorf("CommitAt(%s) = %s, %v; want itself", h[3], got, err)
+	}
+}
+
+func TestTimeIndex_SkewedClock(t *testing.T) {
+	g, h := timeTravelFixture(t, 1, 5)
+	// A commit made on a machine whose clock was days behind.
+	h[2] = commitDay(g, HeadsPrefix+"main", 2, march(2, 12))
+	h[6] = commitDay(g, HeadsPrefix+"main", 6, march(6, 12))
+	index := NewTimeIndex(g.store, g.refs)
+
+	for at, want := range map[time.Time]string{
+		march(3, 0):  h[1],
+		march(5, 12): h[2],
+		march(6, 12): h[6],
+	} {
+		if got, err := index.CommitAt("main", at); err != nil || got != want {
+			t.Errorf("CommitAt(main, %v) = %s, %v; want %s", at, got, err, want)
+		}
+	}
+}
+
+func TestTimeIndex_Concurrent(t *testing.T) {
+	g, h := timeTravelFixture(t, 1, 2, 3, 4, 5, 6, 7, 8)
+	index := NewTimeIndex(g.store, g.refs)
+	var wg sync.WaitGroup
+	for day := 1; day <= 8; day++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := index.CommitAt("main", march(day, 18)); err != nil || got != h[day] {
+				t.Errorf("CommitAt(main, March %d) = %s, %v; want %s", day, got, err, h[day])
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,40 @@
+package merkledb
+
+// Tracer starts spans around the high-level operations of an ObjectStore:
+// writing objects, creating commits and walking history. It lets callers
+// plug in OpenTelemetry or any other tracing system with a small adapter.
+//
+// The operations do not take a context, so spans are reported without a
+// parent; a tracer that needs nesting can track it per goroutine.
+type Tracer interface {
+	// StartSpan starts a span for the named operation, such as
+	// "merkledb.WriteObject".
+	StartSpan(name string) Span
+}
+
+// Span is one traced operation.
+type Span interface {
+	// SetAttribute records a key/value pair describing the operation.
+	SetAttribute(key string, value any)
+	// End finishes the span. err is the error the operation returned, or nil.
+	End(err error)
+}
+
+// noopTracer is the Tracer used when none is configured.
+type noopTracer struct{}
+
+func (noopTracer) StartSpan(name string) Span { return noopSpan{} }
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value any) {}
+func (noopSpan) End(err error)                      {}
+
+// WithTracer makes the ObjectStore report spans to tracer.
+func WithTracer(tracer Tracer) ObjectStoreOption {
+	return func(s *ObjectStore) {
+		if tracer != nil {
+			s.tracer = tracer
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package merkledb
+
+import (
+	"sync"
+	"testing"
+)
+
+// recordingTracer keeps every finished span.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (t *recordingTracer) StartSpan(name string) Span {
+	span := &recordedSpan{name: name, attrs: make(map[string]any)}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return span
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *recordedSpan) End(err error)                      { s.err, s.ended = err, true }
+
+func (t *recordingTracer) names() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var names []string
+	for _, s := range t.spans {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+func TestTracer_WorkspaceCommit(t *testing.T) {
+	tracer := &recordingTracer{}
+	store := NewObjectStore(NewMockStorage(), WithTracer(tracer))
+	ws, _ := NewWorkspace(store)
+
+	ws.Add("file", &mockObject{ID: "1", Data: "traced"})
+	hash, err := ws.Commit("traced commit", nil)
+	if err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	// The object, the tree, then the commit span wrapping the commit write.
+	want := []string{"merkledb.WriteObject", "merkledb.WriteObject", "merkledb.Commit", "merkledb.WriteObject"}
+	got := tracer.names()
+	if len(got) != len(want) {
+		t.Fatalf("spans = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("spans = %v, want %v", got, want)
+		}
+	}
+	for _, s := range tracer.spans {
+		if !s.ended || s.err != nil {
+			t.Errorf("span %s ended=%v err=%v, want ended without error", s.name, s.ended, s.err)
+		}
+	}
+	if commit := tracer.spans[2]; commit.attrs["hash"] != hash {
+		t.Errorf("commit span hash = %v, want %s", commit.attrs["hash"], hash)
+	}
+	if size, _ := tracer.spans[0].attrs["size"].(int); size == 0 {
+		t.Error("WriteObject span has no size attribute")
+	}
+
+	if err := WalkHistory(store, hash, func(string, *Commit) error { return nil }); err != nil {
+		t.Fatalf("WalkHistory() failed: %v", err)
+	}
+	walk := tracer.spans[len(tracer.spans)-1]
+	if walk.name != "merkledb.WalkHistory" || walk.attrs["commits"] != 1 {
+		t.Errorf("last span = %s %v, want merkledb.WalkHistory over 1 commit", walk.name, walk.attrs)
+	}
+}
+
+func TestTracer_DefaultIsNoop(t *testing.T) {
+	store := NewObjectStore(NewMockStorage(), WithTracer(nil))
+	if _, err := store.WriteObject(&mockObject{ID: "1"}); err != nil {
+		t.Fatalf("WriteObject() without a tracer failed: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// EntryKind says what a tree entry refers to.
+type EntryKind string
+
+const (
+	// KindBlob is an object holding data. It is the kind of every entry of
+	// trees written before entries had kinds.
+	KindBlob EntryKind = "blob"
+	// KindChunked is a blob known to be stored as a chunk manifest. It reads
+	// like any other blob.
+	KindChunked EntryKind = "chunked"
+	// KindTree is a subtree.
+	KindTree EntryKind = "tree"
+	// KindLink is a symlink-like reference to another path, held in Target.
+	// It refers to no object.
+	KindLink EntryKind = "link"
+)
+
+// TreeEntry is one named entry of a Tree.
+type TreeEntry struct {
+	Kind EntryKind `json:"kind"`
+	// Hash is the hex-encoded hash of the object the entry refers to. It is
+	// empty for links.
+	Hash string `json:"hash,omitempty"`
+	// Size is the size of the object in bytes, if known.
+	Size int64 `json:"size,omitempty"`
+	// Mode holds user-defined mode bits, such as Unix permissions.
+	Mode uint32 `json:"mode,omitempty"`
+	// Target is the path a link points to.
+	Target string `json:"target,omitempty"`
+}
+
+// BlobEntry returns the entry of a blob with the given hash.
+func BlobEntry(hash string) TreeEntry {
+	return TreeEntry{Kind: KindBlob, Hash: hash}
+}
+
+// TreeEntryOf returns the entry of a subtree with the given hash.
+func TreeEntryOf(hash string) TreeEntry {
+	return TreeEntry{Kind: KindTree, Hash: hash}
+}
+
+// LinkEntry returns the entry of a link to target.
+func LinkEntry(target string) TreeEntry {
+	return TreeEntry{Kind: KindLink, Target: target}
+}
+
+// plain reports whether e is a blob with no metadata, which the flat
+// encoding can represent.
+func (e TreeEntry) plain() bool {
+	return (e.Kind == KindBlob || e.Kind == "") && e.Size == 0 && e.Mode == 0 && e.Target == ""
+}
+
+// validate checks that e is well-formed, and returns it with its kind
+// defaulted to KindBlob.
+func (e TreeEntry) validate() (TreeEntry, error) {
+	switch e.Kind {
+	case "":
+		e.Kind = KindBlob
+		fallthrough
+	case KindBlob, KindChunked, KindTree:
+		if e.Hash == "" {
+			return e, fmt.Errorf("%s entry has no hash", e.Kind)
+		}
+		if e.Target != "" {
+			return e, fmt.Errorf("%s entry has a target", e.Kind)
+		}
+	case KindLink:
+		if e.Target == "" {
+			return e, fmt.Errorf("link entry has no target")
+		}
+	default:
+		return e, fmt.Errorf("unknown entry kind %q", e.Kind)
+	}
+	if e.Size < 0 {
+		return e, fmt.Errorf("negative size %d", e.Size)
+	}
+	return e, nil
+}
+
+// treeVersion is the version of the tree encoding with typed entries.
+const treeVersion = 2
+
+// Tree represents a versioned directory of objects. It maps a set of names
+// to entries referring to other objects or sub-trees, similar to a Git tree.
+// By implementing the Object interface, a Tree can itself be stored in the ObjectStore.
+type Tree struct {
+	// Entries maps a name (like a filename or a subdirectory name) to an entry.
+	Entries map[string]TreeEntry `json:"entries"`
+}
+
+// NewTree creates an empty Tree object
+func NewTree() *Tree {
+	return &Tree{Entries: make(map[string]TreeEntry)}
+}
+
+// treeV2 is the versioned encoding of trees.
+type treeV2 struct {
+	Version int                  `json:"version"`
+	Entries map[string]TreeEntry `json:"entries"`
+}
+
+// encodable returns the value a tree is encoded as. A tree of plain blobs
+// keeps the original flat encoding, a map from names to hashes written by
+// flatTreeJSON, so its hash does not change; any other tree is encoded with
+// its version.
+func (t *Tree) encodable() (any, error) {
+	flat := make(map[string]string, len(t.Entries))
+	typed := make(map[string]TreeEntry, len(t.Entries))
+	for name, e := range t.Entries {
+		e, err := e.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tree entry %q: %w", name, err)
+		}
+		typed[name] = e
+		if flat != nil && e.plain() {
+			flat[name] = e.Hash
+		} else {
+			flat = nil
+		}
+	}
+	if flat != nil {
+		return flat, nil
+	}
+	return treeV2{Version: treeVersion, Entries: typed}, nil
+}
+
+// Serialize implements the Object interface for Tree.
+// It encodes the entries in a stable order, so the hash of a tree only
+// depends on its entries: a tree of plain blobs in its original encoding,
+// and any other tree as canonical JSON.
+func (t *Tree) Serialize() ([]byte, error) {
+	return t.SerializeFormat(FormatJSON)
+}
+
+// SerializeFormat implements the FormatSerializer interface for Tree.
+func (t *Tree) SerializeFormat(f Format) ([]byte, error) {
+	v, err := t.encodable()
+	if err != nil {
+		return nil, err
+	}
+	if flat, ok := v.(map[string]string); ok && f == FormatJSON {
+		return flatTreeJSON(flat), nil
+	}
+	return Encode(f, v)
+}
+
+// flatTreeJSON encodes a tree of plain blobs as trees were first encoded:
+// names in byte order, with names and hashes escaped by encoding/json, which
+// unlike canonical JSON escapes &, < and >.
+func flatTreeJSON(flat map[string]string) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, name := range slices.Sorted(maps.Keys(flat)) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(name)
+		v, _ := json.Marshal(flat[name])
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// decodeTree decodes a tree in any version and format.
+func decodeTree(hash string, data []byte) (*Tree, error) {
+	tree := NewTree()
+	var flat map[string]string
+	if err := Decode(data, &flat); err == nil {
+		for name, h := range flat {
+			tree.Entries[name] = BlobEntry(h)
+		}
+		return tree, nil
+	}
+
+	var v treeV2
+	if err := Decode(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode tree %s: %w", hash, err)
+	}
+	if v.Version != treeVersion {
+		return nil, fmt.Errorf("tree %s has unsupported version %d", hash, v.Version)
+	}
+	for name, e := range v.Entries {
+		e, err := e.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q in tree %s: %w", name, hash, err)
+		}
+		tree.Entries[name] = e
+	}
+	return tree, nil
+}
@@ -0,0 +1,147 @@
+package merkledb
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTree_PlainTreesKeepFlatEncoding(t *testing.T) {
+	tree := NewTree()
+	tree.Entries["a"] = BlobEntry("hash_a")
+	tree.Entries["b"] = TreeEntry{Hash: "hash_b"} // the kind defaults to blob
+
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	if string(data) != `{"a":"hash_a","b":"hash_b"}` {
+		t.Errorf("Serialize() = %s, want the flat encoding", data)
+	}
+
+	// The flat encoding escapes names as it always has.
+	tree = NewTree()
+	tree.Entries["R&D <x>"] = BlobEntry("hash")
+	if data, _ := tree.Serialize(); string(data) != `{"R\u0026D \u003cx\u003e":"hash"}` {
+		t.Errorf("Serialize() = %s, want the flat encoding with &, < and > escaped", data)
+	}
+}
+
+func TestTree_LegacyHashes(t *testing.T) {
+	// Hashes of trees written before canonical JSON, which must not change.
+	tests := []struct {
+		entries map[string]string
+		want    string
+	}{
+		{map[string]string{"a": "hash_a", "b": "hash_b"}, "a7c2af56c2d44cf7d8209f7786afea4c5a8ed1ed603c9fbc833371af15b509a4"},
+		// encoding/json escapes &, < and >.
+		{map[string]string{"R&D <x>": "abc"}, "060724f2505fbefcf531d85e79d4319b8e1f630cb4b9ac2bca0a39c876f7d264"},
+		// Names are sorted by bytes, not UTF-16 code units.
+		{map[string]string{"a b": "1", "\U0001F600": "2", "\ufffd": "3", "x&y": "4"}, "eec586d33d5d933e9d14b62cd451cac7c29c82b85eba9a6ae3da0c37be08ef4a"},
+	}
+	for _, tt := range tests {
+		tree := NewTree()
+		for name, hash := range tt.entries {
+			tree.Entries[name] = BlobEntry(hash)
+		}
+		got, err := NewObjectStore(NewMockStorage()).WriteObject(tree)
+		if err != nil {
+			t.Fatalf("WriteObject() failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("hash of tree %v = %s, want %s", tt.entries, got, tt.want)
+		}
+	}
+}
+
+func TestTree_TypedEntriesRoundTrip(t *testing.T) {
+	tree := NewTree()
+	tree.Entries["data.bin"] = TreeEntry{Kind: KindChunked, Hash: "hash_big", Size: 3 << 20, Mode: 0o644}
+	tree.Entries["dir"] = TreeEntryOf("hash_sub")
+	tree.Entries["latest"] = LinkEntry("data.bin")
+	tree.Entries["plain"] = BlobEntry("hash_plain")
+
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize() failed: %v", err)
+	}
+	want := `{"entries":{` +
+		`"data.bin":{"hash":"hash_big","kind":"chunked","mode":420,"size":3145728},` +
+		`"dir":{"hash":"hash_sub","kind":"tree"},` +
+		`"latest":{"kind":"link","target":"data.bin"},` +
+		`"plain":{"hash":"hash_plain","kind":"blob"}},"version":2}`
+	if string(data) != want {
+		t.Errorf("Serialize() =\n%s\nwant\n%s", data, want)
+	}
+
+	for _, f := range []Format{FormatJSON, FormatCBOR} {
+		encoded, err := tree.SerializeFormat(f)
+		if err != nil {
+			t.Fatalf("SerializeFormat(%s) failed: %v", f, err)
+		}
+		got, err := decodeTree("test", encoded)
+		if err != nil {
+			t.Fatalf("decodeTree(%s) failed: %v", f, err)
+		}
+		if !reflect.DeepEqual(got.Entries, tree.Entries) {
+			t.Errorf("%s round trip = %+v, want %+v", f, got.Entries, tree.Entries)
+		}
+	}
+}
+
+func TestTree_DecodeLegacyAndInvalid(t *testing.T) {
+	// A flat tree with an entry named "version" is still a legacy tree.
+	got, err := decodeTree("legacy", []byte(`{"version":"hash_v","x":"hash_x"}`))
+	if err != nil || got.Entries["version"] != BlobEntry("hash_v") || got.Entries["x"] != BlobEntry("hash_x") {
+		t.Errorf("decodeTree(legacy) = %+v, %v", got, err)
+	}
+
+	for _, data := range []string{
+		`{"version":3,"entries":{}}`,
+		`{"version":2,"entries":{"a":{"kind":"socket","hash":"h"}}}`,
+		`{"version":2,"entries":{"a":{"kind":"tree"}}}`,
+		`[1,2]`,
+	} {
+		if _, err := decodeTree("bad", []byte(data)); err == nil {
+			t.Errorf("decodeTree(%s) succeeded, want an error", data)
+		}
+	}
+
+	tree := NewTree()
+	tree.Entries["broken"] = TreeEntry{Kind: KindLink}
+	if _, err := tree.Serialize(); err == nil {
+		t.Error("Serialize() accepted a link without a target")
+	}
+}
+
+func TestVerifyHistory_Subtrees(t *testing.T) {
+	storage := NewMockStorage()
+	store := NewObjectStore(storage)
+
+	leaf, _ := store.WriteObject(&mockObject{Data: "nested file"})
+	sub := NewTree()
+	sub.Entries["file"] = BlobEntry(leaf)
+	subHash, _ := store.WriteObject(sub)
+
+	ws, _ := NewWorkspace(store)
+	ws.Add("top", &mockObject{Data: "top file"})
+	if err := ws.AddEntry("dir", TreeEntryOf(subHash)); err != nil {
+		t.Fatalf("AddEntry() failed: %v", err)
+	}
+	ws.AddEntry("shortcut", LinkEntry("dir/file"))
+	commit, err := ws.Commit("nested", nil)
+	if err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	if err := VerifyHistory(store, commit); err != nil {
+		t.Fatalf("VerifyHistory() failed: %v", err)
+	}
+
+	// Damage inside the subtree is found.
+	key, _ := hex.DecodeString(leaf)
+	storage.data[string(key)] = []byte("rotten")
+	if err := VerifyHistory(store, commit); !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("VerifyHistory() with a corrupt nested blob returned %v, want ErrCorruptObject", err)
+	}
+}
@@ -1,6 +1,11 @@
 package merkledb
 
-import "fmt"
+import (
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
 
 // Workspace provides a high-level API for staging changes and creating commits.
 // It acts as a "staging area" or an in-memory representation of then next commit's tree.
@@ -28,15 +33,95 @@ func (w *Workspace) Add(name string, obj Object) error {
 		return fmt.Errorf("failed to write object '%s': %w", name, err)
 	}
 
-	w.tree.Entries[name] = hash
+	w.tree.Entries[name] = BlobEntry(hash)
 	return nil
 }
 
+// AddEntry stages an entry that refers to an object already in the store,
+// such as a subtree or a link, or a blob with metadata.
+func (w *Workspace) AddEntry(name string, entry TreeEntry) error {
+	entry, err := entry.validate()
+	if err != nil {
+		return fmt.Errorf("invalid entry '%s': %w", name, err)
+	}
+	w.tree.Entries[name] = entry
+	return nil
+}
+
+// Entry returns the entry staged under name.
+func (w *Workspace) Entry(name string) (TreeEntry, bool) {
+	entry, ok := w.tree.Entries[name]
+	return entry, ok
+}
+
+// Get returns the hash staged under name. It is empty for links.
+func (w *Workspace) Get(name string) (hash string, ok bool) {
+	entry, ok := w.tree.Entries[name]
+	return entry.Hash, ok
+}
+
+// Remove unstages name, and reports whether it was staged.
+func (w *Workspace) Remove(name string) bool {
+	_, ok := w.tree.Entries[name]
+	delete(w.tree.Entries, name)
+	return ok
+}
+
+// List returns the staged names starting with prefix and their hashes, in
+// name order.
+func (w *Workspace) List(prefix string) iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		var names []string
+		for name := range w.tree.Entries {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			entry, ok := w.tree.Entries[name]
+			if !ok {
+				// Removed during iteration.
+				continue
+			}
+			if !yield(name, entry.Hash) {
+				return
+			}
+		}
+	}
+}
+
+// Checkout replaces the staged entries with the tree of the given commit, so
+// the next commit builds on it.
+func (w *Workspace) Checkout(commitHash string) error {
+	commit, err := w.store.ReadCommit(commitHash)
+	if err != nil {
+		return fmt.Errorf("failed to read commit: %w", err)
+	}
+	tree, err := w.store.ReadTree(commit.TreeHash)
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+	w.tree = tree
+	return nil
+}
+
+// Store returns the ObjectStore the workspace writes to.
+func (w *Workspace) Store() *ObjectStore {
+	return w.store
+}
+
 // Commit creates a new commit from the current state of the workspace
 // It writes the workspace's internal Tree to the ObjectStore and then creates a
 // new Commit object pointing to that tree.
 // It returns the hash of the newly created commit.
 func (w *Workspace) Commit(message string, parentHashes []string) (string, error) {
+	return w.CommitWithOptions(message, parentHashes, nil)
+}
+
+// CommitWithOptions is like Commit, but also records the authorship and
+// metadata in opts, which may be nil.
+func (w *Workspace) CommitWithOptions(message string, parentHashes []string, opts *CommitOptions) (string, error) {
 	// First, write the stage stree to the object store to get its hash.
 	treeHash, err := w.store.WriteObject(w.tree)
 	if err != nil {
@@ -44,7 +129,7 @@ func (w *Workspace) Commit(message string, parentHashes []string) (string, error
 	}
 
 	// Now, create a commit pointing to this tree.
-	commitHash, err := CreateCommit(w.store, treeHash, message, parentHashes)
+	commitHash, err := CreateCommitWithOptions(w.store, treeHash, message, parentHashes, opts)
 	if err != nil {
 		return "", fmt.Errorf("failed to create commit: %w", err)
 	}
@@ -61,7 +61,7 @@ func TestWorkspace_AddAndCommit(t *testing.T) {
 	// We can't easily unmarshal back to a Tree struct because of the canonical
 	// serialization, so we'll check the raw JSON string content.
 	// This is a simple but effective way to verify the tree's content.
-	expectedTreeContent := `{"object_a":"e9d71f5ee7b34b3173236058e1ff7f2ed335b7af59f237c1524aaa4a7a45e69e","object_b":"093847248f2c53f3801ad2e7b55f6962f90240d46706e2554a92a5436c641f92"}`
+	expectedTreeContent := `{"object_a":"93a9701e7ec1d5d2326fe3c64be41c10c33eecbccf8cd54ed356b43e96508a56","object_b":"c34e85e9f66e4d142ddfa7558eadf545aa81db9dce7c46828b4cff86de77f516"}`
 	if string(rawTree) != expectedTreeContent {
 		t.Errorf("tree content mismatch.\nExpected: %s\nGot:      %s", expectedTreeContent, string(rawTree))
 	}